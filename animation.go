@@ -0,0 +1,113 @@
+package gosaic
+
+import (
+	"fmt"
+	"image"
+	"image/color/palette"
+	"image/draw"
+	"image/gif"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Defaults of the assembly animation
+const (
+	DefaultAnimationStride = 10
+	DefaultAnimationWidth  = 640
+)
+
+// animationFPS is the frame rate of the assembly video
+const animationFPS = 25
+
+// animation records the assembly of the mosaic, either as an animated GIF
+// or by piping raw frames into ffmpeg to encode any video format it
+// supports.
+type animation struct {
+	filename string
+	w, h     int
+	frames   []*image.Paletted
+	delays   []int
+	ffmpeg   *exec.Cmd
+	stdin    io.WriteCloser
+}
+
+// newAnimation starts recording the assembly of a mosaic of the given
+// bounds into filename, scaled to width pixels.
+func newAnimation(filename string, bounds image.Rectangle, width int) (*animation, error) {
+	if width <= 0 {
+		width = DefaultAnimationWidth
+	}
+	h := bounds.Dy() * width / bounds.Dx()
+
+	// most video codecs need even dimensions
+	a := &animation{filename: filename, w: width &^ 1, h: h &^ 1}
+	if a.w == 0 || a.h == 0 {
+		return nil, fmt.Errorf("the animation of %dx%d pixels is too small", a.w, a.h)
+	}
+	if strings.ToLower(filepath.Ext(filename)) == ".gif" {
+		return a, nil
+	}
+
+	a.ffmpeg = exec.Command("ffmpeg", "-y", "-loglevel", "error",
+		"-f", "rawvideo", "-pix_fmt", "rgba", "-s", fmt.Sprintf("%dx%d", a.w, a.h), "-r", fmt.Sprint(animationFPS), "-i", "-",
+		"-pix_fmt", "yuv420p", filename)
+	a.ffmpeg.Stderr = os.Stderr
+
+	var err error
+	a.stdin, err = a.ffmpeg.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := a.ffmpeg.Start(); err != nil {
+		return nil, fmt.Errorf("ffmpeg: %s", err)
+	}
+	return a, nil
+}
+
+// addFrame scales img to the size of the animation and appends it
+func (a *animation) addFrame(img image.Image) error {
+	frame := scaleImage(img, a.w, a.h)
+	if a.ffmpeg != nil {
+		_, err := a.stdin.Write(frame.Pix)
+		return err
+	}
+
+	paletted := image.NewPaletted(frame.Bounds(), palette.Plan9)
+	draw.FloydSteinberg.Draw(paletted, paletted.Bounds(), frame, image.ZP)
+	a.frames = append(a.frames, paletted)
+	a.delays = append(a.delays, 100/animationFPS)
+	return nil
+}
+
+// finish shows the last frame for two seconds and writes the animation
+func (a *animation) finish() error {
+	if a.ffmpeg != nil {
+		if err := a.stdin.Close(); err != nil {
+			return err
+		}
+		return a.ffmpeg.Wait()
+	}
+
+	if len(a.delays) > 0 {
+		a.delays[len(a.delays)-1] = 200
+	}
+
+	fh, err := os.Create(a.filename)
+	if err != nil {
+		return err
+	}
+	defer fh.Close()
+
+	return gif.EncodeAll(fh, &gif.GIF{Image: a.frames, Delay: a.delays})
+}
+
+// animationStride returns the number of placed tiles per frame
+func (g *Gosaic) animationStride() int {
+	if g.config.AnimationStride > 0 {
+		return g.config.AnimationStride
+	}
+	return DefaultAnimationStride
+}
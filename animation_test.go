@@ -0,0 +1,47 @@
+package gosaic
+
+import (
+	"image"
+	"image/gif"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAnimationGIF(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "assembly.gif")
+	img := image.NewRGBA(image.Rect(0, 0, 101, 51))
+
+	a, err := newAnimation(filename, img.Bounds(), 33)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := a.addFrame(img); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := a.finish(); err != nil {
+		t.Fatal(err)
+	}
+
+	fh, err := os.Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fh.Close()
+
+	anim, err := gif.DecodeAll(fh)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(anim.Image) != 3 {
+		t.Fatalf("%d frames, want 3", len(anim.Image))
+	}
+	if b := anim.Image[0].Bounds(); b.Dx() != 32 || b.Dy() != 16 {
+		t.Fatalf("frames of %v, want 32x16", b)
+	}
+	if anim.Delay[2] != 200 {
+		t.Fatalf("the last frame is shown for %d, want 200", anim.Delay[2])
+	}
+}
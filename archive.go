@@ -0,0 +1,212 @@
+package gosaic
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/davidbyttow/govips/v2/vips"
+)
+
+// archiveSep separates the archive from the entry in the names of tiles
+// read from archives, like tiles.zip!beach/1.jpg
+const archiveSep = "!"
+
+// IsArchive tells if name is a ZIP or tar archive gosaic reads tiles from
+func IsArchive(name string) bool {
+	name = strings.ToLower(name)
+	for _, ext := range []string{".zip", ".tar", ".tar.gz", ".tgz"} {
+		if strings.HasSuffix(name, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// splitArchiveName splits the name of a tile read from an archive into the
+// archive and the entry. ok is false for other tiles.
+func splitArchiveName(name string) (archive, entry string, ok bool) {
+	i := strings.Index(name, archiveSep)
+	if i < 0 || !IsArchive(name[:i]) {
+		return "", "", false
+	}
+	return name[:i], name[i+len(archiveSep):], true
+}
+
+// isArchiveImage tells if the archive entry name is an image which isn't
+// hidden or in a hidden or system directory
+func isArchiveImage(name string) bool {
+	for _, part := range strings.Split(name, "/") {
+		if strings.HasPrefix(part, ".") || systemFiles[strings.ToLower(part)] {
+			return false
+		}
+	}
+	return IsImageFile(name)
+}
+
+// tileArchive reads the images of a ZIP or tar archive without extracting
+// it. ZIP entries are read when they are needed, tar archives can't be
+// read at random, so their images are kept in memory.
+type tileArchive struct {
+	zip     *zip.ReadCloser
+	files   map[string]*zip.File
+	data    map[string][]byte
+	entries []string
+}
+
+// openTileArchive opens the archive filename and indexes its images
+func openTileArchive(filename string) (*tileArchive, error) {
+	a := &tileArchive{files: map[string]*zip.File{}, data: map[string][]byte{}}
+
+	if strings.HasSuffix(strings.ToLower(filename), ".zip") {
+		zr, err := zip.OpenReader(filename)
+		if err != nil {
+			return nil, err
+		}
+		a.zip = zr
+		for _, f := range zr.File {
+			if !f.FileInfo().IsDir() && isArchiveImage(f.Name) {
+				a.files[f.Name] = f
+				a.entries = append(a.entries, f.Name)
+			}
+		}
+		sort.Strings(a.entries)
+		return a, nil
+	}
+
+	fh, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+
+	var r io.Reader = fh
+	if !strings.HasSuffix(strings.ToLower(filename), ".tar") {
+		gz, err := gzip.NewReader(fh)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		name := path.Clean(hdr.Name)
+		if hdr.Typeflag != tar.TypeReg || !isArchiveImage(name) {
+			continue
+		}
+		data, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return nil, err
+		}
+		a.data[name] = data
+		a.entries = append(a.entries, name)
+	}
+	sort.Strings(a.entries)
+	return a, nil
+}
+
+// read returns the content of the entry name
+func (a *tileArchive) read(name string) ([]byte, error) {
+	if data, ok := a.data[name]; ok {
+		return data, nil
+	}
+	f, ok := a.files[name]
+	if !ok {
+		return nil, fmt.Errorf("%s isn't in the archive", name)
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return ioutil.ReadAll(rc)
+}
+
+// archiveCache keeps the archives tiles are read from open
+type archiveCache struct {
+	mutex    sync.Mutex
+	archives map[string]*tileArchive
+}
+
+// get returns the opened archive filename, opening it on first use
+func (c *archiveCache) get(filename string) (*tileArchive, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if a, ok := c.archives[filename]; ok {
+		return a, nil
+	}
+	a, err := openTileArchive(filename)
+	if err != nil {
+		return nil, err
+	}
+	if c.archives == nil {
+		c.archives = map[string]*tileArchive{}
+	}
+	c.archives[filename] = a
+	return a, nil
+}
+
+// archiveTileNames returns the names of the tiles in the archive filename
+func (g *Gosaic) archiveTileNames(filename string) ([]string, error) {
+	a, err := g.archives.get(filename)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, len(a.entries))
+	for i, e := range a.entries {
+		names[i] = filename + archiveSep + e
+	}
+	return names, nil
+}
+
+// loadTileImage loads the tile file name, which may be in an archive, a
+// blob store or at a URL
+func (g *Gosaic) loadTileImage(name string) (*vips.ImageRef, error) {
+	if IsBlobURL(name) {
+		data, err := g.readBlob(name)
+		if err != nil {
+			return nil, err
+		}
+		return LoadImageFromBuffer(data, name)
+	}
+	if IsURL(name) {
+		data, err := g.readURL(name)
+		if err != nil {
+			return nil, err
+		}
+		return LoadImageFromBuffer(data, name)
+	}
+
+	archive, entry, ok := splitArchiveName(name)
+	if !ok {
+		return LoadImage(name)
+	}
+
+	a, err := g.archives.get(archive)
+	if err != nil {
+		return nil, err
+	}
+	data, err := a.read(entry)
+	if err != nil {
+		return nil, err
+	}
+	return LoadImageFromBuffer(data, entry)
+}
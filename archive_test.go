@@ -0,0 +1,95 @@
+package gosaic
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+var archiveFiles = map[string]string{
+	"beach/1.jpg":      "one",
+	"beach/2.png":      "two",
+	"beach/readme.txt": "not a tile",
+	"__MACOSX/1.jpg":   "resource fork",
+	".hidden/3.jpg":    "hidden",
+}
+
+func writeZip(t *testing.T, filename string) {
+	fh, err := os.Create(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fh.Close()
+	zw := zip.NewWriter(fh)
+	for name, content := range archiveFiles {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(content))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func writeTarGz(t *testing.T, filename string) {
+	fh, err := os.Create(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fh.Close()
+	gz := gzip.NewWriter(fh)
+	tw := tar.NewWriter(gz)
+	for name, content := range archiveFiles {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatal(err)
+		}
+		tw.Write([]byte(content))
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTileArchive(t *testing.T) {
+	dir := t.TempDir()
+	zipFile, tgzFile := filepath.Join(dir, "tiles.zip"), filepath.Join(dir, "tiles.tar.gz")
+	writeZip(t, zipFile)
+	writeTarGz(t, tgzFile)
+
+	for _, filename := range []string{zipFile, tgzFile} {
+		g := &Gosaic{}
+		names, err := g.archiveTileNames(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []string{filename + "!beach/1.jpg", filename + "!beach/2.png"}
+		if !reflect.DeepEqual(names, want) {
+			t.Errorf("archiveTileNames(%s) = %v, want %v", filename, names, want)
+		}
+
+		archive, entry, ok := splitArchiveName(names[1])
+		if !ok || archive != filename || entry != "beach/2.png" {
+			t.Errorf("splitArchiveName(%s) = %s, %s, %v", names[1], archive, entry, ok)
+		}
+		a, err := g.archives.get(archive)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if data, err := a.read(entry); err != nil || string(data) != "two" {
+			t.Errorf("read(%s) = %q, %v, want \"two\"", entry, data, err)
+		}
+	}
+
+	if _, _, ok := splitArchiveName("photos/wow!.jpg"); ok {
+		t.Error("a file with a ! in its name was taken for an archive")
+	}
+}
@@ -0,0 +1,366 @@
+package gosaic
+
+import (
+	"container/list"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// AssignGreedy matches the rects in random order, each with the best
+	// tile that is still available.
+	AssignGreedy = "greedy"
+
+	// AssignOptimal solves the assignment of unique tiles to rects among
+	// the closest candidates of each rect with an auction, so their total
+	// distance is minimal up to auctionPrecision.
+	AssignOptimal = "optimal"
+)
+
+const (
+	// OrderRandom lets the greedy assignment visit the rects in random order
+	OrderRandom = "random"
+
+	// OrderSaliency lets the most detailed rects pick their tiles first
+	OrderSaliency = "saliency"
+)
+
+// sortBySaliency orders the rects by the detail of the seed image within
+// their cells, the most detailed first.
+func (g *Gosaic) sortBySaliency(rects []*TileData) {
+	scores := make(map[*TileData]float64, len(rects))
+	for _, td := range rects {
+		scores[td] = g.variance(td.Cell)
+	}
+	sort.SliceStable(rects, func(i, j int) bool { return scores[rects[i]] > scores[rects[j]] })
+}
+
+// optimalCandidates is the number of closest tiles per rect among which the
+// optimal assignment picks. Keeping only a few per rect keeps the problem
+// sparse, rects which none of them can be assigned to are matched greedily.
+const optimalCandidates = 32
+
+type assignCost struct {
+	elem      *list.Element
+	dist      float64
+	transform Transform
+}
+
+// matchOptimal computes the distances of all rects to their candidate
+// tiles, solves the assignment and draws the result. Rects which are left
+// without a tile, or whose tile would repeat too close to another
+// placement, are matched greedily afterwards.
+func (g *Gosaic) matchOptimal(rects []*TileData, bar ProgressIndicator) time.Duration {
+	compareTime := time.Duration(0)
+	if len(rects) == 0 {
+		return compareTime
+	}
+
+	tiles := make([]*list.Element, 0, g.Tiles.Len())
+	for cur := g.Tiles.Front(); cur != nil; cur = cur.Next() {
+		tiles = append(tiles, cur)
+	}
+
+	var tree *kdTree
+	if g.config.Candidates > 0 {
+		tree = newKDTree(g.Tiles)
+	}
+
+	workers := g.config.Workers
+	if workers < 1 {
+		workers = 1
+	}
+
+	rowCosts := make([][]assignCost, len(rects))
+	rectChan := make(chan int)
+	mutex := sync.Mutex{}
+	wg := sync.WaitGroup{}
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for r := range rectChan {
+				td := rects[r]
+				candidates := tiles
+				if tree != nil {
+					candidates = g.nearestCandidates(tree, td)
+				}
+
+				tStart := time.Now()
+				costs := g.rectCosts(td, g.refineCandidates(td, candidates))
+
+				// widen the accepted distance if no tile was close enough
+				for len(costs) == 0 && td.Widen < g.config.WidenSteps && g.compareDist(td) <= 255 {
+					td.Widen++
+					costs = g.rectCosts(td, g.refineCandidates(td, candidates))
+				}
+				if td.Widen > 0 && len(costs) > 0 {
+					g.stats.mutex.Lock()
+					g.stats.Widened++
+					g.stats.mutex.Unlock()
+				}
+
+				sort.Slice(costs, func(i, j int) bool {
+					if costs[i].dist != costs[j].dist {
+						return costs[i].dist < costs[j].dist
+					}
+					return costs[i].elem.Value.(Tile).Filename < costs[j].elem.Value.(Tile).Filename
+				})
+				if len(costs) > optimalCandidates {
+					costs = costs[:optimalCandidates]
+				}
+				rowCosts[r] = costs
+
+				mutex.Lock()
+				compareTime += time.Now().Sub(tStart)
+				mutex.Unlock()
+
+				if bar != nil {
+					bar.Increment()
+				}
+			}
+		}()
+	}
+
+	for r := range rects {
+		rectChan <- r
+	}
+	close(rectChan)
+	wg.Wait()
+
+	cols := map[*list.Element]int{}
+	colElems := []*list.Element{}
+	edges := make([][]assignEdge, len(rects))
+	for r, costs := range rowCosts {
+		edges[r] = make([]assignEdge, len(costs))
+		for i, c := range costs {
+			col, ok := cols[c.elem]
+			if !ok {
+				col = len(colElems)
+				cols[c.elem] = col
+				colElems = append(colElems, c.elem)
+			}
+			edges[r][i] = assignEdge{col: col, cost: c.dist}
+		}
+	}
+
+	log.Infof("solving assignment of %d rects to %d tiles", len(rects), len(colElems))
+	assignment := auctionAssign(edges, len(colElems), g.maxUses(), g.config.UsagePenalty)
+
+	leftover := make([]*TileData, 0)
+	for r, td := range rects {
+		j := assignment[r]
+		if j < 0 {
+			leftover = append(leftover, td)
+			continue
+		}
+
+		var cost assignCost
+		for _, c := range rowCosts[r] {
+			if c.elem == colElems[j] {
+				cost = c
+				break
+			}
+		}
+
+		// the assignment doesn't know about neighbours, so rects whose tile
+		// has already been placed nearby get another one
+		tile := cost.elem.Value.(Tile)
+		if g.config.RepeatDistance > 0 && g.placements.near(tile.Filename, td.X, td.Y, g.config.RepeatDistance) {
+			leftover = append(leftover, td)
+			continue
+		}
+
+		*td.MinTile = tile
+		*td.MinElem = *cost.elem
+		*td.MinDist = cost.dist
+		*td.MinTransform = cost.transform
+		g.placements.add(td.MinTile.Filename, td.X, td.Y)
+
+		err := g.drawTile(td)
+		if err != nil {
+			log.Error(err)
+		}
+		g.placed(td)
+	}
+
+	if len(leftover) == 0 {
+		return compareTime
+	}
+
+	// only tiles which may still be used are left for the greedy matching
+	limit := g.maxUses()
+	var next *list.Element
+	for cur := g.Tiles.Front(); cur != nil; cur = next {
+		next = cur.Next()
+		if g.placements.uses(cur.Value.(Tile).Filename) >= limit {
+			g.Tiles.Remove(cur)
+		}
+	}
+
+	log.Infof("matching %d rects without an optimal tile greedily", len(leftover))
+	return compareTime + g.matchGreedy(leftover, nil)
+}
+
+// rectCosts returns the distances of td to all candidates within the
+// configured average color distance.
+func (g *Gosaic) rectCosts(td *TileData, candidates []*list.Element) []assignCost {
+	costs := make([]assignCost, 0)
+	for _, le := range candidates {
+		tile := le.Value.(Tile)
+		if tile.Tiny == nil {
+			continue
+		}
+
+		if math.Abs(tile.Average-td.Average) > g.compareDist(td) {
+			continue
+		}
+
+		dist, transform, err := g.tileDistance(td, tile)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+
+		costs = append(costs, assignCost{elem: le, dist: dist, transform: transform})
+	}
+
+	return costs
+}
+
+// assignEdge is a tile a row of the assignment may be assigned to
+type assignEdge struct {
+	col  int
+	cost float64
+}
+
+// auctionPrecision bounds how far the total cost of the assignment of the
+// auction may be above the optimum, however many rows there are
+const auctionPrecision = 1e-3
+
+// auctionEps returns the minimal bid increment of the auction of n rows.
+// The total cost is within n times the increment of the optimum, so it is
+// scaled down with the rows to keep the total within auctionPrecision.
+func auctionEps(n int) float64 {
+	if n < 1 {
+		n = 1
+	}
+	return auctionPrecision / float64(n)
+}
+
+// unassignedCost returns the cost of leaving a row without a column. It
+// exceeds the cost of every edge, but only by so much that rows competing
+// for too few columns give up after a few bids.
+func unassignedCost(rows [][]assignEdge, capacity int, penalty float64) float64 {
+	max := 0.0
+	for _, edges := range rows {
+		for _, e := range edges {
+			max = math.Max(max, e.cost)
+		}
+	}
+	return max + penalty*float64(capacity) + 1
+}
+
+// auctionAssign assigns every row to one of the columns of its edges, so
+// the total cost is within auctionPrecision of the minimum. Every column
+// can be assigned to up to capacity rows, the u-th of which costs
+// penalty*u on top of the edge cost. Rows which are better off without a
+// column, at unassignedCost, get -1.
+//
+// It runs the forward auction algorithm: unassigned rows bid for the
+// column slot of the best value, raising its price by the difference to
+// the second best plus auctionEps, until every row holds a slot or is
+// better off without one. All prices start at zero, which keeps that
+// bound when there are more slots than rows. Memory grows with the number
+// of edges, not with the product of rows and columns.
+func auctionAssign(rows [][]assignEdge, cols, capacity int, penalty float64) []int {
+	n := len(rows)
+	if capacity > n {
+		capacity = n
+	}
+	if capacity < 1 {
+		capacity = 1
+	}
+
+	price := make([][]float64, cols)
+	owner := make([][]int, cols)
+	for j := range price {
+		price[j] = make([]float64, capacity)
+		owner[j] = make([]int, capacity)
+		for u := range owner[j] {
+			owner[j][u] = -1
+		}
+	}
+
+	// the cheapest and second cheapest slot of every column
+	type slots struct {
+		best, second float64
+		slot         int
+	}
+	cheapest := make([]slots, cols)
+	update := func(j int) {
+		s := slots{best: math.Inf(1), second: math.Inf(1)}
+		for u, p := range price[j] {
+			c := p + penalty*float64(u)
+			if c < s.best {
+				s.second = s.best
+				s.best, s.slot = c, u
+			} else if c < s.second {
+				s.second = c
+			}
+		}
+		cheapest[j] = s
+	}
+	for j := range price {
+		update(j)
+	}
+
+	unassigned := unassignedCost(rows, capacity, penalty)
+	eps := auctionEps(n)
+	assignment := make([]int, n)
+	queue := make([]int, n)
+	for i := range queue {
+		queue[i] = n - 1 - i
+		assignment[i] = -1
+	}
+
+	for len(queue) > 0 {
+		i := queue[len(queue)-1]
+		queue = queue[:len(queue)-1]
+
+		// leaving the row unassigned is always an option
+		best, second := -unassigned, math.Inf(-1)
+		bestCol := -1
+		for _, e := range rows[i] {
+			s := cheapest[e.col]
+			v := -e.cost - s.best
+			if v > best {
+				second = math.Max(best, -e.cost-s.second)
+				best, bestCol = v, e.col
+			} else {
+				second = math.Max(second, v)
+			}
+		}
+		if bestCol < 0 {
+			continue
+		}
+
+		u := cheapest[bestCol].slot
+		price[bestCol][u] += best - second + eps
+		update(bestCol)
+
+		if prev := owner[bestCol][u]; prev >= 0 {
+			assignment[prev] = -1
+			queue = append(queue, prev)
+		}
+		owner[bestCol][u] = i
+		assignment[i] = bestCol
+	}
+
+	return assignment
+}
@@ -0,0 +1,176 @@
+package gosaic
+
+import (
+	"container/list"
+	"fmt"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// denseEdges connects every row to every column of matrix
+func denseEdges(matrix [][]float64) [][]assignEdge {
+	rows := make([][]assignEdge, len(matrix))
+	for i, costs := range matrix {
+		for j, c := range costs {
+			rows[i] = append(rows[i], assignEdge{col: j, cost: c})
+		}
+	}
+	return rows
+}
+
+func TestAuctionAssign(t *testing.T) {
+	tests := []struct {
+		name     string
+		rows     [][]assignEdge
+		cols     int
+		capacity int
+		penalty  float64
+		want     []int
+	}{
+		{
+			"diagonal",
+			denseEdges([][]float64{{0.1, 0.9}, {0.9, 0.1}}),
+			2, 1, 0,
+			[]int{0, 1},
+		},
+		{
+			// greedily the first row takes column 0 and the second pays 0.9
+			"greedy would be worse",
+			denseEdges([][]float64{{0.1, 0.2}, {0.2, 0.9}}),
+			2, 1, 0,
+			[]int{1, 0},
+		},
+		{
+			"more columns than rows",
+			denseEdges([][]float64{{0.5, 0.3, 0.1}, {0.4, 0.2, 0.1}}),
+			3, 1, 0,
+			[]int{2, 1},
+		},
+		{
+			"row without edges",
+			[][]assignEdge{{{col: 0, cost: 0.2}}, {}},
+			1, 1, 0,
+			[]int{0, -1},
+		},
+		{
+			// only one of the rows gets the single column, the one which
+			// would lose the most without it
+			"too few columns",
+			[][]assignEdge{{{col: 0, cost: 0.4}}, {{col: 0, cost: 0.1}}},
+			1, 1, 0,
+			[]int{-1, 0},
+		},
+		{
+			"column used twice",
+			[][]assignEdge{{{col: 0, cost: 0.1}, {col: 1, cost: 0.5}}, {{col: 0, cost: 0.1}, {col: 1, cost: 0.5}}},
+			2, 2, 0,
+			[]int{0, 0},
+		},
+		{
+			// the second use of column 0 costs 0.5 more than column 1
+			"usage penalty",
+			[][]assignEdge{{{col: 0, cost: 0.1}, {col: 1, cost: 0.3}}, {{col: 0, cost: 0.1}, {col: 1, cost: 0.5}}},
+			2, 2, 0.5,
+			[]int{1, 0},
+		},
+	}
+	for _, tt := range tests {
+		got := auctionAssign(tt.rows, tt.cols, tt.capacity, tt.penalty)
+		if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("%s: auctionAssign() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+// minCost returns the minimal total cost of assigning the rows to distinct
+// columns, or to none at unassigned, by trying every combination
+func minCost(rows [][]assignEdge, row int, used []bool, unassigned float64) float64 {
+	if row == len(rows) {
+		return 0
+	}
+	best := unassigned + minCost(rows, row+1, used, unassigned)
+	for _, e := range rows[row] {
+		if used[e.col] {
+			continue
+		}
+		used[e.col] = true
+		best = math.Min(best, e.cost+minCost(rows, row+1, used, unassigned))
+		used[e.col] = false
+	}
+	return best
+}
+
+// the auction finds the optimal assignment of sparse rows up to its
+// precision, also if the costs differ by less than that
+func TestAuctionAssignOptimal(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	for it := 0; it < 400; it++ {
+		n := 1 + rnd.Intn(7)
+		cols := 1 + rnd.Intn(7)
+		scale := 1.0
+		if it%2 == 1 {
+			scale = auctionPrecision
+		}
+		rows := make([][]assignEdge, n)
+		for i := range rows {
+			for j := 0; j < cols; j++ {
+				if rnd.Intn(3) > 0 {
+					rows[i] = append(rows[i], assignEdge{col: j, cost: 0.5 + rnd.Float64()*scale})
+				}
+			}
+		}
+
+		assignment := auctionAssign(rows, cols, 1, 0)
+		unassigned := unassignedCost(rows, 1, 0)
+		cost := 0.0
+		used := map[int]bool{}
+		for i, j := range assignment {
+			if j < 0 {
+				cost += unassigned
+				continue
+			}
+			if used[j] {
+				t.Fatalf("column %d is assigned twice in %v", j, assignment)
+			}
+			used[j] = true
+			for _, e := range rows[i] {
+				if e.col == j {
+					cost += e.cost
+				}
+			}
+		}
+
+		want := minCost(rows, 0, make([]bool, cols), unassigned)
+		if cost > want+auctionPrecision+1e-9 {
+			t.Fatalf("%d rows, %d columns: cost %f, optimum %f", n, cols, cost, want)
+		}
+	}
+}
+
+// the comparisons of the optimal assignment count like those of the
+// greedy one, every variant of the rect with every candidate
+func TestRectCostsComparisons(t *testing.T) {
+	rect := numbered(4, 4)
+	g := &Gosaic{config: Config{CompareDist: 1, AllowRotate: true}}
+	td := &TileData{CompareImage: rect, Rect: rect.Bounds(), Weight: 0.5}
+	td.Variants = g.compareVariants(rect, nil)
+
+	tiles := list.New()
+	for _, name := range []string{"a", "b", "c"} {
+		tiles.PushBack(Tile{Filename: name, Tiny: rect})
+	}
+	// a tile without its image isn't compared
+	tiles.PushBack(Tile{Filename: "broken"})
+	candidates := []*list.Element{}
+	for e := tiles.Front(); e != nil; e = e.Next() {
+		candidates = append(candidates, e)
+	}
+
+	if costs := g.rectCosts(td, candidates); len(costs) != 3 {
+		t.Errorf("rectCosts() returned %d costs, want 3", len(costs))
+	}
+	if want := 3 * len(td.Variants); g.stats.Comparisons != want {
+		t.Errorf("%d comparisons counted, want %d", g.stats.Comparisons, want)
+	}
+}
@@ -0,0 +1,111 @@
+package gosaic
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// APIKeyHeader is the header requests pass their API key in, the query
+// parameter api_key and an Authorization bearer token work as well
+const APIKeyHeader = "X-API-Key"
+
+// KeyLimits are the limits of an API key, 0 doesn't limit
+type KeyLimits struct {
+	// RateLimit is the number of requests per minute
+	RateLimit int
+	// Quota is the number of builds per calendar month (UTC)
+	Quota int
+}
+
+// APIKey is a key of the REST API and its limits
+type APIKey struct {
+	Key string
+	KeyLimits
+}
+
+// owner identifies the key in the jobs without storing the key itself
+func (k APIKey) owner() string {
+	sum := sha1.Sum([]byte(k.Key))
+	return hex.EncodeToString(sum[:8])
+}
+
+// LoadAPIKeys reads the API keys in filename, one per line. A key may be
+// followed by its own limits like "KEY rate=60 quota=100", the others get
+// defaults. Empty lines and lines starting with # are skipped.
+func LoadAPIKeys(filename string, defaults KeyLimits) ([]APIKey, error) {
+	fh, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+
+	keys := []APIKey{}
+	scanner := bufio.NewScanner(fh)
+	for n := 1; scanner.Scan(); n++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		key := APIKey{Key: fields[0], KeyLimits: defaults}
+		for _, f := range fields[1:] {
+			name, value := f, ""
+			if i := strings.Index(f, "="); i >= 0 {
+				name, value = f[:i], f[i+1:]
+			}
+			limit, err := strconv.Atoi(value)
+			if err != nil || limit < 0 {
+				return nil, fmt.Errorf("%s:%d: invalid limit %q", filename, n, f)
+			}
+			switch name {
+			case "rate":
+				key.RateLimit = limit
+			case "quota":
+				key.Quota = limit
+			default:
+				return nil, fmt.Errorf("%s:%d: unknown limit %q", filename, n, name)
+			}
+		}
+		keys = append(keys, key)
+	}
+	return keys, scanner.Err()
+}
+
+// requestAPIKey returns the API key the request was sent with
+func requestAPIKey(c *gin.Context) string {
+	if key := c.GetHeader(APIKeyHeader); key != "" {
+		return key
+	}
+	if auth := c.GetHeader("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimPrefix(auth, "Bearer ")
+	}
+	return c.Query("api_key")
+}
+
+// apiKeyAuth rejects the requests without one of keys and sets the APIKey
+// of the others as "APIKey"
+func apiKeyAuth(keys []APIKey) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := requestAPIKey(c)
+		var valid *APIKey
+		for i, k := range keys {
+			// compare all keys in constant time, so the timing gives none away
+			if subtle.ConstantTimeCompare([]byte(key), []byte(k.Key)) == 1 {
+				valid = &keys[i]
+			}
+		}
+		if key == "" || valid == nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "missing or invalid API key"})
+			return
+		}
+		c.Set("APIKey", *valid)
+	}
+}
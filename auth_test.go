@@ -0,0 +1,80 @@
+package gosaic
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestLoadAPIKeys(t *testing.T) {
+	fh, err := ioutil.TempFile("", "keys")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(fh.Name())
+	fh.WriteString("# clients\nfirst\n\n  second rate=0 quota=5 \n#third\n")
+	fh.Close()
+
+	keys, err := LoadAPIKeys(fh.Name(), KeyLimits{RateLimit: 60, Quota: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []APIKey{
+		{Key: "first", KeyLimits: KeyLimits{RateLimit: 60, Quota: 10}},
+		{Key: "second", KeyLimits: KeyLimits{RateLimit: 0, Quota: 5}},
+	}
+	if !reflect.DeepEqual(keys, want) {
+		t.Errorf("LoadAPIKeys returned %+v, want %+v", keys, want)
+	}
+
+	if _, err := LoadAPIKeys(fh.Name()+".missing", KeyLimits{}); err == nil {
+		t.Error("LoadAPIKeys of a missing file didn't fail")
+	}
+
+	for _, line := range []string{"key rate", "key rate=x", "key quota=-1", "key burst=5"} {
+		ioutil.WriteFile(fh.Name(), []byte(line), 0644)
+		if _, err := LoadAPIKeys(fh.Name(), KeyLimits{}); err == nil {
+			t.Errorf("LoadAPIKeys of %q didn't fail", line)
+		}
+	}
+}
+
+func TestAPIKeyAuth(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/ping", func(c *gin.Context) { c.Status(http.StatusOK) })
+	routes := router.Group("/", apiKeyAuth([]APIKey{{Key: "first"}, {Key: "second"}}))
+	routes.GET("/labels", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	tests := []struct {
+		path   string
+		header string
+		value  string
+		want   int
+	}{
+		{"/ping", "", "", http.StatusOK},
+		{"/labels", "", "", http.StatusUnauthorized},
+		{"/labels", APIKeyHeader, "first", http.StatusOK},
+		{"/labels", APIKeyHeader, "wrong", http.StatusUnauthorized},
+		{"/labels", "Authorization", "Bearer second", http.StatusOK},
+		{"/labels", "Authorization", "Basic second", http.StatusUnauthorized},
+		{"/labels?api_key=second", "", "", http.StatusOK},
+		{"/labels?api_key=", "", "", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+		if tt.header != "" {
+			req.Header.Set(tt.header, tt.value)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != tt.want {
+			t.Errorf("%s with %s %q answered %d, want %d", tt.path, tt.header, tt.value, w.Code, tt.want)
+		}
+	}
+}
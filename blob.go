@@ -0,0 +1,416 @@
+package gosaic
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Schemes of the blob stores tiles are read from. Google Cloud Storage is
+// read through its S3 compatible API with HMAC keys.
+const (
+	SchemeS3  = "s3://"
+	SchemeGCS = "gs://"
+)
+
+const (
+	gcsEndpoint = "https://storage.googleapis.com"
+	blobTimeout = 60 * time.Second
+	// emptySHA256 is the hash of an empty payload
+	emptySHA256 = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+)
+
+// IsBlobURL tells if name is an object or prefix in S3 or Google Cloud
+// Storage
+func IsBlobURL(name string) bool {
+	return strings.HasPrefix(name, SchemeS3) || strings.HasPrefix(name, SchemeGCS)
+}
+
+// schemeAzure is Azure Blob Storage, which needs its own API and signing
+// and isn't supported yet
+const schemeAzure = "az://"
+
+// checkBlobURL returns an error for URLs of blob stores which aren't
+// supported, instead of taking them for local paths
+func checkBlobURL(name string) error {
+	if strings.HasPrefix(name, schemeAzure) {
+		return fmt.Errorf("%s: Azure Blob Storage isn't supported, only s3:// and gs://", name)
+	}
+	return nil
+}
+
+// blobStore reads and writes the objects of a bucket. The credentials are
+// taken from AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and
+// AWS_SESSION_TOKEN, the region from AWS_REGION and a custom endpoint,
+// like MinIO, from AWS_ENDPOINT_URL. Without credentials the bucket is
+// accessed anonymously.
+type blobStore struct {
+	bucket    string
+	endpoint  *url.URL
+	pathStyle bool
+	region    string
+	accessKey string
+	secretKey string
+	token     string
+	client    *http.Client
+}
+
+// newBlobStore returns the store of the bucket with the given scheme
+func newBlobStore(scheme, bucket string) (*blobStore, error) {
+	s := &blobStore{
+		bucket:    bucket,
+		region:    orDefault(os.Getenv("AWS_REGION"), "us-east-1"),
+		accessKey: os.Getenv("AWS_ACCESS_KEY_ID"),
+		secretKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		token:     os.Getenv("AWS_SESSION_TOKEN"),
+		client:    &http.Client{Timeout: blobTimeout},
+	}
+
+	endpoint := os.Getenv("AWS_ENDPOINT_URL")
+	switch {
+	case scheme == SchemeGCS:
+		endpoint, s.region, s.pathStyle = gcsEndpoint, "auto", true
+	case endpoint != "":
+		s.pathStyle = true
+	default:
+		endpoint = fmt.Sprintf("https://%s.s3.%s.amazonaws.com", bucket, s.region)
+	}
+
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	s.endpoint = u
+	return s, nil
+}
+
+// s3Escape encodes s for the path of S3 URLs, everything but the
+// unreserved characters and slashes is escaped
+func s3Escape(s string) string {
+	var sb strings.Builder
+	for _, b := range []byte(s) {
+		if 'A' <= b && b <= 'Z' || 'a' <= b && b <= 'z' || '0' <= b && b <= '9' || strings.IndexByte("-._~/", b) >= 0 {
+			sb.WriteByte(b)
+		} else {
+			fmt.Fprintf(&sb, "%%%02X", b)
+		}
+	}
+	return sb.String()
+}
+
+// objectURL returns the URL of the object key with the query
+func (s *blobStore) objectURL(key string, query url.Values) *url.URL {
+	u := *s.endpoint
+	u.Path = "/" + key
+	if s.pathStyle {
+		u.Path = "/" + s.bucket + "/" + key
+	}
+	u.RawPath = s3Escape(u.Path)
+	u.RawQuery = strings.Replace(query.Encode(), "+", "%20", -1)
+	return &u
+}
+
+// get requests the object key with the query
+func (s *blobStore) get(key string, query url.Values) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodGet, s.objectURL(key, query).String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	s.sign(req, emptySHA256)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s/%s: %s", s.bucket, key, resp.Status)
+	}
+	return data, nil
+}
+
+// put uploads data as the object key
+func (s *blobStore) put(key string, data []byte, contentType string) error {
+	req, err := http.NewRequest(http.MethodPut, s.objectURL(key, nil).String(), bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	hash := sha256.Sum256(data)
+	s.sign(req, hex.EncodeToString(hash[:]))
+	return s.do(req, key)
+}
+
+// remove deletes the object key
+func (s *blobStore) remove(key string) error {
+	req, err := http.NewRequest(http.MethodDelete, s.objectURL(key, nil).String(), nil)
+	if err != nil {
+		return err
+	}
+	s.sign(req, emptySHA256)
+	return s.do(req, key)
+}
+
+// do sends a request without a response body to the object key
+func (s *blobStore) do(req *http.Request, key string) error {
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%s/%s: %s", s.bucket, key, resp.Status)
+	}
+	return nil
+}
+
+// sign signs req with the credentials of the store, if it has any, for a
+// payload with the given hash
+func (s *blobStore) sign(req *http.Request, payload string) {
+	if s.accessKey == "" {
+		return
+	}
+	req.Header.Set("x-amz-content-sha256", payload)
+	if s.token != "" {
+		req.Header.Set("x-amz-security-token", s.token)
+	}
+	signV4(req, s.accessKey, s.secretKey, s.region, time.Now())
+}
+
+// presign returns a URL of the object key which can be downloaded without
+// credentials for expires from t on. Buckets without credentials are
+// public and get the plain URL.
+func (s *blobStore) presign(key string, expires time.Duration, t time.Time) string {
+	if s.accessKey == "" {
+		return s.objectURL(key, nil).String()
+	}
+	t = t.UTC()
+	scope := t.Format("20060102") + "/" + s.region + "/s3/aws4_request"
+	query := url.Values{
+		"X-Amz-Algorithm":     {"AWS4-HMAC-SHA256"},
+		"X-Amz-Credential":    {s.accessKey + "/" + scope},
+		"X-Amz-Date":          {t.Format("20060102T150405Z")},
+		"X-Amz-Expires":       {fmt.Sprint(int(expires.Seconds()))},
+		"X-Amz-SignedHeaders": {"host"},
+	}
+	if s.token != "" {
+		query.Set("X-Amz-Security-Token", s.token)
+	}
+	u := s.objectURL(key, query)
+
+	canonical := strings.Join([]string{http.MethodGet, u.EscapedPath(), u.RawQuery, "host:" + u.Host + "\n", "host", "UNSIGNED-PAYLOAD"}, "\n")
+	u.RawQuery += "&X-Amz-Signature=" + signatureV4(canonical, s.secretKey, s.region, t)
+	return u.String()
+}
+
+// listBucketResult is the response of ListObjectsV2
+type listBucketResult struct {
+	Contents []struct {
+		Key string
+	}
+	IsTruncated           bool
+	NextContinuationToken string
+}
+
+// list returns the keys of the images below prefix
+func (s *blobStore) list(prefix string) ([]string, error) {
+	keys := []string{}
+	query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+	for {
+		data, err := s.get("", query)
+		if err != nil {
+			return nil, err
+		}
+		var result listBucketResult
+		if err := xml.Unmarshal(data, &result); err != nil {
+			return nil, err
+		}
+		for _, c := range result.Contents {
+			if isArchiveImage(c.Key) {
+				keys = append(keys, c.Key)
+			}
+		}
+		if !result.IsTruncated {
+			return keys, nil
+		}
+		query.Set("continuation-token", result.NextContinuationToken)
+	}
+}
+
+// signV4 signs the request with AWS signature version 4 for S3. The host,
+// the range and all x-amz headers are signed.
+func signV4(req *http.Request, accessKey, secretKey, region string, t time.Time) {
+	t = t.UTC()
+	date := t.Format("20060102")
+	req.Header.Set("x-amz-date", t.Format("20060102T150405Z"))
+
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		k = strings.ToLower(k)
+		if strings.HasPrefix(k, "x-amz-") || k == "range" {
+			headers[k] = strings.TrimSpace(strings.Join(v, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for k := range headers {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	canonicalHeaders := ""
+	for _, k := range names {
+		canonicalHeaders += k + ":" + headers[k] + "\n"
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	// the query is sorted by key and encoded with %20 for spaces
+	query := strings.Replace(req.URL.Query().Encode(), "+", "%20", -1)
+	payload := orDefault(req.Header.Get("x-amz-content-sha256"), emptySHA256)
+	canonical := strings.Join([]string{req.Method, req.URL.EscapedPath(), query, canonicalHeaders, signedHeaders, payload}, "\n")
+
+	scope := date + "/" + region + "/s3/aws4_request"
+	signature := signatureV4(canonical, secretKey, region, t)
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s,SignedHeaders=%s,Signature=%s", accessKey, scope, signedHeaders, signature))
+}
+
+// signatureV4 returns the AWS signature version 4 of the canonical request
+// at t (UTC)
+func signatureV4(canonical, secretKey, region string, t time.Time) string {
+	date := t.Format("20060102")
+	hash := sha256.Sum256([]byte(canonical))
+	scope := date + "/" + region + "/s3/aws4_request"
+	toSign := "AWS4-HMAC-SHA256\n" + t.Format("20060102T150405Z") + "\n" + scope + "\n" + hex.EncodeToString(hash[:])
+
+	key := []byte("AWS4" + secretKey)
+	for _, part := range []string{date, region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	return hex.EncodeToString(hmacSHA256(key, toSign))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// splitBlobURL splits a blob URL like s3://bucket/prefix into its scheme,
+// bucket and key
+func splitBlobURL(name string) (scheme, bucket, key string) {
+	scheme = SchemeS3
+	if strings.HasPrefix(name, SchemeGCS) {
+		scheme = SchemeGCS
+	}
+	rest := strings.TrimPrefix(name, scheme)
+	parts := strings.SplitN(rest, "/", 2)
+	if len(parts) == 2 {
+		key = parts[1]
+	}
+	return scheme, parts[0], key
+}
+
+// blobCache keeps a store per bucket
+type blobCache struct {
+	mutex  sync.Mutex
+	stores map[string]*blobStore
+}
+
+// get returns the store of the bucket of the blob URL name and the key of
+// the object
+func (c *blobCache) get(name string) (*blobStore, string, error) {
+	scheme, bucket, key := splitBlobURL(name)
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if s, ok := c.stores[scheme+bucket]; ok {
+		return s, key, nil
+	}
+	s, err := newBlobStore(scheme, bucket)
+	if err != nil {
+		return nil, "", err
+	}
+	if c.stores == nil {
+		c.stores = map[string]*blobStore{}
+	}
+	c.stores[scheme+bucket] = s
+	return s, key, nil
+}
+
+// blobTileNames lists the images below the blob URL prefix
+func (g *Gosaic) blobTileNames(prefix string) ([]string, error) {
+	s, key, err := g.blobs.get(prefix)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := s.list(key)
+	if err != nil {
+		return nil, err
+	}
+
+	scheme, bucket, _ := splitBlobURL(prefix)
+	names := make([]string, len(keys))
+	for i, k := range keys {
+		names[i] = scheme + bucket + "/" + k
+	}
+	return names, nil
+}
+
+// readBlob reads the object of the blob URL name
+func (g *Gosaic) readBlob(name string) ([]byte, error) {
+	return g.readCached(name, func() ([]byte, error) {
+		s, key, err := g.blobs.get(name)
+		if err != nil {
+			return nil, err
+		}
+		return s.get(key, nil)
+	})
+}
+
+// readCached returns the data of the tile name fetched with fetch. With a
+// TilesCache the tile is only fetched once and read from the cache
+// afterwards.
+func (g *Gosaic) readCached(name string, fetch func() ([]byte, error)) ([]byte, error) {
+	var cached string
+	if g.config.TilesCache != "" {
+		sum := sha1.Sum([]byte(name))
+		cached = filepath.Join(g.config.TilesCache, hex.EncodeToString(sum[:])+path.Ext(strings.SplitN(name, "?", 2)[0]))
+		if data, err := ioutil.ReadFile(cached); err == nil {
+			return data, nil
+		}
+	}
+
+	data, err := fetch()
+	if err != nil {
+		return nil, err
+	}
+
+	if cached != "" {
+		if err := os.MkdirAll(g.config.TilesCache, 0755); err != nil {
+			return nil, err
+		}
+		if err := ioutil.WriteFile(cached, data, 0644); err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
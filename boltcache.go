@@ -0,0 +1,188 @@
+package gosaic
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// SchemeBoltCache selects the embedded bbolt cache, like bolt:tiles.db
+const SchemeBoltCache = "bolt:"
+
+// boltOpenTimeout limits how long opening a bbolt file waits for another
+// process holding it
+const boltOpenTimeout = 2 * time.Second
+
+// boltCache is the TileCache in a bbolt file. The tiles of a label in a
+// size are kept in a bucket named like the index set of the Redis cache,
+// each gob encoded under its name. Unlike the file cache, space of
+// replaced and removed tiles is reused and writes are transactional.
+type boltCache struct {
+	file *boltFile
+	db   *bolt.DB
+}
+
+// boltFile is a bbolt file opened by the process, which bbolt locks
+// against being opened again
+type boltFile struct {
+	db   *bolt.DB
+	refs int
+}
+
+// boltFiles are the bbolt files the process has open by their path, so
+// the builds and requests running at the same time share them
+var boltFiles = struct {
+	mutex sync.Mutex
+	files map[string]*boltFile
+}{files: map[string]*boltFile{}}
+
+// OpenBoltCache opens the bbolt cache file filename, creating it if it
+// doesn't exist. Other processes can't open the file until it is closed.
+func OpenBoltCache(filename string) (TileCache, error) {
+	path, err := filepath.Abs(filename)
+	if err != nil {
+		return nil, err
+	}
+	boltFiles.mutex.Lock()
+	defer boltFiles.mutex.Unlock()
+
+	f, ok := boltFiles.files[path]
+	if !ok {
+		db, err := bolt.Open(path, 0644, &bolt.Options{Timeout: boltOpenTimeout})
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", filename, err)
+		}
+		f = &boltFile{db: db}
+		boltFiles.files[path] = f
+	}
+	f.refs++
+	return &boltCache{file: f, db: f.db}, nil
+}
+
+func (c *boltCache) Index() ([]CacheIndex, error) {
+	index := []CacheIndex{}
+	err := c.db.View(func(tx *bolt.Tx) error {
+		return tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+			label, size, ok := parseIndexKey(string(name))
+			if !ok {
+				return nil
+			}
+			index = append(index, CacheIndex{Label: label, Size: size, Count: b.Stats().KeyN})
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	sortIndex(index)
+	return index, nil
+}
+
+func (c *boltCache) Names(label string, size int) ([]string, error) {
+	names := []string{}
+	err := c.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(indexKey(label, size)))
+		if b == nil {
+			return nil
+		}
+		// the keys are sorted
+		return b.ForEach(func(k, _ []byte) error {
+			names = append(names, string(k))
+			return nil
+		})
+	})
+	return names, err
+}
+
+// Get decodes the tiles, expired tiles are removed
+func (c *boltCache) Get(label string, size int, names []string) ([]*CachedTile, error) {
+	now := time.Now().Unix()
+	tiles := make([]*CachedTile, len(names))
+	expired := []string{}
+	err := c.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(indexKey(label, size)))
+		if b == nil {
+			return nil
+		}
+		for i, name := range names {
+			data := b.Get([]byte(name))
+			if data == nil {
+				continue
+			}
+			tile := &CachedTile{}
+			if err := gob.NewDecoder(bytes.NewReader(data)).Decode(tile); err != nil {
+				return fmt.Errorf("%s: %s", name, err)
+			}
+			if tile.Expires != 0 && tile.Expires <= now {
+				expired = append(expired, name)
+				continue
+			}
+			tiles[i] = tile
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(expired) > 0 {
+		if err := c.Remove(label, size, expired); err != nil {
+			return nil, err
+		}
+	}
+	return tiles, nil
+}
+
+func (c *boltCache) Set(tile *CachedTile) error {
+	buf := bytes.NewBuffer([]byte{})
+	if err := gob.NewEncoder(buf).Encode(tile); err != nil {
+		return err
+	}
+	return c.db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(indexKey(tile.Label, tile.Size)))
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(tile.Name), buf.Bytes())
+	})
+}
+
+// Remove deletes the tiles, and the bucket of label in size once it is
+// empty
+func (c *boltCache) Remove(label string, size int, names []string) error {
+	if len(names) == 0 {
+		return nil
+	}
+	key := []byte(indexKey(label, size))
+	return c.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(key)
+		if b == nil {
+			return nil
+		}
+		for _, name := range names {
+			if err := b.Delete([]byte(name)); err != nil {
+				return err
+			}
+		}
+		if k, _ := b.Cursor().First(); k == nil {
+			return tx.DeleteBucket(key)
+		}
+		return nil
+	})
+}
+
+// Close closes the file once no other cache of the process uses it
+func (c *boltCache) Close() error {
+	boltFiles.mutex.Lock()
+	defer boltFiles.mutex.Unlock()
+	c.file.refs--
+	if c.file.refs > 0 {
+		return nil
+	}
+	delete(boltFiles.files, c.db.Path())
+	return c.db.Close()
+}
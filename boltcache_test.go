@@ -0,0 +1,88 @@
+package gosaic
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestBoltCache(t *testing.T) {
+	spec := SchemeBoltCache + filepath.Join(t.TempDir(), "tiles.db")
+	c, err := OpenCache(spec, RedisOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expired := cachedTile("city", 20, "old.jpg")
+	expired.Expires = time.Now().Add(-time.Minute).Unix()
+	tiles := []*CachedTile{
+		cachedTile("beach", 20, "a.jpg"),
+		cachedTile("beach", 100, "a.jpg"),
+		cachedTile("beach", 20, "b.jpg"),
+		cachedTile("trip:2021", 20, "c.jpg"),
+		expired,
+	}
+	for _, tile := range tiles {
+		if err := c.Set(tile); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// a second cache of the process shares the locked file
+	other, err := OpenCache(spec, RedisOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	again := cachedTile("beach", 20, "b.jpg")
+	again.Data = []byte("b again")
+	if err := other.Set(again); err != nil {
+		t.Fatal(err)
+	}
+	other.Close()
+	c.Close()
+
+	c, err = OpenCache(spec, RedisOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	index, err := c.Index()
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantIndex := []CacheIndex{{"beach", 20, 2}, {"beach", 100, 1}, {"city", 20, 1}, {"trip:2021", 20, 1}}
+	if !reflect.DeepEqual(index, wantIndex) {
+		t.Errorf("Index() = %v, want %v", index, wantIndex)
+	}
+	if names, err := c.Names("beach", 20); err != nil || !reflect.DeepEqual(names, []string{"a.jpg", "b.jpg"}) {
+		t.Errorf("Names() = %v, %v, want [a.jpg b.jpg]", names, err)
+	}
+
+	got, err := c.Get("beach", 20, []string{"a.jpg", "missing.jpg", "b.jpg"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got[0], tiles[0]) {
+		t.Errorf("Get(a.jpg) = %+v, want %+v", got[0], tiles[0])
+	}
+	if got[1] != nil {
+		t.Errorf("Get(missing.jpg) = %+v, want nil", got[1])
+	}
+	if string(got[2].Data) != "b again" {
+		t.Errorf("Get(b.jpg) = %q, want the tile set last", got[2].Data)
+	}
+
+	// expired tiles are removed with their label once it is empty
+	if got, err := c.Get("city", 20, []string{"old.jpg"}); err != nil || got[0] != nil {
+		t.Errorf("Get(old.jpg) = %v, %v, want no tile", got, err)
+	}
+	if err := c.Remove("beach", 100, []string{"a.jpg"}); err != nil {
+		t.Fatal(err)
+	}
+	index, _ = c.Index()
+	if want := []CacheIndex{{"beach", 20, 2}, {"trip:2021", 20, 1}}; !reflect.DeepEqual(index, want) {
+		t.Errorf("Index() after the removals = %v, want %v", index, want)
+	}
+}
@@ -0,0 +1,951 @@
+package gosaic
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"io"
+	"math"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	redis "github.com/go-redis/redis/v8"
+	log "github.com/sirupsen/logrus"
+)
+
+// SchemeFileCache selects the embedded file cache, like file:tiles.db
+const SchemeFileCache = "file:"
+
+// embeddedCache tells if spec is a cache file rather than a Redis server
+func embeddedCache(spec string) bool {
+	return strings.HasPrefix(spec, SchemeFileCache) || strings.HasPrefix(spec, SchemeBoltCache)
+}
+
+// schemeSQLite was asked for as an embedded cache, which the file cache
+// provides without a SQLite library
+const schemeSQLite = "sqlite:"
+
+const (
+	// cachePingTimeout limits how long connecting to Redis may take
+	cachePingTimeout = 2 * time.Second
+	// redisBatchSize is the number of tiles fetched per pipeline
+	redisBatchSize = 100
+)
+
+// CacheEncoding is the format and quality tiles are cached in
+type CacheEncoding struct {
+	Format  string
+	Quality int
+}
+
+// DefaultCacheEncoding caches tiles as JPEGs of quality 90
+var DefaultCacheEncoding = CacheEncoding{Format: FormatJPEG, Quality: 90}
+
+// Check tells if tiles can be cached in the format and quality. JPEG,
+// PNG and WebP are supported, WebP tiles are about half the size of JPEGs
+// of the same quality.
+func (e CacheEncoding) Check() error {
+	switch e.Format {
+	case FormatJPEG, FormatPNG, FormatWebP:
+	default:
+		return fmt.Errorf("tiles can't be cached as %q, only as jpeg, png or webp", e.Format)
+	}
+	if e.Quality < 1 || e.Quality > 100 {
+		return fmt.Errorf("cache quality %d is not in 1..100", e.Quality)
+	}
+	return nil
+}
+
+// ErrNotCached is returned for tiles which aren't cached
+var ErrNotCached = errors.New("tile is not cached")
+
+// CachedTile is a tile imported into the cache with its metadata. The
+// tiles of a label are imported in one or more sizes and named after the
+// content hash of their file, so files with the same name in different
+// directories don't collide. The path of the file is kept as Source.
+type CachedTile struct {
+	Label   string
+	Size    int
+	Name    string
+	Data    []byte // the JPEG, PNG or WebP of the tile
+	Average float64
+	Width   int
+	Height  int
+	Source  string // the path of the imported file
+	// Modified is the modification time of Source in Unix nanoseconds
+	Modified int64
+	// Expires is the Unix time the tile expires at, 0 if it doesn't
+	Expires int64
+	PHash   uint64 // the difference hash of the tile
+	Tags    []string
+	// Features are the mean colors of a grid over the tile, so the builder
+	// doesn't compute them for every build
+	Features []float64
+}
+
+// NewCachedTile encodes img, the tile of the file source scaled to size,
+// for the cache with enc. The tile is named name, the FileHash of source.
+func NewCachedTile(label string, size int, name, source string, img image.Image, avg float64, enc CacheEncoding) (*CachedTile, error) {
+	if err := enc.Check(); err != nil {
+		return nil, err
+	}
+	data, err := encodeImage(img, enc.Format, enc.Quality, false)
+	if err != nil {
+		return nil, err
+	}
+	b := img.Bounds()
+	return &CachedTile{
+		Label:    label,
+		Size:     size,
+		Name:     name,
+		Data:     data,
+		Average:  avg,
+		Width:    b.Dx(),
+		Height:   b.Dy(),
+		Source:   source,
+		PHash:    dHash(img),
+		Features: featureVector(img),
+	}, nil
+}
+
+// NewCachedTiles encodes img, the tile of the file source at the largest
+// of sizes with the average avg, for the cache in each of sizes with enc
+// under name. The
+// smaller sizes are scaled from img, so the file is only decoded once.
+func NewCachedTiles(label string, sizes []int, name, source string, img image.Image, avg float64, enc CacheEncoding) ([]*CachedTile, error) {
+	tiles := make([]*CachedTile, 0, len(sizes))
+	for _, size := range sizes {
+		scaled, scaledAvg := img, avg
+		if b := img.Bounds(); size != b.Dx() || size != b.Dy() {
+			scaled = scaleImage(img, size, size)
+			mean := meanColor(scaled)
+			scaledAvg = (mean[0] + mean[1] + mean[2]) / 3 / 0x101
+		}
+		tile, err := NewCachedTile(label, size, name, source, scaled, scaledAvg, enc)
+		if err != nil {
+			return nil, err
+		}
+		tiles = append(tiles, tile)
+	}
+	return tiles, nil
+}
+
+// FileHash returns the hex SHA-1 of the content of filename, which names
+// its tiles in the cache
+func FileHash(filename string) (string, error) {
+	fh, err := os.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer fh.Close()
+
+	h := sha1.New()
+	if _, err := io.Copy(h, fh); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// CacheIndex is the number of tiles of a label in one size
+type CacheIndex struct {
+	Label string `json:"label"`
+	Size  int    `json:"size"`
+	Count int    `json:"count"`
+}
+
+// TileCache stores the tiles imported by redisimport by their label, size
+// and name, the content hash of their file.
+type TileCache interface {
+	// Index returns the labels and sizes of the tiles with their counts
+	Index() ([]CacheIndex, error)
+	// Names returns the names of the tiles of label in size
+	Names(label string, size int) ([]string, error)
+	// Get returns the tiles of label in size with the names, nil for tiles
+	// which aren't cached
+	Get(label string, size int, names []string) ([]*CachedTile, error)
+	Set(tile *CachedTile) error
+	// Remove removes the tiles of label in size with the names
+	Remove(label string, size int, names []string) error
+	Close() error
+}
+
+// RedisOptions are the password, database and TLS of a Redis cache. They
+// take precedence over those of a redis:// URL.
+type RedisOptions struct {
+	Password string
+	DB       int
+	TLS      bool
+}
+
+// RedisEnv returns the RedisOptions of the environment variables
+// REDIS_PASSWORD, REDIS_DB and REDIS_TLS
+func RedisEnv() RedisOptions {
+	db, _ := strconv.Atoi(os.Getenv("REDIS_DB"))
+	useTLS, _ := strconv.ParseBool(os.Getenv("REDIS_TLS"))
+	return RedisOptions{Password: os.Getenv("REDIS_PASSWORD"), DB: db, TLS: useTLS}
+}
+
+// OpenCache opens the tile cache of spec: the embedded file cache of a
+// file:path spec, the bbolt file of a bolt:path spec, or else the Redis
+// server of a redis:// or rediss:// URL or an address like localhost:6379.
+func OpenCache(spec string, ro RedisOptions) (TileCache, error) {
+	if strings.HasPrefix(spec, SchemeFileCache) {
+		return OpenFileCache(strings.TrimPrefix(spec, SchemeFileCache))
+	}
+	if strings.HasPrefix(spec, SchemeBoltCache) {
+		return OpenBoltCache(strings.TrimPrefix(spec, SchemeBoltCache))
+	}
+	if strings.HasPrefix(spec, schemeSQLite) {
+		return nil, fmt.Errorf("SQLite caches aren't supported, use the embedded file cache %s%s", SchemeFileCache, strings.TrimPrefix(spec, schemeSQLite))
+	}
+	rdb, err := connectRedis(spec, ro)
+	if err != nil {
+		return nil, err
+	}
+	return &redisCache{rdb: rdb}, nil
+}
+
+// connectRedis connects to the Redis server of spec
+func connectRedis(spec string, ro RedisOptions) (*redis.Client, error) {
+	opts := &redis.Options{Addr: spec}
+	if strings.Contains(spec, "://") {
+		var err error
+		if opts, err = redis.ParseURL(spec); err != nil {
+			return nil, err
+		}
+	}
+	if ro.Password != "" {
+		opts.Password = ro.Password
+	}
+	if ro.DB != 0 {
+		opts.DB = ro.DB
+	}
+	if ro.TLS && opts.TLSConfig == nil {
+		host, _, err := net.SplitHostPort(opts.Addr)
+		if err != nil {
+			return nil, err
+		}
+		opts.TLSConfig = &tls.Config{ServerName: host, MinVersion: tls.VersionTLS12}
+	}
+	rdb := redis.NewClient(opts)
+
+	ctx, cancel := context.WithTimeout(context.Background(), cachePingTimeout)
+	defer cancel()
+	if err := rdb.Ping(ctx).Err(); err != nil {
+		rdb.Close()
+		return nil, err
+	}
+	return rdb, nil
+}
+
+// tileKey returns the key of the tile name of label in size
+func tileKey(label string, size int, name string) string {
+	return fmt.Sprintf("tile:%s:%d:%s", label, size, name)
+}
+
+// indexKey returns the key of the set of the names of the tiles of label
+// in size
+func indexKey(label string, size int) string {
+	return fmt.Sprintf("index:%s:%d", label, size)
+}
+
+// parseIndexKey returns the label and size of an index key
+func parseIndexKey(key string) (string, int, bool) {
+	rest := strings.TrimPrefix(key, "index:")
+	i := strings.LastIndex(rest, ":")
+	if i < 0 || rest == key {
+		return "", 0, false
+	}
+	size, err := strconv.Atoi(rest[i+1:])
+	if err != nil {
+		return "", 0, false
+	}
+	return rest[:i], size, true
+}
+
+// redisCache is the TileCache in a Redis server. Every tile is a hash of
+// its data and metadata, and the names of the tiles of a label in a size
+// are kept in an index set.
+type redisCache struct {
+	rdb *redis.Client
+}
+
+func (c *redisCache) Index() ([]CacheIndex, error) {
+	ctx := context.Background()
+	index := []CacheIndex{}
+	iter := c.rdb.Scan(ctx, 0, "index:*", 1000).Iterator()
+	for iter.Next(ctx) {
+		label, size, ok := parseIndexKey(iter.Val())
+		if !ok {
+			continue
+		}
+		n, err := c.rdb.SCard(ctx, iter.Val()).Result()
+		if err != nil {
+			return nil, err
+		}
+		index = append(index, CacheIndex{Label: label, Size: size, Count: int(n)})
+	}
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+	sortIndex(index)
+	return index, nil
+}
+
+func (c *redisCache) Names(label string, size int) ([]string, error) {
+	names, err := c.rdb.SMembers(context.Background(), indexKey(label, size)).Result()
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Get fetches the hashes of the tiles in batches sent in one pipeline.
+// The names of expired tiles are removed from the index.
+func (c *redisCache) Get(label string, size int, names []string) ([]*CachedTile, error) {
+	ctx := context.Background()
+	tiles := make([]*CachedTile, 0, len(names))
+	expired := []interface{}{}
+	for i := 0; i < len(names); i += redisBatchSize {
+		end := i + redisBatchSize
+		if end > len(names) {
+			end = len(names)
+		}
+
+		pipe := c.rdb.Pipeline()
+		cmds := make([]*redis.StringStringMapCmd, end-i)
+		for j, name := range names[i:end] {
+			cmds[j] = pipe.HGetAll(ctx, tileKey(label, size, name))
+		}
+		if _, err := pipe.Exec(ctx); err != nil {
+			return nil, err
+		}
+		for j, cmd := range cmds {
+			tile, err := tileFromHash(label, size, names[i+j], cmd.Val())
+			if err != nil {
+				return nil, err
+			}
+			if tile == nil {
+				expired = append(expired, names[i+j])
+			}
+			tiles = append(tiles, tile)
+		}
+	}
+	if len(expired) > 0 {
+		if err := c.rdb.SRem(ctx, indexKey(label, size), expired...).Err(); err != nil {
+			return nil, err
+		}
+	}
+	return tiles, nil
+}
+
+// Set stores the hash of the tile and adds it to the index of its label.
+// Redis removes the hash when the tile expires.
+func (c *redisCache) Set(tile *CachedTile) error {
+	ctx := context.Background()
+	key := tileKey(tile.Label, tile.Size, tile.Name)
+	pipe := c.rdb.TxPipeline()
+	pipe.Del(ctx, key)
+	pipe.HSet(ctx, key, tileHash(tile))
+	if tile.Expires != 0 {
+		pipe.ExpireAt(ctx, key, time.Unix(tile.Expires, 0))
+	}
+	pipe.SAdd(ctx, indexKey(tile.Label, tile.Size), tile.Name)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// Remove deletes the hashes of the tiles and their names from the index.
+// The hashes are unlinked, so Redis frees their memory in the background.
+func (c *redisCache) Remove(label string, size int, names []string) error {
+	if len(names) == 0 {
+		return nil
+	}
+	ctx := context.Background()
+	keys := make([]string, len(names))
+	members := make([]interface{}, len(names))
+	for i, name := range names {
+		keys[i] = tileKey(label, size, name)
+		members[i] = name
+	}
+
+	pipe := c.rdb.TxPipeline()
+	pipe.Unlink(ctx, keys...)
+	pipe.SRem(ctx, indexKey(label, size), members...)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func (c *redisCache) Close() error {
+	return c.rdb.Close()
+}
+
+// tileHash returns the fields of the hash of tile
+func tileHash(tile *CachedTile) map[string]interface{} {
+	return map[string]interface{}{
+		"bytes":    tile.Data,
+		"average":  strconv.FormatFloat(tile.Average, 'f', -1, 64),
+		"width":    tile.Width,
+		"height":   tile.Height,
+		"source":   tile.Source,
+		"modified": strconv.FormatInt(tile.Modified, 10),
+		"expires":  strconv.FormatInt(tile.Expires, 10),
+		"phash":    strconv.FormatUint(tile.PHash, 16),
+		"tags":     strings.Join(tile.Tags, ","),
+		"features": packFeatures(tile.Features),
+	}
+}
+
+// packFeatures quantizes the features, which are between 0 and 1, to a
+// byte each
+func packFeatures(features []float64) []byte {
+	packed := make([]byte, len(features))
+	for i, f := range features {
+		packed[i] = uint8(math.Round(math.Max(0, math.Min(1, f)) * 255))
+	}
+	return packed
+}
+
+// unpackFeatures returns the features of packFeatures, nil if there are
+// none
+func unpackFeatures(packed []byte) []float64 {
+	if len(packed) == 0 {
+		return nil
+	}
+	features := make([]float64, len(packed))
+	for i, b := range packed {
+		features[i] = float64(b) / 255
+	}
+	return features
+}
+
+// tileFromHash returns the tile of the fields of its hash, nil if there
+// are none
+func tileFromHash(label string, size int, name string, fields map[string]string) (*CachedTile, error) {
+	if len(fields) == 0 {
+		return nil, nil
+	}
+	tile := &CachedTile{
+		Label:  label,
+		Size:   size,
+		Name:   name,
+		Data:   []byte(fields["bytes"]),
+		Source: fields["source"],
+	}
+
+	var err error
+	if tile.Average, err = strconv.ParseFloat(fields["average"], 64); err != nil {
+		return nil, fmt.Errorf("%s: average: %s", name, err)
+	}
+	if tile.Width, err = strconv.Atoi(fields["width"]); err != nil {
+		return nil, fmt.Errorf("%s: width: %s", name, err)
+	}
+	if tile.Height, err = strconv.Atoi(fields["height"]); err != nil {
+		return nil, fmt.Errorf("%s: height: %s", name, err)
+	}
+	// tiles imported before the modification time was stored have none
+	if m := fields["modified"]; m != "" {
+		if tile.Modified, err = strconv.ParseInt(m, 10, 64); err != nil {
+			return nil, fmt.Errorf("%s: modified: %s", name, err)
+		}
+	}
+	if e := fields["expires"]; e != "" {
+		if tile.Expires, err = strconv.ParseInt(e, 10, 64); err != nil {
+			return nil, fmt.Errorf("%s: expires: %s", name, err)
+		}
+	}
+	if tile.PHash, err = strconv.ParseUint(fields["phash"], 16, 64); err != nil {
+		return nil, fmt.Errorf("%s: phash: %s", name, err)
+	}
+	if fields["tags"] != "" {
+		tile.Tags = strings.Split(fields["tags"], ",")
+	}
+	tile.Features = unpackFeatures([]byte(fields["features"]))
+	return tile, nil
+}
+
+// sortIndex orders the index by label and size
+func sortIndex(index []CacheIndex) {
+	sort.Slice(index, func(i, j int) bool {
+		if index[i].Label != index[j].Label {
+			return index[i].Label < index[j].Label
+		}
+		return index[i].Size < index[j].Size
+	})
+}
+
+// fileCacheMagic starts the files of the embedded cache
+const fileCacheMagic = "gosaic-cache-2\n"
+
+// fileTombstone is the length of the records of removed keys
+const fileTombstone = 0xffffffff
+
+// fileCompactMin is the size in bytes of the outdated records from which
+// on a file cache is compacted once they outnumber the current ones
+const fileCompactMin = 1 << 20
+
+// fileRecord is the position of a value in the file of a fileCache
+type fileRecord struct {
+	offset int64
+	size   uint32
+}
+
+// fileCache is an embedded TileCache in a single file, for installations
+// without a Redis server. The tiles are gob encoded and appended to the
+// file under their tile keys, each preceded by the length of its key and
+// its own length, and indexed in memory when the file is opened. A tile
+// set again is appended and the old one left in the file, a removed tile
+// is marked by a record without a value. Once the outdated records take
+// up more space than the current ones the file is rewritten with the
+// current ones only. The file is not shared between processes.
+type fileCache struct {
+	mutex    sync.RWMutex
+	filename string
+	fh       *os.File
+	size     int64
+	// live is the size of the current records
+	live  int64
+	index map[string]fileRecord
+}
+
+// OpenFileCache opens the cache file filename, creating it if it doesn't
+// exist. A record which was cut off by a crash is dropped.
+func OpenFileCache(filename string) (TileCache, error) {
+	c, err := openFileCache(filename)
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func openFileCache(filename string) (*fileCache, error) {
+	fh, err := os.OpenFile(filename, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	c := &fileCache{filename: filename, fh: fh, index: map[string]fileRecord{}}
+	if err := c.load(); err != nil {
+		fh.Close()
+		return nil, fmt.Errorf("%s: %s", filename, err)
+	}
+	if c.outdated() {
+		if err := c.compact(); err != nil {
+			c.fh.Close()
+			return nil, fmt.Errorf("%s: %s", filename, err)
+		}
+	}
+	return c, nil
+}
+
+// load indexes the records of the file
+func (c *fileCache) load() error {
+	info, err := c.fh.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() == 0 {
+		_, err := c.fh.WriteAt([]byte(fileCacheMagic), 0)
+		c.size = int64(len(fileCacheMagic))
+		return err
+	}
+
+	magic := make([]byte, len(fileCacheMagic))
+	if _, err := c.fh.ReadAt(magic, 0); err != nil || string(magic) != fileCacheMagic {
+		return errors.New("not a gosaic cache file of this version")
+	}
+
+	pos := int64(len(fileCacheMagic))
+	header := make([]byte, 8)
+	for {
+		if _, err := c.fh.ReadAt(header, pos); err != nil {
+			break
+		}
+		keyLen := int64(binary.LittleEndian.Uint32(header))
+		size := binary.LittleEndian.Uint32(header[4:])
+		end := pos + 8 + keyLen
+		if size != fileTombstone {
+			end += int64(size)
+		}
+		if end > info.Size() {
+			break
+		}
+		key := make([]byte, keyLen)
+		if _, err := c.fh.ReadAt(key, pos+8); err != nil {
+			return err
+		}
+		c.drop(string(key))
+		if size != fileTombstone {
+			c.index[string(key)] = fileRecord{offset: pos + 8 + keyLen, size: size}
+			c.live += end - pos
+		}
+		pos = end
+	}
+
+	c.size = pos
+	return c.fh.Truncate(pos)
+}
+
+// drop removes key from the index, the mutex must be held
+func (c *fileCache) drop(key string) {
+	if r, ok := c.index[key]; ok {
+		c.live -= 8 + int64(len(key)) + int64(r.size)
+		delete(c.index, key)
+	}
+}
+
+// outdated tells if the outdated records should be compacted, the mutex
+// must be held
+func (c *fileCache) outdated() bool {
+	dead := c.size - int64(len(fileCacheMagic)) - c.live
+	return dead >= fileCompactMin && dead > c.live
+}
+
+// compact rewrites the file with the current records only, the mutex must
+// be held. The new file replaces the old one once it is complete.
+func (c *fileCache) compact() error {
+	tmp := c.filename + ".compact"
+	fh, err := os.OpenFile(tmp, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	index, size, err := c.copyRecords(fh)
+	if err == nil {
+		err = fh.Sync()
+	}
+	if err != nil {
+		fh.Close()
+		os.Remove(tmp)
+		return err
+	}
+
+	// the old file has to be closed before it is replaced on Windows
+	c.fh.Close()
+	if renameErr := os.Rename(tmp, c.filename); renameErr != nil {
+		fh.Close()
+		os.Remove(tmp)
+		if c.fh, err = os.OpenFile(c.filename, os.O_RDWR, 0644); err != nil {
+			return err
+		}
+		return renameErr
+	}
+	c.fh, c.index, c.size, c.live = fh, index, size, size-int64(len(fileCacheMagic))
+	return nil
+}
+
+// copyRecords writes the current records to fh, returning their index and
+// the size of the file
+func (c *fileCache) copyRecords(fh *os.File) (map[string]fileRecord, int64, error) {
+	if _, err := fh.WriteAt([]byte(fileCacheMagic), 0); err != nil {
+		return nil, 0, err
+	}
+	keys := make([]string, 0, len(c.index))
+	for k := range c.index {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	index := make(map[string]fileRecord, len(keys))
+	pos := int64(len(fileCacheMagic))
+	for _, k := range keys {
+		r := c.index[k]
+		record := make([]byte, 8+len(k)+int(r.size))
+		binary.LittleEndian.PutUint32(record, uint32(len(k)))
+		binary.LittleEndian.PutUint32(record[4:], r.size)
+		copy(record[8:], k)
+		if _, err := c.fh.ReadAt(record[8+len(k):], r.offset); err != nil && err != io.EOF {
+			return nil, 0, err
+		}
+		if _, err := fh.WriteAt(record, pos); err != nil {
+			return nil, 0, err
+		}
+		index[k] = fileRecord{offset: pos + 8 + int64(len(k)), size: r.size}
+		pos += int64(len(record))
+	}
+	return index, pos, nil
+}
+
+// get returns the value of key
+func (c *fileCache) get(key string) ([]byte, error) {
+	// the file may be replaced by a compaction, so it is read with the
+	// index locked
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	r, ok := c.index[key]
+	if !ok {
+		return nil, ErrNotCached
+	}
+
+	data := make([]byte, r.size)
+	if _, err := c.fh.ReadAt(data, r.offset); err != nil && err != io.EOF {
+		return nil, err
+	}
+	return data, nil
+}
+
+// set appends the value of key, a nil value removes the key
+func (c *fileCache) set(key string, data []byte) error {
+	size := uint32(len(data))
+	if data == nil {
+		size = fileTombstone
+	}
+	record := make([]byte, 8, 8+len(key)+len(data))
+	binary.LittleEndian.PutUint32(record, uint32(len(key)))
+	binary.LittleEndian.PutUint32(record[4:], size)
+	record = append(record, key...)
+	record = append(record, data...)
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if _, ok := c.index[key]; !ok && data == nil {
+		return nil
+	}
+	if _, err := c.fh.WriteAt(record, c.size); err != nil {
+		return err
+	}
+	c.drop(key)
+	if data != nil {
+		c.index[key] = fileRecord{offset: c.size + 8 + int64(len(key)), size: size}
+		c.live += int64(len(record))
+	}
+	c.size += int64(len(record))
+
+	// the value is written, so a failed compaction only keeps the file
+	// as it is
+	if c.outdated() {
+		if err := c.compact(); err != nil {
+			log.Warnf("%s: compaction: %s", c.filename, err)
+		}
+	}
+	return nil
+}
+
+// labelEscaper escapes the colons of labels, which separate the parts of
+// the keys of the file cache, and labelUnescaper reverts it. Labels without
+// colons and percent signs keep their keys.
+var (
+	labelEscaper   = strings.NewReplacer("%", "%25", ":", "%3A")
+	labelUnescaper = strings.NewReplacer("%3A", ":", "%25", "%")
+)
+
+// fileTileKey returns the key of the tile name of label in size in the
+// file cache
+func fileTileKey(label string, size int, name string) string {
+	return tileKey(labelEscaper.Replace(label), size, name)
+}
+
+// parseFileTileKey returns the label and size of a key of fileTileKey
+func parseFileTileKey(key string) (string, int, bool) {
+	if !strings.HasPrefix(key, "tile:") {
+		return "", 0, false
+	}
+	parts := strings.SplitN(strings.TrimPrefix(key, "tile:"), ":", 3)
+	if len(parts) < 3 {
+		return "", 0, false
+	}
+	size, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return "", 0, false
+	}
+	return labelUnescaper.Replace(parts[0]), size, true
+}
+
+func (c *fileCache) Index() ([]CacheIndex, error) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	counts := map[CacheIndex]int{}
+	for k := range c.index {
+		label, size, ok := parseFileTileKey(k)
+		if !ok {
+			continue
+		}
+		counts[CacheIndex{Label: label, Size: size}]++
+	}
+
+	index := make([]CacheIndex, 0, len(counts))
+	for ci, n := range counts {
+		ci.Count = n
+		index = append(index, ci)
+	}
+	sortIndex(index)
+	return index, nil
+}
+
+func (c *fileCache) Names(label string, size int) ([]string, error) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	prefix := fileTileKey(label, size, "")
+	names := []string{}
+	for k := range c.index {
+		if strings.HasPrefix(k, prefix) {
+			names = append(names, k[len(prefix):])
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Get decodes the tiles, expired tiles are removed
+func (c *fileCache) Get(label string, size int, names []string) ([]*CachedTile, error) {
+	now := time.Now().Unix()
+	tiles := make([]*CachedTile, len(names))
+	for i, name := range names {
+		data, err := c.get(fileTileKey(label, size, name))
+		if err == ErrNotCached {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		tile := &CachedTile{}
+		if err := gob.NewDecoder(bytes.NewReader(data)).Decode(tile); err != nil {
+			return nil, fmt.Errorf("%s: %s", name, err)
+		}
+		if tile.Expires != 0 && tile.Expires <= now {
+			if err := c.set(fileTileKey(label, size, name), nil); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		tiles[i] = tile
+	}
+	return tiles, nil
+}
+
+func (c *fileCache) Set(tile *CachedTile) error {
+	buf := bytes.NewBuffer([]byte{})
+	if err := gob.NewEncoder(buf).Encode(tile); err != nil {
+		return err
+	}
+	return c.set(fileTileKey(tile.Label, tile.Size, tile.Name), buf.Bytes())
+}
+
+func (c *fileCache) Remove(label string, size int, names []string) error {
+	for _, name := range names {
+		if err := c.set(fileTileKey(label, size, name), nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *fileCache) Close() error {
+	return c.fh.Close()
+}
+
+// CopyCache copies the tiles of src to dst, only those of label if it is
+// set, so a cache can be moved between backends. It returns the number of
+// copied tiles.
+func CopyCache(dst, src TileCache, label string) (int, error) {
+	index, err := src.Index()
+	if err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for _, ci := range index {
+		if label != "" && ci.Label != label {
+			continue
+		}
+		names, err := src.Names(ci.Label, ci.Size)
+		if err != nil {
+			return n, err
+		}
+		for i := 0; i < len(names); i += redisBatchSize {
+			end := i + redisBatchSize
+			if end > len(names) {
+				end = len(names)
+			}
+			tiles, err := src.Get(ci.Label, ci.Size, names[i:end])
+			if err != nil {
+				return n, err
+			}
+			for _, tile := range tiles {
+				// deleted since it was listed
+				if tile == nil {
+					continue
+				}
+				if err := dst.Set(tile); err != nil {
+					return n, fmt.Errorf("%s: %s", tile.Name, err)
+				}
+				n++
+			}
+		}
+	}
+	return n, nil
+}
+
+// CopyLegacyCache copies the tiles which redisimport stored as JPEGs under
+// keys of the form label:size:average:file in the Redis server of spec to
+// dst, only those of label if it is set. It returns the number of copied
+// tiles.
+func CopyLegacyCache(dst TileCache, spec string, ro RedisOptions, label string) (int, error) {
+	rdb, err := connectRedis(spec, ro)
+	if err != nil {
+		return 0, err
+	}
+	defer rdb.Close()
+
+	ctx := context.Background()
+	pattern := "*:*:*:*"
+	if label != "" {
+		pattern = label + ":*:*:*"
+	}
+	n := 0
+	iter := rdb.Scan(ctx, 0, pattern, 1000).Iterator()
+	for iter.Next(ctx) {
+		key := iter.Val()
+		parts := strings.SplitN(key, ":", 4)
+		if len(parts) < 4 || parts[0] == "tile" || parts[0] == "index" {
+			continue
+		}
+		size, err1 := strconv.Atoi(parts[1])
+		avg, err2 := strconv.Atoi(parts[2])
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		data, err := rdb.Get(ctx, key).Bytes()
+		if err == redis.Nil {
+			continue
+		}
+		if err != nil {
+			return n, err
+		}
+		img, err := jpeg.Decode(bytes.NewReader(data))
+		if err != nil {
+			return n, fmt.Errorf("%s: %s", key, err)
+		}
+
+		b := img.Bounds()
+		tile := &CachedTile{
+			Label:   parts[0],
+			Size:    size,
+			Name:    parts[3],
+			Data:    data,
+			Average: float64(avg),
+			Width:   b.Dx(),
+			Height:  b.Dy(),
+			PHash:   dHash(img),
+		}
+		if err := dst.Set(tile); err != nil {
+			return n, fmt.Errorf("%s: %s", key, err)
+		}
+		n++
+	}
+	return n, iter.Err()
+}
@@ -0,0 +1,425 @@
+package gosaic
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/draw"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// cachedTile returns a tile of label in size named name, with name as its
+// data
+func cachedTile(label string, size int, name string) *CachedTile {
+	return &CachedTile{Label: label, Size: size, Name: name, Data: []byte(name), Average: 120.5, Width: size, Height: size, Source: "/photos/" + name, Modified: 1600000000000000000, Expires: 4102444800, PHash: 0xf0f0, Tags: []string{"beach"}, Features: []float64{0, 128.0 / 255, 1}}
+}
+
+func TestFileCache(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "tiles.db")
+	c, err := OpenCache(SchemeFileCache+filename, RedisOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tiles := []*CachedTile{
+		cachedTile("beach", 20, "a.jpg"),
+		cachedTile("beach", 100, "a.jpg"),
+		cachedTile("beach", 20, "b.jpg"),
+		cachedTile("city", 20, "c:1.jpg"),
+	}
+	for _, tile := range tiles {
+		if err := c.Set(tile); err != nil {
+			t.Fatal(err)
+		}
+	}
+	again := cachedTile("beach", 20, "b.jpg")
+	again.Data = []byte("b again")
+	if err := c.Set(again); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// a record cut off by a crash is dropped
+	fh, err := os.OpenFile(filename, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fh.Write([]byte{5, 0, 0, 0, 9, 0, 0, 0, 'x'})
+	fh.Close()
+
+	c, err = OpenCache(SchemeFileCache+filename, RedisOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	index, err := c.Index()
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantIndex := []CacheIndex{{"beach", 20, 2}, {"beach", 100, 1}, {"city", 20, 1}}
+	if !reflect.DeepEqual(index, wantIndex) {
+		t.Errorf("Index() = %v, want %v", index, wantIndex)
+	}
+
+	names, err := c.Names("beach", 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"a.jpg", "b.jpg"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("Names() = %v, want %v", names, want)
+	}
+
+	got, err := c.Get("beach", 20, []string{"a.jpg", "missing.jpg", "b.jpg"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got[0], tiles[0]) {
+		t.Errorf("Get(a.jpg) = %+v, want %+v", got[0], tiles[0])
+	}
+	if got[1] != nil {
+		t.Errorf("Get(missing.jpg) = %+v, want nil", got[1])
+	}
+	if string(got[2].Data) != "b again" {
+		t.Errorf("Get(b.jpg) = %q, want the tile set last", got[2].Data)
+	}
+
+	// the cut off record was truncated, so new records can be read
+	if err := c.Set(cachedTile("city", 20, "d.jpg")); err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+	c, err = OpenCache(SchemeFileCache+filename, RedisOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := c.Get("city", 20, []string{"d.jpg"}); err != nil || got[0] == nil {
+		t.Errorf("Get() after reopening = %v, %v", got, err)
+	}
+}
+
+func TestTileHash(t *testing.T) {
+	tile := cachedTile("beach", 20, "a.jpg")
+	fields := map[string]string{}
+	for k, v := range tileHash(tile) {
+		switch v := v.(type) {
+		case []byte:
+			fields[k] = string(v)
+		case string:
+			fields[k] = v
+		case int:
+			fields[k] = strconv.Itoa(v)
+		}
+	}
+	got, err := tileFromHash("beach", 20, "a.jpg", fields)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, tile) {
+		t.Errorf("tileFromHash(tileHash()) = %+v, want %+v", got, tile)
+	}
+
+	if got, err := tileFromHash("beach", 20, "a.jpg", map[string]string{}); got != nil || err != nil {
+		t.Errorf("tileFromHash() of no fields = %v, %v, want nil", got, err)
+	}
+}
+
+func TestParseIndexKey(t *testing.T) {
+	tests := []struct {
+		key   string
+		label string
+		size  int
+		ok    bool
+	}{
+		{"index:beach:20", "beach", 20, true},
+		{"index:beach:2021:100", "beach:2021", 100, true},
+		{"index:beach", "", 0, false},
+		{"tile:beach:20:a.jpg", "", 0, false},
+	}
+	for _, test := range tests {
+		label, size, ok := parseIndexKey(test.key)
+		if label != test.label || size != test.size || ok != test.ok {
+			t.Errorf("parseIndexKey(%s) = %s, %d, %t, want %s, %d, %t", test.key, label, size, ok, test.label, test.size, test.ok)
+		}
+	}
+}
+
+func TestCopyCache(t *testing.T) {
+	dir := t.TempDir()
+	src, err := OpenFileCache(filepath.Join(dir, "src.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+	dst, err := OpenFileCache(filepath.Join(dir, "dst.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dst.Close()
+
+	for _, tile := range []*CachedTile{cachedTile("beach", 20, "a.jpg"), cachedTile("beach", 100, "a.jpg"), cachedTile("city", 20, "c.jpg")} {
+		if err := src.Set(tile); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	n, err := CopyCache(dst, src, "beach")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("CopyCache() copied %d tiles, want 2", n)
+	}
+	index, _ := dst.Index()
+	if want := []CacheIndex{{"beach", 20, 1}, {"beach", 100, 1}}; !reflect.DeepEqual(index, want) {
+		t.Errorf("copied index = %v, want %v", index, want)
+	}
+	if got, _ := dst.Get("beach", 100, []string{"a.jpg"}); got[0] == nil || !reflect.DeepEqual(got[0], cachedTile("beach", 100, "a.jpg")) {
+		t.Errorf("copied tile = %+v", got[0])
+	}
+}
+
+func TestNewCachedTiles(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 100, 100))
+	draw.Draw(img, img.Bounds(), &image.Uniform{color.RGBA{200, 100, 0, 255}}, image.ZP, draw.Src)
+
+	tiles, err := NewCachedTiles("beach", []int{100, 50}, "5ca1ab1e", "/photos/a.jpg", img, 42, DefaultCacheEncoding)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tiles) != 2 {
+		t.Fatalf("%d tiles, want 2", len(tiles))
+	}
+	for i, want := range []struct {
+		size    int
+		average float64
+	}{{100, 42}, {50, 100}} {
+		tile := tiles[i]
+		if tile.Size != want.size || tile.Width != want.size || tile.Height != want.size {
+			t.Errorf("tile %d is %dx%d in size %d, want %d", i, tile.Width, tile.Height, tile.Size, want.size)
+		}
+		if math.Abs(tile.Average-want.average) > 0.5 {
+			t.Errorf("tile %d has the average %f, want %f", i, tile.Average, want.average)
+		}
+		if tile.Name != "5ca1ab1e" || tile.Source != "/photos/a.jpg" {
+			t.Errorf("tile %d is %s from %s", i, tile.Name, tile.Source)
+		}
+	}
+}
+
+func TestPackFeatures(t *testing.T) {
+	features := []float64{0, 0.5, 1, -0.1, 1.2}
+	got := unpackFeatures(packFeatures(features))
+	want := []float64{0, 128.0 / 255, 1, 0, 1}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("unpackFeatures(packFeatures(%v)) = %v, want %v", features, got, want)
+	}
+	if got := unpackFeatures(nil); got != nil {
+		t.Errorf("unpackFeatures(nil) = %v, want nil", got)
+	}
+}
+
+func TestCacheEncoding(t *testing.T) {
+	tests := []struct {
+		enc CacheEncoding
+		ok  bool
+	}{
+		{DefaultCacheEncoding, true},
+		{CacheEncoding{FormatPNG, 100}, true},
+		{CacheEncoding{FormatWebP, 75}, true},
+		{CacheEncoding{FormatTIFF, 90}, false},
+		{CacheEncoding{"", 90}, false},
+		{CacheEncoding{FormatJPEG, 0}, false},
+		{CacheEncoding{FormatJPEG, 101}, false},
+	}
+	for _, tt := range tests {
+		if err := tt.enc.Check(); (err == nil) != tt.ok {
+			t.Errorf("%+v.Check() = %v, want ok %t", tt.enc, err, tt.ok)
+		}
+	}
+
+	// PNG tiles are cached losslessly
+	img := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	img.Set(1, 2, color.RGBA{10, 200, 30, 255})
+	tile, err := NewCachedTile("beach", 4, "5ca1ab1e", "/photos/a.png", img, 10, CacheEncoding{FormatPNG, 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, format, err := image.Decode(bytes.NewReader(tile.Data))
+	if err != nil || format != FormatPNG {
+		t.Fatalf("cached tile decodes as %s, %v", format, err)
+	}
+	if c := color.RGBAModel.Convert(decoded.At(1, 2)); c != (color.RGBA{10, 200, 30, 255}) {
+		t.Errorf("cached pixel = %v", c)
+	}
+}
+
+func TestFileHash(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a/IMG_0001.jpg", "b/IMG_0001.jpg"} {
+		if err := os.MkdirAll(filepath.Join(dir, filepath.Dir(name)), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "a/IMG_0001.jpg"), []byte("abc"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "b/IMG_0001.jpg"), []byte("abd"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	a, err := FileHash(filepath.Join(dir, "a/IMG_0001.jpg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "a9993e364706816aba3e25717850c26c9cd0d89d"; a != want {
+		t.Errorf("FileHash() = %s, want %s", a, want)
+	}
+	if b, err := FileHash(filepath.Join(dir, "b/IMG_0001.jpg")); err != nil || b == a {
+		t.Errorf("FileHash() of a file with the same name = %s, %v, want another hash", b, err)
+	}
+	if _, err := FileHash(filepath.Join(dir, "missing.jpg")); err == nil {
+		t.Error("FileHash() of a missing file didn't fail")
+	}
+}
+
+func TestFileCacheExpires(t *testing.T) {
+	c, err := OpenFileCache(filepath.Join(t.TempDir(), "tiles.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	expired, kept := cachedTile("event", 20, "a.jpg"), cachedTile("event", 20, "b.jpg")
+	expired.Expires = time.Now().Add(-time.Minute).Unix()
+	kept.Expires = 0
+	for _, tile := range []*CachedTile{expired, kept} {
+		if err := c.Set(tile); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tiles, err := c.Get("event", 20, []string{"a.jpg", "b.jpg"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tiles[0] != nil || tiles[1] == nil {
+		t.Errorf("Get() = %v, want only the tile which doesn't expire", tiles)
+	}
+	if names, err := c.Names("event", 20); err != nil || !reflect.DeepEqual(names, []string{"b.jpg"}) {
+		t.Errorf("Names() after getting an expired tile = %v, %v, want [b.jpg]", names, err)
+	}
+}
+
+// labels with colons are listed and read as they were set
+func TestFileCacheLabelColons(t *testing.T) {
+	c, err := OpenFileCache(filepath.Join(t.TempDir(), "tiles.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	for _, tile := range []*CachedTile{cachedTile("trip:2021", 20, "a.jpg"), cachedTile("trip", 2021, "20:b.jpg"), cachedTile("50%", 20, "c.jpg")} {
+		if err := c.Set(tile); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	index, err := c.Index()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []CacheIndex{{Label: "50%", Size: 20, Count: 1}, {Label: "trip", Size: 2021, Count: 1}, {Label: "trip:2021", Size: 20, Count: 1}}
+	if !reflect.DeepEqual(index, want) {
+		t.Errorf("Index() = %+v, want %+v", index, want)
+	}
+	if names, err := c.Names("trip:2021", 20); err != nil || !reflect.DeepEqual(names, []string{"a.jpg"}) {
+		t.Errorf("Names(trip:2021) = %v, %v, want [a.jpg]", names, err)
+	}
+	if names, err := c.Names("trip", 2021); err != nil || !reflect.DeepEqual(names, []string{"20:b.jpg"}) {
+		t.Errorf("Names(trip) = %v, %v, want [20:b.jpg]", names, err)
+	}
+}
+
+// outdated records are dropped once they outnumber the current ones, on
+// open as well as while the cache is used
+func TestFileCacheCompaction(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "tiles.db")
+	c, err := openFileCache(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := bytes.Repeat([]byte{7}, 64<<10)
+	if err := c.set("kept", []byte("value")); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 40; i++ {
+		if err := c.set("overwritten", append(data, byte(i))); err != nil {
+			t.Fatal(err)
+		}
+		if err := c.set("removed:"+strconv.Itoa(i), data); err != nil {
+			t.Fatal(err)
+		}
+		if err := c.set("removed:"+strconv.Itoa(i), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	info, err := os.Stat(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if max := 2*int64(len(data)) + 2*fileCompactMin; info.Size() > max {
+		t.Errorf("the file grew to %d bytes, want at most %d", info.Size(), max)
+	}
+	c.Close()
+
+	// a file written without compaction is compacted on open
+	fh, err := os.OpenFile(filename, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 40; i++ {
+		record := make([]byte, 8, 8+len("overwritten")+len(data))
+		binary.LittleEndian.PutUint32(record, uint32(len("overwritten")))
+		binary.LittleEndian.PutUint32(record[4:], uint32(len(data)))
+		record = append(append(record, "overwritten"...), data...)
+		fh.Write(record)
+	}
+	fh.Close()
+
+	c, err = openFileCache(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if c.size != int64(len(fileCacheMagic))+c.live {
+		t.Errorf("the opened file has %d bytes of outdated records", c.size-int64(len(fileCacheMagic))-c.live)
+	}
+	for key, want := range map[string][]byte{"kept": []byte("value"), "overwritten": data} {
+		if got, err := c.get(key); err != nil || !bytes.Equal(got, want) {
+			t.Errorf("get(%s) after the compaction = %d bytes, %v", key, len(got), err)
+		}
+	}
+	if _, err := c.get("removed:0"); err != ErrNotCached {
+		t.Errorf("get of a removed key returned %v", err)
+	}
+}
+
+func TestOpenCacheSQLite(t *testing.T) {
+	_, err := OpenCache("sqlite:tiles.db", RedisOptions{})
+	if err == nil || !strings.Contains(err.Error(), "file:tiles.db") {
+		t.Errorf("OpenCache(sqlite:tiles.db) = %v, want an error pointing to file:tiles.db", err)
+	}
+}
@@ -0,0 +1,147 @@
+package gosaic
+
+import (
+	"container/list"
+	"encoding/json"
+	"image"
+	"io/ioutil"
+	"os"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultCheckpointEvery is the number of placed tiles after which the
+// checkpoint is written.
+const DefaultCheckpointEvery = 100
+
+// Checkpoint is the manifest of the cells placed so far by an interrupted
+// build and the tiles which may still be placed.
+type Checkpoint struct {
+	Manifest
+	Remaining []string `json:"remaining"`
+}
+
+// LoadCheckpoint reads a checkpoint written with Config.Checkpoint
+func LoadCheckpoint(filename string) (*Checkpoint, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	cp := &Checkpoint{}
+	if err := json.Unmarshal(data, cp); err != nil {
+		return nil, err
+	}
+	return cp, nil
+}
+
+// placed records a rect which got its tile, writes the checkpoint every
+// CheckpointEvery placements, saves a snapshot every SnapshotEvery
+// placements and records a frame of the animation.
+func (g *Gosaic) placed(td *TileData) {
+	g.placedRects = append(g.placedRects, td)
+	n := len(g.placedRects)
+
+	if g.progress != nil && td.MinDist != nil {
+		g.progress.place(*td.MinDist)
+	}
+
+	if g.animation != nil && n%g.animationStride() == 0 {
+		if err := g.animation.addFrame(g.SeedImage); err != nil {
+			log.Errorf("animation error: %s", err)
+		}
+	}
+
+	if g.config.SnapshotEvery > 0 && n%g.config.SnapshotEvery == 0 {
+		if err := g.saveSnapshot(n); err != nil {
+			log.Errorf("snapshot error: %s", err)
+		}
+	}
+
+	if g.config.Checkpoint == "" {
+		return
+	}
+	every := g.config.CheckpointEvery
+	if every <= 0 {
+		every = DefaultCheckpointEvery
+	}
+	if n%every != 0 {
+		return
+	}
+	if err := g.saveCheckpoint(g.config.Checkpoint); err != nil {
+		log.Errorf("checkpoint error: %s", err)
+	}
+}
+
+// saveCheckpoint writes the checkpoint to a temporary file first, so a
+// crash while it is written doesn't destroy the previous checkpoint.
+func (g *Gosaic) saveCheckpoint(filename string) error {
+	m, err := g.manifest(g.placedRects)
+	if err != nil {
+		return err
+	}
+
+	cp := Checkpoint{Manifest: m, Remaining: make([]string, 0, g.Tiles.Len())}
+	for cur := g.Tiles.Front(); cur != nil; cur = cur.Next() {
+		cp.Remaining = append(cp.Remaining, cur.Value.(Tile).Filename)
+	}
+
+	data, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filename+".tmp", data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(filename+".tmp", filename)
+}
+
+// resume draws the cells placed before the build was interrupted, keeps
+// only the remaining tiles and returns the rects which are still left to
+// match. The rects are those of the same seed image and configuration.
+func (g *Gosaic) resume(cp *Checkpoint, rects []*TileData) []*TileData {
+	type key struct {
+		cell image.Point
+		rect image.Rectangle
+	}
+
+	cells := make(map[key]ManifestCell, len(cp.Cells))
+	for _, c := range cp.Cells {
+		r := image.Rect(c.Left, c.Top, c.Left+c.Width, c.Top+c.Height)
+		cells[key{image.Pt(c.X, c.Y), r}] = c
+	}
+
+	remaining := make(map[string]bool, len(cp.Remaining))
+	for _, filename := range cp.Remaining {
+		remaining[filename] = true
+	}
+	var next *list.Element
+	for cur := g.Tiles.Front(); cur != nil; cur = next {
+		next = cur.Next()
+		if !remaining[cur.Value.(Tile).Filename] {
+			g.Tiles.Remove(cur)
+		}
+	}
+
+	b := g.SeedImage.Bounds()
+	pending := make([]*TileData, 0, len(rects))
+	for _, td := range rects {
+		c, ok := cells[key{image.Pt(td.X, td.Y), td.Cell.Sub(b.Min)}]
+		if !ok || c.Tile == "" {
+			pending = append(pending, td)
+			continue
+		}
+
+		*td.MinTile = Tile{Filename: c.Tile}
+		*td.MinDist = c.Distance
+		*td.MinTransform = Transform{Rotate: c.Rotate, Flip: c.Flip}
+		g.placements.add(c.Tile, td.X, td.Y)
+		if err := g.drawTile(td); err != nil {
+			log.Error(err)
+		}
+		g.placed(td)
+	}
+
+	log.Infof("Resumed %d placed cells, %d cells and %d tiles left", len(rects)-len(pending), len(pending), g.Tiles.Len())
+	return pending
+}
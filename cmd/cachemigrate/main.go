@@ -0,0 +1,49 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+
+	"github.com/elcamino/gosaic"
+)
+
+func main() {
+	var from = flag.String("from", "", "copy the tiles from this cache: a redis address or URL, file:path.db or bolt:path.db. REDIS_PASSWORD, REDIS_DB and REDIS_TLS apply to both caches")
+	var to = flag.String("to", "", "copy the tiles into this cache: a redis address or URL, file:path.db or bolt:path.db")
+	var label = flag.String("label", "", "only copy the tiles with this label (default all)")
+	var legacy = flag.Bool("legacy", false, "copy the tiles stored as label:size:average:file keys by older versions of redisimport from the redis instance -from")
+
+	flag.Parse()
+	if *from == "" || *to == "" {
+		log.Fatal("both -from and -to are needed")
+	}
+
+	dst, err := gosaic.OpenCache(*to, gosaic.RedisEnv())
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer dst.Close()
+
+	// the tiles of older versions of redisimport are upgraded on the way
+	if *legacy {
+		n, err := gosaic.CopyLegacyCache(dst, *from, gosaic.RedisEnv(), *label)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("upgraded %d tiles\n", n)
+		return
+	}
+
+	src, err := gosaic.OpenCache(*from, gosaic.RedisEnv())
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer src.Close()
+
+	n, err := gosaic.CopyCache(dst, src, *label)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("copied %d tiles\n", n)
+}
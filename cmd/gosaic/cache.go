@@ -0,0 +1,57 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+
+	"github.com/elcamino/gosaic"
+)
+
+const cacheUsage = `usage: gosaic cache purge -label LABEL [flags]
+
+Removes the tiles of LABEL from the tile cache in batches, so Redis keeps
+serving other clients while a large label is purged.
+
+flags:
+`
+
+// runCache runs the cache command with the arguments args
+func runCache(args []string) error {
+	fs := flag.NewFlagSet("cache", flag.ExitOnError)
+	env := gosaic.RedisEnv()
+	label := fs.String("label", "", "the label whose tiles are removed")
+	cache := fs.String("cache", "127.0.0.1:6379", "the tile cache: a redis address or URL, file:path.db or bolt:path.db")
+	redisPassword := fs.String("redis-password", env.Password, "the password of the redis instance (default $REDIS_PASSWORD)")
+	redisDB := fs.Int("redis-db", env.DB, "the database of the redis instance (default $REDIS_DB)")
+	redisTLS := fs.Bool("redis-tls", env.TLS, "connect to the redis instance with TLS (default $REDIS_TLS)")
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), cacheUsage)
+		fs.PrintDefaults()
+	}
+
+	if len(args) == 0 || args[0] != "purge" {
+		fs.Usage()
+		return errors.New("unknown cache command")
+	}
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+	if *label == "" {
+		fs.Usage()
+		return errors.New("purge needs a -label")
+	}
+
+	c, err := gosaic.OpenCache(*cache, gosaic.RedisOptions{Password: *redisPassword, DB: *redisDB, TLS: *redisTLS})
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	n, err := gosaic.DeleteLabel(c, *label)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("purged %d tiles of %s\n", n, *label)
+	return nil
+}
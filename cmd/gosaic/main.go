@@ -17,26 +17,155 @@ import (
 )
 
 var (
-	seed         = flag.String("seed", "", "the seed image")
-	tilesGlob    = flag.String("tiles", "", "glob for all tiles")
-	tileSize     = flag.Int("tilesize", 100, "size of each tile")
-	outputSize   = flag.Int("outputsize", 2000, "size of the output file")
-	output       = flag.String("output", "mosaic.jpg", "the mosaic output file")
-	comparesize  = flag.Int("comparesize", 50, "the size to which to scale pictures before comparing them for their distance")
-	comparedist  = flag.Int("comparedist", 30, "only compare image whose average color is this far apart")
-	unique       = flag.Bool("unique", true, "use each tile only once")
-	cpuprofile   = flag.String("cpuprofile", "", "profile the CPU usage to this file")
-	smartcrop    = flag.Bool("smartcrop", false, "perform smart cropping of the tiles")
-	progressbar  = flag.Bool("progressbar", false, "show a progress bar when loading tiles and building the mosaic")
-	progresstext = flag.Bool("progresstext", false, "show the progress line by line")
-	redisAddr    = flag.String("redisaddr", "127.0.0.1:6379", "use the tile cache at this redis address")
-	redisLabel   = flag.String("redislabel", "interesting", "load cached tiles with this label")
-	httpAddr     = flag.String("http-address", "", "run the REST API server at this address")
-	apiKey       = flag.String("api-key", "", "the API key with which to authenticate requests")
-	loglevel     = flag.String("loglevel", "error", "the loglevel")
-	workers      = flag.Int("workers", 16, "run this many tile workers in parallel")
-	user         = flag.String("user", "", "require HTTP authentication with this user")
-	password     = flag.String("password", "", "require HTTP authentication with thi password")
+	seed              = flag.String("seed", "", "the seed image, a file, an http or https URL, or - to read it from stdin")
+	tilesGlob         = flag.String("tiles", "", "glob for all tiles, a .zip, .tar or .tar.gz archive of tiles, or the tiles below an s3://bucket/prefix or gs://bucket/prefix (credentials from AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY, or HMAC keys for gs://), Azure Blob Storage isn't supported")
+	tilesCache        = flag.String("tiles-cache", "", "keep the tiles downloaded from s3://, gs:// and -tiles-urls in this directory, so they are only downloaded once")
+	thumbCache        = flag.String("thumb-cache", "", "keep the scaled tiles in this directory and reuse them while the tile files don't change, so repeated builds over the same tiles load faster")
+	tilesURLs         = flag.String("tiles-urls", "", "download the tiles from the URLs in this text file, one per line, or JSON array, instead of -tiles")
+	downloadRate      = flag.Float64("download-rate", 0, "download at most this many tiles per second from -tiles-urls (default unlimited)")
+	tilesVideo        = flag.String("tiles-video", "", "use frames of these comma separated video files as the tiles, extracted with ffmpeg")
+	videoFrameStep    = flag.Int("video-every", gosaic.DefaultVideoFrameStep, "extract every Nth frame of -tiles-video")
+	ffmpeg            = flag.String("ffmpeg", gosaic.FFmpeg, "the ffmpeg command frames of -tiles-video are extracted with")
+	tilesDir          = flag.String("tiles-dir", "", "use the images in this directory and all its subdirectories as tiles, skipping hidden and system files, instead of -tiles")
+	tileExtensions    = flag.String("tile-ext", "", "only use the files with these comma separated extensions, like jpg,png, from -tiles-dir (default all images gosaic can read)")
+	tileSize          = flag.Int("tilesize", 100, "size of each tile")
+	outputSize        = flag.Int("outputsize", 2000, "size of the output file")
+	output            = flag.String("output", "mosaic.jpg", "the mosaic output file, or - to write it to stdout in -output-format")
+	comparesize       = flag.Int("comparesize", 50, "the size to which to scale pictures before comparing them for their distance")
+	comparedist       = flag.Int("comparedist", 30, "only compare image whose average color is this far apart")
+	unique            = flag.Bool("unique", true, "use each tile only once")
+	cpuprofile        = flag.String("cpuprofile", "", "profile the CPU usage to this file")
+	smartcrop         = flag.Bool("smartcrop", false, "perform smart cropping of the tiles")
+	progressbar       = flag.Bool("progressbar", false, "show a progress bar when loading tiles and building the mosaic")
+	progresstext      = flag.Bool("progresstext", false, "show the progress line by line")
+	redisAddr         = flag.String("redisaddr", "127.0.0.1:6379", "use the tile cache at this redis address")
+	redisLabel        = flag.String("redislabel", "interesting", "load cached tiles with this label")
+	tileFilter        = flag.String("tile-filter", "", "only use the tiles of -redislabel whose tags match this expression, like \"beach AND (2023 OR 2024) AND NOT night\"")
+	redisEnv          = gosaic.RedisEnv()
+	redisPassword     = flag.String("redis-password", redisEnv.Password, "the password of the redis instance (default $REDIS_PASSWORD)")
+	redisDB           = flag.Int("redis-db", redisEnv.DB, "the database of the redis instance (default $REDIS_DB)")
+	redisTLS          = flag.Bool("redis-tls", redisEnv.TLS, "connect to the redis instance with TLS (default $REDIS_TLS)")
+	cacheSpec         = flag.String("cache", "", "use this tile cache instead of -redisaddr: a redis://[:password@]host:port/db URL, or file:path.db or bolt:path.db for an embedded cache file, prefer -redis-password since the URL is visible in the process list")
+	httpAddr          = flag.String("http-address", "", "run the REST API server at this address")
+	apiKey            = flag.String("api-key", "", "the API key with which to authenticate requests")
+	apiKeysFile       = flag.String("api-keys", "", "also accept the API keys in this file, one per line, optionally followed by their own limits like \"KEY rate=60 quota=100\"")
+	rateLimit         = flag.Int("rate-limit", 0, "allow each API key this many requests per minute (0 doesn't limit them)")
+	buildQuota        = flag.Int("build-quota", 0, "allow each API key this many builds per month (0 doesn't limit them), counted in -job-store so they survive a restart")
+	maxBuilds         = flag.Int("max-builds", gosaic.DefaultMaxBuilds, "run this many builds of the server at the same time (0 doesn't limit them)")
+	jobQueue          = flag.Int("job-queue", gosaic.DefaultJobQueue, "queue this many builds of the server, more are rejected")
+	maxMemory         = flag.Int("max-memory", 0, "the memory in MB all running builds of the server may use (0 doesn't limit it)")
+	jobMemory         = flag.Int("job-memory", gosaic.DefaultJobMemory, "the memory in MB a build of the server needs besides its output image")
+	webhookSecret     = flag.String("webhook-secret", "", "sign the webhooks of the jobs with this secret, in the X-Gosaic-Signature header")
+	webhookAllow      = flag.String("webhook-allow", "", "also post webhooks to the loopback, private and link-local addresses of these comma separated networks, like 10.1.0.0/16")
+	jobStore          = flag.String("job-store", "", "keep the jobs of the server and the builds per API key in this store so they survive a restart: a redis address or URL, or file:path.db")
+	results           = flag.String("results", gosaic.DefaultResults, "keep the mosaics of the server in this directory or s3://bucket/prefix or gs://bucket/prefix")
+	resultURLTTL      = flag.Duration("result-url-ttl", gosaic.DefaultResultTTL, "let the download URLs of the mosaics of the server expire after this duration")
+	resultRetention   = flag.Duration("result-retention", 0, "remove the finished jobs of the server and their mosaics after this duration (0 keeps them)")
+	minFreeDisk       = flag.Int("min-free-disk", gosaic.DefaultMinFreeDisk, "the free space in MB the output directories of the server need for /readyz to pass")
+	loglevel          = flag.String("loglevel", "error", "the loglevel")
+	workers           = flag.Int("workers", 16, "run this many tile workers in parallel")
+	user              = flag.String("user", "", "require HTTP authentication with this user")
+	password          = flag.String("password", "", "require HTTP authentication with thi password")
+	candidates        = flag.Int("candidates", 0, "only compare each rect with this many tiles of the most similar feature vectors (0 compares all tiles)")
+	assignment        = flag.String("assignment", gosaic.AssignGreedy, "how to assign unique tiles to rects: greedy or optimal")
+	allowRotate       = flag.Bool("allow-rotate", false, "also match the tiles rotated by 90, 180 and 270 degrees")
+	allowFlip         = flag.Bool("allow-flip", false, "also match the tiles flipped horizontally and vertically")
+	colorBlend        = flag.Float64("colorblend", 0, "shift the colors of each tile towards the average color of its rect by this amount (0..1)")
+	overlayOpacity    = flag.Float64("overlay-opacity", 0, "blend the seed image over the finished mosaic with this opacity (0..1)")
+	overlayMode       = flag.String("overlay-mode", gosaic.BlendNormal, "the blend mode of the seed overlay: normal, multiply or softlight")
+	maxUses           = flag.Int("max-uses", 0, "use each tile at most this many times, overrides -unique (0 means unlimited unless -unique is set)")
+	quadtree          = flag.Int("quadtree", 0, "split detailed rects into smaller tiles up to this many times, not with -cols and -rows")
+	quadtreeThreshold = flag.Float64("quadtree-threshold", 0.15, "split rects whose luminance standard deviation exceeds this value (0..0.5)")
+	layout            = flag.String("layout", gosaic.LayoutGrid, "the layout of the tiles: grid, hex, brick, voronoi or collage")
+	voronoiSites      = flag.Int("voronoi-sites", 0, "the number of cells of the voronoi layout (0 derives it from the tile size)")
+	voronoiSaliency   = flag.Bool("voronoi-saliency", false, "place more voronoi cells in detailed regions of the seed image")
+	groutWidth        = flag.Int("grout-width", 0, "leave a gap of this many pixels between the tiles")
+	groutColor        = flag.String("grout-color", gosaic.DefaultGroutColor, "the color of the gaps between the tiles")
+	grayscale         = flag.Bool("grayscale", false, "compare the tiles by their luminance only")
+	grayscaleOutput   = flag.Bool("grayscale-output", false, "convert the finished mosaic to grayscale")
+	centerWeight      = flag.Float64("center-weight", 0, "weigh the pixels near the center of a tile higher when comparing (0 weighs all pixels equally)")
+	background        = flag.String("background", "", "fill transparent parts of the seed image with this color instead of keeping them transparent")
+	maskImage         = flag.String("mask", "", "never place tiles where this mask image is black")
+	maskBlank         = flag.Bool("mask-blank", false, "leave the masked regions blank instead of showing the seed image")
+	randomSeed        = flag.Int64("seed-rand", 0, "seed for the random number generator, 0 picks a new one for every build")
+	dedupe            = flag.Bool("dedupe", false, "skip tiles which are near duplicates of other tiles")
+	dedupeDistance    = flag.Int("dedupe-distance", gosaic.DefaultDedupeDistance, "maximum number of differing perceptual hash bits (of 64) for two tiles to count as duplicates")
+	minSharpness      = flag.Float64("min-sharpness", 0, "skip blurry tiles whose laplacian variance is below this value, 0 disables the check")
+	minContrast       = flag.Float64("min-contrast", 0, "skip tiles whose luminance standard deviation (0-255) is below this value, 0 disables the check")
+	maxUniformity     = flag.Float64("max-uniformity", 0, "skip tiles where more than this share (0-1) of the pixels have nearly the same brightness, 0 disables the check")
+	widenSteps        = flag.Int("widen-steps", 0, "double the compare distance up to this many times for cells without a matching tile")
+	filler            = flag.String("filler", "", "fill cells without a matching tile with a synthetic tile: solid, gradient or noise")
+	mergeThreshold    = flag.Float64("merge", 0, "merge neighbouring grid cells whose mean colors differ by less than this (0-255) into 2x2, 2x1 and 1x2 cells, 0 disables merging")
+	collageRotation   = flag.Float64("collage-rotation", gosaic.DefaultCollageRotation, "rotate the tiles of the collage layout by up to this many degrees")
+	collageJitter     = flag.Float64("collage-jitter", gosaic.DefaultCollageJitter, "move the tiles of the collage layout by up to this fraction of the tile size")
+	collageOverlap    = flag.Float64("collage-overlap", gosaic.DefaultCollageOverlap, "enlarge the tiles of the collage layout by this fraction so they overlap")
+	palette           = flag.String("palette", "", "only use tiles near a color of this palette, either a comma separated list of hex colors or an image to extract the palette from")
+	paletteDistance   = flag.Float64("palette-distance", gosaic.DefaultPaletteDistance, "the maximum distance (0-441) of a tile's average color to the nearest palette color")
+	paletteSize       = flag.Int("palette-size", gosaic.DefaultPaletteSize, "the number of colors to extract from a palette image")
+	tileStyle         = flag.String("tile-style", "", "convert all tiles to this treatment: sepia, duotone or desaturate")
+	duotoneShadow     = flag.String("duotone-shadow", gosaic.DefaultDuotoneShadow, "the color of the shadows of duotone tiles")
+	duotoneHighlight  = flag.String("duotone-highlight", gosaic.DefaultDuotoneHighlight, "the color of the highlights of duotone tiles")
+	order             = flag.String("order", gosaic.OrderRandom, "the order in which the greedy assignment picks tiles for the rects: random or saliency")
+	linearLight       = flag.Bool("linear-light", false, "compare the colors in linear light instead of sRGB")
+	refine            = flag.Int("refine", 0, "only compare the pixels of this many tiles per rect, picked by their 4x4 block signature (0 compares all candidates)")
+	feather           = flag.Int("feather", 0, "soften the seams between the tiles by fading them into each other over this many pixels")
+	outputWidth       = flag.Int("outputwidth", 0, "the width of the output file, overrides -outputsize")
+	outputHeight      = flag.Int("outputheight", 0, "the height of the output file, overrides -outputsize")
+	outputStretch     = flag.Bool("outputstretch", false, "stretch the seed image to -outputwidth x -outputheight instead of cropping it")
+	cols              = flag.Int("cols", 0, "split the mosaic into this many columns, overrides -tilesize")
+	rows              = flag.Int("rows", 0, "split the mosaic into this many rows, overrides -tilesize")
+	letterbox         = flag.Bool("letterbox", false, "keep the aspect ratio of the tiles and fill the rest of the cell with the matte color instead of cropping them (only for tiles loaded from disk)")
+	matteColor        = flag.String("matte-color", gosaic.DefaultMatteColor, "the color around letterboxed tiles")
+	noTrim            = flag.Bool("no-trim", false, "keep the frames around the tiles loaded from disk instead of removing them")
+	trimThreshold     = flag.Float64("trim-threshold", gosaic.DefaultTrimThreshold, "remove the rows and columns at the edges of the tiles loaded from disk whose pixels differ from -trim-color by less than this")
+	trimColor         = flag.String("trim-color", gosaic.DefaultTrimColor, "the color of the frames removed around the tiles loaded from disk")
+	crop              = flag.String("crop", "", "how to crop the tiles and rects to squares: center, attention, entropy, low or high (by default tiles use attention and rects center)")
+	faceCascade       = flag.String("face-cascade", "", "detect faces in the seed image with this pigo cascade file and give them smaller tiles and stricter matches")
+	faceSplit         = flag.Int("face-split", gosaic.DefaultFaceSplit, "split the cells covering a face in half this many times")
+	usagePenalty      = flag.Float64("usage-penalty", 0, "add this much to the distance (0..1) of a tile for every time it has already been used when ranking the tiles of a rect")
+	pickFromTop       = flag.Int("pick-from-top", 0, "pick a random tile of this many best matches for every rect instead of the best one")
+	weightMap         = flag.String("weightmap", "", "grayscale map where bright regions demand stricter matches and dark regions are relaxed")
+	selfMosaic        = flag.Bool("self", false, "build the mosaic from slices of the seed image instead of a tile collection")
+	selfImage         = flag.String("self-image", "", "slice this image instead of the seed image for -self")
+	selfSlices        = flag.Int("self-slices", gosaic.DefaultSelfSlices, "cut the image of -self into this many slices per side, 1 uses the whole image as every tile")
+	recurse           = flag.Int("recurse", 0, "replace every tile with a mosaic of smaller tiles, down to this many levels")
+	recurseSplit      = flag.Int("recurse-split", gosaic.DefaultRecurseSplit, "split every tile of -recurse into this many smaller tiles per side")
+	compareSpace      = flag.String("compare", gosaic.ComparePixels, "compare the tiles by their pixels or by their low frequency DCT coefficients: pixels or dct")
+	dctSize           = flag.Int("dct-size", gosaic.DefaultDCTSize, "the number of DCT coefficients per side compared by -compare dct")
+	outputProfile     = flag.String("output-profile", "", "embed this ICC profile into the mosaic, it has to be sRGB compatible since the pixels are not converted")
+	histogramMatch    = flag.Float64("histogram-match", 0, "remap the colors of the finished mosaic towards the histogram of the seed image by this amount (0..1)")
+	heatmap           = flag.String("heatmap", "", "write a PNG to this file which shows how well the tile of every cell matches, from green to red")
+	usageReport       = flag.String("usage-report", "", "write which tiles were placed how often and at which cells, and which were never used, to this JSON or .csv file")
+	manifest          = flag.String("manifest", "", "write the tile, transform and distance of every cell to this JSON file")
+	render            = flag.String("render", "", "render the mosaic of this manifest at the output size instead of matching tiles to the seed image")
+	checkpoint        = flag.String("checkpoint", "", "save the cells placed so far and the remaining tiles to this file while building")
+	checkpointEvery   = flag.Int("checkpoint-every", gosaic.DefaultCheckpointEvery, "save the checkpoint every time this many tiles have been placed")
+	resume            = flag.String("resume", "", "continue the build interrupted at this checkpoint, with the same seed image and options")
+	snapshotEvery     = flag.Int("snapshot-every", 0, "save the partially assembled mosaic every time this many tiles have been placed, numbered after the output file")
+	animation         = flag.String("animation", "", "record the assembly of the mosaic as an animated .gif or, through ffmpeg, a video of any other format like .mp4")
+	animationStride   = flag.Int("animation-stride", gosaic.DefaultAnimationStride, "record a frame of the animation every time this many tiles have been placed")
+	animationWidth    = flag.Int("animation-width", gosaic.DefaultAnimationWidth, "the width of the animation")
+	export            = flag.String("export", "", "write an HTML page, or an SVG if the name ends in .svg, which shows the filename of the tile of every cell and links it to -export-url")
+	exportURL         = flag.String("export-url", "", "the link of each cell of -export as a Go template of the cell, e.g. \"https://example.com/{{base .Tile}}\"")
+	deepZoom          = flag.String("deepzoom", "", "also save the mosaic as a tiled pyramid for viewers like OpenSeadragon, to this name plus .dzi and _files, or to this directory for -deepzoom-layout iiif (an empty -output skips the single image)")
+	deepZoomLayout    = flag.String("deepzoom-layout", gosaic.DeepZoomDZ, "the layout of the tiled pyramid: dz or iiif")
+	outputFormat      = flag.String("output-format", "", "format of the mosaic: jpeg, png, webp, tiff, tiff16 (16 bits per channel) or avif, by default from the extension of -output")
+	quality           = flag.Int("quality", gosaic.DefaultQuality, "quality (1..100) of JPEG, WebP, TIFF and AVIF output")
+	lossless          = flag.Bool("lossless", false, "save WebP and AVIF output lossless")
+	pdf               = flag.String("pdf", "", "also save the mosaic as a PDF for printing to this file (an empty -output skips the single image)")
+	printSize         = flag.String("print-size", "", "the physical size the mosaic is fitted into in the PDF, like 60x90cm, 24x36in or A2")
+	pdfPages          = flag.String("pdf-pages", "", "split the PDF across pages of this size, like A4 or 20x30cm, with crop marks for assembling a poster")
+	dpi               = flag.Int("dpi", gosaic.DefaultDPI, "the resolution of the mosaic in the PDF and of the paper sizes of -split-pages")
+	splitPages        = flag.String("split-pages", "", "also save the mosaic cut into overlapping pages of this size for printing at home, like A4, A3, 20x30cm at -dpi or 2480x3508 pixels")
+	splitOverlap      = flag.Int("split-overlap", gosaic.DefaultSplitOverlap, "the overlap of the pages of -split-pages in pixels, a dashed line in its middle shows where to cut")
+	legend            = flag.String("legend", "", "save a contact sheet of every placed tile with the cells it is at, like B7, to this image or .pdf file")
+	stream            = flag.Bool("stream", false, "with -render, draw the mosaic band by band into -output as a tiled BigTIFF, for mosaics larger than the memory")
+	metadata          = flag.Bool("metadata", true, "embed the gosaic version, random seed, tiles and parameters as XMP and EXIF metadata into JPEG and PNG output")
+	watermark         = flag.String("watermark", "", "composite this image, like a logo, onto the finished mosaic, scaled down to a quarter of its width at most")
+	watermarkPos      = flag.String("watermark-pos", gosaic.PositionBottomRight, "the position of the watermark: topleft, top, topright, left, center, right, bottomleft, bottom or bottomright")
+	watermarkOpacity  = flag.Float64("watermark-opacity", gosaic.DefaultWatermarkOpacity, "the opacity of the watermark (0..1)")
+	rawDecoder        = flag.String("raw-decoder", gosaic.RAWDecoder, "the dcraw compatible command RAW camera files like .cr2, .nef, .arw and .dng are decoded with")
+	repeatDistance    = flag.Int("repeat-distance", 0, "don't reuse a tile within this many cells of where it has already been placed")
 )
 
 type lineNumberHook struct {
@@ -85,7 +214,35 @@ func (hook *lineNumberHook) Fire(entry *log.Entry) error {
 }
 
 func runServer() error {
-	srv, err := gosaic.NewServer(*httpAddr, *redisAddr, *user, *password)
+	cache := *redisAddr
+	if *cacheSpec != "" {
+		cache = *cacheSpec
+	}
+	ro := gosaic.RedisOptions{Password: *redisPassword, DB: *redisDB, TLS: *redisTLS}
+	limits := gosaic.JobLimits{Builds: *maxBuilds, Queue: *jobQueue, Memory: *maxMemory, JobMemory: *jobMemory}
+	keyLimits := gosaic.KeyLimits{RateLimit: *rateLimit, Quota: *buildQuota}
+	keys := []gosaic.APIKey{}
+	if *apiKey != "" {
+		keys = append(keys, gosaic.APIKey{Key: *apiKey, KeyLimits: keyLimits})
+	}
+	if *apiKeysFile != "" {
+		fileKeys, err := gosaic.LoadAPIKeys(*apiKeysFile, keyLimits)
+		if err != nil {
+			return err
+		}
+		if len(fileKeys) == 0 {
+			return fmt.Errorf("%s: no API keys", *apiKeysFile)
+		}
+		keys = append(keys, fileKeys...)
+	}
+
+	resultOptions := gosaic.ResultOptions{Store: *results, URLTTL: *resultURLTTL, Retention: *resultRetention, MinFreeDisk: *minFreeDisk}
+	allow, err := gosaic.ParseWebhookAllow(*webhookAllow)
+	if err != nil {
+		return fmt.Errorf("-webhook-allow: %s", err)
+	}
+	webhooks := gosaic.WebhookOptions{Secret: *webhookSecret, Allow: allow}
+	srv, err := gosaic.NewServer(*httpAddr, cache, ro, *user, *password, keys, limits, *jobStore, resultOptions, webhooks)
 	if err != nil {
 		return err
 	}
@@ -93,7 +250,16 @@ func runServer() error {
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "cache" {
+		if err := runCache(os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	flag.Parse()
+	gosaic.RAWDecoder = *rawDecoder
+	gosaic.FFmpeg = *ffmpeg
 
 	// log.SetFlags(log.Flags() | log.Lshortfile)
 	level, err := logrus.ParseLevel(*loglevel)
@@ -104,7 +270,9 @@ func main() {
 	log.AddHook(&lineNumberHook{skip: -1})
 
 	if *httpAddr != "" {
-		runServer()
+		if err := runServer(); err != nil {
+			log.Fatal(err)
+		}
 		return
 	}
 
@@ -118,26 +286,149 @@ func main() {
 	}
 
 	config := gosaic.Config{
-		SeedImage:    *seed,
-		TilesGlob:    *tilesGlob,
-		TileSize:     *tileSize,
-		OutputSize:   *outputSize,
-		OutputImage:  *output,
-		CompareSize:  *comparesize,
-		CompareDist:  float64(*comparedist),
-		Unique:       *unique,
-		SmartCrop:    *smartcrop,
-		ProgressBar:  *progressbar,
-		ProgressText: *progresstext,
-		RedisAddr:    *redisAddr,
-		RedisLabel:   *redisLabel,
-		Workers:      *workers,
+		SeedImage:         *seed,
+		TilesGlob:         *tilesGlob,
+		TilesDir:          *tilesDir,
+		TileExtensions:    *tileExtensions,
+		TilesCache:        *tilesCache,
+		ThumbCache:        *thumbCache,
+		TilesURLs:         *tilesURLs,
+		DownloadRate:      *downloadRate,
+		TilesVideo:        *tilesVideo,
+		VideoFrameStep:    *videoFrameStep,
+		TileSize:          *tileSize,
+		OutputSize:        *outputSize,
+		OutputWidth:       *outputWidth,
+		OutputHeight:      *outputHeight,
+		OutputStretch:     *outputStretch,
+		Cols:              *cols,
+		Rows:              *rows,
+		Letterbox:         *letterbox,
+		MatteColor:        *matteColor,
+		NoTrim:            *noTrim,
+		TrimThreshold:     *trimThreshold,
+		TrimColor:         *trimColor,
+		Crop:              *crop,
+		FaceCascade:       *faceCascade,
+		FaceSplit:         *faceSplit,
+		UsagePenalty:      *usagePenalty,
+		PickFromTop:       *pickFromTop,
+		SelfMosaic:        *selfMosaic,
+		SelfImage:         *selfImage,
+		SelfSlices:        *selfSlices,
+		Recurse:           *recurse,
+		RecurseSplit:      *recurseSplit,
+		CompareSpace:      *compareSpace,
+		DCTSize:           *dctSize,
+		OutputProfile:     *outputProfile,
+		HistogramMatch:    *histogramMatch,
+		Heatmap:           *heatmap,
+		UsageReport:       *usageReport,
+		Manifest:          *manifest,
+		Checkpoint:        *checkpoint,
+		CheckpointEvery:   *checkpointEvery,
+		Resume:            *resume,
+		SnapshotEvery:     *snapshotEvery,
+		Animation:         *animation,
+		AnimationStride:   *animationStride,
+		AnimationWidth:    *animationWidth,
+		Export:            *export,
+		ExportURL:         *exportURL,
+		DeepZoom:          *deepZoom,
+		DeepZoomLayout:    *deepZoomLayout,
+		OutputImage:       *output,
+		CompareSize:       *comparesize,
+		CompareDist:       float64(*comparedist),
+		Unique:            *unique,
+		SmartCrop:         *smartcrop,
+		ProgressBar:       *progressbar,
+		ProgressText:      *progresstext,
+		RedisAddr:         *redisAddr,
+		RedisLabel:        *redisLabel,
+		TileFilter:        *tileFilter,
+		RedisPassword:     *redisPassword,
+		RedisDB:           *redisDB,
+		RedisTLS:          *redisTLS,
+		Cache:             *cacheSpec,
+		Workers:           *workers,
+		Candidates:        *candidates,
+		Assignment:        *assignment,
+		AllowRotate:       *allowRotate,
+		AllowFlip:         *allowFlip,
+		ColorBlend:        *colorBlend,
+		OverlayOpacity:    *overlayOpacity,
+		OverlayMode:       *overlayMode,
+		RepeatDistance:    *repeatDistance,
+		OutputFormat:      *outputFormat,
+		Quality:           *quality,
+		Lossless:          *lossless,
+		PDF:               *pdf,
+		PrintSize:         *printSize,
+		PDFPages:          *pdfPages,
+		DPI:               *dpi,
+		SplitPages:        *splitPages,
+		SplitOverlap:      *splitOverlap,
+		Legend:            *legend,
+		Stream:            *stream,
+		Metadata:          *metadata,
+		Watermark:         *watermark,
+		WatermarkPos:      *watermarkPos,
+		WatermarkOpacity:  *watermarkOpacity,
+		MaxUses:           *maxUses,
+		Quadtree:          *quadtree,
+		QuadtreeThreshold: *quadtreeThreshold,
+		Layout:            *layout,
+		VoronoiSites:      *voronoiSites,
+		VoronoiSaliency:   *voronoiSaliency,
+		GroutWidth:        *groutWidth,
+		GroutColor:        *groutColor,
+		Grayscale:         *grayscale,
+		GrayscaleOutput:   *grayscaleOutput,
+		CenterWeight:      *centerWeight,
+		Background:        *background,
+		MaskImage:         *maskImage,
+		MaskBlank:         *maskBlank,
+		WeightMap:         *weightMap,
+		RandomSeed:        *randomSeed,
+		Dedupe:            *dedupe,
+		DedupeDistance:    *dedupeDistance,
+		MinSharpness:      *minSharpness,
+		MinContrast:       *minContrast,
+		MaxUniformity:     *maxUniformity,
+		WidenSteps:        *widenSteps,
+		Filler:            *filler,
+		MergeThreshold:    *mergeThreshold,
+		CollageRotation:   *collageRotation,
+		CollageJitter:     *collageJitter,
+		CollageOverlap:    *collageOverlap,
+		Palette:           *palette,
+		PaletteDistance:   *paletteDistance,
+		PaletteSize:       *paletteSize,
+		TileStyle:         *tileStyle,
+		DuotoneShadow:     *duotoneShadow,
+		DuotoneHighlight:  *duotoneHighlight,
+		Order:             *order,
+		LinearLight:       *linearLight,
+		Refine:            *refine,
+		Feather:           *feather,
+	}
+
+	if *render != "" {
+		m, err := gosaic.LoadManifest(*render)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := gosaic.Render(config, m); err != nil {
+			log.Fatal(err)
+		}
+		return
 	}
 
 	g, err := gosaic.New(config)
 	if err != nil {
 		log.Fatal(err)
 	}
+	defer g.Close()
 
 	err = g.Build()
 	if err != nil {
@@ -1,55 +1,80 @@
 package main
 
 import (
-	"bytes"
-	"context"
 	"flag"
 	"fmt"
-	"image/jpeg"
 	"log"
+	"os"
 	"path/filepath"
+	"sort"
 	"sync"
 	"time"
 
 	"github.com/davidbyttow/govips/v2/vips"
-	redis "github.com/go-redis/redis/v8"
+	"github.com/elcamino/gosaic"
 )
 
 type Importer struct {
-	Label    string
+	// TileImport turns the files into the tiles of its label
+	*gosaic.TileImport
 	Tilesize int
-	Redis    *redis.Client
-	Time     time.Duration
-	Workers  int
-	Total    int
-	Current  int
+	// Force imports all files, otherwise files whose content is already
+	// cached in all sizes are skipped
+	Force   bool
+	Cache   gosaic.TileCache
+	Time    time.Duration
+	Workers int
+	Total   int
+	Current int
+	Skipped int
+	// Scanned and Imported count the files passed to Run and those
+	// imported
+	Scanned  int
+	Imported int
+	// Failed are the files which couldn't be imported
+	Failed []Failure
+	// Stages sums up the time spent in each stage of the import
+	Stages map[string]time.Duration
+	// ReportFile is written a JSON Report after every Run if it is set,
+	// covering the files imported since Started
+	ReportFile string
+	Started    time.Time
+	// Hashes are the difference hashes of the imported tiles of Label,
+	// images near one of them are rejected if it is set
+	Hashes *gosaic.HashIndex
+	// Rejected counts the rejected images by the reason
+	Rejected map[string]int
 	wg       sync.WaitGroup
 	mutex    sync.Mutex
 }
 
-func NewImporter(label string, tilesize int, redisAddr string, workers int) (*Importer, error) {
-	i := Importer{
-		Label:    label,
-		Tilesize: tilesize,
-		Time:     0,
-		Redis:    redis.NewClient(&redis.Options{Addr: redisAddr}),
-		Workers:  workers,
-		Current:  0,
-		mutex:    sync.Mutex{},
-		wg:       sync.WaitGroup{},
+// NewImporter returns the importer of the tiles of label in tilesize and,
+// if it isn't 0, in compareSize for the comparisons
+func NewImporter(label string, tilesize, compareSize int, cache string, ro gosaic.RedisOptions, workers int) (*Importer, error) {
+	c, err := gosaic.OpenCache(cache, ro)
+	if err != nil {
+		return nil, err
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
-	defer cancel()
-	res := i.Redis.Ping(ctx)
-	if res.Err() != nil {
-		return nil, res.Err()
+	i := Importer{
+		TileImport: gosaic.NewTileImport(label, tilesize, compareSize),
+		Tilesize:   tilesize,
+		Time:       0,
+		Cache:      c,
+		Workers:    workers,
+		Current:    0,
+		Rejected:   map[string]int{},
+		Stages:     map[string]time.Duration{},
+		Started:    time.Now(),
+		mutex:      sync.Mutex{},
+		wg:         sync.WaitGroup{},
 	}
 
 	return &i, nil
 }
 
 func (i *Importer) Worker(filenameChan chan string) {
+	defer i.wg.Done()
 	for fn := range filenameChan {
 		i.Import(fn)
 	}
@@ -72,18 +97,36 @@ func (i *Importer) Progress() {
 	}
 }
 
-func (i *Importer) Run(glob string) error {
-	images, err := filepath.Glob(glob)
+// listImages returns the image files in dir and its subdirectories with
+// one of exts if dir is set, otherwise those matching glob.
+func listImages(glob, dir, exts string) ([]string, error) {
+	if dir != "" {
+		return gosaic.ListImageFiles(dir, gosaic.ParseExtensions(exts))
+	}
+
+	matches, err := filepath.Glob(glob)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	images := make([]string, 0, len(matches))
+	for _, m := range matches {
+		if gosaic.IsImageFile(m) {
+			images = append(images, m)
+		}
 	}
+	return images, nil
+}
 
+func (i *Importer) Run(images []string) error {
 	i.mutex.Lock()
 	i.Total = len(images)
+	i.Current = 0
+	i.Scanned += len(images)
 	i.mutex.Unlock()
 
 	fnameChan := make(chan string)
 	for x := 0; x < i.Workers; x++ {
+		i.wg.Add(1)
 		go i.Worker(fnameChan)
 	}
 
@@ -93,91 +136,200 @@ func (i *Importer) Run(glob string) error {
 	}
 	close(fnameChan)
 	i.wg.Wait()
+
+	if i.ReportFile != "" {
+		return i.WriteReport(i.ReportFile)
+	}
 	return nil
 }
 
+// duplicate returns the name of an imported tile near the tile size of
+// tiles, which are added to the hashes otherwise
+func (i *Importer) duplicate(tiles []*gosaic.CachedTile) (string, bool) {
+	for _, tile := range tiles {
+		if tile.Size != i.Tilesize {
+			continue
+		}
+		i.mutex.Lock()
+		defer i.mutex.Unlock()
+		if duplicate, ok := i.Hashes.Duplicate(tile.PHash, tile.Name); ok {
+			return duplicate, true
+		}
+		i.Hashes.Add(tile.PHash, tile.Name)
+	}
+	return "", false
+}
+
 func (i *Importer) Import(filename string) {
 	tStart := time.Now()
-	img, err := vips.NewImageFromFile(filename)
-	if err != nil {
-		log.Printf("%s: %s\n", filename, err)
-		return
-	}
 
-	// remove a white frame around the picture
-	left, top, width, height, err := img.FindTrim(40, &vips.Color{R: 255, G: 255, B: 255})
+	source, err := filepath.Abs(filename)
 	if err != nil {
-		log.Printf("%s: %s\n", filename, err)
+		source = filename
 	}
-
-	if width < img.Width() || height < img.Height() {
-		err = img.ExtractArea(left, top, width, height)
-		if err != nil {
-			log.Printf("%s: %s\n", filename, err)
-		}
-	}
-
-	err = img.Thumbnail(i.Tilesize, i.Tilesize, vips.InterestingCentre)
+	info, err := os.Stat(filename)
 	if err != nil {
-		log.Printf("%s: %s\n", filename, err)
+		i.fail(filename, err)
 		return
 	}
-
-	avg, err := img.Average()
+	name, err := gosaic.FileHash(filename)
 	if err != nil {
-		log.Printf("%s: %s\n", filename, err)
+		i.fail(filename, err)
 		return
 	}
-
-	image, err := img.ToImage(vips.NewDefaultPNGExportParams())
-	if err != nil {
-		log.Printf("%s: %s\n", filename, err)
+	if !i.Force && i.UpToDate(i.Cache, name) {
+		i.mutex.Lock()
+		i.Skipped++
+		i.mutex.Unlock()
+		return
+	}
+	t := i.stage(stageCheck, tStart)
+
+	tiles, err := i.Tiles(filename, name, source, info.ModTime().UnixNano(), func(stage string) {
+		t = i.stage(stage, t)
+	})
+	if rejected, ok := err.(*gosaic.RejectError); ok {
+		i.mutex.Lock()
+		i.Rejected[rejected.Reason]++
+		i.mutex.Unlock()
 		return
 	}
-
-	buf := bytes.NewBuffer([]byte{})
-	err = jpeg.Encode(buf, image, &jpeg.Options{Quality: 90})
 	if err != nil {
-		log.Printf("%s: %s\n", filename, err)
+		i.fail(filename, err)
 		return
 	}
 
-	i.AddToTime(time.Now().Sub(tStart))
+	if i.Hashes != nil {
+		if duplicate, ok := i.duplicate(tiles); ok {
+			log.Printf("%s: near duplicate of %s\n", filename, duplicate)
+			i.mutex.Lock()
+			i.Rejected["near duplicates of imported tiles"]++
+			i.mutex.Unlock()
+			return
+		}
+	}
 
-	k := fmt.Sprintf("%s:%d:%d:%s", i.Label, i.Tilesize, int(avg), filepath.Base(filename))
+	i.AddToTime(time.Now().Sub(tStart))
 
-	res := i.Redis.Set(context.Background(), k, buf.Bytes(), 0)
-	if res.Err() != nil {
-		log.Printf("%s: %s\n", filename, res.Err())
+	for _, tile := range tiles {
+		if err := i.Cache.Set(tile); err != nil {
+			i.fail(filename, err)
+			return
+		}
 	}
+	i.stage(stageStore, t)
 
-	img.Close()
-	buf = nil
-	image = nil
+	i.mutex.Lock()
+	i.Imported++
+	i.mutex.Unlock()
 }
 
 func main() {
 	var tileGlob = flag.String("tileglob", "", "import all images that match this glob pattern")
+	var tilesDir = flag.String("tilesdir", "", "import all images in this directory and its subdirectories, skipping hidden and system files, instead of -tileglob")
+	var tileExt = flag.String("ext", "", "only import the files with these comma separated extensions from -tilesdir, like jpg,png (default all images gosaic can read)")
 	var label = flag.String("label", "gosaic", "save the tiles using this label")
 	var tileSize = flag.Int("tilesize", 100, "crop and scale the tiles to this size")
+	var compareSize = flag.Int("comparesize", 50, "also import the tiles in this size for the comparisons of gosaic -comparesize, 0 to only import -tilesize")
 	var redisAddr = flag.String("redisaddr", "localhost:6379", "import the images into this redis instance")
+	env := gosaic.RedisEnv()
+	var redisPassword = flag.String("redis-password", env.Password, "the password of the redis instance (default $REDIS_PASSWORD)")
+	var redisDB = flag.Int("redis-db", env.DB, "the database of the redis instance (default $REDIS_DB)")
+	var redisTLS = flag.Bool("redis-tls", env.TLS, "connect to the redis instance with TLS (default $REDIS_TLS)")
+	var cache = flag.String("cache", "", "import the images into this tile cache instead of -redisaddr: a redis://[:password@]host:port/db URL, or file:path.db or bolt:path.db for an embedded cache file, prefer -redis-password since the URL is visible in the process list")
 	var workers = flag.Int("workers", 8, "the number of parallel import workers")
+	var crop = flag.String("crop", gosaic.CropCenter, "how to crop the tiles to squares: center, attention, entropy, low or high")
+	var noTrim = flag.Bool("no-trim", false, "keep the frames around the images instead of removing them")
+	var trimThreshold = flag.Float64("trim-threshold", gosaic.DefaultTrimThreshold, "remove the rows and columns at the edges of the images whose pixels differ from -trim-color by less than this")
+	var trimColor = flag.String("trim-color", gosaic.DefaultTrimColor, "the color of the frames removed around the images")
+	var minWidth = flag.Int("min-width", 0, "skip images narrower than this many pixels, like icons")
+	var minHeight = flag.Int("min-height", 0, "skip images lower than this many pixels")
+	var maxAspect = flag.Float64("max-aspect", 0, "skip images whose longer side is more than this many times the shorter one, like panoramas and screenshots, 0 for no limit")
+	var dedupeThreshold = flag.Int("dedupe-threshold", -1, "skip images whose perceptual hash differs in at most this many bits (of 64) from a tile already imported under -label, like the shots of a burst, -1 imports all")
+	var tags = flag.String("tags", "", "add these comma separated tags, like beach,2023, to the imported tiles for gosaic -tile-filter")
+	var report = flag.String("report", "", "write the scanned, imported, skipped, rejected and failed files and the time spent in each stage to this JSON file")
+	var cacheFormat = flag.String("cache-format", gosaic.DefaultCacheEncoding.Format, "cache the tiles as jpeg, png or webp, which typically takes half the memory of jpeg")
+	var cacheQuality = flag.Int("cache-quality", gosaic.DefaultCacheEncoding.Quality, "the quality (1..100) of the cached jpeg and webp tiles")
+	var ttl = flag.Duration("ttl", 0, "let the imported tiles expire after this long, like 72h for the tiles of an event, 0 keeps them")
+	var force = flag.Bool("force", false, "import all files again, by default files whose content is already imported under -label are skipped")
+	var watch = flag.Bool("watch", false, "keep watching -tilesdir, or the directory of -tileglob, and import new and changed images as they appear")
+	var rawDecoder = flag.String("raw-decoder", gosaic.RAWDecoder, "the dcraw compatible command RAW camera files are decoded with")
 
 	flag.Parse()
+	gosaic.RAWDecoder = *rawDecoder
 
 	vips.LoggingSettings(func(messageDomain string, messageLevel vips.LogLevel, message string) {
 		log.Println(message)
 	}, vips.LogLevelError)
 
-	imp, err := NewImporter(*label, *tileSize, *redisAddr, *workers)
+	if *cache == "" {
+		*cache = *redisAddr
+	}
+	ro := gosaic.RedisOptions{Password: *redisPassword, DB: *redisDB, TLS: *redisTLS}
+	imp, err := NewImporter(*label, *tileSize, *compareSize, *cache, ro, *workers)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer imp.Cache.Close()
+
+	imp.Force = *force
+	imp.TTL = *ttl
+	imp.Encoding = gosaic.CacheEncoding{Format: *cacheFormat, Quality: *cacheQuality}
+	if err := imp.Encoding.Check(); err != nil {
+		log.Fatal(err)
+	}
+	imp.ReportFile = *report
+	imp.Tags = gosaic.ParseTags(*tags)
+	imp.NoTrim = *noTrim
+	imp.TrimThreshold = *trimThreshold
+	imp.TrimColor = *trimColor
+	imp.MinWidth = *minWidth
+	imp.MinHeight = *minHeight
+	imp.MaxAspect = *maxAspect
+	if *maxAspect != 0 && *maxAspect < 1 {
+		log.Fatalf("max aspect %g is less than 1", *maxAspect)
+	}
+	if *trimThreshold < 0 {
+		log.Fatalf("trim threshold %g is negative", *trimThreshold)
+	}
+	imp.Crop, err = gosaic.ParseCrop(*crop)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if *dedupeThreshold >= 0 {
+		imp.Hashes = gosaic.NewHashIndex(*dedupeThreshold)
+		if err := gosaic.LabelHashes(imp.Cache, imp.Label, imp.Tilesize, imp.Hashes); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	images, err := listImages(*tileGlob, *tilesDir, *tileExt)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	err = imp.Run(*tileGlob)
+	err = imp.Run(images)
 	if err != nil {
 		log.Fatal(err)
 	}
 
+	reasons := make([]string, 0, len(imp.Rejected))
+	for reason := range imp.Rejected {
+		reasons = append(reasons, reason)
+	}
+	sort.Strings(reasons)
+	for _, reason := range reasons {
+		fmt.Printf("rejected %d files %s\n", imp.Rejected[reason], reason)
+	}
+	if imp.Skipped > 0 {
+		fmt.Printf("skipped %d unchanged files\n", imp.Skipped)
+	}
 	fmt.Printf("load time: %s\n", imp.Time)
+
+	if *watch {
+		if err := imp.Watch(*tileGlob, *tilesDir, *tileExt, images); err != nil {
+			log.Fatal(err)
+		}
+	}
 }
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"time"
+)
+
+// Stages of the import of a file which are timed for the report
+// besides gosaic.StageLoad, StageScale and StageEncode
+const (
+	stageCheck = "check" // checking whether the file is up to date
+	stageStore = "store" // storing the tiles in the cache
+)
+
+// Failure is a file which couldn't be imported
+type Failure struct {
+	File   string `json:"file"`
+	Reason string `json:"reason"`
+}
+
+// Report sums up an import for auditing
+type Report struct {
+	Label        string             `json:"label"`
+	Started      time.Time          `json:"started"`
+	Scanned      int                `json:"scanned"`
+	Imported     int                `json:"imported"`
+	Skipped      int                `json:"skipped"`
+	Rejected     map[string]int     `json:"rejected"`
+	Failed       []Failure          `json:"failed"`
+	TotalSeconds float64            `json:"total_seconds"`
+	StageSeconds map[string]float64 `json:"stage_seconds"`
+}
+
+// fail logs and records that filename couldn't be imported
+func (i *Importer) fail(filename string, err error) {
+	log.Printf("%s: %s\n", filename, err)
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	i.Failed = append(i.Failed, Failure{File: filename, Reason: err.Error()})
+}
+
+// stage adds the time since start to stage and returns the current time
+// as the start of the next stage
+func (i *Importer) stage(stage string, start time.Time) time.Time {
+	now := time.Now()
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	i.Stages[stage] += now.Sub(start)
+	return now
+}
+
+// Report returns the report of the files imported so far
+func (i *Importer) Report() Report {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	r := Report{
+		Label:        i.Label,
+		Started:      i.Started,
+		Scanned:      i.Scanned,
+		Imported:     i.Imported,
+		Skipped:      i.Skipped,
+		Rejected:     map[string]int{},
+		Failed:       append([]Failure{}, i.Failed...),
+		TotalSeconds: time.Since(i.Started).Seconds(),
+		StageSeconds: map[string]float64{},
+	}
+	for reason, n := range i.Rejected {
+		r.Rejected[reason] = n
+	}
+	for stage, d := range i.Stages {
+		r.StageSeconds[stage] = d.Seconds()
+	}
+	return r
+}
+
+// WriteReport writes the report as JSON to filename
+func (i *Importer) WriteReport(filename string) error {
+	data, err := json.MarshalIndent(i.Report(), "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filename, data, 0644)
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// watchSettle is how long the watched directories have to be quiet before
+// they are scanned, so files which are still being copied aren't imported
+const watchSettle = 2 * time.Second
+
+// watchDirs returns the directories to watch for new images: dir and its
+// subdirectories which aren't hidden if dir is set, otherwise the
+// directories glob matches files in.
+func watchDirs(glob, dir string) ([]string, error) {
+	if dir == "" {
+		return filepath.Glob(filepath.Dir(glob))
+	}
+
+	dirs := []string{}
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			return nil
+		}
+		if path != dir && strings.HasPrefix(info.Name(), ".") {
+			return filepath.SkipDir
+		}
+		dirs = append(dirs, path)
+		return nil
+	})
+	return dirs, err
+}
+
+// modTimes returns the modification times of the files
+func modTimes(files []string) map[string]time.Time {
+	times := map[string]time.Time{}
+	for _, f := range files {
+		if info, err := os.Stat(f); err == nil {
+			times[f] = info.ModTime()
+		}
+	}
+	return times
+}
+
+// Watch imports the new and changed images of glob or dir as they appear
+// until the watcher fails. seen are the images which were already
+// imported.
+func (i *Importer) Watch(glob, dir, exts string, seen []string) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+
+	dirs, err := watchDirs(glob, dir)
+	if err != nil {
+		return err
+	}
+	for _, d := range dirs {
+		if err := watcher.Add(d); err != nil {
+			return err
+		}
+	}
+	log.Printf("watching %d directories for new images\n", len(dirs))
+
+	times := modTimes(seen)
+	settle := time.NewTimer(watchSettle)
+	settle.Stop()
+	for {
+		select {
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			// new subdirectories of dir are watched too
+			if dir != "" && event.Op&fsnotify.Create != 0 {
+				if info, err := os.Stat(event.Name); err == nil && info.IsDir() && !strings.HasPrefix(info.Name(), ".") {
+					if err := watcher.Add(event.Name); err != nil {
+						log.Printf("%s: %s\n", event.Name, err)
+					}
+				}
+			}
+			settle.Reset(watchSettle)
+
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			return err
+
+		case <-settle.C:
+			images, err := listImages(glob, dir, exts)
+			if err != nil {
+				log.Println(err)
+				continue
+			}
+			changed := []string{}
+			current := modTimes(images)
+			for _, f := range images {
+				if t, ok := times[f]; !ok || !t.Equal(current[f]) {
+					changed = append(changed, f)
+				}
+			}
+			times = current
+			if len(changed) == 0 {
+				continue
+			}
+
+			log.Printf("importing %d new or changed images\n", len(changed))
+			if err := i.Run(changed); err != nil {
+				log.Println(err)
+			}
+		}
+	}
+}
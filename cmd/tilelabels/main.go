@@ -0,0 +1,75 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/elcamino/gosaic"
+)
+
+const usage = `usage: tilelabels [flags] command
+
+commands:
+  list                 list the labels with their tile counts per size
+  count LABEL          print the tile counts of LABEL per size
+  rename LABEL NEW     rename LABEL to NEW
+  delete LABEL         delete the tiles of LABEL
+
+flags:
+`
+
+func main() {
+	env := gosaic.RedisEnv()
+	var cache = flag.String("cache", "localhost:6379", "the tile cache: a redis address or URL, file:path.db or bolt:path.db")
+	var redisPassword = flag.String("redis-password", env.Password, "the password of the redis instance (default $REDIS_PASSWORD)")
+	var redisDB = flag.Int("redis-db", env.DB, "the database of the redis instance (default $REDIS_DB)")
+	var redisTLS = flag.Bool("redis-tls", env.TLS, "connect to the redis instance with TLS (default $REDIS_TLS)")
+
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	args := flag.Args()
+	want := map[string]int{"list": 1, "count": 2, "rename": 3, "delete": 2}
+	if len(args) == 0 || want[args[0]] != len(args) {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	c, err := gosaic.OpenCache(*cache, gosaic.RedisOptions{Password: *redisPassword, DB: *redisDB, TLS: *redisTLS})
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer c.Close()
+
+	switch args[0] {
+	case "list", "count":
+		label := ""
+		if len(args) > 1 {
+			label = args[1]
+		}
+		index, err := gosaic.LabelIndex(c, label)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, ci := range index {
+			fmt.Printf("%s\t%d\t%d\n", ci.Label, ci.Size, ci.Count)
+		}
+	case "rename":
+		n, err := gosaic.RenameLabel(c, args[1], args[2])
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("renamed %d tiles\n", n)
+	case "delete":
+		n, err := gosaic.DeleteLabel(c, args[1])
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("deleted %d tiles\n", n)
+	}
+}
@@ -0,0 +1,61 @@
+package gosaic
+
+import (
+	"image"
+	"math"
+
+	xdraw "golang.org/x/image/draw"
+	"golang.org/x/image/math/f64"
+)
+
+// Defaults of the collage layout
+const (
+	DefaultCollageRotation = 10.0
+	DefaultCollageJitter   = 0.2
+	DefaultCollageOverlap  = 0.3
+)
+
+// collageCells scatters enlarged tiles over the grid with random offsets
+// and rotations so that they overlap like photos in a scrapbook. The
+// shuffled match order decides which tile ends up on top.
+func (g *Gosaic) collageCells() []cell {
+	size := g.SeedImage.Bounds().Size()
+	ts := g.config.TileSize
+	side := int(float64(ts) * (1 + g.config.CollageOverlap))
+	jitter := g.config.CollageJitter * float64(ts)
+
+	cells := make([]cell, 0)
+	for x := 0; x < size.X/ts+1; x++ {
+		for y := 0; y < size.Y/ts+1; y++ {
+			cx := x*ts + ts/2 + int((g.rand.Float64()*2-1)*jitter)
+			cy := y*ts + ts/2 + int((g.rand.Float64()*2-1)*jitter)
+			r := image.Rect(cx-side/2, cy-side/2, cx-side/2+side, cy-side/2+side)
+			angle := (g.rand.Float64()*2 - 1) * g.config.CollageRotation
+			cells = append(cells, cell{X: x, Y: y, Rect: r, Angle: angle})
+		}
+	}
+
+	return cells
+}
+
+// drawRotated draws img rotated by angle degrees around its center onto
+// dst, centered on the center of r.
+func drawRotated(dst *image.RGBA, r image.Rectangle, img image.Image, mask image.Image, angle float64) {
+	b := img.Bounds()
+	sin, cos := math.Sincos(angle * math.Pi / 180)
+	cx := float64(r.Min.X) + float64(r.Dx())/2
+	cy := float64(r.Min.Y) + float64(r.Dy())/2
+	sx := float64(b.Min.X) + float64(b.Dx())/2
+	sy := float64(b.Min.Y) + float64(b.Dy())/2
+
+	s2d := f64.Aff3{
+		cos, -sin, cx - cos*sx + sin*sy,
+		sin, cos, cy - sin*sx - cos*sy,
+	}
+
+	var opts *xdraw.Options
+	if mask != nil {
+		opts = &xdraw.Options{SrcMask: mask}
+	}
+	xdraw.BiLinear.Transform(dst, s2d, img, b, xdraw.Over, opts)
+}
@@ -0,0 +1,138 @@
+package gosaic
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"strconv"
+	"strings"
+)
+
+// meanColor returns the mean red, green and blue value of img in the
+// 0..0xffff range of color.RGBA64.
+func meanColor(img image.Image) [3]float64 {
+	return meanColorMasked(img, nil)
+}
+
+// meanColorMasked is meanColor restricted to the opaque pixels of mask
+func meanColorMasked(img, mask image.Image) [3]float64 {
+	var mean [3]float64
+	var n float64
+
+	b := img.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if mask != nil {
+				mb := mask.Bounds()
+				_, _, _, a := mask.At(x-b.Min.X+mb.Min.X, y-b.Min.Y+mb.Min.Y).RGBA()
+				if a < 0x8000 {
+					continue
+				}
+			}
+
+			r, g, bl, _ := img.At(x, y).RGBA()
+			mean[0] += float64(r)
+			mean[1] += float64(g)
+			mean[2] += float64(bl)
+			n++
+		}
+	}
+
+	if n == 0 {
+		return mean
+	}
+
+	for i := range mean {
+		mean[i] /= n
+	}
+	return mean
+}
+
+// colorBlend shifts the colors of img so that its mean color moves towards
+// target by the given amount (0 leaves img untouched, 1 matches target).
+func colorBlend(img image.Image, target [3]float64, amount float64) *image.RGBA {
+	b := img.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(dst, dst.Bounds(), img, b.Min, draw.Src)
+
+	mean := meanColor(dst)
+	var shift [3]float64
+	for i := range shift {
+		shift[i] = amount * (target[i] - mean[i]) / 0x101
+	}
+
+	for y := 0; y < b.Dy(); y++ {
+		for x := 0; x < b.Dx(); x++ {
+			c := dst.RGBAAt(x, y)
+			dst.SetRGBA(x, y, color.RGBA{
+				R: clamp8(float64(c.R) + shift[0]),
+				G: clamp8(float64(c.G) + shift[1]),
+				B: clamp8(float64(c.B) + shift[2]),
+				A: c.A,
+			})
+		}
+	}
+
+	return dst
+}
+
+// toGray returns a copy of img with every pixel replaced by its luminance
+func toGray(img image.Image) *image.RGBA {
+	b := img.Bounds()
+	gray := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	for y := 0; y < b.Dy(); y++ {
+		for x := 0; x < b.Dx(); x++ {
+			c := img.At(b.Min.X+x, b.Min.Y+y)
+			l := color.GrayModel.Convert(c).(color.Gray).Y
+			_, _, _, a := c.RGBA()
+			gray.SetRGBA(x, y, color.RGBA{R: l, G: l, B: l, A: uint8(a >> 8)})
+		}
+	}
+	return gray
+}
+
+// grayAverage returns the mean luminance (0..255) of a gray image
+func grayAverage(img *image.RGBA) float64 {
+	b := img.Bounds()
+	n := b.Dx() * b.Dy()
+	if n == 0 {
+		return 0
+	}
+
+	var sum int
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			sum += int(img.RGBAAt(x, y).R)
+		}
+	}
+	return float64(sum) / float64(n)
+}
+
+// parseHexColor parses colors in the form #rrggbb or #rgb
+func parseHexColor(s string) (color.RGBA, error) {
+	hex := strings.TrimPrefix(s, "#")
+	if len(hex) == 3 {
+		hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+	}
+	if len(hex) != 6 {
+		return color.RGBA{}, fmt.Errorf("invalid color %q", s)
+	}
+
+	v, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return color.RGBA{}, fmt.Errorf("invalid color %q: %s", s, err)
+	}
+
+	return color.RGBA{R: uint8(v >> 16), G: uint8(v >> 8), B: uint8(v), A: 0xff}, nil
+}
+
+func clamp8(v float64) uint8 {
+	switch {
+	case v < 0:
+		return 0
+	case v > 255:
+		return 255
+	}
+	return uint8(v + 0.5)
+}
@@ -0,0 +1,170 @@
+package gosaic
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"sync"
+)
+
+// Comparator computes the distance between two equally sized images. Lower
+// distances mean more similar images, 0.0 means identical.
+type Comparator interface {
+	Distance(a, b image.Image) (float64, error)
+}
+
+// RGBComparator is the default Comparator. It returns the mean absolute
+// difference of the red, green and blue channels, normalized to 0..1.
+// CenterWeight > 0 weighs the pixels near the center higher. Linear
+// compares the colors in linear light instead of sRGB, which doesn't
+// exaggerate the differences of dark colors.
+type RGBComparator struct {
+	CenterWeight float64
+	Linear       bool
+}
+
+func (c RGBComparator) Distance(img1, img2 image.Image) (float64, error) {
+	if img1.ColorModel() != img2.ColorModel() {
+		return 0.0, errors.New("different color models")
+	}
+
+	b := img1.Bounds()
+	d := img2.Bounds()
+	if b.Dx() != d.Dx() || b.Dy() != d.Dy() {
+		return 0.0, fmt.Errorf("bounds are not identical: %v vs. %v", b, d)
+	}
+
+	weights := centerWeights(b.Dx(), b.Dy(), c.CenterWeight)
+
+	var sum float64
+	for x := 0; x < b.Dx(); x++ {
+		for y := 0; y < b.Dy(); y++ {
+			x1 := x + b.Min.X
+			y1 := y + b.Min.Y
+			x2 := x + d.Min.X
+			y2 := y + d.Min.Y
+			r1, g1, b1, _ := img1.At(x1, y1).RGBA()
+			r2, g2, b2, _ := img2.At(x2, y2).RGBA()
+			if c.Linear {
+				r1, g1, b1 = linearLUT[r1>>8], linearLUT[g1>>8], linearLUT[b1>>8]
+				r2, g2, b2 = linearLUT[r2>>8], linearLUT[g2>>8], linearLUT[b2>>8]
+			}
+
+			px := float64(diff(r1, r2) + diff(g1, g2) + diff(b1, b2))
+			if weights != nil {
+				px *= weights[y*b.Dx()+x]
+			}
+			sum += px
+		}
+	}
+
+	nPixels := b.Dx() * b.Dy()
+
+	dist := float64(sum) / (float64(nPixels) * 0xffff * 3)
+	return dist, nil
+}
+
+// LumaComparator only compares the luminance of the images, which is what
+// matters for black-and-white mosaics. Distances are normalized to 0..1.
+type LumaComparator struct {
+	CenterWeight float64
+	Linear       bool
+}
+
+func (c LumaComparator) Distance(img1, img2 image.Image) (float64, error) {
+	b := img1.Bounds()
+	d := img2.Bounds()
+	if b.Dx() != d.Dx() || b.Dy() != d.Dy() {
+		return 0.0, fmt.Errorf("bounds are not identical: %v vs. %v", b, d)
+	}
+
+	weights := centerWeights(b.Dx(), b.Dy(), c.CenterWeight)
+
+	var sum float64
+	for x := 0; x < b.Dx(); x++ {
+		for y := 0; y < b.Dy(); y++ {
+			l1 := uint32(color.Gray16Model.Convert(img1.At(x+b.Min.X, y+b.Min.Y)).(color.Gray16).Y)
+			l2 := uint32(color.Gray16Model.Convert(img2.At(x+d.Min.X, y+d.Min.Y)).(color.Gray16).Y)
+			if c.Linear {
+				l1, l2 = linearLUT[l1>>8], linearLUT[l2>>8]
+			}
+
+			px := float64(diff(l1, l2))
+			if weights != nil {
+				px *= weights[y*b.Dx()+x]
+			}
+			sum += px
+		}
+	}
+
+	nPixels := b.Dx() * b.Dy()
+
+	dist := float64(sum) / (float64(nPixels) * 0xffff)
+	return dist, nil
+}
+
+// linearLUT maps 8 bit sRGB values to 16 bit linear light
+var linearLUT = func() [256]uint32 {
+	var lut [256]uint32
+	for i := range lut {
+		v := float64(i) / 255
+		if v <= 0.04045 {
+			v /= 12.92
+		} else {
+			v = math.Pow((v+0.055)/1.055, 2.4)
+		}
+		lut[i] = uint32(v*0xffff + 0.5)
+	}
+	return lut
+}()
+
+type weightKey struct {
+	w, h     int
+	strength float64
+}
+
+var weightCache sync.Map
+
+// centerWeights returns gaussian weights for the pixels of a w x h image,
+// which favor the center the more the higher strength is. The weights sum
+// up to w*h so distances stay in the same range. A strength <= 0 returns nil.
+func centerWeights(w, h int, strength float64) []float64 {
+	if strength <= 0 || w == 0 || h == 0 {
+		return nil
+	}
+
+	key := weightKey{w: w, h: h, strength: strength}
+	if cached, ok := weightCache.Load(key); ok {
+		return cached.([]float64)
+	}
+
+	sigma := math.Max(float64(w), float64(h)) / (2 * strength)
+	cx, cy := float64(w-1)/2, float64(h-1)/2
+
+	weights := make([]float64, w*h)
+	var sum float64
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			dx, dy := float64(x)-cx, float64(y)-cy
+			weights[y*w+x] = math.Exp(-(dx*dx + dy*dy) / (2 * sigma * sigma))
+			sum += weights[y*w+x]
+		}
+	}
+
+	scale := float64(w*h) / sum
+	for i := range weights {
+		weights[i] *= scale
+	}
+
+	weightCache.Store(key, weights)
+	return weights
+}
+
+func diff(a, b uint32) int32 {
+	if a > b {
+		return int32(a - b)
+	}
+	return int32(b - a)
+}
@@ -0,0 +1,46 @@
+package gosaic
+
+import (
+	"fmt"
+
+	"github.com/davidbyttow/govips/v2/vips"
+)
+
+// Crop strategies which pick the part of an image kept when it is cropped
+// to a square
+const (
+	CropCenter    = "center"
+	CropAttention = "attention"
+	CropEntropy   = "entropy"
+	CropLow       = "low"
+	CropHigh      = "high"
+)
+
+// ParseCrop returns the vips interesting mode of a crop strategy
+func ParseCrop(name string) (vips.Interesting, error) {
+	switch name {
+	case CropCenter:
+		return vips.InterestingCentre, nil
+	case CropAttention:
+		return vips.InterestingAttention, nil
+	case CropEntropy:
+		return vips.InterestingEntropy, nil
+	case CropLow:
+		return vips.InterestingLow, nil
+	case CropHigh:
+		return vips.InterestingHigh, nil
+	}
+	return vips.InterestingNone, fmt.Errorf("unknown crop strategy %q", name)
+}
+
+// crop returns the configured crop strategy, or def if none is configured
+func (g *Gosaic) crop(def vips.Interesting) vips.Interesting {
+	if g.config.Crop == "" {
+		return def
+	}
+	interesting, err := ParseCrop(g.config.Crop)
+	if err != nil {
+		return def
+	}
+	return interesting
+}
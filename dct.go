@@ -0,0 +1,140 @@
+package gosaic
+
+import (
+	"image"
+	"math"
+	"sync"
+)
+
+// Spaces in which the tiles are compared to the rects
+const (
+	ComparePixels = "pixels"
+	CompareDCT    = "dct"
+)
+
+// DefaultDCTSize is the number of DCT coefficients per side compared by the
+// DCTComparator.
+const DefaultDCTSize = 8
+
+// DCTComparator compares the Size x Size lowest frequency DCT coefficients
+// of the red, green and blue channels, or of the luminance only if Luma is
+// set. It tolerates small shifts and, as tiles and rects keep their
+// coefficients, is much cheaper per comparison than comparing pixels.
+// Distances are normalized to 0..1.
+type DCTComparator struct {
+	Size int
+	Luma bool
+}
+
+func (c DCTComparator) Distance(img1, img2 image.Image) (float64, error) {
+	return c.CoefficientDistance(c.Coefficients(img1), c.Coefficients(img2)), nil
+}
+
+func (c DCTComparator) size() int {
+	if c.Size <= 0 {
+		return DefaultDCTSize
+	}
+	return c.Size
+}
+
+// Coefficients returns the low frequency DCT coefficients of img, which is
+// scaled to four times the coefficients per side first. The coefficients
+// are scaled so that the distance of two images never exceeds 1.
+func (c DCTComparator) Coefficients(img image.Image) []float64 {
+	n := c.size()
+	s := 4 * n
+	small := scaleImage(img, s, s)
+
+	channels := 3
+	if c.Luma {
+		channels = 1
+	}
+
+	coeffs := make([]float64, 0, channels*n*n)
+	px := make([]float64, s*s)
+	for ch := 0; ch < channels; ch++ {
+		for i := range px {
+			p := small.Pix[i*4 : i*4+3]
+			if c.Luma {
+				px[i] = (0.299*float64(p[0]) + 0.587*float64(p[1]) + 0.114*float64(p[2])) / 255
+			} else {
+				px[i] = float64(p[ch]) / 255
+			}
+		}
+		coeffs = append(coeffs, dct2(px, s, n)...)
+	}
+
+	return coeffs
+}
+
+// CoefficientDistance returns the distance of two sets of coefficients
+// returned by Coefficients.
+func (c DCTComparator) CoefficientDistance(a, b []float64) float64 {
+	channels := 3
+	if c.Luma {
+		channels = 1
+	}
+
+	var sum float64
+	for i := range a {
+		d := a[i] - b[i]
+		sum += d * d
+	}
+	return math.Sqrt(sum / float64(channels))
+}
+
+var dctBases sync.Map
+
+// dctBasis returns the first n orthonormal DCT-II basis vectors of length s
+func dctBasis(s, n int) [][]float64 {
+	key := [2]int{s, n}
+	if cached, ok := dctBases.Load(key); ok {
+		return cached.([][]float64)
+	}
+
+	basis := make([][]float64, n)
+	for k := range basis {
+		a := math.Sqrt(2 / float64(s))
+		if k == 0 {
+			a = math.Sqrt(1 / float64(s))
+		}
+		basis[k] = make([]float64, s)
+		for i := range basis[k] {
+			basis[k][i] = a * math.Cos(math.Pi*float64((2*i+1)*k)/float64(2*s))
+		}
+	}
+
+	dctBases.Store(key, basis)
+	return basis
+}
+
+// dct2 returns the n x n lowest frequency coefficients of the orthonormal
+// two dimensional DCT of the s x s values px, divided by s. The rows are
+// transformed first and then the columns.
+func dct2(px []float64, s, n int) []float64 {
+	basis := dctBasis(s, n)
+
+	rows := make([]float64, s*n)
+	for y := 0; y < s; y++ {
+		for k := 0; k < n; k++ {
+			var sum float64
+			for x := 0; x < s; x++ {
+				sum += px[y*s+x] * basis[k][x]
+			}
+			rows[y*n+k] = sum
+		}
+	}
+
+	coeffs := make([]float64, n*n)
+	for l := 0; l < n; l++ {
+		for k := 0; k < n; k++ {
+			var sum float64
+			for y := 0; y < s; y++ {
+				sum += rows[y*n+k] * basis[l][y]
+			}
+			coeffs[l*n+k] = sum / float64(s)
+		}
+	}
+
+	return coeffs
+}
@@ -0,0 +1,82 @@
+package gosaic
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestDCT2(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	for _, s := range []int{4, 8, 32} {
+		t.Run(fmt.Sprintf("%dx%d", s, s), func(t *testing.T) {
+			px := make([]float64, s*s)
+			for i := range px {
+				px[i] = rnd.Float64()
+			}
+
+			n := s / 4
+			got := dct2(px, s, n)
+			for l := 0; l < n; l++ {
+				for k := 0; k < n; k++ {
+					// the textbook definition of the orthonormal DCT-II
+					ak, al := math.Sqrt(2/float64(s)), math.Sqrt(2/float64(s))
+					if k == 0 {
+						ak = math.Sqrt(1 / float64(s))
+					}
+					if l == 0 {
+						al = math.Sqrt(1 / float64(s))
+					}
+					var sum float64
+					for y := 0; y < s; y++ {
+						for x := 0; x < s; x++ {
+							sum += px[y*s+x] * math.Cos(math.Pi*float64((2*x+1)*k)/float64(2*s)) * math.Cos(math.Pi*float64((2*y+1)*l)/float64(2*s))
+						}
+					}
+					want := ak * al * sum / float64(s)
+					if math.Abs(got[l*n+k]-want) > 1e-9 {
+						t.Fatalf("coefficient %d/%d is %f, want %f", k, l, got[l*n+k], want)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestDCTComparator(t *testing.T) {
+	uniform := func(c color.Color) image.Image {
+		img := image.NewRGBA(image.Rect(0, 0, 40, 40))
+		draw.Draw(img, img.Bounds(), &image.Uniform{c}, image.ZP, draw.Src)
+		return img
+	}
+	black, white, red := uniform(color.Black), uniform(color.White), uniform(color.RGBA{R: 0xff, A: 0xff})
+
+	tests := []struct {
+		name       string
+		comparator DCTComparator
+		a, b       image.Image
+		want       float64
+	}{
+		{"identical", DCTComparator{}, red, red, 0},
+		{"black and white", DCTComparator{}, black, white, 1},
+		{"black and red", DCTComparator{Size: 16}, black, red, math.Sqrt(1.0 / 3)},
+		{"luma of black and white", DCTComparator{Luma: true}, black, white, 1},
+		{"luma of black and red", DCTComparator{Luma: true}, black, red, 0.299},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.comparator.Distance(tt.a, tt.b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if math.Abs(got-tt.want) > 1e-3 {
+				t.Fatalf("distance %f, want %f", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,129 @@
+package gosaic
+
+import (
+	"container/list"
+	"image"
+	"image/color"
+	"math/bits"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultDedupeDistance is the maximum number of differing hash bits for
+// two tiles to count as duplicates.
+const DefaultDedupeDistance = 4
+
+// dHash returns the 64 bit difference hash of img: every bit tells whether
+// a pixel of the 9x8 grayscale thumbnail is brighter than its right
+// neighbour.
+func dHash(img image.Image) uint64 {
+	small := scaleImage(img, 9, 8)
+
+	var hash uint64
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			l := color.GrayModel.Convert(small.At(x, y)).(color.Gray).Y
+			r := color.GrayModel.Convert(small.At(x+1, y)).(color.Gray).Y
+			hash <<= 1
+			if l > r {
+				hash |= 1
+			}
+		}
+	}
+
+	return hash
+}
+
+// HashIndex finds the difference hashes which differ from a hash in at
+// most a maximum number of bits.
+//
+// The hashes are split into maxDist+1 bands. Two hashes within maxDist
+// bits of each other always share at least one band, so only hashes with
+// a matching band need to be compared.
+type HashIndex struct {
+	maxDist int
+	bands   int
+	width   int
+	index   []map[uint64][]hashEntry
+}
+
+// hashEntry is a hash in the index with the name of its tile
+type hashEntry struct {
+	hash uint64
+	name string
+}
+
+// NewHashIndex returns an empty index of hashes within maxDist bits
+func NewHashIndex(maxDist int) *HashIndex {
+	bands := maxDist + 1
+	if bands > 64 {
+		bands = 64
+	}
+	if bands < 1 {
+		bands = 1
+	}
+	h := &HashIndex{maxDist: maxDist, bands: bands, width: 64 / bands, index: make([]map[uint64][]hashEntry, bands)}
+	for b := range h.index {
+		h.index[b] = map[uint64][]hashEntry{}
+	}
+	return h
+}
+
+// band returns the bits of hash in the band b
+func (h *HashIndex) band(hash uint64, b int) uint64 {
+	shift := uint(b * h.width)
+	if b == h.bands-1 {
+		return hash >> shift
+	}
+	return (hash >> shift) & (1<<uint(h.width) - 1)
+}
+
+// Add adds the hash of the tile name
+func (h *HashIndex) Add(hash uint64, name string) {
+	for b := 0; b < h.bands; b++ {
+		h.index[b][h.band(hash, b)] = append(h.index[b][h.band(hash, b)], hashEntry{hash, name})
+	}
+}
+
+// Duplicate returns the name of a tile other than name whose hash is
+// within the maximum distance of hash
+func (h *HashIndex) Duplicate(hash uint64, name string) (string, bool) {
+	for b := 0; b < h.bands; b++ {
+		for _, other := range h.index[b][h.band(hash, b)] {
+			if other.name != name && bits.OnesCount64(hash^other.hash) <= h.maxDist {
+				return other.name, true
+			}
+		}
+	}
+	return "", false
+}
+
+// dedupeTiles removes tiles whose hash differs in at most maxDist bits
+// from a tile loaded before them.
+func (g *Gosaic) dedupeTiles(maxDist int) int {
+	if maxDist < 0 {
+		return 0
+	}
+	index := NewHashIndex(maxDist)
+
+	removed := 0
+	var next *list.Element
+	for cur := g.Tiles.Front(); cur != nil; cur = next {
+		next = cur.Next()
+		tile := cur.Value.(Tile)
+		if tile.Tiny == nil {
+			continue
+		}
+		hash := dHash(tile.Tiny)
+
+		if _, ok := index.Duplicate(hash, tile.Filename); ok {
+			log.Debugf("skipping duplicate tile %s", tile.Filename)
+			g.Tiles.Remove(cur)
+			removed++
+			continue
+		}
+		index.Add(hash, tile.Filename)
+	}
+
+	return removed
+}
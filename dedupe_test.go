@@ -0,0 +1,81 @@
+package gosaic
+
+import (
+	"container/list"
+	"fmt"
+	"image"
+	"image/color"
+	"testing"
+)
+
+// hashImage returns a 9x8 grayscale image whose difference hash is hash
+func hashImage(hash uint64) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, 9, 8))
+	for y := 0; y < 8; y++ {
+		v := 128
+		img.SetRGBA(0, y, color.RGBA{uint8(v), uint8(v), uint8(v), 0xff})
+		for x := 0; x < 8; x++ {
+			if hash&(1<<uint(63-y*8-x)) != 0 {
+				v -= 12
+			} else {
+				v += 12
+			}
+			img.SetRGBA(x+1, y, color.RGBA{uint8(v), uint8(v), uint8(v), 0xff})
+		}
+	}
+	return img
+}
+
+func TestDHash(t *testing.T) {
+	for _, hash := range []uint64{0, 1<<64 - 1, 0xf0f0f0f00f0f0f0f, 0x0123456789abcdef} {
+		img := hashImage(hash)
+		if got := dHash(img); got != hash {
+			t.Errorf("dHash = %016x, want %016x", got, hash)
+		}
+		// the hash doesn't depend on the size of the image
+		if got := dHash(scaleImage(img, 36, 32)); got != hash {
+			t.Errorf("dHash of the enlarged image = %016x, want %016x", got, hash)
+		}
+	}
+}
+
+// flipBits flips n bits of hash spread over all of its bands
+func flipBits(hash uint64, n int) uint64 {
+	for i := 0; i < n; i++ {
+		hash ^= 1 << uint(i*64/n)
+	}
+	return hash
+}
+
+func TestDedupeTiles(t *testing.T) {
+	const base = 0x0123456789abcdef
+	tests := []struct {
+		maxDist int
+		flipped int
+		want    string
+	}{
+		// a tile within maxDist bits is dropped wherever the bits differ
+		{4, 0, "[first other]"},
+		{4, 4, "[first other]"},
+		{4, 5, "[first near other]"},
+		{12, 12, "[first other]"},
+		{12, 13, "[first near other]"},
+		// a negative distance keeps all tiles
+		{-1, 0, "[first near other]"},
+	}
+	for _, tt := range tests {
+		g := &Gosaic{Tiles: list.New()}
+		g.Tiles.PushBack(Tile{Filename: "first", Tiny: hashImage(base)})
+		g.Tiles.PushBack(Tile{Filename: "near", Tiny: hashImage(flipBits(base, tt.flipped))})
+		g.Tiles.PushBack(Tile{Filename: "other", Tiny: hashImage(^uint64(base))})
+
+		removed := g.dedupeTiles(tt.maxDist)
+		kept := []string{}
+		for cur := g.Tiles.Front(); cur != nil; cur = cur.Next() {
+			kept = append(kept, cur.Value.(Tile).Filename)
+		}
+		if got := fmt.Sprint(kept); got != tt.want || removed != 3-len(kept) {
+			t.Errorf("distance %d with %d bits flipped kept %s and removed %d, want %s", tt.maxDist, tt.flipped, got, removed, tt.want)
+		}
+	}
+}
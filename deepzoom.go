@@ -0,0 +1,152 @@
+package gosaic
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// Layouts of the deep zoom pyramid
+const (
+	DeepZoomDZ   = "dz"
+	DeepZoomIIIF = "iiif"
+)
+
+// Tiling of the deep zoom pyramid
+const (
+	deepZoomTileSize = 254
+	deepZoomOverlap  = 1
+	iiifTileSize     = 256
+)
+
+// pyramidLevels calls fn with every level of the pyramid of img, from the
+// full size down to a single pixel, each half the size of the previous
+// one. Only two levels are kept in memory at a time.
+func pyramidLevels(img *image.RGBA, fn func(level *image.RGBA, scale int) error) error {
+	scale := 1
+	for {
+		if err := fn(img, scale); err != nil {
+			return err
+		}
+		b := img.Bounds()
+		if b.Dx() == 1 && b.Dy() == 1 {
+			return nil
+		}
+		img = scaleImage(img, (b.Dx()+1)/2, (b.Dy()+1)/2)
+		scale *= 2
+	}
+}
+
+// saveTile encodes the part r of img as a JPEG file, creating its directory
+func saveTile(img *image.RGBA, r image.Rectangle, filename string) error {
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		return err
+	}
+
+	buf := bytes.NewBuffer([]byte{})
+	if err := jpeg.Encode(buf, img.SubImage(r), &jpeg.Options{Quality: 85}); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filename, buf.Bytes(), 0644)
+}
+
+// saveDeepZoom writes img as a tiled pyramid which can be browsed with
+// viewers like OpenSeadragon. The Deep Zoom layout is written to base.dzi
+// and base_files, the IIIF layout to the directory base with its
+// info.json, whose id is the name of the directory.
+func saveDeepZoom(img *image.RGBA, base, layout string) error {
+	switch layout {
+	case "", DeepZoomDZ:
+		return saveDZI(img, base)
+	case DeepZoomIIIF:
+		return saveIIIF(img, base)
+	}
+	return fmt.Errorf("unknown deep zoom layout %q", layout)
+}
+
+func saveDZI(img *image.RGBA, base string) error {
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+	maxLevel := 0
+	for (1<<uint(maxLevel)) < w || (1<<uint(maxLevel)) < h {
+		maxLevel++
+	}
+
+	err := pyramidLevels(img, func(level *image.RGBA, scale int) error {
+		n := maxLevel
+		for s := scale; s > 1; s /= 2 {
+			n--
+		}
+
+		b := level.Bounds()
+		for row := 0; row*deepZoomTileSize < b.Dy(); row++ {
+			for col := 0; col*deepZoomTileSize < b.Dx(); col++ {
+				r := image.Rect(col*deepZoomTileSize, row*deepZoomTileSize, (col+1)*deepZoomTileSize, (row+1)*deepZoomTileSize)
+				r = r.Inset(-deepZoomOverlap).Intersect(b)
+				filename := filepath.Join(fmt.Sprintf("%s_files", base), fmt.Sprint(n), fmt.Sprintf("%d_%d.jpg", col, row))
+				if err := saveTile(level, r, filename); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	dzi := fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<Image xmlns="http://schemas.microsoft.com/deepzoom/2008" Format="jpg" Overlap="%d" TileSize="%d">
+  <Size Width="%d" Height="%d"/>
+</Image>
+`, deepZoomOverlap, deepZoomTileSize, w, h)
+	return ioutil.WriteFile(base+".dzi", []byte(dzi), 0644)
+}
+
+func saveIIIF(img *image.RGBA, base string) error {
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+
+	// every level covers the full image with tiles of scale times the
+	// tile size, scaled down to the tile size
+	scales := []int{}
+	err := pyramidLevels(img, func(level *image.RGBA, scale int) error {
+		if scale > 1 && iiifTileSize*scale/2 >= w && iiifTileSize*scale/2 >= h {
+			return nil
+		}
+		scales = append(scales, scale)
+
+		b := level.Bounds()
+		for y := 0; y < b.Dy(); y += iiifTileSize {
+			for x := 0; x < b.Dx(); x += iiifTileSize {
+				r := image.Rect(x, y, x+iiifTileSize, y+iiifTileSize).Intersect(b)
+				region := image.Rect(x*scale, y*scale, (x+iiifTileSize)*scale, (y+iiifTileSize)*scale).Intersect(image.Rect(0, 0, w, h))
+				filename := filepath.Join(base, fmt.Sprintf("%d,%d,%d,%d", region.Min.X, region.Min.Y, region.Dx(), region.Dy()), fmt.Sprintf("%d,", r.Dx()), "0", "default.jpg")
+				if err := saveTile(level, r, filename); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	info, err := json.MarshalIndent(map[string]interface{}{
+		"@context": "http://iiif.io/api/image/2/context.json",
+		"@id":      filepath.Base(base),
+		"protocol": "http://iiif.io/api/image",
+		"width":    w,
+		"height":   h,
+		"profile":  []string{"http://iiif.io/api/image/2/level0.json"},
+		"tiles":    []map[string]interface{}{{"width": iiifTileSize, "scaleFactors": scales}},
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(base, "info.json"), info, 0644)
+}
@@ -0,0 +1,61 @@
+package gosaic
+
+import (
+	"fmt"
+	"image"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSaveDeepZoom(t *testing.T) {
+	tests := []struct {
+		layout string
+		w, h   int
+		tiles  map[string]image.Point
+	}{
+		{DeepZoomDZ, 600, 300, map[string]image.Point{
+			"mosaic_files/10/0_0.jpg": image.Pt(255, 255),
+			"mosaic_files/10/2_1.jpg": image.Pt(93, 47),
+			"mosaic_files/9/1_0.jpg":  image.Pt(47, 150),
+			"mosaic_files/0/0_0.jpg":  image.Pt(1, 1),
+			"mosaic.dzi":              {},
+		}},
+		{DeepZoomIIIF, 600, 300, map[string]image.Point{
+			"mosaic/0,0,256,256/256,/0/default.jpg":  image.Pt(256, 256),
+			"mosaic/512,256,88,44/88,/0/default.jpg": image.Pt(88, 44),
+			"mosaic/512,0,88,300/44,/0/default.jpg":  image.Pt(44, 150),
+			"mosaic/0,0,600,300/150,/0/default.jpg":  image.Pt(150, 75),
+			"mosaic/info.json":                       {},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s %dx%d", tt.layout, tt.w, tt.h), func(t *testing.T) {
+			dir := t.TempDir()
+			if err := saveDeepZoom(image.NewRGBA(image.Rect(0, 0, tt.w, tt.h)), filepath.Join(dir, "mosaic"), tt.layout); err != nil {
+				t.Fatal(err)
+			}
+
+			for name, size := range tt.tiles {
+				fh, err := os.Open(filepath.Join(dir, name))
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer fh.Close()
+				if size == (image.Point{}) {
+					continue
+				}
+
+				cfg, err := jpeg.DecodeConfig(fh)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if cfg.Width != size.X || cfg.Height != size.Y {
+					t.Fatalf("%s is %dx%d, want %dx%d", name, cfg.Width, cfg.Height, size.X, size.Y)
+				}
+			}
+		})
+	}
+}
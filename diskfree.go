@@ -0,0 +1,16 @@
+//go:build !windows
+// +build !windows
+
+package gosaic
+
+import "syscall"
+
+// diskFree returns the space in bytes available to the process on the
+// file system of path
+func diskFree(path string) (uint64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, err
+	}
+	return uint64(st.Bavail) * uint64(st.Bsize), nil
+}
@@ -0,0 +1,7 @@
+package gosaic
+
+// diskFree isn't supported on Windows, the disk check of the readiness
+// passes
+func diskFree(path string) (uint64, error) {
+	return 0, errNoDiskStats
+}
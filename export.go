@@ -0,0 +1,107 @@
+package gosaic
+
+import (
+	"bytes"
+	"html/template"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	texttemplate "text/template"
+)
+
+var htmlExport = template.Must(template.New("html").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Image}}</title>
+<style>
+.mosaic { position: relative; display: inline-block; }
+.mosaic img { display: block; }
+.mosaic a { position: absolute; }
+.mosaic a:hover { outline: 2px solid #fff; }
+</style>
+</head>
+<body>
+<div class="mosaic">
+<img src="{{.Image}}" width="{{.Width}}" height="{{.Height}}" alt="">
+{{- range .Cells}}
+<a style="left:{{.Left}}px;top:{{.Top}}px;width:{{.Width}}px;height:{{.Height}}px"{{if .URL}} href="{{.URL}}"{{end}} title="{{.Tile}}"></a>
+{{- end}}
+</div>
+</body>
+</html>
+`))
+
+var svgExport = template.Must(template.New("svg").Parse(`<svg xmlns="http://www.w3.org/2000/svg" xmlns:xlink="http://www.w3.org/1999/xlink" width="{{.Width}}" height="{{.Height}}" viewBox="0 0 {{.Width}} {{.Height}}">
+<image xlink:href="{{.Image}}" width="{{.Width}}" height="{{.Height}}"/>
+{{- range .Cells}}
+<a{{if .URL}} xlink:href="{{.URL}}"{{end}}><rect x="{{.Left}}" y="{{.Top}}" width="{{.Width}}" height="{{.Height}}" fill="transparent"><title>{{.Tile}}</title></rect></a>
+{{- end}}
+</svg>
+`))
+
+// exportCell is a cell of the interactive export with its link
+type exportCell struct {
+	ManifestCell
+	URL string
+}
+
+// saveExport writes an HTML page or, if filename ends in .svg, an SVG
+// which shows the output image with every cell linked to the URL of its
+// tile. The URL is rendered from urlTemplate with the fields of the
+// ManifestCell, e.g. "https://example.com/{{base .Tile}}", and left out if
+// the template is empty. Cells always show their tile as a tooltip.
+func (g *Gosaic) saveExport(rects []*TileData, filename, urlTemplate string) error {
+	m, err := g.manifest(rects)
+	if err != nil {
+		return err
+	}
+
+	var urls *texttemplate.Template
+	if urlTemplate != "" {
+		urls, err = texttemplate.New("url").Funcs(texttemplate.FuncMap{"base": path.Base}).Parse(urlTemplate)
+		if err != nil {
+			return err
+		}
+	}
+
+	data := struct {
+		Image         string
+		Width, Height int
+		Cells         []exportCell
+	}{Width: m.Width, Height: m.Height}
+
+	// the output image is referenced relative to the export
+	data.Image, err = filepath.Rel(filepath.Dir(filename), g.config.OutputImage)
+	if err != nil {
+		data.Image = g.config.OutputImage
+	}
+	data.Image = filepath.ToSlash(data.Image)
+
+	for _, c := range m.Cells {
+		if c.Tile == "" {
+			continue
+		}
+		cell := exportCell{ManifestCell: c}
+		if urls != nil {
+			buf := bytes.NewBuffer([]byte{})
+			if err := urls.Execute(buf, c); err != nil {
+				return err
+			}
+			cell.URL = buf.String()
+		}
+		data.Cells = append(data.Cells, cell)
+	}
+
+	fh, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer fh.Close()
+
+	if strings.ToLower(filepath.Ext(filename)) == ".svg" {
+		return svgExport.Execute(fh, data)
+	}
+	return htmlExport.Execute(fh, data)
+}
@@ -0,0 +1,55 @@
+package gosaic
+
+import (
+	"image"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSaveExport(t *testing.T) {
+	dir := t.TempDir()
+	g := &Gosaic{
+		SeedImage: image.NewRGBA(image.Rect(0, 0, 20, 10)),
+		config:    Config{OutputImage: filepath.Join(dir, "out", "mosaic.jpg")},
+	}
+
+	dist := 0.25
+	rects := []*TileData{
+		{X: 0, Y: 0, Cell: image.Rect(0, 0, 10, 10), MinTile: &Tile{Filename: "tiles/a&b.jpg"}, MinDist: &dist, MinTransform: &Transform{}},
+		{X: 1, Y: 0, Cell: image.Rect(10, 0, 20, 10), MinTile: &Tile{}, MinDist: &dist, MinTransform: &Transform{}},
+	}
+
+	tests := []struct {
+		filename string
+		url      string
+		want     []string
+	}{
+		{"mosaic.html", "", []string{`src="out/mosaic.jpg"`, `title="tiles/a&amp;b.jpg"`, "left:0px;top:0px;width:10px;height:10px"}},
+		{"mosaic.html", "https://example.com/{{base .Tile}}?x={{.X}}", []string{`href="https://example.com/a&amp;b.jpg?x=0"`}},
+		{"mosaic.svg", "https://example.com/{{.X}}/{{.Y}}", []string{`xlink:href="out/mosaic.jpg"`, `xlink:href="https://example.com/0/0"`, "<title>tiles/a&amp;b.jpg</title>"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.filename+" "+tt.url, func(t *testing.T) {
+			filename := filepath.Join(dir, tt.filename)
+			if err := g.saveExport(rects, filename, tt.url); err != nil {
+				t.Fatal(err)
+			}
+			data, err := ioutil.ReadFile(filename)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, w := range tt.want {
+				if !strings.Contains(string(data), w) {
+					t.Fatalf("%s doesn't contain %s:\n%s", tt.filename, w, data)
+				}
+			}
+			if strings.Count(string(data), "<a") != 1 {
+				t.Fatalf("cells without a tile are linked:\n%s", data)
+			}
+		})
+	}
+}
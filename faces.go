@@ -0,0 +1,127 @@
+package gosaic
+
+import (
+	"image"
+	"io/ioutil"
+
+	pigo "github.com/esimov/pigo/core"
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultFaceSplit is how often cells covering a face are split in half
+const DefaultFaceSplit = 1
+
+// detectFaces finds the faces in the seed image with the pigo cascade file
+func (g *Gosaic) detectFaces(cascadeFile string) ([]image.Rectangle, error) {
+	cascade, err := ioutil.ReadFile(cascadeFile)
+	if err != nil {
+		return nil, err
+	}
+
+	classifier, err := pigo.NewPigo().Unpack(cascade)
+	if err != nil {
+		return nil, err
+	}
+
+	b := g.SeedImage.Bounds()
+	maxSize := b.Dx()
+	if b.Dy() < maxSize {
+		maxSize = b.Dy()
+	}
+
+	params := pigo.CascadeParams{
+		MinSize:     20,
+		MaxSize:     maxSize,
+		ShiftFactor: 0.1,
+		ScaleFactor: 1.1,
+		ImageParams: pigo.ImageParams{
+			Pixels: pigo.RgbToGrayscale(g.SeedImage),
+			Rows:   b.Dy(),
+			Cols:   b.Dx(),
+			Dim:    b.Dx(),
+		},
+	}
+
+	detections := classifier.RunCascade(params, 0.0)
+	detections = classifier.ClusterDetections(detections, 0.2)
+
+	faces := make([]image.Rectangle, 0)
+	for _, d := range detections {
+		if d.Q < 5.0 {
+			continue
+		}
+		r := image.Rect(d.Col-d.Scale/2, d.Row-d.Scale/2, d.Col+d.Scale/2, d.Row+d.Scale/2)
+		faces = append(faces, r.Add(b.Min))
+		log.Debugf("found a face at %v (score %.1f)", r, d.Q)
+	}
+
+	log.Infof("Found %d faces in the seed image", len(faces))
+	return faces, nil
+}
+
+// onFace reports whether r overlaps any of the detected faces
+func (g *Gosaic) onFace(r image.Rectangle) bool {
+	for _, f := range g.faces {
+		if r.Overlaps(f) {
+			return true
+		}
+	}
+	return false
+}
+
+// faceSplit returns how often cells covering a face are split
+func (g *Gosaic) faceSplit() int {
+	if len(g.faces) == 0 {
+		return 0
+	}
+	if g.config.FaceSplit <= 0 {
+		return DefaultFaceSplit
+	}
+	return g.config.FaceSplit
+}
+
+// splitFaces splits the cells covering a face into quadrants so faces get
+// smaller tiles. All rectangular cells are moved onto the grid of the
+// smallest cells, so the distances between them stay comparable.
+func (g *Gosaic) splitFaces(cells []cell) []cell {
+	levels := g.faceSplit()
+	if levels == 0 {
+		return cells
+	}
+
+	unit := g.gridUnit()
+	split := make([]cell, 0, len(cells))
+	for _, c := range cells {
+		if c.Mask == nil {
+			c.X, c.Y = c.Rect.Min.X/unit, c.Rect.Min.Y/unit
+		}
+		split = append(split, g.splitFace(c, levels)...)
+	}
+	return split
+}
+
+func (g *Gosaic) splitFace(c cell, levels int) []cell {
+	r := c.Rect
+	if levels <= 0 || c.Mask != nil || r.Dx() < 2 || r.Dy() < 2 || !g.onFace(r) {
+		return []cell{c}
+	}
+
+	mid := image.Pt(r.Min.X+r.Dx()/2, r.Min.Y+r.Dy()/2)
+	quadrants := []image.Rectangle{
+		image.Rect(r.Min.X, r.Min.Y, mid.X, mid.Y),
+		image.Rect(mid.X, r.Min.Y, r.Max.X, mid.Y),
+		image.Rect(r.Min.X, mid.Y, mid.X, r.Max.Y),
+		image.Rect(mid.X, mid.Y, r.Max.X, r.Max.Y),
+	}
+
+	cells := make([]cell, 0, 4)
+	for _, q := range quadrants {
+		if !q.Overlaps(g.SeedImage.Bounds()) {
+			continue
+		}
+		unit := g.gridUnit()
+		sub := cell{X: q.Min.X / unit, Y: q.Min.Y / unit, Rect: q, Angle: c.Angle}
+		cells = append(cells, g.splitFace(sub, levels-1)...)
+	}
+	return cells
+}
@@ -0,0 +1,70 @@
+package gosaic
+
+import (
+	"image"
+	"image/draw"
+)
+
+// featherMask returns the alpha mask of a tile drawn into a w x h cell with
+// feathered edges. The mask covers the cell grown by r = feather/2 pixels
+// on every side and fades from opaque to transparent over feather pixels
+// across the cell's edge, so neighbouring tiles cross-fade. A nil mask
+// covers the whole cell.
+func featherMask(mask image.Image, w, h, feather int) (*image.Alpha, int) {
+	r := feather / 2
+	feathered := image.NewAlpha(image.Rect(0, 0, w+2*r, h+2*r))
+	dst := image.Rect(r, r, r+w, r+h)
+	if mask == nil {
+		draw.Draw(feathered, dst, image.Opaque, image.ZP, draw.Src)
+	} else {
+		draw.Draw(feathered, dst, mask, mask.Bounds().Min, draw.Src)
+	}
+
+	return blurAlpha(feathered, r), r
+}
+
+// blurAlpha box blurs the alpha mask with radius r, first the rows and then
+// the columns.
+func blurAlpha(a *image.Alpha, r int) *image.Alpha {
+	if r < 1 {
+		return a
+	}
+
+	b := a.Bounds()
+	w, h := b.Dx(), b.Dy()
+	n := 2*r + 1
+
+	tmp := make([]int, w*h)
+	for y := 0; y < h; y++ {
+		sum := 0
+		for x := -r - 1; x < w; x++ {
+			if x+r >= 0 && x+r < w {
+				sum += int(a.Pix[y*a.Stride+x+r])
+			}
+			if x-r-1 >= 0 {
+				sum -= int(a.Pix[y*a.Stride+x-r-1])
+			}
+			if x >= 0 {
+				tmp[y*w+x] = sum
+			}
+		}
+	}
+
+	blurred := image.NewAlpha(image.Rect(0, 0, w, h))
+	for x := 0; x < w; x++ {
+		sum := 0
+		for y := -r - 1; y < h; y++ {
+			if y+r >= 0 && y+r < h {
+				sum += tmp[(y+r)*w+x]
+			}
+			if y-r-1 >= 0 {
+				sum -= tmp[(y-r-1)*w+x]
+			}
+			if y >= 0 {
+				blurred.Pix[y*blurred.Stride+x] = uint8(sum / (n * n))
+			}
+		}
+	}
+
+	return blurred
+}
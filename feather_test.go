@@ -0,0 +1,80 @@
+package gosaic
+
+import (
+	"image"
+	"testing"
+)
+
+func TestFeatherMask(t *testing.T) {
+	const w, h, feather = 12, 10, 6
+	mask, r := featherMask(nil, w, h, feather)
+	if r != feather/2 || mask.Bounds() != image.Rect(0, 0, w+2*r, h+2*r) {
+		t.Fatalf("mask of %v grown by %d, want %d", mask.Bounds(), r, feather/2)
+	}
+
+	// the middle of the cell stays opaque and the grown border fades out
+	if a := mask.AlphaAt(r+w/2, r+h/2).A; a != 0xff {
+		t.Errorf("the center is %d, want opaque", a)
+	}
+	// only the outermost pixel of the cell reaches the grown border
+	if a := mask.AlphaAt(0, r+h/2).A; int(a) != 0xff/(2*r+1) {
+		t.Errorf("the outer edge is %d, want %d", a, 0xff/(2*r+1))
+	}
+	y := r + h/2
+	for x := 1; x < 2*r+1; x++ {
+		if mask.AlphaAt(x, y).A < mask.AlphaAt(x-1, y).A {
+			t.Errorf("the fade isn't monotonic at %d: %d after %d", x, mask.AlphaAt(x, y).A, mask.AlphaAt(x-1, y).A)
+		}
+	}
+
+	// the masks of two neighbouring tiles add up to an opaque seam
+	for x := w; x < w+2*r; x++ {
+		left, right := int(mask.AlphaAt(x, y).A), int(mask.AlphaAt(x-w, y).A)
+		if sum := left + right; sum < 0xff-2 || sum > 0xff {
+			t.Errorf("at %d of the seam the tiles add up to %d", x-w-r, sum)
+		}
+	}
+}
+
+func TestFeatherMaskShape(t *testing.T) {
+	// a cell whose right half is masked out fades out at its middle too
+	shape := image.NewAlpha(image.Rect(5, 5, 25, 25))
+	for y := 5; y < 25; y++ {
+		for x := 5; x < 15; x++ {
+			shape.Pix[(y-5)*shape.Stride+x-5] = 0xff
+		}
+	}
+
+	mask, r := featherMask(shape, 20, 20, 4)
+	if a := mask.AlphaAt(r+5, r+10).A; a != 0xff {
+		t.Errorf("the inside of the shape is %d, want opaque", a)
+	}
+	if a := mask.AlphaAt(r+15, r+10).A; a != 0 {
+		t.Errorf("the outside of the shape is %d, want transparent", a)
+	}
+	if a := mask.AlphaAt(r+10, r+10).A; a == 0 || a == 0xff {
+		t.Errorf("the edge of the shape is %d, want faded", a)
+	}
+}
+
+func TestBlurAlphaRadius(t *testing.T) {
+	a := image.NewAlpha(image.Rect(0, 0, 5, 5))
+	a.Pix[2*a.Stride+2] = 225
+	if got := blurAlpha(a, 0); got != a {
+		t.Error("a radius of 0 doesn't return the mask")
+	}
+
+	// a single pixel spreads evenly over its 3x3 neighbourhood
+	blurred := blurAlpha(a, 1)
+	for y := 0; y < 5; y++ {
+		for x := 0; x < 5; x++ {
+			want := uint8(0)
+			if x >= 1 && x <= 3 && y >= 1 && y <= 3 {
+				want = 25
+			}
+			if got := blurred.AlphaAt(x, y).A; got != want {
+				t.Errorf("pixel %d/%d is %d, want %d", x, y, got, want)
+			}
+		}
+	}
+}
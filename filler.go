@@ -0,0 +1,71 @@
+package gosaic
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math/rand"
+)
+
+// Kinds of synthetic tiles drawn into cells no tile could be matched to
+const (
+	FillerSolid    = "solid"
+	FillerGradient = "gradient"
+	FillerNoise    = "noise"
+)
+
+// fillerImage returns a synthetic w x h tile of the given kind matching the
+// colors of td. Noise is drawn from rnd.
+func fillerImage(td *TileData, kind string, w, h int, rnd *rand.Rand) (*image.RGBA, error) {
+	top, bottom := td.MeanColor, td.MeanColor
+	if kind == FillerGradient {
+		b := td.CompareImage.Bounds()
+		cmp := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+		draw.Draw(cmp, cmp.Bounds(), td.CompareImage, b.Min, draw.Src)
+		top = meanColor(cmp.SubImage(image.Rect(0, 0, b.Dx(), b.Dy()/2)))
+		bottom = meanColor(cmp.SubImage(image.Rect(0, b.Dy()/2, b.Dx(), b.Dy())))
+	} else if kind != FillerSolid && kind != FillerNoise {
+		return nil, fmt.Errorf("unknown filler %q", kind)
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		t := 0.0
+		if h > 1 {
+			t = float64(y) / float64(h-1)
+		}
+
+		var row [3]float64
+		for i := range row {
+			row[i] = (top[i]*(1-t) + bottom[i]*t) / 0x101
+		}
+
+		for x := 0; x < w; x++ {
+			var noise float64
+			if kind == FillerNoise {
+				noise = rnd.Float64()*24 - 12
+			}
+			img.SetRGBA(x, y, color.RGBA{
+				R: clamp8(row[0] + noise),
+				G: clamp8(row[1] + noise),
+				B: clamp8(row[2] + noise),
+				A: 0xff,
+			})
+		}
+	}
+
+	return img, nil
+}
+
+// drawFiller draws a synthetic tile into a cell which is left without a
+// matching tile.
+func (g *Gosaic) drawFiller(td *TileData) error {
+	img, err := fillerImage(td, g.config.Filler, td.Cell.Dx(), td.Cell.Dy(), g.rand)
+	if err != nil {
+		return err
+	}
+
+	g.paintTile(td, img)
+	return nil
+}
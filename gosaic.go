@@ -3,7 +3,6 @@ package gosaic
 import (
 	"bytes"
 	"container/list"
-	"context"
 	"errors"
 	"fmt"
 	"image"
@@ -11,48 +10,174 @@ import (
 	"image/draw"
 	"image/jpeg"
 	"image/png"
+	"io"
+	"io/ioutil"
 
 	"math"
 	"math/rand"
 	"os"
-	"path/filepath"
-	"strconv"
-	"strings"
+	"sort"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/cheggaaa/pb/v3"
 	"github.com/davidbyttow/govips/v2/vips"
-	redis "github.com/go-redis/redis/v8"
-	"github.com/sirupsen/logrus"
 	log "github.com/sirupsen/logrus"
 )
 
 type Config struct {
-	SeedImage    string
-	OutputImage  string
-	OutputSize   int
-	TileSize     int
-	TilesGlob    string
-	CompareSize  int
-	CompareDist  float64
-	Unique       bool
-	SmartCrop    bool
-	ProgressBar  bool
-	ProgressText bool
-	RedisAddr    string
-	RedisLabel   string
-	HTTPAddr     string
-	Workers      int
-	User         string
-	Password     string
+	SeedImage         string
+	OutputImage       string
+	OutputSize        int
+	OutputWidth       int
+	OutputHeight      int
+	OutputStretch     bool
+	TileSize          int
+	TilesGlob         string
+	TilesDir          string
+	TileExtensions    string
+	TilesCache        string
+	ThumbCache        string
+	TilesURLs         string
+	TilesVideo        string
+	VideoFrameStep    int
+	DownloadRate      float64
+	CompareSize       int
+	CompareDist       float64
+	Unique            bool
+	SmartCrop         bool
+	ProgressBar       bool
+	ProgressText      bool
+	RedisAddr         string
+	RedisLabel        string
+	TileFilter        string
+	RedisPassword     string `json:"-"`
+	RedisDB           int
+	RedisTLS          bool
+	Cache             string
+	HTTPAddr          string
+	Workers           int
+	User              string
+	Password          string
+	Candidates        int
+	Assignment        string
+	AllowRotate       bool
+	AllowFlip         bool
+	ColorBlend        float64
+	OverlayOpacity    float64
+	OverlayMode       string
+	RepeatDistance    int
+	MaxUses           int
+	Quadtree          int
+	QuadtreeThreshold float64
+	Layout            string
+	VoronoiSites      int
+	VoronoiSaliency   bool
+	GroutWidth        int
+	GroutColor        string
+	Grayscale         bool
+	GrayscaleOutput   bool
+	CenterWeight      float64
+	Background        string
+	MaskImage         string
+	MaskBlank         bool
+	WeightMap         string
+	RandomSeed        int64
+	Dedupe            bool
+	DedupeDistance    int
+	MinSharpness      float64
+	MinContrast       float64
+	MaxUniformity     float64
+	WidenSteps        int
+	Filler            string
+	MergeThreshold    float64
+	CollageRotation   float64
+	CollageJitter     float64
+	CollageOverlap    float64
+	Palette           string
+	PaletteDistance   float64
+	PaletteSize       int
+	TileStyle         string
+	DuotoneShadow     string
+	DuotoneHighlight  string
+	Order             string
+	LinearLight       bool
+	Refine            int
+	Feather           int
+	Cols              int
+	Rows              int
+	Letterbox         bool
+	MatteColor        string
+	NoTrim            bool
+	TrimThreshold     float64
+	TrimColor         string
+	Crop              string
+	FaceCascade       string
+	FaceSplit         int
+	UsagePenalty      float64
+	PickFromTop       int
+	SelfMosaic        bool
+	SelfImage         string
+	SelfSlices        int
+	Recurse           int
+	RecurseSplit      int
+	CompareSpace      string
+	DCTSize           int
+	OutputProfile     string
+	HistogramMatch    float64
+	Heatmap           string
+	UsageReport       string
+	Manifest          string
+	Checkpoint        string
+	CheckpointEvery   int
+	Resume            string
+	SnapshotEvery     int
+	Animation         string
+	AnimationStride   int
+	AnimationWidth    int
+	Export            string
+	ExportURL         string
+	DeepZoom          string
+	DeepZoomLayout    string
+	OutputFormat      string
+	Quality           int
+	Lossless          bool
+	PDF               string
+	PrintSize         string
+	PDFPages          string
+	DPI               int
+	SplitPages        string
+	SplitOverlap      int
+	Legend            string
+	Stream            bool
+	Metadata          bool
+	Watermark         string
+	WatermarkPos      string
+	WatermarkOpacity  float64
+
+	// SeedReader is read for the seed image instead of SeedImage if set
+	SeedReader io.Reader `json:"-"`
+
+	// TileSource lists and loads the tiles instead of the cache, the self
+	// mosaic or the tile files if set
+	TileSource TileSource `json:"-"`
+
+	// OnProgress is called after every cell matched by Build if set
+	OnProgress func(Progress) `json:"-"`
 }
 
+// maxDistance is the distance from which on tiles are never matched, the
+// comparators return distances in 0..1.
+const maxDistance = 1.0
+
 type Tile struct {
 	Filename string
 	Tiny     image.Image
 	Average  float64
+	Features []float64
+	DCT      []float64
+	Tags     []string
 }
 
 type HasAt interface {
@@ -74,6 +199,16 @@ type TileData struct {
 	CompareTime  *time.Duration
 	Tile         *Tile
 	Mutex        *sync.Mutex
+	Features     []float64
+	Variants     []Variant
+	MinTransform *Transform
+	MeanColor    [3]float64
+	Cell         image.Rectangle
+	Mask         image.Image
+	Weight       float64
+	Widen        int
+	Angle        float64
+	Ranked       *[]assignCost
 }
 
 type ProgressIndicator interface {
@@ -100,92 +235,46 @@ type Stats struct {
 	TStart      time.Time
 	Comparisons int
 	CompareTime time.Duration
+	Seed        int64
+	Widened     int
+	Fillers     int
+	Quality     QualityReport
 	mutex       sync.Mutex
 }
 
 type Gosaic struct {
-	seedVIPSImage *vips.ImageRef
-	seed          int64
-	SeedImage     *image.RGBA
-	Tiles         *list.List
-	config        Config
-	scaleFactor   float64
-	rdb           *redis.Client
-	stats         Stats
-	mutex         sync.Mutex
-	tileData      [][]*TileData
-}
-
-func (g *Gosaic) diff(a, b uint32) int32 {
-	if a > b {
-		return int32(a - b)
-	}
-	return int32(b - a)
-}
-
-func (g *Gosaic) loadTilesFromRedis() error {
-	var cursor uint64
-	tRedis := time.Duration(0)
-
-	keyPattern := fmt.Sprintf("%s:%d:*.jpg", g.config.RedisLabel, g.config.CompareSize)
-	keys := []string{}
-	cmd := g.rdb.Scan(context.Background(), cursor, keyPattern, 1000)
-	iter := cmd.Iterator()
-	for iter.Next(context.Background()) {
-		keys = append(keys, iter.Val())
-	}
-
-	var bar ProgressIndicator
-	switch {
-	case g.config.ProgressBar:
-		bar = pb.StartNew(len(keys))
-	case g.config.ProgressText:
-		bar = &ProgressCounter{count: 0, max: uint64(len(keys))}
-	}
-
-	for _, k := range keys {
-		if bar != nil {
-			bar.Increment()
-		}
-		tStart := time.Now()
-
-		keyParts := strings.Split(k, ":")
-		avg, err := strconv.Atoi(keyParts[2])
-		if err != nil {
-			logrus.Error(err)
-			continue
-		}
-
-		data, err := g.rdb.Get(context.Background(), k).Bytes()
-		if err != nil {
-			logrus.Error(err)
-			continue
-		}
-
-		buf := bytes.NewBuffer(data)
-		img, err := jpeg.Decode(buf)
-		if err != nil {
-			log.Error(err)
-			continue
-		}
-
-		tile, err := g.buildTile(img, k, avg)
-		if err != nil {
-			log.Error(err)
-			continue
-		}
-		g.Tiles.PushBack(tile)
-
-		tRedis += time.Now().Sub(tStart)
-	}
-
-	if bar != nil {
-		bar.Finish()
-	}
-	return nil
+	seed        int64
+	SeedImage   *image.RGBA
+	Tiles       *list.List
+	config      Config
+	scaleFactor float64
+	cache       TileCache
+	thumbs      *thumbCache
+	source      TileSource
+	stats       Stats
+	mutex       sync.Mutex
+	tileData    [][]*TileData
+	placements  *placementMap
+	keepOut     *image.Gray
+	weightMap   *image.Gray
+	faces       []image.Rectangle
+	rand        *rand.Rand
+	selfTiles   map[string]*image.RGBA
+	recurseTree *kdTree
+	placedRects []*TileData
+	progress    *progressHook
+	tileFilter  *TagFilter
+	animation   *animation
+	archives    archiveCache
+	blobs       blobCache
+	downloads   downloader
+
+	// Comparator scores the candidate tiles against each rect of the seed
+	// image. It defaults to the RGBComparator and can be replaced after New.
+	Comparator Comparator
 }
 
-func (g *Gosaic) buildTile(img image.Image, label string, avg int) (Tile, error) {
+func (g *Gosaic) buildTile(img image.Image, label string, avg float64) (Tile, error) {
 	var err error
 
 	defer func() {
@@ -201,26 +290,49 @@ func (g *Gosaic) buildTile(img image.Image, label string, avg int) (Tile, error)
 
 	tile := Tile{
 		Filename: label,
-		Average:  float64(avg),
+		Average:  avg,
 		Tiny:     m,
 	}
 
+	if err == nil {
+		tile, err = g.styleTile(tile)
+	}
+
 	return tile, err
 }
 
-func (g *Gosaic) loadTilesFromDisk() error {
+// prepareTile converts a freshly loaded tile for the comparisons
+func (g *Gosaic) prepareTile(tile Tile) Tile {
+	if g.config.Grayscale {
+		gray := toGray(tile.Tiny)
+		tile.Tiny = gray
+		tile.Average = grayAverage(gray)
+		tile.Features = nil
+	}
+	// tiles from the cache come with their features
+	if tile.Features == nil {
+		tile.Features = featureVector(tile.Tiny)
+	}
+	if dct, ok := g.Comparator.(DCTComparator); ok {
+		tile.DCT = dct.Coefficients(tile.Tiny)
+	}
+	return tile
+}
+
+// loadTiles loads the tiles of source at the compare size
+func (g *Gosaic) loadTiles(source TileSource) error {
 	tileChan := make(chan Tile)
-	imgPathChan := make(chan string)
+	imgPathChan := make(chan []string)
 	wg := sync.WaitGroup{}
 	wg2 := sync.WaitGroup{}
 
-	tilePaths, err := filepath.Glob(g.config.TilesGlob)
+	tilePaths, err := source.List()
 	if err != nil {
 		return err
 	}
 
+	wg2.Add(1)
 	go func() {
-		wg2.Add(1)
 		for tile := range tileChan {
 			g.Tiles.PushBack(tile)
 		}
@@ -236,30 +348,51 @@ func (g *Gosaic) loadTilesFromDisk() error {
 		bar = &ProgressCounter{count: 0, max: uint64(len(tilePaths))}
 	}
 
-	count := 0
 	for i := 0; i < 50; i++ {
+		wg.Add(1)
 		go func(id int) {
-			wg.Add(1)
-			for path := range imgPathChan {
-				count++
-				if bar != nil {
-					bar.Increment()
+			for paths := range imgPathChan {
+				tiles, errs := loadBatch(source, paths, g.config.CompareSize)
+				for i, path := range paths {
+					if bar != nil {
+						bar.Increment()
+					}
+					// tiles which expired since they were listed are gone
+					if errs[i] == ErrNotCached {
+						log.Debugf("%s: %s", path, errs[i])
+						continue
+					}
+					if errs[i] != nil {
+						log.Warnf("%s: %s", path, errs[i])
+						continue
+					}
+					if g.tileFilter != nil && !g.tileFilter.Match(tiles[i].Tags) {
+						log.Debugf("%s: tags %v don't match the filter", path, tiles[i].Tags)
+						continue
+					}
+					if err := g.checkQuality(tiles[i]); err != nil {
+						log.Debugf("%s: %s", path, err)
+						continue
+					}
+
+					tileChan <- g.prepareTile(tiles[i])
 				}
-
-				tile, err := g.loadTileFromDisk(path, g.config.CompareSize)
-				if err != nil {
-					log.Warnf("%s: %s", path, err)
-					continue
-				}
-
-				tileChan <- tile
 			}
 			wg.Done()
 		}(i)
 	}
 
-	for _, path := range tilePaths {
-		imgPathChan <- path
+	// sources which load many tiles at once get them in batches
+	batch := 1
+	if _, ok := source.(tileBatcher); ok {
+		batch = tileBatchSize
+	}
+	for i := 0; i < len(tilePaths); i += batch {
+		end := i + batch
+		if end > len(tilePaths) {
+			end = len(tilePaths)
+		}
+		imgPathChan <- tilePaths[i:end]
 	}
 	close(imgPathChan)
 	wg.Wait()
@@ -274,161 +407,197 @@ func (g *Gosaic) loadTilesFromDisk() error {
 	return nil
 }
 
-func (g *Gosaic) Difference(img1, img2 HasAt) (float64, error) {
-	if img1.ColorModel() != img2.ColorModel() {
-		return 0.0, errors.New("different color models")
+// sortTiles orders the tiles by filename, so the build doesn't depend on
+// the order in which the tiles finished loading.
+func (g *Gosaic) sortTiles() {
+	tiles := make([]Tile, 0, g.Tiles.Len())
+	for cur := g.Tiles.Front(); cur != nil; cur = cur.Next() {
+		tiles = append(tiles, cur.Value.(Tile))
 	}
+	sort.SliceStable(tiles, func(i, j int) bool { return tiles[i].Filename < tiles[j].Filename })
 
-	b := img1.Bounds()
-	c := img2.Bounds()
-	if b.Dx() != c.Dx() || b.Dy() != c.Dy() {
-		return 0.0, fmt.Errorf("bounds are not identical: %v vs. %v", b, c)
+	g.Tiles.Init()
+	for _, tile := range tiles {
+		g.Tiles.PushBack(tile)
 	}
+}
 
-	var sum int64
-	for x := 0; x < b.Dx(); x++ {
-		for y := 0; y < b.Dy(); y++ {
-			x1 := x + b.Min.X
-			y1 := y + b.Min.Y
-			x2 := x + c.Min.X
-			y2 := y + c.Min.Y
-			r1, g1, b1, _ := img1.At(x1, y1).RGBA()
-			r2, g2, b2, _ := img2.At(x2, y2).RGBA()
+// QualityReport returns the quality metrics of the last build
+func (g *Gosaic) QualityReport() QualityReport {
+	return g.stats.Quality
+}
 
-			sum += int64(g.diff(r1, r2))
-			sum += int64(g.diff(g1, g2))
-			sum += int64(g.diff(b1, b2))
-		}
+// Difference returns the distance between two images as computed by the
+// configured Comparator, falling back to the RGBComparator if none is set.
+func (g *Gosaic) Difference(img1, img2 HasAt) (float64, error) {
+	if g.Comparator == nil {
+		return RGBComparator{CenterWeight: g.config.CenterWeight, Linear: g.config.LinearLight}.Distance(img1, img2)
 	}
-
-	nPixels := b.Dx() * b.Dy()
-
-	dist := float64(sum) / (float64(nPixels) * 0xffff * 3)
-	return dist, nil
+	return g.Comparator.Distance(img1, img2)
 }
 
 func (g *Gosaic) SaveAsJPEG(img image.Image, filename string) error {
-	fh, err := os.Create(filename)
+	buf := bytes.NewBuffer([]byte{})
+	err := jpeg.Encode(buf, img, &jpeg.Options{Quality: g.quality()})
 	if err != nil {
-		return fmt.Errorf("%s: %s", filename, err)
+		return err
 	}
-	defer fh.Close()
 
-	err = jpeg.Encode(fh, img, &jpeg.Options{Quality: 85})
+	return g.writeImage(buf.Bytes(), filename)
+}
+
+func (g *Gosaic) SaveAsPNG(img image.Image, filename string) error {
+	buf := bytes.NewBuffer([]byte{})
+	err := png.Encode(buf, img)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	return g.writeImage(buf.Bytes(), filename)
 }
 
-func (g *Gosaic) loadTileFromRedis(key string, size int) (Tile, error) {
-	tile := Tile{Filename: key}
-
-	keyParts := strings.Split(key, ":")
-	keyParts[1] = fmt.Sprintf("%d", size)
-	avg, err := strconv.Atoi(keyParts[2])
-	if err != nil {
-		return tile, err
+// writeImage writes the encoded image to filename, or to stdout for
+// StdoutOutput, and embeds the configured ICC profile and the metadata.
+func (g *Gosaic) writeImage(data []byte, filename string) error {
+	if g.config.OutputProfile != "" {
+		profile, err := ioutil.ReadFile(g.config.OutputProfile)
+		if err != nil {
+			return err
+		}
+		data, err = embedICCProfile(data, profile)
+		if err != nil {
+			return err
+		}
 	}
 
-	keyParts[2] = "*"
-	keyPattern := strings.Join(keyParts, ":")
-	var cursor uint64
-	resp := g.rdb.Scan(context.Background(), cursor, keyPattern, 100)
-	iter := resp.Iterator()
-	var imgKey string
-	if iter.Next(context.Background()) {
-		imgKey = iter.Val()
+	if g.config.Metadata {
+		xmp, exif, err := g.metadata()
+		if err != nil {
+			return err
+		}
+		data = embedMetadata(data, xmp, exif)
 	}
-	if err != nil {
-		log.Error(err)
-		return tile, err
+
+	var err error
+	if filename == StdoutOutput {
+		_, err = os.Stdout.Write(data)
+	} else {
+		err = ioutil.WriteFile(filename, data, 0644)
 	}
-	data, err := g.rdb.Get(context.Background(), imgKey).Bytes()
 	if err != nil {
-		log.Error(err)
-		return tile, err
+		return fmt.Errorf("%s: %s", filename, err)
 	}
+	return nil
+}
 
-	buf := bytes.NewBuffer(data)
-	img, err := jpeg.Decode(buf)
+// loadTileFromDisk loads the tile filename scaled to cover a w x h cell.
+// Tiles are cropped to a square of the longer side, letterboxed tiles are
+// fitted into the w x h cell instead.
+func (g *Gosaic) loadTileFromDisk(filename string, w, h int) (Tile, error) {
+	img, avg, err := g.loadTileFile(filename, w, h)
 	if err != nil {
-		return tile, err
+		return Tile{}, err
 	}
-
-	b := img.Bounds()
-	m := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
-	draw.Draw(m, m.Bounds(), img, b.Min, draw.Src)
-
-	tile.Tiny = m
-	tile.Average = float64(avg)
-
-	return tile, nil
+	return g.styleTile(Tile{Tiny: img, Average: avg, Filename: filename})
 }
 
-func (g *Gosaic) loadTileFromDisk(filename string, size int) (Tile, error) {
-	imgRef, err := vips.NewImageFromFile(filename)
+// loadTileFile loads, trims and scales the tile filename to cover a w x h
+// cell and returns it with its average brightness.
+func (g *Gosaic) loadTileFile(filename string, w, h int) (image.Image, float64, error) {
+	imgRef, err := g.loadTileImage(filename)
 	if err != nil {
-		return Tile{}, err
+		return nil, 0, err
 	}
 	defer imgRef.Close()
 
-	// remove a white frame around the picture
-	left, top, width, height, err := imgRef.FindTrim(40, &vips.Color{R: 255, G: 255, B: 255})
-	if err != nil {
-		return Tile{}, err
+	if err := AutoOrient(imgRef); err != nil {
+		return nil, 0, err
 	}
 
-	if width < imgRef.Width() || height < imgRef.Height() {
-		err = imgRef.ExtractArea(left, top, width, height)
-		if err != nil {
-			return Tile{}, err
-		}
+	// remove a white frame around the picture
+	if err := g.trimFrame(imgRef); err != nil {
+		return nil, 0, err
 	}
 
-	err = imgRef.ToColorSpace(vips.InterpretationSRGB)
+	err = ToSRGB(imgRef)
 	if err != nil {
-		return Tile{}, err
+		return nil, 0, err
 	}
 
 	avg, err := imgRef.Average()
 	if err != nil {
-		return Tile{}, err
+		return nil, 0, err
 	}
 
-	if g.config.SmartCrop {
-		err = imgRef.SmartCrop(size, size, vips.InterestingAttention)
+	size := w
+	if h > size {
+		size = h
+	}
+
+	if g.config.Letterbox {
+		err = imgRef.Thumbnail(w, h, vips.InterestingNone)
+	} else if g.config.SmartCrop {
+		err = imgRef.SmartCrop(size, size, g.crop(vips.InterestingAttention))
 	} else {
-		err = imgRef.Thumbnail(size, size, vips.InterestingAttention)
+		err = imgRef.Thumbnail(size, size, g.crop(vips.InterestingAttention))
 	}
 	if err != nil {
-		return Tile{}, err
+		return nil, 0, err
 	}
 
 	img, err := imgRef.ToImage(vips.NewDefaultPNGExportParams())
 	if err != nil {
 		log.Errorf("create image %s error: %s", filename, err)
+		return nil, 0, err
+	}
+
+	// keep the aspect ratio and fill the rest of the cell with the matte
+	if g.config.Letterbox {
+		matte, err := parseHexColor(orDefault(g.config.MatteColor, DefaultMatteColor))
+		if err != nil {
+			return nil, 0, err
+		}
+		img = letterbox(img, w, h, matte)
+		mean := meanColor(img)
+		avg = (mean[0] + mean[1] + mean[2]) / 3 / 0x101
 	}
-	return Tile{Tiny: img, Average: avg, Filename: filename}, err
+	return img, avg, nil
 }
 
-func (g *Gosaic) loadRect(x, y int) (*TileData, error) {
+func (g *Gosaic) loadRect(c cell) (*TileData, error) {
 	compareTime := time.Duration(0)
 
 	td := TileData{
-		X:           x,
-		Y:           y,
-		Rect:        image.Rect(x*g.config.TileSize, y*g.config.TileSize, (x+1)*g.config.TileSize, (y+1)*g.config.TileSize),
-		Mutex:       &sync.Mutex{},
-		Tile:        &Tile{},
-		MinTile:     &Tile{},
-		MinElem:     &list.Element{},
-		TileElem:    &list.Element{},
-		CompareTime: &compareTime,
+		X:            c.X,
+		Y:            c.Y,
+		Rect:         c.Rect,
+		Cell:         c.Rect,
+		Mutex:        &sync.Mutex{},
+		Tile:         &Tile{},
+		MinTile:      &Tile{},
+		MinElem:      &list.Element{},
+		TileElem:     &list.Element{},
+		CompareTime:  &compareTime,
+		MinTransform: &Transform{},
+		Weight:       g.cellWeight(c.Rect),
+		Angle:        c.Angle,
 	}
 
-	subImg := g.SeedImage.SubImage(td.Rect)
+	if !c.Rect.Overlaps(g.SeedImage.Bounds()) {
+		return nil, fmt.Errorf("rect %v is outside of the seed image", c.Rect)
+	}
+
+	// cells may reach beyond the edges of the seed, so copy the visible
+	// part onto an opaque canvas of the full cell size
+	subImg := image.NewRGBA(image.Rect(0, 0, c.Rect.Dx(), c.Rect.Dy()))
+	draw.Draw(subImg, subImg.Bounds(), image.Black, image.ZP, draw.Src)
+	draw.Draw(subImg, subImg.Bounds(), g.SeedImage, c.Rect.Min, draw.Over)
+
+	// transparent parts of the seed and masked regions are left out
+	mask, coverage := g.coverageMask(clipMask(c, g.SeedImage.Bounds()), c.Rect)
+	if coverage == 0 {
+		return nil, fmt.Errorf("rect %v is transparent", c.Rect)
+	}
 
 	buf := bytes.NewBuffer([]byte{})
 	err := png.Encode(buf, subImg)
@@ -442,7 +611,7 @@ func (g *Gosaic) loadRect(x, y int) (*TileData, error) {
 	}
 	defer imgRef.Close()
 
-	err = imgRef.Thumbnail(g.config.CompareSize, g.config.CompareSize, vips.InterestingCentre)
+	err = imgRef.Thumbnail(g.config.CompareSize, g.config.CompareSize, g.crop(vips.InterestingCentre))
 	if err != nil {
 		return nil, err
 	}
@@ -456,8 +625,26 @@ func (g *Gosaic) loadRect(x, y int) (*TileData, error) {
 	if err != nil {
 		return nil, err
 	}
+	if g.config.Grayscale {
+		gray := toGray(td.CompareImage)
+		td.CompareImage = gray
+		td.Average = grayAverage(gray)
+	}
+	td.Features = featureVector(td.CompareImage)
+
+	var compareMask image.Image
+	if mask != nil {
+		compareMask = scaleImage(mask, g.config.CompareSize, g.config.CompareSize)
+	}
+	td.Mask = mask
+	td.Variants = g.compareVariants(td.CompareImage, compareMask)
+	td.MeanColor = meanColorMasked(td.CompareImage, compareMask)
+	if compareMask != nil {
+		// only average the pixels which are part of the cell
+		td.Average = (td.MeanColor[0] + td.MeanColor[1] + td.MeanColor[2]) / 3 / 0x101
+	}
 
-	minDist := 1.0
+	minDist := maxDistance
 	td.MinDist = &minDist
 	td.Rect = image.Rect(0, 0, g.config.CompareSize, g.config.CompareSize)
 
@@ -465,27 +652,41 @@ func (g *Gosaic) loadRect(x, y int) (*TileData, error) {
 }
 
 func (g *Gosaic) Build() error {
-	rows := g.SeedImage.Bounds().Size().X/g.config.TileSize + 1
-	cols := g.SeedImage.Bounds().Size().Y/g.config.TileSize + 1
+	// resumed builds need the same layout and order as the interrupted one
+	var checkpoint *Checkpoint
+	if g.config.Resume != "" {
+		var err error
+		checkpoint, err = LoadCheckpoint(g.config.Resume)
+		if err != nil {
+			return err
+		}
+	}
+
+	// a fixed seed makes the shuffling and layout reproducible
+	g.seed = g.config.RandomSeed
+	if checkpoint != nil {
+		g.seed = checkpoint.RandomSeed
+	}
+	if g.seed == 0 {
+		g.seed = time.Now().UnixNano()
+	}
+	g.rand = rand.New(rand.NewSource(g.seed))
+	g.stats.Seed = g.seed
 
 	rects := make([]*TileData, 0)
-	for x := 0; x < rows; x++ {
-		for y := 0; y < cols; y++ {
-			rect, err := g.loadRect(x, y)
-			if err != nil {
-				// log.Errorf("%d/%d load error %s", x, y, err)
-				continue
-			}
-			rects = append(rects, rect)
+	for _, c := range g.cells() {
+		rect, err := g.loadRect(c)
+		if err != nil {
+			// log.Errorf("%d/%d load error %s", c.X, c.Y, err)
+			continue
 		}
+		rects = append(rects, rect)
 	}
 
-	g.seed = time.Now().UnixNano()
-	rand.Seed(g.seed)
-	rand.Shuffle(len(rects), func(i, j int) { rects[i], rects[j] = rects[j], rects[i] })
-
-	var wg sync.WaitGroup
-	compareTime := time.Duration(0)
+	g.rand.Shuffle(len(rects), func(i, j int) { rects[i], rects[j] = rects[j], rects[i] })
+	if g.config.Order == OrderSaliency {
+		g.sortBySaliency(rects)
+	}
 
 	var bar ProgressIndicator
 	switch {
@@ -494,38 +695,284 @@ func (g *Gosaic) Build() error {
 	case g.config.ProgressText:
 		bar = &ProgressCounter{max: uint64(len(rects))}
 	}
+	if g.config.OnProgress != nil {
+		g.progress = newProgressHook(bar, g.config.OnProgress, len(rects))
+		bar = g.progress
+	}
+
+	// the quality metrics compare the mosaic to a small copy of the seed
+	metricsRef := metricsImage(g.SeedImage)
+
+	// keep a copy of the seed since the tiles are drawn right onto it
+	var seed *image.RGBA
+	if g.config.OverlayOpacity > 0 || g.config.HistogramMatch > 0 {
+		seed = image.NewRGBA(g.SeedImage.Bounds())
+		draw.Draw(seed, seed.Bounds(), g.SeedImage, g.SeedImage.Bounds().Min, draw.Src)
+	}
+
+	// the grout shows between the tiles, which are inset by half its width
+	if g.config.GroutWidth > 0 {
+		groutColor := g.config.GroutColor
+		if groutColor == "" {
+			groutColor = DefaultGroutColor
+		}
+		grout, err := parseHexColor(groutColor)
+		if err != nil {
+			return err
+		}
+
+		// keep transparent parts of the seed transparent and masked
+		// regions untouched
+		b := g.SeedImage.Bounds()
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				if g.SeedImage.RGBAAt(x, y).A >= 0x80 && !g.keptOut(x, y) {
+					g.SeedImage.SetRGBA(x, y, grout)
+				}
+			}
+		}
+	}
+
+	// the sub-tiles of a recursive mosaic may use every tile, even the ones
+	// the matching removes
+	if g.config.Recurse > 0 {
+		g.recurseTree = newKDTree(g.Tiles)
+	}
+
+	// the matching removes used up tiles from the list
+	var library []string
+	if g.config.UsageReport != "" {
+		for cur := g.Tiles.Front(); cur != nil; cur = cur.Next() {
+			library = append(library, cur.Value.(Tile).Filename)
+		}
+	}
+
+	if g.config.Animation != "" {
+		var err error
+		g.animation, err = newAnimation(g.config.Animation, g.SeedImage.Bounds(), g.config.AnimationWidth)
+		if err != nil {
+			return err
+		}
+		if err := g.animation.addFrame(g.SeedImage); err != nil {
+			return err
+		}
+	}
+
+	pending := rects
+	if checkpoint != nil {
+		pending = g.resume(checkpoint, rects)
+	}
+
+	var compareTime time.Duration
+	if g.maxUses() > 0 && g.config.Assignment == AssignOptimal {
+		compareTime = g.matchOptimal(pending, bar)
+	} else {
+		compareTime = g.matchGreedy(pending, bar)
+	}
+
+	if bar != nil {
+		bar.Finish()
+	}
+
+	// fill the cells no tile could be matched to with synthetic tiles
+	if g.config.Filler != "" {
+		for _, td := range rects {
+			if td.MinTile.Filename != "" {
+				continue
+			}
+			if err := g.drawFiller(td); err != nil {
+				return err
+			}
+			g.stats.Fillers++
+		}
+	}
+
+	if g.config.UsageReport != "" {
+		report := g.placements.usageReport(library)
+		log.Infof("Tiles used: %d, unused: %d", len(report.Used), len(report.Unused))
+		if err := saveUsageReport(report, g.config.UsageReport); err != nil {
+			return err
+		}
+	}
+
+	if g.config.Manifest != "" {
+		if err := g.saveManifest(rects, g.config.Manifest); err != nil {
+			return err
+		}
+	}
+
+	if g.config.Export != "" {
+		if err := g.saveExport(rects, g.config.Export, g.config.ExportURL); err != nil {
+			return err
+		}
+	}
+
+	if g.config.Heatmap != "" {
+		if err := g.saveHeatmap(rects, g.config.Heatmap); err != nil {
+			return err
+		}
+	}
+
+	if g.config.Legend != "" {
+		m, err := g.manifest(rects)
+		if err != nil {
+			return err
+		}
+		if err := g.saveLegend(m, g.config.Legend); err != nil {
+			return err
+		}
+	}
+
+	if g.config.HistogramMatch > 0 {
+		matchHistogram(g.SeedImage, seed, g.config.HistogramMatch)
+	}
+
+	if g.config.OverlayOpacity > 0 {
+		err := overlay(g.SeedImage, seed, g.config.OverlayOpacity, g.config.OverlayMode)
+		if err != nil {
+			return err
+		}
+	}
+
+	if g.keepOut != nil && g.config.MaskBlank {
+		b := g.SeedImage.Bounds()
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				if g.keptOut(x, y) {
+					g.SeedImage.SetRGBA(x, y, color.RGBA{})
+				}
+			}
+		}
+	}
+
+	if err := g.finishOutput(); err != nil {
+		return err
+	}
+
+	if g.animation != nil {
+		err := g.animation.addFrame(g.SeedImage)
+		if err == nil {
+			err = g.animation.finish()
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	g.stats.Quality = qualityReport(metricsImage(g.SeedImage), metricsRef)
+
+	log.Infof("Random seed: %d", g.stats.Seed)
+	log.Infof("Comparisons: %d", g.stats.Comparisons)
+	if g.stats.Fillers > 0 {
+		log.Infof("Cells filled with synthetic tiles: %d", g.stats.Fillers)
+	}
+	if g.stats.Widened > 0 {
+		log.Infof("Cells matched with a widened distance: %d", g.stats.Widened)
+	}
+	log.Infof("PSNR: %.2f dB, SSIM: %.4f", g.stats.Quality.PSNR, g.stats.Quality.SSIM)
+	log.Infof("Compare time: %s", compareTime)
+	log.Infof("Wall time: %s", time.Now().Sub(g.stats.TStart))
+	return g.saveOutput()
+}
+
+// finishOutput converts the finished mosaic to grayscale and fills its
+// transparent parts with the background color as configured.
+func (g *Gosaic) finishOutput() error {
+	if g.config.GrayscaleOutput {
+		g.SeedImage = toGray(g.SeedImage)
+	}
+
+	if g.config.Background != "" {
+		bg, err := parseHexColor(g.config.Background)
+		if err != nil {
+			return err
+		}
+		canvas := image.NewRGBA(g.SeedImage.Bounds())
+		draw.Draw(canvas, canvas.Bounds(), &image.Uniform{bg}, image.ZP, draw.Src)
+		draw.Draw(canvas, canvas.Bounds(), g.SeedImage, g.SeedImage.Bounds().Min, draw.Over)
+		g.SeedImage = canvas
+	}
+
+	if g.config.Watermark != "" {
+		if err := g.drawWatermark(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// saveOutput saves the mosaic to the output file in the output format, and
+// as a deep zoom pyramid, PDF or pages if configured. Mosaics saved as a
+// pyramid or PDF may leave out the output file.
+func (g *Gosaic) saveOutput() error {
+	if g.config.DeepZoom != "" {
+		if err := saveDeepZoom(g.SeedImage, g.config.DeepZoom, g.config.DeepZoomLayout); err != nil {
+			log.Errorf("deep zoom error: %s", err)
+			return err
+		}
+	}
+
+	if g.config.PDF != "" {
+		if err := savePDF(g.SeedImage, g.config.PDF, g.config.PrintSize, g.config.PDFPages, g.config.DPI, g.quality()); err != nil {
+			log.Errorf("PDF error: %s", err)
+			return err
+		}
+	}
+
+	if g.config.OutputImage == "" && (g.config.DeepZoom != "" || g.config.PDF != "") {
+		return nil
+	}
+
+	if err := g.saveImage(g.SeedImage, g.config.OutputImage); err != nil {
+		log.Errorf("save error: %s", err)
+		return err
+	}
+
+	if g.config.SplitPages != "" {
+		if err := g.splitPages(); err != nil {
+			log.Errorf("split pages error: %s", err)
+			return err
+		}
+	}
+
+	return nil
+}
+
+// matchGreedy matches the rects one after another with the best tile that
+// is still available and draws it right away.
+func (g *Gosaic) matchGreedy(rects []*TileData, bar ProgressIndicator) time.Duration {
+	compareTime := time.Duration(0)
+
+	// index the tiles so only the nearest candidates are compared to each rect
+	var tree *kdTree
+	if g.config.Candidates > 0 {
+		tree = newKDTree(g.Tiles)
+	}
 
 	for _, td := range rects {
 
 		//log.Infof("tile %d/%d", i, len(rects))
-		tileDataChan := make(chan *TileData)
-
-		for i := 0; i < g.config.Workers; i++ {
-			wg.Add(1)
-			go g.tileWorker(i, &wg, tileDataChan)
-		}
-
-		var cur *list.Element
-		for cur = g.Tiles.Front(); cur != nil; cur = cur.Next() {
-			le := cur
-			tileData := TileData{
-				X:            td.X,
-				Y:            td.Y,
-				Average:      td.Average,
-				CompareImage: td.CompareImage,
-				MinDist:      td.MinDist,
-				Rect:         td.Rect,
-				Mutex:        td.Mutex,
-				MinTile:      td.MinTile,
-				MinElem:      td.MinElem,
-				TileElem:     le,
-				CompareTime:  td.CompareTime,
+		var candidates []*list.Element
+		if tree != nil {
+			candidates = g.nearestCandidates(tree, td)
+		} else {
+			for cur := g.Tiles.Front(); cur != nil; cur = cur.Next() {
+				candidates = append(candidates, cur)
 			}
-			tileDataChan <- &tileData
 		}
+		for {
+			g.compareCandidates(td, g.refineCandidates(td, candidates))
 
-		close(tileDataChan)
-		wg.Wait()
+			// widen the accepted distance if no tile was close enough
+			if td.MinTile.Filename != "" || td.Widen >= g.config.WidenSteps || g.compareDist(td) > 255 {
+				break
+			}
+			td.Widen++
+		}
+		if td.Widen > 0 && td.MinTile.Filename != "" {
+			g.stats.Widened++
+		}
 
 		if td == nil || td.MinTile == nil || td.MinTile.Filename == "" {
 			log.Warnf("minTile is empty at rect %d/%d (%v)", td.Rect.Min.X, td.Rect.Min.Y, td.MinTile)
@@ -538,47 +985,216 @@ func (g *Gosaic) Build() error {
 		log.Tracef("tile %d/%d (%v) read", td.X, td.Y, td.Rect)
 
 		compareTime += *td.CompareTime
+		g.placements.add(td.MinTile.Filename, td.X, td.Y)
 
-		if g.config.Unique {
+		if limit := g.maxUses(); limit > 0 && g.placements.uses(td.MinTile.Filename) >= limit {
 			if td.MinElem == nil {
 				log.Error("MinElem is nil!")
 			} else {
 				g.Tiles.Remove(td.MinElem)
 			}
+			if tree != nil {
+				tree.Remove(td.MinTile.Filename)
+			}
 		}
 
-		var tile Tile
-		var err error
+		err := g.drawTile(td)
+		if err != nil {
+			log.Error(err)
+		}
+		g.placed(td)
+	}
 
-		if g.rdb != nil {
-			tile, err = g.loadTileFromRedis(td.MinTile.Filename, g.config.TileSize)
-		} else {
-			tile, err = g.loadTileFromDisk(td.MinTile.Filename, g.config.TileSize)
+	return compareTime
+}
+
+// compareCandidates compares td to all candidates in parallel and records
+// the closest one in td.
+func (g *Gosaic) compareCandidates(td *TileData, candidates []*list.Element) {
+	var wg sync.WaitGroup
+	tileDataChan := make(chan *TileData)
+
+	if g.config.PickFromTop > 1 {
+		td.Ranked = &[]assignCost{}
+	}
+
+	for i := 0; i < g.config.Workers; i++ {
+		wg.Add(1)
+		go g.tileWorker(i, &wg, tileDataChan)
+	}
+
+	for _, le := range candidates {
+		tileData := TileData{
+			X:            td.X,
+			Y:            td.Y,
+			Average:      td.Average,
+			CompareImage: td.CompareImage,
+			MinDist:      td.MinDist,
+			Rect:         td.Rect,
+			Mutex:        td.Mutex,
+			MinTile:      td.MinTile,
+			MinElem:      td.MinElem,
+			TileElem:     le,
+			CompareTime:  td.CompareTime,
+			Variants:     td.Variants,
+			MinTransform: td.MinTransform,
+			Weight:       td.Weight,
+			Widen:        td.Widen,
+			Ranked:       td.Ranked,
 		}
+		tileDataChan <- &tileData
+	}
 
-		if err != nil {
-			log.Error(err)
-			continue
+	close(tileDataChan)
+	wg.Wait()
+
+	if td.Ranked != nil {
+		g.pickFromTop(td)
+	}
+}
+
+// pickFromTop replaces the best tile of td with a random one of the
+// configured number of best tiles.
+func (g *Gosaic) pickFromTop(td *TileData) {
+	ranked := *td.Ranked
+	if len(ranked) == 0 {
+		return
+	}
+
+	// sort by name on ties so the order doesn't depend on the workers
+	sort.Slice(ranked, func(i, j int) bool {
+		si := g.usageScore(ranked[i].dist, ranked[i].elem.Value.(Tile).Filename)
+		sj := g.usageScore(ranked[j].dist, ranked[j].elem.Value.(Tile).Filename)
+		if si != sj {
+			return si < sj
 		}
-		rect := image.Rect(td.X*g.config.TileSize, td.Y*g.config.TileSize, (td.X+td.Rect.Dx())*g.config.TileSize, (td.Y+td.Rect.Dy())*g.config.TileSize)
-		draw.Draw(g.SeedImage, rect, tile.Tiny, image.ZP, draw.Over)
+		return ranked[i].elem.Value.(Tile).Filename < ranked[j].elem.Value.(Tile).Filename
+	})
+
+	n := g.config.PickFromTop
+	if n > len(ranked) {
+		n = len(ranked)
 	}
-	if bar != nil {
-		bar.Finish()
+	pick := ranked[g.rand.Intn(n)]
+
+	*td.MinTile = pick.elem.Value.(Tile)
+	*td.MinElem = *pick.elem
+	*td.MinDist = pick.dist
+	*td.MinTransform = pick.transform
+}
+
+// usageScore returns the distance of the tile filename plus its usage
+// penalty, which the tiles are ranked by. Tiles that have been used before
+// have to be that much better to be ranked first, but may still fill a
+// rect no other tile matches, and keep their distance for the reports.
+func (g *Gosaic) usageScore(dist float64, filename string) float64 {
+	if g.config.UsagePenalty <= 0 || filename == "" {
+		return dist
+	}
+	return dist + g.config.UsagePenalty*float64(g.placements.uses(filename))
+}
+
+// maxUses returns how often a tile may be placed, 0 means unlimited
+func (g *Gosaic) maxUses() int {
+	if g.config.MaxUses > 0 {
+		return g.config.MaxUses
 	}
+	if g.config.Unique {
+		return 1
+	}
+	return 0
+}
 
-	log.Infof("Comparisons: %d", g.stats.Comparisons)
-	log.Infof("Compare time: %s", compareTime)
-	log.Infof("Wall time: %s", time.Now().Sub(g.stats.TStart))
-	err := g.SaveAsJPEG(g.SeedImage, g.config.OutputImage)
+// drawTile loads the full size version of the tile matched to td and draws
+// it onto the mosaic.
+func (g *Gosaic) drawTile(td *TileData) error {
+	// quarter turns swap the sides of the tile before it fills the cell
+	w, h := td.Cell.Dx(), td.Cell.Dy()
+	if td.MinTransform != nil && td.MinTransform.Rotate%2 == 1 {
+		w, h = h, w
+	}
+	tile, err := g.loadTile(td.MinTile.Filename, w, h)
 	if err != nil {
-		log.Errorf("save error: %s", err)
 		return err
 	}
 
+	if g.config.Recurse > 0 {
+		tile.Tiny = g.recurseTile(tile.Tiny, w, h, g.config.Recurse)
+	}
+
+	g.paintTile(td, tile.Tiny)
 	return nil
 }
 
+// loadTile loads the full size version of the tile name to cover a w x h
+// cell. The cache only holds tiles in the imported size, cells of a
+// different size get the tile scaled when it is painted.
+func (g *Gosaic) loadTile(name string, w, h int) (Tile, error) {
+	// only tile files are letterboxed, into the shape of the cell
+	if g.config.Letterbox {
+		return g.loadTileFromDisk(name, w, h)
+	}
+	size := w
+	if h > size {
+		size = h
+	}
+	return g.source.Load(name, size)
+}
+
+// paintTile scales img to the cell of td and draws it onto the mosaic.
+// The tile is turned before it is cropped, so rotated tiles cover cells
+// which aren't square as well. Letterboxed tiles already have the shape of
+// the cell and are not cropped.
+func (g *Gosaic) paintTile(td *TileData, img image.Image) {
+	if td.MinTransform != nil && *td.MinTransform != (Transform{}) {
+		img = applyTransform(img, *td.MinTransform)
+	}
+	if !g.config.Letterbox {
+		img = cropToAspect(img, td.Cell.Dx(), td.Cell.Dy())
+	}
+	if b := img.Bounds(); b.Dx() != td.Cell.Dx() || b.Dy() != td.Cell.Dy() {
+		img = scaleImage(img, td.Cell.Dx(), td.Cell.Dy())
+	}
+	img = g.adjustTile(td, img)
+
+	mask := td.Mask
+	if g.config.GroutWidth > 0 {
+		if mask == nil {
+			opaque := image.NewAlpha(image.Rect(0, 0, td.Cell.Dx(), td.Cell.Dy()))
+			draw.Draw(opaque, opaque.Bounds(), image.Opaque, image.ZP, draw.Src)
+			mask = opaque
+		}
+		mask = erodeMask(mask, (g.config.GroutWidth+1)/2)
+	}
+
+	// feathered tiles reach into their neighbours to fade into them
+	r := td.Cell
+	if g.config.Feather > 1 {
+		var grow int
+		mask, grow = featherMask(mask, r.Dx(), r.Dy(), g.config.Feather)
+		r = r.Inset(-grow)
+		img = scaleImage(img, r.Dx(), r.Dy())
+	}
+
+	if td.Angle != 0 {
+		drawRotated(g.SeedImage, r, img, mask, td.Angle)
+	} else if mask != nil {
+		draw.DrawMask(g.SeedImage, r, img, image.ZP, mask, image.ZP, draw.Over)
+	} else {
+		draw.Draw(g.SeedImage, r, img, image.ZP, draw.Over)
+	}
+}
+
+// adjustTile applies the color corrections to the full size tile matched
+// to td before it is drawn.
+func (g *Gosaic) adjustTile(td *TileData, img image.Image) image.Image {
+	if g.config.ColorBlend > 0 {
+		img = colorBlend(img, td.MeanColor, math.Min(g.config.ColorBlend, 1.0))
+	}
+
+	return img
+}
+
 func (g *Gosaic) tileWorker(id int, wg *sync.WaitGroup, tileDataChan chan *TileData) {
 	var td *TileData
 	var tile Tile
@@ -591,31 +1207,39 @@ func (g *Gosaic) tileWorker(id int, wg *sync.WaitGroup, tileDataChan chan *TileD
 			continue
 		}
 
-		if math.Abs(tile.Average-td.Average) > g.config.CompareDist {
+		if math.Abs(tile.Average-td.Average) > g.compareDist(td) {
 			continue
 		}
 
-		tileImg := tile.Tiny
-		dist, err := g.Difference(
-			td.CompareImage.(*image.RGBA).SubImage(td.Rect),
-			tileImg.(*image.RGBA),
-		)
+		// don't repeat a tile right next to where it has been placed before
+		if g.config.RepeatDistance > 0 && g.placements.near(tile.Filename, td.X, td.Y, g.config.RepeatDistance) {
+			continue
+		}
+
+		dist, transform, err := g.tileDistance(td, tile)
 		if err != nil {
 			log.Println(err)
 			continue
 		}
+		if dist >= maxDistance {
+			continue
+		}
 
-		g.mutex.Lock()
-		g.stats.Comparisons++
-		g.mutex.Unlock()
+		score := g.usageScore(dist, tile.Filename)
 
 		td.Mutex.Lock()
+		if td.Ranked != nil {
+			*td.Ranked = append(*td.Ranked, assignCost{elem: td.TileElem, dist: dist, transform: transform})
+		}
 		*td.CompareTime += time.Now().Sub(tStart)
-		if dist < *td.MinDist {
-			log.Tracef("found tile %s (%.4f < %.4f)", tile.Filename, dist, *td.MinDist)
+		// equal scores go to the first name, whichever worker is faster
+		minScore := g.usageScore(*td.MinDist, td.MinTile.Filename)
+		if td.MinTile.Filename == "" || score < minScore || (score == minScore && tile.Filename < td.MinTile.Filename) {
+			log.Tracef("found tile %s (%.4f < %.4f)", tile.Filename, score, minScore)
 			*td.MinDist = dist
 			*td.MinTile = tile
 			*td.MinElem = *td.TileElem
+			*td.MinTransform = transform
 		}
 		td.Mutex.Unlock()
 	}
@@ -623,34 +1247,220 @@ func (g *Gosaic) tileWorker(id int, wg *sync.WaitGroup, tileDataChan chan *TileD
 	wg.Done()
 }
 
+// scaleSeed scales the seed image to the output dimensions and returns the
+// horizontal scale factor. With both OutputWidth and OutputHeight set the
+// seed is scaled to cover them and cropped at the center, or stretched if
+// OutputStretch is set. With only one of them the other side follows the
+// aspect ratio of the seed. Otherwise the shorter side is scaled to
+// OutputSize.
+func scaleSeed(img *vips.ImageRef, config Config) (float64, error) {
+	w, h := float64(img.Width()), float64(img.Height())
+	scaleX := float64(config.OutputWidth) / w
+	scaleY := float64(config.OutputHeight) / h
+
+	switch {
+	case config.OutputWidth > 0 && config.OutputHeight > 0 && config.OutputStretch:
+		return scaleX, img.ResizeWithVScale(scaleX, scaleY, vips.KernelAuto)
+	case config.OutputWidth > 0 && config.OutputHeight > 0:
+		scale := math.Max(scaleX, scaleY)
+		if err := img.Resize(scale, vips.KernelAuto); err != nil {
+			return 0, err
+		}
+		width, height := config.OutputWidth, config.OutputHeight
+		if width > img.Width() {
+			width = img.Width()
+		}
+		if height > img.Height() {
+			height = img.Height()
+		}
+		return scale, img.ExtractArea((img.Width()-width)/2, (img.Height()-height)/2, width, height)
+	case config.OutputWidth > 0:
+		return scaleX, img.Resize(scaleX, vips.KernelAuto)
+	case config.OutputHeight > 0:
+		return scaleY, img.Resize(scaleY, vips.KernelAuto)
+	}
+
+	scale := math.Max(float64(config.OutputSize)/w, float64(config.OutputSize)/h)
+	return scale, img.Resize(scale, vips.KernelAuto)
+}
+
+// checkConfig returns an error for unknown modes and invalid colors, so a
+// typo fails before the tiles are loaded instead of after the build.
+func checkConfig(config Config) error {
+	if config.Crop != "" {
+		if _, err := ParseCrop(config.Crop); err != nil {
+			return err
+		}
+	}
+
+	if err := checkStyle(config); err != nil {
+		return err
+	}
+
+	if err := checkBlobURL(config.TilesGlob); err != nil {
+		return err
+	}
+
+	// the cache and the self mosaic only hold tiles which are already
+	// cropped to squares
+	if config.Letterbox && (config.SelfMosaic || config.cacheSpec() != "" && config.RedisLabel != "" || config.TileSource != nil) {
+		return errors.New("letterboxed tiles can only be loaded from disk")
+	}
+
+	if config.Quality < 0 || config.Quality > 100 {
+		return fmt.Errorf("quality %d is not in 1..100", config.Quality)
+	}
+
+	// only the tiles in the cache have tags
+	if config.TileFilter != "" {
+		if _, err := ParseTagFilter(config.TileFilter); err != nil {
+			return err
+		}
+		if config.TileSource == nil && (config.cacheSpec() == "" || config.RedisLabel == "") {
+			return errors.New("a tile filter needs the tiles of a label in the cache")
+		}
+	}
+
+	if config.TrimThreshold < 0 {
+		return fmt.Errorf("trim threshold %g is negative", config.TrimThreshold)
+	}
+
+	if config.DownloadRate < 0 {
+		return fmt.Errorf("download rate %g is negative", config.DownloadRate)
+	}
+
+	if config.PDF != "" && config.PrintSize == "" {
+		return errors.New("a PDF needs a print size")
+	}
+
+	if config.SplitPages != "" && config.OutputImage == "" {
+		return errors.New("splitting the mosaic into pages needs an output image to name them after")
+	}
+
+	if config.Stream && (config.SelfMosaic || config.DeepZoom != "" || config.PDF != "" || config.SplitPages != "" || config.Watermark != "") {
+		return errors.New("streamed mosaics can't be self mosaics, be watermarked or be saved as a pyramid, PDF or pages")
+	}
+
+	if config.OutputImage == StdoutOutput && (config.SnapshotEvery > 0 || config.SplitPages != "" || config.Stream) {
+		return errors.New("snapshots, pages and streamed mosaics are named after the output file and can't be written to stdout")
+	}
+
+	// quadtree cells are squares on the grid of the tile size
+	if config.Quadtree > 0 && (config.Cols > 0 || config.Rows > 0) {
+		return errors.New("the quadtree can't split a grid of fixed columns and rows")
+	}
+
+	if config.Layout == LayoutHex && config.TileSize < MinHexTileSize {
+		return fmt.Errorf("the hex layout needs a tile size of at least %d", MinHexTileSize)
+	}
+
+	if _, ok := blendFuncs[orDefault(config.OverlayMode, BlendNormal)]; !ok {
+		return fmt.Errorf("unknown blend mode %q", config.OverlayMode)
+	}
+
+	enums := []struct {
+		name  string
+		value string
+		known []string
+	}{
+		{"layout", config.Layout, []string{LayoutGrid, LayoutHex, LayoutBrick, LayoutVoronoi, LayoutCollage}},
+		{"assignment", config.Assignment, []string{AssignGreedy, AssignOptimal}},
+		{"order", config.Order, []string{OrderRandom, OrderSaliency}},
+		{"filler", config.Filler, []string{FillerSolid, FillerGradient, FillerNoise}},
+		{"compare space", config.CompareSpace, []string{ComparePixels, CompareDCT}},
+		{"deep zoom layout", config.DeepZoomLayout, []string{DeepZoomDZ, DeepZoomIIIF}},
+		{"watermark position", config.WatermarkPos, []string{PositionTopLeft, PositionTop, PositionTopRight, PositionLeft, PositionCenter, PositionRight, PositionBottomLeft, PositionBottom, PositionBottomRight}},
+		{"output format", config.OutputFormat, []string{FormatJPEG, FormatPNG, FormatWebP, FormatTIFF, FormatTIFF16, FormatAVIF}},
+	}
+	for _, e := range enums {
+		if e.value == "" {
+			continue
+		}
+		known := false
+		for _, k := range e.known {
+			known = known || e.value == k
+		}
+		if !known {
+			return fmt.Errorf("unknown %s %q", e.name, e.value)
+		}
+	}
+
+	for _, c := range []string{config.GroutColor, config.Background, config.MatteColor, config.TrimColor} {
+		if c == "" {
+			continue
+		}
+		if _, err := parseHexColor(c); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// loadSeed loads the seed image and scales it to the output size
+func loadSeed(config Config) (*image.RGBA, float64, error) {
+	data, err := readSeed(config)
+	if err != nil {
+		return nil, 0, err
+	}
+	img, err := LoadImageFromBuffer(data, config.SeedImage)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer img.Close()
+
+	if err := ToSRGB(img); err != nil {
+		return nil, 0, err
+	}
+
+	scaleFactor, err := scaleSeed(img, config)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	seed, err := img.ToImage(vips.NewDefaultPNGExportParams())
+	if err != nil {
+		log.Error(err)
+		return nil, 0, err
+	}
+
+	// seeds with an alpha channel are decoded as NRGBA
+	rgba, ok := seed.(*image.RGBA)
+	if !ok {
+		b := seed.Bounds()
+		rgba = image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+		draw.Draw(rgba, rgba.Bounds(), seed, b.Min, draw.Src)
+	}
+
+	return rgba, scaleFactor, nil
+}
+
 func New(config Config) (*Gosaic, error) {
 	vips.LoggingSettings(func(messageDomain string, messageLevel vips.LogLevel, message string) {
 		log.Error(message)
 	}, vips.LogLevelError)
 
-	// Load the master image and scale it to the output size
-	img, err := vips.NewImageFromFile(config.SeedImage)
-	if err != nil {
+	if err := checkConfig(config); err != nil {
 		return nil, err
 	}
-	defer img.Close()
-
-	scaleFactorX := float64(config.OutputSize) / float64(img.Width())
-	scaleFactorY := float64(config.OutputSize) / float64(img.Height())
-
-	scaleFactor := scaleFactorX
-	if scaleFactor < scaleFactorY {
-		scaleFactor = scaleFactorY
+	// the tiles are compared by the workers, without one none would match
+	if config.Workers < 1 {
+		config.Workers = 1
 	}
 
-	img.Resize(scaleFactor, vips.KernelAuto)
+	// Load the master image and scale it to the output size
+	seed, scaleFactor, err := loadSeed(config)
+	if err != nil {
+		return nil, err
+	}
 
 	// Create the mosaic
 	g := Gosaic{
-		config:        config,
-		seedVIPSImage: img,
-		Tiles:         list.New(),
-		scaleFactor:   scaleFactor,
+		config:      config,
+		Tiles:       list.New(),
+		scaleFactor: scaleFactor,
+		Comparator:  RGBComparator{CenterWeight: config.CenterWeight, Linear: config.LinearLight},
+		placements:  newPlacementMap(),
 		stats: Stats{
 			Comparisons: 0,
 			CompareTime: 0,
@@ -660,36 +1470,76 @@ func New(config Config) (*Gosaic, error) {
 		mutex: sync.Mutex{},
 	}
 
-	if config.RedisAddr != "" {
-		g.rdb = redis.NewClient(&redis.Options{
-			Addr:     config.RedisAddr,
-			Password: "", // no password set
-			DB:       0,  // use default DB
-		})
+	switch {
+	case config.CompareSpace == CompareDCT:
+		g.Comparator = DCTComparator{Size: config.DCTSize, Luma: config.Grayscale}
+	case config.Grayscale:
+		g.Comparator = LumaComparator{CenterWeight: config.CenterWeight, Linear: config.LinearLight}
+	}
+
+	g.SeedImage = seed
 
-		resp := g.rdb.Ping(context.Background())
-		if resp.Err() != nil {
+	if g.config.MaskImage != "" {
+		g.keepOut, err = loadMask(g.config.MaskImage, g.SeedImage.Bounds())
+		if err != nil {
+			log.Error(err)
 			return nil, err
 		}
 	}
 
-	seed, err := img.ToImage(vips.NewDefaultPNGExportParams())
-	if err != nil {
-		log.Error(err)
-		return nil, err
+	if g.config.FaceCascade != "" {
+		g.faces, err = g.detectFaces(g.config.FaceCascade)
+		if err != nil {
+			log.Error(err)
+			return nil, err
+		}
 	}
 
-	g.SeedImage = seed.(*image.RGBA)
-	if g.config.RedisAddr != "" && g.config.RedisLabel != "" {
-		err = g.loadTilesFromRedis()
-	} else {
-		err = g.loadTilesFromDisk()
+	if g.config.WeightMap != "" {
+		g.weightMap, err = loadMask(g.config.WeightMap, g.SeedImage.Bounds())
+		if err != nil {
+			log.Error(err)
+			return nil, err
+		}
 	}
 
-	if err != nil {
+	if g.config.TileFilter != "" {
+		if g.tileFilter, err = ParseTagFilter(g.config.TileFilter); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := g.openTileSource(); err != nil {
+		return nil, err
+	}
+	if err := g.loadTiles(g.source); err != nil {
 		log.Error(err)
 		return nil, err
 	}
+	if g.thumbs != nil {
+		if err := g.thumbs.save(); err != nil {
+			log.Warnf("thumbnail cache: %s", err)
+		}
+	}
+	g.sortTiles()
+
+	if g.config.Dedupe {
+		removed := g.dedupeTiles(g.config.DedupeDistance)
+		log.Infof("Removed %d duplicate tiles", removed)
+	}
+
+	if g.config.Palette != "" {
+		size := g.config.PaletteSize
+		if size <= 0 {
+			size = DefaultPaletteSize
+		}
+		palette, err := loadPalette(g.config.Palette, size)
+		if err != nil {
+			log.Error(err)
+			return nil, err
+		}
+		g.filterPalette(palette, g.config.PaletteDistance)
+	}
 
 	return &g, nil
 }
@@ -0,0 +1,111 @@
+package gosaic
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"github.com/davidbyttow/govips/v2/vips"
+	"github.com/gin-gonic/gin"
+)
+
+// DefaultMinFreeDisk is the free space in MB the output directories of the
+// server need for it to be ready
+const DefaultMinFreeDisk = 512
+
+// healthCheck checks a dependency of the server, it returns nil if the
+// dependency is fine
+type healthCheck func() error
+
+// errNoDiskStats is returned by diskFree on systems it isn't supported on
+var errNoDiskStats = errors.New("disk statistics aren't supported")
+
+// checkRedis checks if the Redis server of spec answers
+func checkRedis(spec string, ro RedisOptions) healthCheck {
+	return func() error {
+		rdb, err := connectRedis(spec, ro)
+		if err != nil {
+			return err
+		}
+		return rdb.Close()
+	}
+}
+
+// checkDisk checks if the directory dir has at least minFree MB free
+func checkDisk(dir string, minFree int) healthCheck {
+	return func() error {
+		free, err := diskFree(dir)
+		if err == errNoDiskStats {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if free < uint64(minFree)<<20 {
+			return fmt.Errorf("%s: %d MB free, need %d MB", dir, free>>20, minFree)
+		}
+		return nil
+	}
+}
+
+// checkVips creates an image with libvips, which starts it if it isn't
+// running yet. libvips panics if it can't be started.
+func checkVips() (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("libvips: %v", r)
+		}
+	}()
+	img, err := vips.Black(1, 1)
+	if err != nil {
+		return err
+	}
+	img.Close()
+	return nil
+}
+
+// healthz answers if the server is alive, without checking its
+// dependencies
+func healthz(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// readiness answers if all checks pass, with the result of each. The
+// server isn't ready if one fails.
+func readiness(checks map[string]healthCheck) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		status, state := http.StatusOK, "ready"
+		results := map[string]string{}
+		for name, check := range checks {
+			if err := check(); err != nil {
+				status, state = http.StatusServiceUnavailable, "unavailable"
+				results[name] = err.Error()
+				continue
+			}
+			results[name] = "ok"
+		}
+		c.JSON(status, gin.H{"status": state, "checks": results})
+	}
+}
+
+// readyChecks returns the checks of the readiness of the server: the Redis
+// servers of the tile cache and the job store, the free space of the
+// output directories and libvips
+func (srv *Server) readyChecks(storeSpec string, minFree int) map[string]healthCheck {
+	checks := map[string]healthCheck{
+		"disk": checkDisk("mosaics", minFree),
+		"vips": checkVips,
+	}
+	if !embeddedCache(srv.cache) {
+		checks["cache"] = checkRedis(srv.cache, srv.redis)
+	}
+	if storeSpec != "" && !strings.HasPrefix(storeSpec, SchemeFileCache) {
+		checks["job_store"] = checkRedis(storeSpec, srv.redis)
+	}
+	if results, ok := srv.results.(*localResults); ok && filepath.Clean(results.dir) != "mosaics" {
+		checks["results_disk"] = checkDisk(results.dir, minFree)
+	}
+	return checks
+}
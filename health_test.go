@@ -0,0 +1,59 @@
+package gosaic
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestReadiness(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	ok := func() error { return nil }
+	failed := func() error { return errors.New("connection refused") }
+
+	tests := []struct {
+		checks map[string]healthCheck
+		status int
+		want   map[string]string
+	}{
+		{map[string]healthCheck{"cache": ok, "disk": ok}, http.StatusOK, map[string]string{"cache": "ok", "disk": "ok"}},
+		{map[string]healthCheck{"cache": failed, "disk": ok}, http.StatusServiceUnavailable, map[string]string{"cache": "connection refused", "disk": "ok"}},
+	}
+	for _, tt := range tests {
+		router := gin.New()
+		router.GET("/readyz", readiness(tt.checks))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		if w.Code != tt.status {
+			t.Errorf("/readyz answered %d, want %d", w.Code, tt.status)
+		}
+		var body struct {
+			Checks map[string]string `json:"checks"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+			t.Fatal(err)
+		}
+		for name, want := range tt.want {
+			if body.Checks[name] != want {
+				t.Errorf("check %s is %q, want %q", name, body.Checks[name], want)
+			}
+		}
+	}
+}
+
+func TestCheckDisk(t *testing.T) {
+	dir := t.TempDir()
+	if err := checkDisk(dir, 0)(); err != nil {
+		t.Errorf("checkDisk without a minimum returned %v", err)
+	}
+	if err := checkDisk(dir, 1<<30)(); err == nil {
+		t.Error("checkDisk of a petabyte didn't fail")
+	}
+	if err := checkDisk(dir+"/missing", 0)(); err == nil {
+		t.Error("checkDisk of a missing directory didn't fail")
+	}
+}
@@ -0,0 +1,60 @@
+package gosaic
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"os"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// heatColor maps v (0..1) from green over yellow to red
+func heatColor(v float64) color.RGBA {
+	if v < 0.5 {
+		return color.RGBA{R: clamp8(v * 2 * 255), G: 0xff, A: 0xff}
+	}
+	return color.RGBA{R: 0xff, G: clamp8((1 - v) * 2 * 255), A: 0xff}
+}
+
+// saveHeatmap writes a PNG of the size of the mosaic which shows the
+// distance of the tile matched to every cell, from green for a perfect
+// match to red for the worst match of the mosaic. Cells without a matching
+// tile stay black.
+func (g *Gosaic) saveHeatmap(rects []*TileData, filename string) error {
+	worst := 0.0
+	for _, td := range rects {
+		if td.MinTile.Filename != "" && *td.MinDist > worst {
+			worst = *td.MinDist
+		}
+	}
+	log.Infof("Heatmap: red marks a distance of %.4f", worst)
+
+	heatmap := image.NewRGBA(g.SeedImage.Bounds())
+	draw.Draw(heatmap, heatmap.Bounds(), image.Black, image.ZP, draw.Src)
+	for _, td := range rects {
+		if td.MinTile.Filename == "" {
+			continue
+		}
+
+		v := 0.0
+		if worst > 0 {
+			v = *td.MinDist / worst
+		}
+		c := &image.Uniform{heatColor(v)}
+		if td.Mask != nil {
+			draw.DrawMask(heatmap, td.Cell, c, image.ZP, td.Mask, image.ZP, draw.Over)
+		} else {
+			draw.Draw(heatmap, td.Cell, c, image.ZP, draw.Src)
+		}
+	}
+
+	fh, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer fh.Close()
+
+	return png.Encode(fh, heatmap)
+}
@@ -0,0 +1,73 @@
+package gosaic
+
+import "image"
+
+// matchHistogram remaps every color channel of img so its histogram
+// matches the one of ref, which must have the same bounds, and blends the
+// result with the original values by amount (0..1). Transparent pixels are
+// neither counted nor changed.
+func matchHistogram(img, ref *image.RGBA, amount float64) {
+	if amount <= 0 {
+		return
+	}
+	if amount > 1 {
+		amount = 1
+	}
+
+	var hist, refHist [3][256]float64
+	b := img.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c, r := img.RGBAAt(x, y), ref.RGBAAt(x, y)
+			if c.A >= 0x80 {
+				hist[0][c.R]++
+				hist[1][c.G]++
+				hist[2][c.B]++
+			}
+			if r.A >= 0x80 {
+				refHist[0][r.R]++
+				refHist[1][r.G]++
+				refHist[2][r.B]++
+			}
+		}
+	}
+
+	var luts [3][256]uint8
+	for ch := range luts {
+		cdf, refCDF := cumulative(hist[ch]), cumulative(refHist[ch])
+		u := 0
+		for v := range luts[ch] {
+			for u < 255 && refCDF[u] < cdf[v] {
+				u++
+			}
+			luts[ch][v] = clamp8(float64(v) + amount*float64(u-v))
+		}
+	}
+
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := img.RGBAAt(x, y)
+			if c.A < 0x80 {
+				continue
+			}
+			c.R, c.G, c.B = luts[0][c.R], luts[1][c.G], luts[2][c.B]
+			img.SetRGBA(x, y, c)
+		}
+	}
+}
+
+// cumulative returns the normalized cumulative distribution of hist
+func cumulative(hist [256]float64) [256]float64 {
+	var cdf [256]float64
+	sum := 0.0
+	for i, n := range hist {
+		sum += n
+		cdf[i] = sum
+	}
+	if sum > 0 {
+		for i := range cdf {
+			cdf[i] /= sum
+		}
+	}
+	return cdf
+}
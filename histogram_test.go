@@ -0,0 +1,48 @@
+package gosaic
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestMatchHistogram(t *testing.T) {
+	// a horizontal gray ramp, its inverse and a ramp of half the contrast
+	ramp := func(f func(x int) uint8) *image.RGBA {
+		img := image.NewRGBA(image.Rect(0, 0, 256, 2))
+		for y := 0; y < 2; y++ {
+			for x := 0; x < 256; x++ {
+				v := f(x)
+				img.SetRGBA(x, y, color.RGBA{R: v, G: v, B: v, A: 0xff})
+			}
+		}
+		return img
+	}
+	identity := func(x int) uint8 { return uint8(x) }
+	half := func(x int) uint8 { return uint8(x / 2) }
+
+	tests := []struct {
+		name   string
+		img    func(int) uint8
+		ref    func(int) uint8
+		amount float64
+		want   func(int) uint8
+	}{
+		{"itself", identity, identity, 1, identity},
+		{"no amount", identity, half, 0, identity},
+		{"half the contrast", identity, half, 1, half},
+		{"double the contrast", half, identity, 1, func(x int) uint8 { return uint8(x/2*2 + 1) }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			img := ramp(tt.img)
+			matchHistogram(img, ramp(tt.ref), tt.amount)
+			for x := 0; x < 256; x++ {
+				if got := img.RGBAAt(x, 0).R; got != tt.want(x) {
+					t.Fatalf("pixel %d is %d, want %d", x, got, tt.want(x))
+				}
+			}
+		})
+	}
+}
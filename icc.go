@@ -0,0 +1,107 @@
+package gosaic
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+
+	"github.com/davidbyttow/govips/v2/vips"
+)
+
+// ToSRGB converts img into the sRGB working space of the mosaic. CMYK
+// images and images with an embedded ICC profile are transformed with
+// their profile first, so seeds and tiles exported from print workflows
+// keep their colors.
+func ToSRGB(img *vips.ImageRef) error {
+	if img.Interpretation() == vips.InterpretationCMYK || img.HasICCProfile() {
+		if err := img.OptimizeICCProfile(); err != nil {
+			return err
+		}
+	}
+	return img.ToColorSpace(vips.InterpretationSRGB)
+}
+
+// iccChunkSize is the most profile data a JPEG APP2 segment holds
+const iccChunkSize = 65519
+
+var (
+	jpegMagic = []byte{0xff, 0xd8}
+	pngMagic  = []byte("\x89PNG\r\n\x1a\n")
+)
+
+// embedICCProfile returns the encoded JPEG or PNG data with the ICC
+// profile embedded. The pixels aren't converted, the profile has to
+// describe the sRGB working space or one compatible with it.
+func embedICCProfile(data, profile []byte) ([]byte, error) {
+	switch {
+	case bytes.HasPrefix(data, jpegMagic):
+		return embedJPEGSegments(data, iccSegments(profile)), nil
+	case bytes.HasPrefix(data, pngMagic):
+		var compressed bytes.Buffer
+		zw := zlib.NewWriter(&compressed)
+		if _, err := zw.Write(profile); err != nil {
+			return nil, err
+		}
+		if err := zw.Close(); err != nil {
+			return nil, err
+		}
+
+		chunk := append([]byte("ICC Profile\x00\x00"), compressed.Bytes()...)
+		return embedPNGChunk(data, "iCCP", chunk), nil
+	}
+	return nil, errors.New("ICC profiles can only be embedded into JPEG and PNG files")
+}
+
+// iccSegments splits the profile into the APP2 segments of a JPEG, each
+// tagged with its sequence number and the number of segments.
+func iccSegments(profile []byte) [][]byte {
+	count := (len(profile) + iccChunkSize - 1) / iccChunkSize
+	segments := make([][]byte, 0, count)
+	for i := 0; i < count; i++ {
+		end := (i + 1) * iccChunkSize
+		if end > len(profile) {
+			end = len(profile)
+		}
+		payload := append([]byte("ICC_PROFILE\x00"), byte(i+1), byte(count))
+		segments = append(segments, jpegSegment(0xe2, append(payload, profile[i*iccChunkSize:end]...)))
+	}
+	return segments
+}
+
+// jpegSegment returns an application segment with the given marker
+func jpegSegment(marker byte, payload []byte) []byte {
+	segment := []byte{0xff, marker, 0, 0}
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(payload)+2))
+	return append(segment, payload...)
+}
+
+// embedJPEGSegments inserts the segments right after the start of image
+// marker of the JPEG data.
+func embedJPEGSegments(data []byte, segments [][]byte) []byte {
+	out := append([]byte{}, data[:len(jpegMagic)]...)
+	for _, s := range segments {
+		out = append(out, s...)
+	}
+	return append(out, data[len(jpegMagic):]...)
+}
+
+// embedPNGChunk inserts a chunk right after the IHDR chunk of the PNG data
+func embedPNGChunk(data []byte, typ string, payload []byte) []byte {
+	// the signature is followed by the 13 bytes of the IHDR chunk, framed
+	// by its length, type and checksum
+	ihdrEnd := len(pngMagic) + 4 + 4 + 13 + 4
+
+	chunk := make([]byte, 4, 12+len(payload))
+	binary.BigEndian.PutUint32(chunk, uint32(len(payload)))
+	chunk = append(chunk, typ...)
+	chunk = append(chunk, payload...)
+	crc := make([]byte, 4)
+	binary.BigEndian.PutUint32(crc, crc32.ChecksumIEEE(chunk[4:]))
+	chunk = append(chunk, crc...)
+
+	out := append([]byte{}, data[:ihdrEnd]...)
+	out = append(out, chunk...)
+	return append(out, data[ihdrEnd:]...)
+}
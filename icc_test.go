@@ -0,0 +1,50 @@
+package gosaic
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+func TestEmbedICCProfile(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 8, 8))
+	jpegData, pngData := &bytes.Buffer{}, &bytes.Buffer{}
+	if err := jpeg.Encode(jpegData, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := png.Encode(pngData, img); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		data    []byte
+		profile []byte
+		marker  []byte
+	}{
+		{"jpeg", jpegData.Bytes(), []byte("profile"), []byte("ICC_PROFILE\x00\x01\x01profile")},
+		{"jpeg with two segments", jpegData.Bytes(), make([]byte, iccChunkSize+1), []byte("ICC_PROFILE\x00\x02\x02\x00")},
+		{"png", pngData.Bytes(), []byte("profile"), []byte("iCCPICC Profile\x00\x00")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := embedICCProfile(tt.data, tt.profile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Contains(data, tt.marker) {
+				t.Fatalf("the profile isn't embedded")
+			}
+			if _, _, err := image.Decode(bytes.NewReader(data)); err != nil {
+				t.Fatalf("the image can't be decoded anymore: %s", err)
+			}
+		})
+	}
+
+	if _, err := embedICCProfile([]byte("GIF89a"), []byte("profile")); err == nil {
+		t.Fatal("embedding into a GIF didn't fail")
+	}
+}
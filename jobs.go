@@ -0,0 +1,440 @@
+package gosaic
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// States of a build job of the server
+const (
+	JobQueued  = "queued"
+	JobRunning = "running"
+	JobDone    = "done"
+	JobFailed  = "failed"
+)
+
+// Job is a mosaic built in the background by the server
+type Job struct {
+	ID       string         `json:"id"`
+	State    string         `json:"state"`
+	Progress Progress       `json:"progress"`
+	Error    string         `json:"error,omitempty"`
+	Created  time.Time      `json:"created"`
+	Started  *time.Time     `json:"started,omitempty"`
+	Finished *time.Time     `json:"finished,omitempty"`
+	Quality  *QualityReport `json:"quality,omitempty"`
+	// ResultURL is a download URL of the mosaic of a done job, which
+	// expires
+	ResultURL string `json:"result_url,omitempty"`
+	// Output is the file the mosaic is written to
+	Output string `json:"-"`
+}
+
+// buildFunc builds the mosaic of a job, reporting its progress to
+// onProgress
+type buildFunc func(onProgress func(Progress)) (QualityReport, error)
+
+// Default limits of the builds of the server
+const (
+	DefaultMaxBuilds = 2
+	DefaultJobQueue  = 16
+	DefaultJobMemory = 256
+)
+
+// JobLimits bound the builds of the server so simultaneous requests can't
+// exhaust the memory of the host
+type JobLimits struct {
+	// Builds is the number of builds running at the same time, 0 doesn't
+	// limit them
+	Builds int
+	// Queue is the number of jobs waiting for a build, more are rejected
+	Queue int
+	// Memory is the memory in MB all running builds may use, 0 doesn't
+	// limit it
+	Memory int
+	// JobMemory is the memory in MB a build needs besides its output image
+	JobMemory int
+}
+
+var (
+	// errQueueFull is returned for jobs which don't fit into the queue
+	errQueueFull = errors.New("too many jobs are queued")
+	// errJobTooLarge is returned for jobs which need more memory than all
+	// builds may use
+	errJobTooLarge = errors.New("the mosaic needs too much memory")
+	// errQuotaExceeded is returned for jobs of owners who have used up
+	// their builds of the month
+	errQuotaExceeded = errors.New("monthly build quota exceeded")
+)
+
+// queuedJob is a job waiting for a build
+type queuedJob struct {
+	id     string
+	memory int
+}
+
+// jobManager runs the build jobs of the server in the process within its
+// limits and keeps their state, in its store if it has one
+type jobManager struct {
+	mutex     sync.Mutex
+	saveMutex sync.Mutex
+	limits    JobLimits
+	store     jobStore
+	newBuild  func(jobRecord) buildFunc
+	// onFinish is called with every finished job in the background if it
+	// is set
+	onFinish func(jobRecord)
+	jobs     map[string]*jobRecord
+	watchers map[string][]chan Job
+	queue    []queuedJob
+	running  int
+	memory   int
+	// used is the number of builds of every owner in month, like 2006-01.
+	// It is kept apart from the jobs, so expired jobs still count.
+	month string
+	used  map[string]int
+}
+
+// newJobManager returns a job manager building the jobs with the builds
+// of newBuild. The store may be nil to keep the jobs in memory only.
+func newJobManager(limits JobLimits, store jobStore, newBuild func(jobRecord) buildFunc) *jobManager {
+	return &jobManager{
+		limits:   limits,
+		store:    store,
+		newBuild: newBuild,
+		jobs:     map[string]*jobRecord{},
+		watchers: map[string][]chan Job{},
+	}
+}
+
+// start queues the job rec.Job.ID, writing the mosaic to rec.Output and
+// needing rec.Memory MB, and returns the job. Jobs which would exceed the
+// limits, or the quota of builds of this month of rec.Owner if it isn't 0,
+// are rejected.
+func (m *jobManager) start(rec jobRecord, quota int) (Job, error) {
+	m.mutex.Lock()
+	if m.limits.Memory > 0 && rec.Memory > m.limits.Memory {
+		m.mutex.Unlock()
+		return Job{}, errJobTooLarge
+	}
+	if quota > 0 && m.builds(rec.Owner, time.Now()) >= quota {
+		m.mutex.Unlock()
+		return Job{}, errQuotaExceeded
+	}
+	if !m.fits(rec.Memory) && len(m.queue) >= m.limits.Queue {
+		m.mutex.Unlock()
+		return Job{}, errQueueFull
+	}
+
+	job := Job{ID: rec.Job.ID, State: JobQueued, Created: time.Now(), Output: rec.Output}
+	rec.Job = job
+	m.jobs[job.ID] = &rec
+	m.queue = append(m.queue, queuedJob{id: job.ID, memory: rec.Memory})
+	if rec.Owner != "" {
+		m.builds(rec.Owner, job.Created)
+		m.used[rec.Owner]++
+	}
+	month := m.month
+	m.mutex.Unlock()
+
+	// the queued job is saved before it is scheduled
+	if rec.Owner != "" {
+		m.countBuild(rec.Owner, month)
+	}
+	m.save(job.ID)
+	m.mutex.Lock()
+	m.schedule()
+	m.mutex.Unlock()
+	return job, nil
+}
+
+// resume loads the jobs of the store. Jobs which were queued or running
+// when the server stopped are queued again.
+func (m *jobManager) resume() error {
+	if m.store == nil {
+		return nil
+	}
+	recs, err := m.store.load()
+	if err != nil {
+		return err
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for i := range recs {
+		rec := &recs[i]
+		rec.Job.Output = rec.Output
+		m.jobs[rec.Job.ID] = rec
+		if rec.Job.finished() {
+			continue
+		}
+		log.Infof("resuming job %s", rec.Job.ID)
+		rec.Job.State, rec.Job.Started, rec.Job.Progress = JobQueued, nil, Progress{}
+		m.queue = append(m.queue, queuedJob{id: rec.Job.ID, memory: rec.Memory})
+	}
+	m.schedule()
+	return nil
+}
+
+// save persists the job id if the manager has a store. The saves are
+// serialized, so a stale state can't overwrite a newer one.
+func (m *jobManager) save(id string) {
+	if m.store == nil {
+		return
+	}
+	m.saveMutex.Lock()
+	defer m.saveMutex.Unlock()
+	m.mutex.Lock()
+	rec, ok := m.jobs[id]
+	if !ok {
+		m.mutex.Unlock()
+		return
+	}
+	r := *rec
+	m.mutex.Unlock()
+
+	if err := m.store.save(r); err != nil {
+		log.Errorf("job %s: %s", id, err)
+	}
+}
+
+// buildMonth returns the month of t the quotas count the builds in, like
+// 2006-01
+func buildMonth(t time.Time) string {
+	return monthStart(t).Format("2006-01")
+}
+
+// builds returns the number of builds of owner in the month of now, the
+// mutex must be held. The counts are loaded from the store in every new
+// month.
+func (m *jobManager) builds(owner string, now time.Time) int {
+	if month := buildMonth(now); month != m.month {
+		m.month, m.used = month, map[string]int{}
+		if m.store != nil {
+			used, err := m.store.builds(month)
+			if err != nil {
+				log.Errorf("builds of %s: %s", month, err)
+			} else {
+				m.used = used
+			}
+		}
+	}
+	return m.used[owner]
+}
+
+// countBuild persists another build of owner in month if the manager has
+// a store, taking over the count of the store which other servers may
+// share.
+func (m *jobManager) countBuild(owner, month string) {
+	if m.store == nil {
+		return
+	}
+	n, err := m.store.countBuild(owner, month)
+	if err != nil {
+		log.Errorf("builds of %s: %s", owner, err)
+		return
+	}
+	m.mutex.Lock()
+	if m.month == month && n > m.used[owner] {
+		m.used[owner] = n
+	}
+	m.mutex.Unlock()
+}
+
+// fits tells if a build needing memory MB may run right now
+func (m *jobManager) fits(memory int) bool {
+	if m.limits.Builds > 0 && m.running >= m.limits.Builds {
+		return false
+	}
+	return m.limits.Memory <= 0 || m.memory+memory <= m.limits.Memory
+}
+
+// schedule starts the queued jobs in order as long as they fit, the mutex
+// must be held
+func (m *jobManager) schedule() {
+	for len(m.queue) > 0 && m.fits(m.queue[0].memory) {
+		q := m.queue[0]
+		m.queue = m.queue[1:]
+		m.running++
+		m.memory += q.memory
+		go m.run(q)
+	}
+}
+
+// run builds the job q, records its result and starts the next queued
+// jobs. A panicking build fails the job instead of taking the server down.
+func (m *jobManager) run(q queuedJob) {
+	id := q.id
+	defer func() {
+		m.mutex.Lock()
+		defer m.mutex.Unlock()
+		m.running--
+		m.memory -= q.memory
+		m.schedule()
+	}()
+
+	m.update(id, func(job *Job) {
+		now := time.Now()
+		job.State, job.Started = JobRunning, &now
+	})
+	m.save(id)
+	m.mutex.Lock()
+	rec := *m.jobs[id]
+	m.mutex.Unlock()
+
+	var quality QualityReport
+	err := func() (err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = fmt.Errorf("build panicked: %v", r)
+			}
+		}()
+		quality, err = m.newBuild(rec)(func(p Progress) {
+			m.update(id, func(job *Job) { job.Progress = p })
+		})
+		return err
+	}()
+
+	m.update(id, func(job *Job) {
+		now := time.Now()
+		job.Finished = &now
+		if err != nil {
+			log.Errorf("job %s: %s", id, err)
+			job.State, job.Error = JobFailed, err.Error()
+			return
+		}
+		job.State, job.Quality = JobDone, &quality
+	})
+	m.save(id)
+
+	if m.onFinish != nil {
+		m.mutex.Lock()
+		rec = *m.jobs[id]
+		m.mutex.Unlock()
+		go m.onFinish(rec)
+	}
+}
+
+// update changes the job id with fn and passes it on to its watchers.
+// Watchers which are behind only get the latest state.
+func (m *jobManager) update(id string, fn func(*Job)) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	rec, ok := m.jobs[id]
+	if !ok {
+		return
+	}
+	fn(&rec.Job)
+	for _, ch := range m.watchers[id] {
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- rec.Job
+	}
+}
+
+// watch returns the job id and a channel receiving it whenever it changes,
+// until cancel is called
+func (m *jobManager) watch(id string) (job Job, updates <-chan Job, cancel func(), err error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	rec, ok := m.jobs[id]
+	if !ok {
+		return Job{}, nil, nil, errUnknownJob
+	}
+
+	ch := make(chan Job, 1)
+	m.watchers[id] = append(m.watchers[id], ch)
+	cancel = func() {
+		m.mutex.Lock()
+		defer m.mutex.Unlock()
+		watchers := m.watchers[id]
+		for i, w := range watchers {
+			if w == ch {
+				watchers = append(watchers[:i], watchers[i+1:]...)
+				break
+			}
+		}
+		if len(watchers) == 0 {
+			delete(m.watchers, id)
+		} else {
+			m.watchers[id] = watchers
+		}
+	}
+	return rec.Job, ch, cancel, nil
+}
+
+// wait waits until the job id is finished or ctx is done
+func (m *jobManager) wait(ctx context.Context, id string) (Job, error) {
+	job, updates, cancel, err := m.watch(id)
+	if err != nil {
+		return job, err
+	}
+	defer cancel()
+	for !job.finished() {
+		select {
+		case job = <-updates:
+		case <-ctx.Done():
+			return job, ctx.Err()
+		}
+	}
+	return job, nil
+}
+
+// expire removes the finished jobs which finished before, from the store
+// as well, and returns them
+func (m *jobManager) expire(before time.Time) []jobRecord {
+	m.mutex.Lock()
+	expired := []jobRecord{}
+	for id, rec := range m.jobs {
+		if rec.Job.finished() && rec.Job.Finished.Before(before) {
+			expired = append(expired, *rec)
+			delete(m.jobs, id)
+		}
+	}
+	m.mutex.Unlock()
+
+	if m.store != nil {
+		for _, rec := range expired {
+			if err := m.store.remove(rec.Job.ID); err != nil {
+				log.Errorf("job %s: %s", rec.Job.ID, err)
+			}
+		}
+	}
+	return expired
+}
+
+// jobMemory estimates the memory in MB the build of s needs: base MB plus
+// the seed image, the canvas and the encoded output at the output size
+func jobMemory(s Seed, base int) int {
+	w, h := s.OutputWidth, s.OutputHeight
+	if w == 0 || h == 0 {
+		w, h = s.OutputSize, s.OutputSize
+	}
+	return base + w*h*4*3/(1<<20)
+}
+
+// finished tells if the job won't change anymore
+func (j Job) finished() bool {
+	return j.State == JobDone || j.State == JobFailed
+}
+
+// errUnknownJob is returned for job IDs the server doesn't know
+var errUnknownJob = errors.New("unknown job")
+
+// get returns a copy of the job id
+func (m *jobManager) get(id string) (Job, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	rec, ok := m.jobs[id]
+	if !ok {
+		return Job{}, errUnknownJob
+	}
+	return rec.Job, nil
+}
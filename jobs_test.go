@@ -0,0 +1,399 @@
+package gosaic
+
+import (
+	"errors"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// waitJob waits until the job id of m is finished
+func waitJob(t *testing.T, m *jobManager, id string) Job {
+	t.Helper()
+	for i := 0; i < 200; i++ {
+		job, err := m.get(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if job.finished() {
+			return job
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("job %s didn't finish", id)
+	return Job{}
+}
+
+// testJobs returns a job manager building each job with the build of its
+// ID in builds
+func testJobs(limits JobLimits, store jobStore, builds map[string]buildFunc) *jobManager {
+	return newJobManager(limits, store, func(rec jobRecord) buildFunc {
+		return builds[rec.Job.ID]
+	})
+}
+
+// startJob starts the job id needing memory MB
+func startJob(m *jobManager, id string, memory int) (Job, error) {
+	return m.start(jobRecord{Job: Job{ID: id}, Output: id + ".jpg", Memory: memory}, 0)
+}
+
+func TestJobManager(t *testing.T) {
+	m := testJobs(JobLimits{}, nil, map[string]buildFunc{
+		"ok": func(onProgress func(Progress)) (QualityReport, error) {
+			onProgress(Progress{Done: 1, Total: 2})
+			onProgress(Progress{Done: 2, Total: 2})
+			return QualityReport{PSNR: 20}, nil
+		},
+		"failed": func(func(Progress)) (QualityReport, error) {
+			return QualityReport{}, errors.New("no tiles")
+		},
+		"panicked": func(func(Progress)) (QualityReport, error) {
+			panic("boom")
+		},
+	})
+
+	job, err := startJob(m, "ok", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.ID != "ok" || job.Output != "ok.jpg" || job.State != JobQueued {
+		t.Errorf("start returned %+v", job)
+	}
+	job = waitJob(t, m, "ok")
+	if job.State != JobDone || job.Quality == nil || job.Quality.PSNR != 20 {
+		t.Errorf("finished job is %+v", job)
+	}
+	if job.Progress.Done != 2 || job.Progress.Total != 2 {
+		t.Errorf("progress is %+v, want 2/2", job.Progress)
+	}
+	if job.Started == nil || job.Finished == nil {
+		t.Errorf("job times aren't set: %+v", job)
+	}
+
+	startJob(m, "failed", 0)
+	if job = waitJob(t, m, "failed"); job.State != JobFailed || job.Error != "no tiles" {
+		t.Errorf("failed job is %+v", job)
+	}
+
+	startJob(m, "panicked", 0)
+	if job = waitJob(t, m, "panicked"); job.State != JobFailed || job.Error == "" {
+		t.Errorf("panicked job is %+v", job)
+	}
+
+	if _, err := m.get("unknown"); err != errUnknownJob {
+		t.Errorf("get of an unknown job returned %v", err)
+	}
+}
+
+func TestJobManagerWatch(t *testing.T) {
+	release := make(chan bool)
+	m := testJobs(JobLimits{}, nil, map[string]buildFunc{
+		"watched": func(onProgress func(Progress)) (QualityReport, error) {
+			<-release
+			onProgress(Progress{Done: 1, Total: 1})
+			return QualityReport{}, nil
+		},
+	})
+	if _, _, _, err := m.watch("unknown"); err != errUnknownJob {
+		t.Errorf("watch of an unknown job returned %v", err)
+	}
+
+	startJob(m, "watched", 0)
+	_, updates, cancel, err := m.watch("watched")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cancel()
+	close(release)
+
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case job := <-updates:
+			if !job.finished() {
+				continue
+			}
+			if job.State != JobDone || job.Progress.Done != 1 {
+				t.Errorf("last update is %+v", job)
+			}
+			return
+		case <-timeout:
+			t.Fatal("the job didn't finish")
+		}
+	}
+}
+
+func TestJobManagerOnFinish(t *testing.T) {
+	m := testJobs(JobLimits{}, nil, map[string]buildFunc{
+		"failed": func(func(Progress)) (QualityReport, error) { return QualityReport{}, errors.New("no tiles") },
+	})
+	finished := make(chan jobRecord, 1)
+	m.onFinish = func(rec jobRecord) { finished <- rec }
+
+	m.start(jobRecord{Job: Job{ID: "failed"}, BaseURL: "http://gosaic"}, 0)
+	select {
+	case rec := <-finished:
+		if rec.Job.State != JobFailed || rec.BaseURL == "" {
+			t.Errorf("finished job is %+v", rec)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("onFinish wasn't called")
+	}
+}
+
+func TestJobManagerLimits(t *testing.T) {
+	release := make(chan bool)
+	build := func(func(Progress)) (QualityReport, error) {
+		<-release
+		return QualityReport{}, nil
+	}
+	m := testJobs(JobLimits{Builds: 1, Queue: 1, Memory: 100}, nil, map[string]buildFunc{
+		"first":  build,
+		"second": build,
+	})
+
+	if _, err := startJob(m, "large", 101); err != errJobTooLarge {
+		t.Errorf("start of a job over the memory limit returned %v", err)
+	}
+	if _, err := startJob(m, "first", 10); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := startJob(m, "second", 10); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := startJob(m, "third", 10); err != errQueueFull {
+		t.Errorf("start of a job beyond the queue returned %v", err)
+	}
+	if job, _ := m.get("second"); job.State != JobQueued {
+		t.Errorf("second job is %s, want queued", job.State)
+	}
+
+	close(release)
+	if job := waitJob(t, m, "second"); job.State != JobDone {
+		t.Errorf("second job is %s, want done", job.State)
+	}
+}
+
+func TestJobManagerQuota(t *testing.T) {
+	ok := func(func(Progress)) (QualityReport, error) { return QualityReport{}, nil }
+	m := testJobs(JobLimits{}, nil, map[string]buildFunc{"a1": ok, "a2": ok, "b1": ok})
+
+	for _, tt := range []struct {
+		id, owner string
+		want      error
+	}{
+		{"a1", "a", nil},
+		{"a2", "a", nil},
+		{"a3", "a", errQuotaExceeded},
+		{"b1", "b", nil},
+	} {
+		_, err := m.start(jobRecord{Job: Job{ID: tt.id}, Owner: tt.owner}, 2)
+		if err != tt.want {
+			t.Errorf("start of %s of %s returned %v, want %v", tt.id, tt.owner, err, tt.want)
+		}
+	}
+
+	// the builds of last month don't count
+	m.mutex.Lock()
+	m.month = buildMonth(monthStart(time.Now()).Add(-time.Second))
+	m.mutex.Unlock()
+	if _, err := m.start(jobRecord{Job: Job{ID: "a4"}, Owner: "a"}, 2); err != nil {
+		t.Errorf("start after a build of last month returned %v", err)
+	}
+}
+
+// the builds of the month still count once their jobs expired and after a
+// restart
+func TestJobManagerQuotaPersisted(t *testing.T) {
+	spec := SchemeFileCache + filepath.Join(t.TempDir(), "jobs.db")
+	store, err := openJobStore(spec, RedisOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ok := func(func(Progress)) (QualityReport, error) { return QualityReport{}, nil }
+	m := testJobs(JobLimits{}, store, map[string]buildFunc{"a1": ok, "a2": ok})
+	for _, id := range []string{"a1", "a2"} {
+		if _, err := m.start(jobRecord{Job: Job{ID: id}, Owner: "a"}, 3); err != nil {
+			t.Fatal(err)
+		}
+		waitJob(t, m, id)
+	}
+	if expired := m.expire(time.Now().Add(time.Second)); len(expired) != 2 {
+		t.Fatalf("expire removed %d jobs, want 2", len(expired))
+	}
+	store.Close()
+
+	store, err = openJobStore(spec, RedisOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	m = testJobs(JobLimits{}, store, map[string]buildFunc{"a3": ok})
+	if err := m.resume(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.start(jobRecord{Job: Job{ID: "a3"}, Owner: "a"}, 3); err != nil {
+		t.Errorf("start of the third build returned %v", err)
+	}
+	if _, err := m.start(jobRecord{Job: Job{ID: "a4"}, Owner: "a"}, 3); err != errQuotaExceeded {
+		t.Errorf("start of the fourth build returned %v, want %v", err, errQuotaExceeded)
+	}
+	if used, err := store.builds(buildMonth(time.Now())); err != nil || used["a"] != 3 {
+		t.Errorf("stored builds = %v, %v, want 3 of a", used, err)
+	}
+}
+
+func TestJobManagerResume(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gosaic")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	spec := SchemeFileCache + filepath.Join(dir, "jobs.db")
+
+	// the first server stops while one job is running and another queued
+	store, err := openJobStore(spec, RedisOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	release := make(chan bool)
+	m := testJobs(JobLimits{Builds: 1, Queue: 1}, store, map[string]buildFunc{
+		"done": func(func(Progress)) (QualityReport, error) { return QualityReport{PSNR: 30}, nil },
+		"running": func(func(Progress)) (QualityReport, error) {
+			<-release
+			return QualityReport{}, nil
+		},
+	})
+	startJob(m, "done", 0)
+	waitJob(t, m, "done")
+	startJob(m, "running", 0)
+	if _, err := m.start(jobRecord{Job: Job{ID: "queued"}, Output: "queued.jpg", Params: Seed{Tilesize: 32}}, 0); err != nil {
+		t.Fatal(err)
+	}
+	store.Close()
+
+	store, err = openJobStore(spec, RedisOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	built := make(chan jobRecord, 2)
+	m = newJobManager(JobLimits{Builds: 1}, store, func(rec jobRecord) buildFunc {
+		return func(func(Progress)) (QualityReport, error) {
+			built <- rec
+			return QualityReport{}, nil
+		}
+	})
+	if err := m.resume(); err != nil {
+		t.Fatal(err)
+	}
+
+	if job, _ := m.get("done"); job.State != JobDone || job.Quality.PSNR != 30 || job.Output != "done.jpg" {
+		t.Errorf("finished job after the restart is %+v", job)
+	}
+	for _, id := range []string{"running", "queued"} {
+		if job := waitJob(t, m, id); job.State != JobDone {
+			t.Errorf("job %s after the restart is %s, want done", id, job.State)
+		}
+	}
+	close(built)
+	ids := []string{}
+	for rec := range built {
+		ids = append(ids, rec.Job.ID)
+		if rec.Job.ID == "queued" && rec.Params.Tilesize != 32 {
+			t.Errorf("the parameters of the queued job weren't kept: %+v", rec.Params)
+		}
+	}
+	if strings.Join(ids, ",") != "running,queued" {
+		t.Errorf("resumed jobs %v, want running,queued", ids)
+	}
+	close(release)
+}
+
+func TestJobManagerExpire(t *testing.T) {
+	dir := t.TempDir()
+	store, err := openJobStore(SchemeFileCache+filepath.Join(dir, "jobs.db"), RedisOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	release := make(chan bool)
+	m := testJobs(JobLimits{}, store, map[string]buildFunc{
+		"done": func(func(Progress)) (QualityReport, error) { return QualityReport{}, nil },
+		"running": func(func(Progress)) (QualityReport, error) {
+			<-release
+			return QualityReport{}, nil
+		},
+	})
+	defer close(release)
+	startJob(m, "done", 0)
+	waitJob(t, m, "done")
+	startJob(m, "running", 0)
+
+	if expired := m.expire(time.Now().Add(-time.Hour)); len(expired) != 0 {
+		t.Errorf("expire of an hour ago removed %d jobs", len(expired))
+	}
+	expired := m.expire(time.Now().Add(time.Second))
+	if len(expired) != 1 || expired[0].Job.ID != "done" {
+		t.Fatalf("expire removed %+v, want the done job", expired)
+	}
+	if _, err := m.get("done"); err != errUnknownJob {
+		t.Errorf("get of an expired job returned %v", err)
+	}
+	if _, err := m.get("running"); err != nil {
+		t.Errorf("get of the running job returned %v", err)
+	}
+	recs, err := store.load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, rec := range recs {
+		if rec.Job.ID == "done" {
+			t.Error("the expired job is still stored")
+		}
+	}
+}
+
+func TestJobMemory(t *testing.T) {
+	tests := []struct {
+		s    Seed
+		want int
+	}{
+		{Seed{OutputSize: 1024}, 256 + 12},
+		{Seed{OutputSize: 1024, OutputWidth: 2048, OutputHeight: 1024}, 256 + 24},
+		{Seed{}, 256},
+	}
+	for _, tt := range tests {
+		if got := jobMemory(tt.s, 256); got != tt.want {
+			t.Errorf("jobMemory(%+v) = %d, want %d", tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestProgressHook(t *testing.T) {
+	var last Progress
+	h := newProgressHook(nil, func(p Progress) { last = p }, 4)
+
+	h.Increment()
+	if last.Done != 1 || last.Total != 4 {
+		t.Errorf("progress is %d/%d, want 1/4", last.Done, last.Total)
+	}
+	if last.Elapsed > 0 && last.ETA <= 0 {
+		t.Errorf("no ETA with %d of %d done", last.Done, last.Total)
+	}
+
+	for _, d := range []float64{0.2, 0.1, 0.6} {
+		h.place(d)
+	}
+	h.Finish()
+	want := DistanceStats{Placed: 3, Min: 0.1, Mean: 0.3, Max: 0.6}
+	got := last.Distance
+	if got.Placed != want.Placed || got.Min != want.Min || got.Max != want.Max || math.Abs(got.Mean-want.Mean) > 1e-9 {
+		t.Errorf("distance stats are %+v, want %+v", got, want)
+	}
+}
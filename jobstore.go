@@ -0,0 +1,253 @@
+package gosaic
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	redis "github.com/go-redis/redis/v8"
+)
+
+// jobRecord is a job as it is persisted, with what it takes to build it
+// again after a restart
+type jobRecord struct {
+	Job      Job    `json:"job"`
+	Output   string `json:"output"`
+	Params   Seed   `json:"params"`
+	SeedFile string `json:"seed_file"`
+	Memory   int    `json:"memory"`
+	// Owner identifies the API key of the job for its quota
+	Owner string `json:"owner,omitempty"`
+	// BaseURL is the URL of the server the job was sent to, for the
+	// result URL of the webhook
+	BaseURL string `json:"base_url,omitempty"`
+}
+
+// jobStore persists the jobs of the server so finished mosaics and queued
+// builds survive a restart, and the builds of the owners for their quotas
+type jobStore interface {
+	save(rec jobRecord) error
+	remove(id string) error
+	load() ([]jobRecord, error)
+	// countBuild adds a build of owner in month and returns the builds of
+	// owner in month
+	countBuild(owner, month string) (int, error)
+	// builds returns the number of builds of every owner in month
+	builds(month string) (map[string]int, error)
+	Close() error
+}
+
+// openJobStore opens the job store of spec, a Redis address or URL or
+// file:path.db for an embedded file like the tile cache
+func openJobStore(spec string, ro RedisOptions) (jobStore, error) {
+	if strings.HasPrefix(spec, SchemeFileCache) {
+		c, err := openFileCache(strings.TrimPrefix(spec, SchemeFileCache))
+		if err != nil {
+			return nil, err
+		}
+		return &fileJobStore{c: c}, nil
+	}
+	rdb, err := connectRedis(spec, ro)
+	if err != nil {
+		return nil, err
+	}
+	return &redisJobStore{rdb: rdb}, nil
+}
+
+// jobKey returns the key of the job id
+func jobKey(id string) string {
+	return "job:" + id
+}
+
+// buildsKey returns the key of the builds of the owners in month
+func buildsKey(month string) string {
+	return "builds:" + month
+}
+
+// jobIndexKey is the key of the set of the IDs of all jobs in Redis
+const jobIndexKey = "jobs"
+
+// redisJobStore keeps every job as JSON in Redis, with the IDs of the jobs
+// in an index set
+type redisJobStore struct {
+	rdb *redis.Client
+}
+
+func (s *redisJobStore) save(rec jobRecord) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	pipe := s.rdb.TxPipeline()
+	pipe.Set(ctx, jobKey(rec.Job.ID), data, 0)
+	pipe.SAdd(ctx, jobIndexKey, rec.Job.ID)
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+func (s *redisJobStore) remove(id string) error {
+	ctx := context.Background()
+	pipe := s.rdb.TxPipeline()
+	pipe.Del(ctx, jobKey(id))
+	pipe.SRem(ctx, jobIndexKey, id)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func (s *redisJobStore) load() ([]jobRecord, error) {
+	ctx := context.Background()
+	ids, err := s.rdb.SMembers(ctx, jobIndexKey).Result()
+	if err != nil || len(ids) == 0 {
+		return nil, err
+	}
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = jobKey(id)
+	}
+	values, err := s.rdb.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	recs := make([]jobRecord, 0, len(values))
+	for _, v := range values {
+		data, ok := v.(string)
+		if !ok {
+			continue
+		}
+		rec := jobRecord{}
+		if err := json.Unmarshal([]byte(data), &rec); err != nil {
+			return nil, err
+		}
+		recs = append(recs, rec)
+	}
+	sortJobRecords(recs)
+	return recs, nil
+}
+
+func (s *redisJobStore) countBuild(owner, month string) (int, error) {
+	n, err := s.rdb.HIncrBy(context.Background(), buildsKey(month), owner, 1).Result()
+	return int(n), err
+}
+
+func (s *redisJobStore) builds(month string) (map[string]int, error) {
+	values, err := s.rdb.HGetAll(context.Background(), buildsKey(month)).Result()
+	if err != nil {
+		return nil, err
+	}
+	used := make(map[string]int, len(values))
+	for owner, v := range values {
+		if used[owner], err = strconv.Atoi(v); err != nil {
+			return nil, err
+		}
+	}
+	return used, nil
+}
+
+func (s *redisJobStore) Close() error {
+	return s.rdb.Close()
+}
+
+// fileJobStore keeps every job as JSON in an embedded file of the format
+// of the file cache
+type fileJobStore struct {
+	c *fileCache
+	// mutex serializes the counting of builds
+	mutex sync.Mutex
+}
+
+func (s *fileJobStore) save(rec jobRecord) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	return s.c.set(jobKey(rec.Job.ID), data)
+}
+
+func (s *fileJobStore) remove(id string) error {
+	return s.c.set(jobKey(id), nil)
+}
+
+func (s *fileJobStore) load() ([]jobRecord, error) {
+	s.c.mutex.RLock()
+	keys := []string{}
+	for k := range s.c.index {
+		if strings.HasPrefix(k, jobKey("")) {
+			keys = append(keys, k)
+		}
+	}
+	s.c.mutex.RUnlock()
+
+	recs := make([]jobRecord, 0, len(keys))
+	for _, k := range keys {
+		data, err := s.c.get(k)
+		if err != nil {
+			return nil, err
+		}
+		rec := jobRecord{}
+		if err := json.Unmarshal(data, &rec); err != nil {
+			return nil, err
+		}
+		recs = append(recs, rec)
+	}
+	sortJobRecords(recs)
+	return recs, nil
+}
+
+// the builds of an owner are kept as a number under the builds key of the
+// month followed by the owner
+func (s *fileJobStore) countBuild(owner, month string) (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	key := buildsKey(month) + ":" + owner
+	n := 0
+	data, err := s.c.get(key)
+	if err == nil {
+		n, err = strconv.Atoi(string(data))
+	}
+	if err != nil && err != ErrNotCached {
+		return 0, err
+	}
+	n++
+	return n, s.c.set(key, []byte(strconv.Itoa(n)))
+}
+
+func (s *fileJobStore) builds(month string) (map[string]int, error) {
+	prefix := buildsKey(month) + ":"
+	s.c.mutex.RLock()
+	keys := []string{}
+	for k := range s.c.index {
+		if strings.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+		}
+	}
+	s.c.mutex.RUnlock()
+
+	used := make(map[string]int, len(keys))
+	for _, k := range keys {
+		data, err := s.c.get(k)
+		if err != nil {
+			return nil, err
+		}
+		if used[strings.TrimPrefix(k, prefix)], err = strconv.Atoi(string(data)); err != nil {
+			return nil, err
+		}
+	}
+	return used, nil
+}
+
+func (s *fileJobStore) Close() error {
+	return s.c.Close()
+}
+
+// sortJobRecords sorts recs by their creation, so queued jobs are resumed
+// in their order
+func sortJobRecords(recs []jobRecord) {
+	sort.Slice(recs, func(i, j int) bool {
+		return recs[i].Job.Created.Before(recs[j].Job.Created)
+	})
+}
@@ -0,0 +1,216 @@
+package gosaic
+
+import (
+	"container/list"
+	"image"
+	"math"
+	"sort"
+)
+
+// featureGrid is the number of cells per axis used by featureVector
+const featureGrid = 4
+
+// featureVector downsamples img to a featureGrid x featureGrid grid and
+// returns the mean red, green and blue value (0..1) of every cell.
+func featureVector(img image.Image) []float64 {
+	b := img.Bounds()
+	features := make([]float64, featureGrid*featureGrid*3)
+	if b.Dx() == 0 || b.Dy() == 0 {
+		return features
+	}
+
+	counts := make([]float64, featureGrid*featureGrid)
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		cy := (y - b.Min.Y) * featureGrid / b.Dy()
+		for x := b.Min.X; x < b.Max.X; x++ {
+			cx := (x - b.Min.X) * featureGrid / b.Dx()
+			cell := cy*featureGrid + cx
+			r, g, bl, _ := img.At(x, y).RGBA()
+			features[cell*3] += float64(r) / 0xffff
+			features[cell*3+1] += float64(g) / 0xffff
+			features[cell*3+2] += float64(bl) / 0xffff
+			counts[cell]++
+		}
+	}
+
+	for cell, n := range counts {
+		if n == 0 {
+			continue
+		}
+		features[cell*3] /= n
+		features[cell*3+1] /= n
+		features[cell*3+2] /= n
+	}
+
+	return features
+}
+
+type kdNode struct {
+	point   []float64
+	elem    *list.Element
+	axis    int
+	left    *kdNode
+	right   *kdNode
+	deleted bool
+}
+
+type kdResult struct {
+	node *kdNode
+	dist float64
+}
+
+// kdTree indexes the feature vectors of a list of tiles so the nearest
+// candidates of a rect can be found without scanning the whole list.
+type kdTree struct {
+	root  *kdNode
+	nodes map[string][]*kdNode
+}
+
+func newKDTree(tiles *list.List) *kdTree {
+	t := &kdTree{nodes: map[string][]*kdNode{}}
+
+	nodes := make([]*kdNode, 0, tiles.Len())
+	for cur := tiles.Front(); cur != nil; cur = cur.Next() {
+		tile := cur.Value.(Tile)
+		if tile.Tiny == nil {
+			continue
+		}
+
+		features := tile.Features
+		if features == nil {
+			features = featureVector(tile.Tiny)
+		}
+
+		n := &kdNode{point: features, elem: cur}
+		nodes = append(nodes, n)
+		t.nodes[tile.Filename] = append(t.nodes[tile.Filename], n)
+	}
+
+	t.root = t.build(nodes)
+	return t
+}
+
+// build splits the nodes at the median of the axis with the largest spread
+func (t *kdTree) build(nodes []*kdNode) *kdNode {
+	if len(nodes) == 0 {
+		return nil
+	}
+
+	axis := 0
+	maxSpread := -1.0
+	for d := range nodes[0].point {
+		min, max := math.Inf(1), math.Inf(-1)
+		for _, n := range nodes {
+			min = math.Min(min, n.point[d])
+			max = math.Max(max, n.point[d])
+		}
+		if max-min > maxSpread {
+			maxSpread = max - min
+			axis = d
+		}
+	}
+
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].point[axis] < nodes[j].point[axis] })
+	mid := len(nodes) / 2
+
+	n := nodes[mid]
+	n.axis = axis
+	n.left = t.build(nodes[:mid])
+	n.right = t.build(nodes[mid+1:])
+
+	return n
+}
+
+// Remove excludes all tiles with the given filename from future searches
+func (t *kdTree) Remove(filename string) {
+	for _, n := range t.nodes[filename] {
+		n.deleted = true
+	}
+}
+
+// Nearest returns the list elements of the k tiles whose feature vectors
+// are closest to point, nearest first.
+func (t *kdTree) Nearest(point []float64, k int) []*list.Element {
+	best := make([]kdResult, 0, k+1)
+	t.search(t.root, point, k, &best)
+
+	elems := make([]*list.Element, len(best))
+	for i, r := range best {
+		elems[i] = r.node.elem
+	}
+	return elems
+}
+
+func (t *kdTree) search(n *kdNode, point []float64, k int, best *[]kdResult) {
+	if n == nil || k <= 0 {
+		return
+	}
+
+	if !n.deleted {
+		dist := sqDist(n.point, point)
+		if len(*best) < k || dist < (*best)[len(*best)-1].dist {
+			i := sort.Search(len(*best), func(i int) bool { return (*best)[i].dist > dist })
+			*best = append(*best, kdResult{})
+			copy((*best)[i+1:], (*best)[i:])
+			(*best)[i] = kdResult{node: n, dist: dist}
+			if len(*best) > k {
+				*best = (*best)[:k]
+			}
+		}
+	}
+
+	delta := point[n.axis] - n.point[n.axis]
+	near, far := n.left, n.right
+	if delta > 0 {
+		near, far = n.right, n.left
+	}
+
+	t.search(near, point, k, best)
+	if len(*best) < k || delta*delta < (*best)[len(*best)-1].dist {
+		t.search(far, point, k, best)
+	}
+}
+
+// nearestCandidates returns the tiles closest to any variant of td, so the
+// rotated and mirrored matches are among the candidates as well.
+func (g *Gosaic) nearestCandidates(tree *kdTree, td *TileData) []*list.Element {
+	k := g.candidates(td)
+	if len(td.Variants) <= 1 {
+		return tree.Nearest(td.Features, k)
+	}
+
+	seen := map[*list.Element]bool{}
+	candidates := make([]*list.Element, 0, k*len(td.Variants))
+	for _, v := range td.Variants {
+		for _, le := range tree.Nearest(v.Features, k) {
+			if !seen[le] {
+				seen[le] = true
+				candidates = append(candidates, le)
+			}
+		}
+	}
+	return candidates
+}
+
+// variantDist returns the squared distance of features to the closest
+// feature vector of the variants of td.
+func variantDist(td *TileData, features []float64) float64 {
+	if len(td.Variants) <= 1 {
+		return sqDist(td.Features, features)
+	}
+
+	min := math.Inf(1)
+	for _, v := range td.Variants {
+		min = math.Min(min, sqDist(v.Features, features))
+	}
+	return min
+}
+
+func sqDist(a, b []float64) float64 {
+	var sum float64
+	for i := range a {
+		d := a[i] - b[i]
+		sum += d * d
+	}
+	return sum
+}
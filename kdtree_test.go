@@ -0,0 +1,120 @@
+package gosaic
+
+import (
+	"container/list"
+	"fmt"
+	"image"
+	"image/color"
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+func TestFeatureVector(t *testing.T) {
+	// the quadrants of the image are red, green, blue and white, so every
+	// cell of the feature grid is one of them
+	img := image.NewRGBA(image.Rect(0, 0, 8, 8))
+	quadrants := []color.RGBA{{R: 0xff, A: 0xff}, {G: 0xff, A: 0xff}, {B: 0xff, A: 0xff}, {R: 0xff, G: 0xff, B: 0xff, A: 0xff}}
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			img.SetRGBA(x, y, quadrants[y/4*2+x/4])
+		}
+	}
+
+	features := featureVector(img)
+	if len(features) != featureGrid*featureGrid*3 {
+		t.Fatalf("%d features, want %d", len(features), featureGrid*featureGrid*3)
+	}
+	tests := []struct {
+		cx, cy  int
+		r, g, b float64
+	}{
+		{0, 0, 1, 0, 0},
+		{3, 0, 0, 1, 0},
+		{0, 3, 0, 0, 1},
+		{3, 3, 1, 1, 1},
+		{1, 2, 0, 0, 1},
+	}
+	for _, tt := range tests {
+		cell := (tt.cy*featureGrid + tt.cx) * 3
+		if got := features[cell : cell+3]; got[0] != tt.r || got[1] != tt.g || got[2] != tt.b {
+			t.Errorf("cell %d/%d is %v, want [%g %g %g]", tt.cx, tt.cy, got, tt.r, tt.g, tt.b)
+		}
+	}
+}
+
+// pointTiles returns a list of tiles named after their index with the
+// points as their feature vectors
+func pointTiles(points ...[]float64) *list.List {
+	tiles := list.New()
+	for i, p := range points {
+		tiles.PushBack(Tile{Filename: fmt.Sprint(i), Tiny: image.NewRGBA(image.Rect(0, 0, 1, 1)), Features: p})
+	}
+	return tiles
+}
+
+func filenames(elems []*list.Element) []string {
+	names := make([]string, len(elems))
+	for i, le := range elems {
+		names[i] = le.Value.(Tile).Filename
+	}
+	return names
+}
+
+func TestKDTreeNearest(t *testing.T) {
+	tiles := pointTiles([]float64{0, 0}, []float64{1, 0}, []float64{0, 1.5}, []float64{5, 5}, []float64{0.4, 0.4})
+	// tiles which failed to load aren't indexed
+	tiles.PushBack(Tile{Filename: "broken", Features: []float64{0.1, 0.1}})
+	tree := newKDTree(tiles)
+
+	tests := []struct {
+		point []float64
+		k     int
+		want  string
+	}{
+		{[]float64{0, 0}, 1, "[0]"},
+		{[]float64{0.1, 0.1}, 2, "[0 4]"},
+		{[]float64{0.9, 0.1}, 3, "[1 4 0]"},
+		{[]float64{4, 4}, 1, "[3]"},
+		{[]float64{0, 0}, 10, "[0 4 1 2 3]"},
+		{[]float64{0, 0}, 0, "[]"},
+	}
+	for _, tt := range tests {
+		if got := fmt.Sprint(filenames(tree.Nearest(tt.point, tt.k))); got != tt.want {
+			t.Errorf("Nearest(%v, %d) = %s, want %s", tt.point, tt.k, got, tt.want)
+		}
+	}
+
+	tree.Remove("4")
+	if got := fmt.Sprint(filenames(tree.Nearest([]float64{0.4, 0.4}, 2))); got != "[0 1]" {
+		t.Errorf("Nearest after Remove = %s, want the removed tile skipped", got)
+	}
+}
+
+// the pruned search finds the same tiles as a scan of all of them
+func TestKDTreeNearestScan(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	points := make([][]float64, 300)
+	for i := range points {
+		points[i] = make([]float64, featureGrid*featureGrid*3)
+		for d := range points[i] {
+			points[i][d] = rnd.Float64()
+		}
+	}
+	tiles := pointTiles(points...)
+	tree := newKDTree(tiles)
+
+	for q := 0; q < 20; q++ {
+		point := points[rnd.Intn(len(points))]
+		want := make([]int, len(points))
+		for i := range want {
+			want[i] = i
+		}
+		sort.Slice(want, func(i, j int) bool { return sqDist(points[want[i]], point) < sqDist(points[want[j]], point) })
+
+		got := filenames(tree.Nearest(point, 8))
+		if fmt.Sprint(got) != fmt.Sprint(want[:8]) {
+			t.Fatalf("Nearest = %v, a scan finds %v", got, want[:8])
+		}
+	}
+}
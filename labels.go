@@ -0,0 +1,130 @@
+package gosaic
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrUnknownLabel is returned for labels without tiles in the cache
+var ErrUnknownLabel = errors.New("no tiles have this label")
+
+// LabelIndex returns the sizes of the tiles of label in c with their
+// counts, or all labels if label is empty.
+func LabelIndex(c TileCache, label string) ([]CacheIndex, error) {
+	index, err := c.Index()
+	if err != nil {
+		return nil, err
+	}
+	if label == "" {
+		return index, nil
+	}
+
+	sizes := []CacheIndex{}
+	for _, ci := range index {
+		if ci.Label == label {
+			sizes = append(sizes, ci)
+		}
+	}
+	if len(sizes) == 0 {
+		return nil, ErrUnknownLabel
+	}
+	return sizes, nil
+}
+
+// forLabelBatches calls fn with the names of the tiles of label in c in
+// batches, size by size
+func forLabelBatches(c TileCache, label string, fn func(size int, names []string) error) error {
+	sizes, err := LabelIndex(c, label)
+	if err != nil {
+		return err
+	}
+	for _, ci := range sizes {
+		names, err := c.Names(label, ci.Size)
+		if err != nil {
+			return err
+		}
+		for i := 0; i < len(names); i += redisBatchSize {
+			end := i + redisBatchSize
+			if end > len(names) {
+				end = len(names)
+			}
+			if err := fn(ci.Size, names[i:end]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// DeleteLabel removes the tiles of label from c in batches and returns the
+// number of removed tiles
+func DeleteLabel(c TileCache, label string) (int, error) {
+	n := 0
+	err := forLabelBatches(c, label, func(size int, names []string) error {
+		if err := c.Remove(label, size, names); err != nil {
+			return err
+		}
+		n += len(names)
+		return nil
+	})
+	return n, err
+}
+
+// RenameLabel moves the tiles of label from to the label to in batches
+// and returns the number of moved tiles. The new label must not be in use.
+func RenameLabel(c TileCache, from, to string) (int, error) {
+	if to == "" {
+		return 0, errors.New("the new label is empty")
+	}
+	if _, err := LabelIndex(c, to); err != ErrUnknownLabel {
+		if err != nil {
+			return 0, err
+		}
+		return 0, fmt.Errorf("label %s is already in use", to)
+	}
+
+	n := 0
+	err := forLabelBatches(c, from, func(size int, names []string) error {
+		tiles, err := c.Get(from, size, names)
+		if err != nil {
+			return err
+		}
+		for _, tile := range tiles {
+			if tile == nil {
+				continue
+			}
+			tile.Label = to
+			if err := c.Set(tile); err != nil {
+				return err
+			}
+			n++
+		}
+		return c.Remove(from, size, names)
+	})
+	return n, err
+}
+
+// LabelHashes adds the difference hashes of the tiles of label in size in
+// c to index
+func LabelHashes(c TileCache, label string, size int, index *HashIndex) error {
+	names, err := c.Names(label, size)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < len(names); i += redisBatchSize {
+		end := i + redisBatchSize
+		if end > len(names) {
+			end = len(names)
+		}
+		tiles, err := c.Get(label, size, names[i:end])
+		if err != nil {
+			return err
+		}
+		for _, tile := range tiles {
+			if tile != nil {
+				index.Add(tile.PHash, tile.Name)
+			}
+		}
+	}
+	return nil
+}
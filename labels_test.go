@@ -0,0 +1,100 @@
+package gosaic
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestLabels(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "tiles.db")
+	c, err := OpenFileCache(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tile := range []*CachedTile{cachedTile("beach", 20, "a.jpg"), cachedTile("beach", 100, "a.jpg"), cachedTile("beach", 20, "b.jpg"), cachedTile("city", 20, "c.jpg")} {
+		if err := c.Set(tile); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := LabelIndex(c, "forest"); err != ErrUnknownLabel {
+		t.Errorf("LabelIndex() of an unknown label = %v, want %v", err, ErrUnknownLabel)
+	}
+	if _, err := RenameLabel(c, "beach", "city"); err == nil {
+		t.Error("RenameLabel() to a label in use didn't fail")
+	}
+
+	n, err := RenameLabel(c, "beach", "coast")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 3 {
+		t.Errorf("RenameLabel() renamed %d tiles, want 3", n)
+	}
+	tiles, err := c.Get("coast", 20, []string{"b.jpg"})
+	if err != nil || tiles[0] == nil || tiles[0].Label != "coast" || string(tiles[0].Data) != "b.jpg" {
+		t.Errorf("renamed tile = %+v, %v", tiles[0], err)
+	}
+
+	n, err = DeleteLabel(c, "city")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("DeleteLabel() deleted %d tiles, want 1", n)
+	}
+	c.Close()
+
+	// the removals survive reopening the cache
+	c, err = OpenFileCache(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	index, err := LabelIndex(c, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []CacheIndex{{"coast", 20, 2}, {"coast", 100, 1}}; !reflect.DeepEqual(index, want) {
+		t.Errorf("LabelIndex() = %v, want %v", index, want)
+	}
+}
+
+func TestLabelHashes(t *testing.T) {
+	c, err := OpenFileCache(filepath.Join(t.TempDir(), "tiles.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	a, b := cachedTile("beach", 20, "a.jpg"), cachedTile("beach", 20, "b.jpg")
+	a.PHash, b.PHash = 0x00ff, 0xff00
+	for _, tile := range []*CachedTile{a, b, cachedTile("city", 20, "c.jpg")} {
+		if err := c.Set(tile); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	index := NewHashIndex(2)
+	if err := LabelHashes(c, "beach", 20, index); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		hash uint64
+		name string
+		want string
+	}{
+		{0x00ff, "new.jpg", "a.jpg"},
+		{0x00fc, "new.jpg", "a.jpg"},
+		{0xff01, "new.jpg", "b.jpg"},
+		{0x00f0, "new.jpg", ""},
+		{0xf0f0, "new.jpg", ""},
+		{0x00ff, "a.jpg", ""},
+	}
+	for _, tt := range tests {
+		if got, _ := index.Duplicate(tt.hash, tt.name); got != tt.want {
+			t.Errorf("Duplicate(%#x, %s) = %q, want %q", tt.hash, tt.name, got, tt.want)
+		}
+	}
+}
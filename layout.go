@@ -0,0 +1,408 @@
+package gosaic
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+
+	"github.com/davidbyttow/govips/v2/vips"
+	xdraw "golang.org/x/image/draw"
+)
+
+const (
+	LayoutGrid    = "grid"
+	LayoutHex     = "hex"
+	LayoutBrick   = "brick"
+	LayoutVoronoi = "voronoi"
+	LayoutCollage = "collage"
+)
+
+const DefaultGroutColor = "#808080"
+
+// cell is a region of the seed image that is covered by a single tile.
+// X and Y are its coordinates on the grid of the smallest cell size. If
+// Mask is set, only its opaque pixels belong to the cell. Tiles of cells
+// with an Angle are drawn rotated by that many degrees.
+type cell struct {
+	X     int
+	Y     int
+	Rect  image.Rectangle
+	Mask  image.Image
+	Angle float64
+}
+
+// cells splits the seed image into the cells of the mosaic. Cells covering
+// a face are split into smaller ones.
+func (g *Gosaic) cells() []cell {
+	return g.splitFaces(g.layoutCells())
+}
+
+// layoutCells returns the cells of the configured layout
+func (g *Gosaic) layoutCells() []cell {
+	switch g.config.Layout {
+	case LayoutHex:
+		return g.hexCells()
+	case LayoutBrick:
+		return g.brickCells()
+	case LayoutVoronoi:
+		return g.voronoiCells()
+	case LayoutCollage:
+		return g.collageCells()
+	}
+
+	if g.config.Cols > 0 || g.config.Rows > 0 {
+		return g.fixedGridCells()
+	}
+
+	if g.config.MergeThreshold > 0 && g.config.Quadtree == 0 {
+		return g.mergedCells()
+	}
+
+	size := g.SeedImage.Bounds().Size()
+	ts := g.config.TileSize
+
+	cells := make([]cell, 0)
+	for x := 0; x < size.X/ts+1; x++ {
+		for y := 0; y < size.Y/ts+1; y++ {
+			r := image.Rect(x*ts, y*ts, (x+1)*ts, (y+1)*ts)
+			if g.config.Quadtree > 0 {
+				cells = append(cells, g.subdivide(r, g.config.Quadtree)...)
+				continue
+			}
+			cells = append(cells, cell{X: x, Y: y, Rect: r})
+		}
+	}
+
+	return cells
+}
+
+// gridDimensions returns the number of columns and rows of the grid, which
+// follow the aspect ratio of the seed if only one of them is configured.
+func (g *Gosaic) gridDimensions() (int, int) {
+	size := g.SeedImage.Bounds().Size()
+	cols, rows := g.config.Cols, g.config.Rows
+	if cols <= 0 {
+		cols = int(math.Round(float64(rows) * float64(size.X) / float64(size.Y)))
+	}
+	if rows <= 0 {
+		rows = int(math.Round(float64(cols) * float64(size.Y) / float64(size.X)))
+	}
+	if cols < 1 {
+		cols = 1
+	}
+	if rows < 1 {
+		rows = 1
+	}
+	return cols, rows
+}
+
+// fixedGridCells splits the seed image into the configured number of
+// columns and rows instead of cells of the tile size. The remainder pixels
+// are spread over the cells, so their sizes differ by at most one pixel.
+func (g *Gosaic) fixedGridCells() []cell {
+	size := g.SeedImage.Bounds().Size()
+	cols, rows := g.gridDimensions()
+
+	cells := make([]cell, 0, cols*rows)
+	for x := 0; x < cols; x++ {
+		for y := 0; y < rows; y++ {
+			r := image.Rect(x*size.X/cols, y*size.Y/rows, (x+1)*size.X/cols, (y+1)*size.Y/rows)
+			cells = append(cells, cell{X: x, Y: y, Rect: r})
+		}
+	}
+
+	return cells
+}
+
+// MinHexTileSize is the smallest tile size of the hex layout, smaller
+// hexagons would have no height left between the rows.
+const MinHexTileSize = 4
+
+// hexCells covers the seed image with regular pointy-top hexagons as tall
+// as the tile size and sqrt(3)/2 times as wide. Every other row is offset
+// by half a hexagon.
+func (g *Gosaic) hexCells() []cell {
+	size := g.SeedImage.Bounds().Size()
+	ts := g.config.TileSize
+	if ts < MinHexTileSize {
+		ts = MinHexTileSize
+	}
+	// an even width lets the offset rows meet the others edge to edge
+	w := 2 * int(math.Round(float64(ts)*math.Sqrt(3)/4))
+	rowStep := ts * 3 / 4
+	mask := polygonMask(image.Rect(0, 0, w, ts), []image.Point{
+		{w / 2, 0}, {w, ts / 4}, {w, ts * 3 / 4}, {w / 2, ts}, {0, ts * 3 / 4}, {0, ts / 4},
+	})
+
+	cells := make([]cell, 0)
+	for row := 0; row*rowStep-ts/4 < size.Y; row++ {
+		y := row*rowStep - ts/4
+		offset := 0
+		if row%2 == 1 {
+			offset = -w / 2
+		}
+		for col := 0; col*w+offset < size.X; col++ {
+			x := col*w + offset
+			cells = append(cells, cell{X: col, Y: row, Rect: image.Rect(x, y, x+w, y+ts), Mask: mask})
+		}
+	}
+
+	return cells
+}
+
+// brickCells lays out the tiles in rows like bricks in a wall, every other
+// row is offset by half a tile.
+func (g *Gosaic) brickCells() []cell {
+	size := g.SeedImage.Bounds().Size()
+	ts := g.config.TileSize
+
+	cells := make([]cell, 0)
+	for row := 0; row*ts < size.Y; row++ {
+		offset := 0
+		if row%2 == 1 {
+			offset = -ts / 2
+		}
+		for col := 0; col*ts+offset < size.X; col++ {
+			x := col*ts + offset
+			cells = append(cells, cell{X: col, Y: row, Rect: image.Rect(x, row*ts, x+ts, (row+1)*ts)})
+		}
+	}
+
+	return cells
+}
+
+// clipMask returns the mask of c restricted to the part of the cell which
+// lies within bounds, or nil if the whole cell is to be covered.
+func clipMask(c cell, bounds image.Rectangle) image.Image {
+	if c.Rect.In(bounds) {
+		return c.Mask
+	}
+
+	mask := image.NewAlpha(image.Rect(0, 0, c.Rect.Dx(), c.Rect.Dy()))
+	visible := bounds.Intersect(c.Rect).Sub(c.Rect.Min)
+	if c.Mask == nil {
+		draw.Draw(mask, visible, image.Opaque, image.ZP, draw.Src)
+	} else {
+		draw.Draw(mask, visible, c.Mask, visible.Min.Add(c.Mask.Bounds().Min), draw.Src)
+	}
+
+	return mask
+}
+
+// coverageMask restricts mask to the opaque pixels of the seed within r
+// that are not excluded by the keep-out mask. It returns the new mask and
+// the fraction of the masked cell that is covered.
+func (g *Gosaic) coverageMask(mask image.Image, r image.Rectangle) (image.Image, float64) {
+	seed := g.SeedImage
+	w, h := r.Dx(), r.Dy()
+	opaque := image.NewAlpha(image.Rect(0, 0, w, h))
+	transparent := false
+	covered, total := 0, 0
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			if mask != nil {
+				mb := mask.Bounds()
+				_, _, _, a := mask.At(mb.Min.X+x, mb.Min.Y+y).RGBA()
+				if a < 0x8000 {
+					continue
+				}
+			}
+			total++
+
+			p := image.Pt(r.Min.X+x, r.Min.Y+y)
+			if !p.In(seed.Bounds()) || seed.RGBAAt(p.X, p.Y).A < 0x80 || g.keptOut(p.X, p.Y) {
+				transparent = true
+				continue
+			}
+			covered++
+			opaque.SetAlpha(x, y, color.Alpha{A: 0xff})
+		}
+	}
+
+	if total == 0 {
+		return opaque, 0
+	}
+	if !transparent {
+		return mask, 1
+	}
+	return opaque, float64(covered) / float64(total)
+}
+
+// loadMask loads a grayscale mask, like the keep-out mask or the weight
+// map, and scales it to bounds.
+func loadMask(filename string, bounds image.Rectangle) (*image.Gray, error) {
+	imgRef, err := vips.NewImageFromFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer imgRef.Close()
+
+	img, err := imgRef.ToImage(vips.NewDefaultPNGExportParams())
+	if err != nil {
+		return nil, err
+	}
+
+	mask := image.NewGray(bounds)
+	draw.Draw(mask, bounds, scaleImage(img, bounds.Dx(), bounds.Dy()), image.ZP, draw.Src)
+	return mask, nil
+}
+
+// keptOut reports whether the keep-out mask excludes x/y from tiling
+func (g *Gosaic) keptOut(x, y int) bool {
+	return g.keepOut != nil && g.keepOut.GrayAt(x, y).Y < 0x80
+}
+
+// erodeMask shrinks the opaque area of mask by r pixels on every side
+func erodeMask(mask image.Image, r int) *image.Alpha {
+	b := mask.Bounds()
+	w, h := b.Dx(), b.Dy()
+
+	opaque := make([]bool, w*h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			_, _, _, a := mask.At(b.Min.X+x, b.Min.Y+y).RGBA()
+			opaque[y*w+x] = a >= 0x8000
+		}
+	}
+
+	// a pixel stays opaque if all pixels within r in a row are opaque,
+	// then the same for the columns
+	horizontal := make([]bool, w*h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			ok := x-r >= 0 && x+r < w
+			for i := x - r; ok && i <= x+r; i++ {
+				ok = opaque[y*w+i]
+			}
+			horizontal[y*w+x] = ok
+		}
+	}
+
+	eroded := image.NewAlpha(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			ok := y-r >= 0 && y+r < h
+			for i := y - r; ok && i <= y+r; i++ {
+				ok = horizontal[i*w+x]
+			}
+			if ok {
+				eroded.SetAlpha(x, y, color.Alpha{A: 0xff})
+			}
+		}
+	}
+
+	return eroded
+}
+
+// polygonMask returns an alpha mask of the given bounds which is opaque
+// inside the polygon.
+func polygonMask(bounds image.Rectangle, polygon []image.Point) *image.Alpha {
+	mask := image.NewAlpha(bounds)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			if insidePolygon(float64(x)+0.5, float64(y)+0.5, polygon) {
+				mask.SetAlpha(x, y, color.Alpha{A: 0xff})
+			}
+		}
+	}
+	return mask
+}
+
+// insidePolygon tests x/y against the polygon with the even-odd rule
+func insidePolygon(x, y float64, polygon []image.Point) bool {
+	inside := false
+	j := len(polygon) - 1
+	for i := range polygon {
+		xi, yi := float64(polygon[i].X), float64(polygon[i].Y)
+		xj, yj := float64(polygon[j].X), float64(polygon[j].Y)
+		if (yi > y) != (yj > y) && x < (xj-xi)*(y-yi)/(yj-yi)+xi {
+			inside = !inside
+		}
+		j = i
+	}
+	return inside
+}
+
+// maskedImage makes all pixels of Image transparent black where mask is
+// transparent, so comparisons only consider the masked region.
+type maskedImage struct {
+	image.Image
+	mask image.Image
+}
+
+func (m maskedImage) At(x, y int) color.Color {
+	b := m.Image.Bounds()
+	mb := m.mask.Bounds()
+	_, _, _, a := m.mask.At(x-b.Min.X+mb.Min.X, y-b.Min.Y+mb.Min.Y).RGBA()
+	if a < 0x8000 {
+		return color.RGBA{}
+	}
+	return m.Image.At(x, y)
+}
+
+// gridUnit returns the size of the smallest possible cell
+func (g *Gosaic) gridUnit() int {
+	unit := g.config.TileSize >> uint(g.config.Quadtree+g.faceSplit())
+	if unit < 1 {
+		unit = 1
+	}
+	return unit
+}
+
+// subdivide splits r into quadrants as long as its detail exceeds the
+// quadtree threshold and levels are left.
+func (g *Gosaic) subdivide(r image.Rectangle, levels int) []cell {
+	half := r.Dx() / 2
+	if levels <= 0 || half < 1 || g.variance(r) < g.config.QuadtreeThreshold {
+		unit := g.gridUnit()
+		return []cell{{X: r.Min.X / unit, Y: r.Min.Y / unit, Rect: r}}
+	}
+
+	mid := r.Min.Add(image.Pt(half, half))
+	quadrants := []image.Rectangle{
+		image.Rect(r.Min.X, r.Min.Y, mid.X, mid.Y),
+		image.Rect(mid.X, r.Min.Y, r.Max.X, mid.Y),
+		image.Rect(r.Min.X, mid.Y, mid.X, r.Max.Y),
+		image.Rect(mid.X, mid.Y, r.Max.X, r.Max.Y),
+	}
+
+	cells := make([]cell, 0, 4)
+	for _, q := range quadrants {
+		if !q.Overlaps(g.SeedImage.Bounds()) {
+			continue
+		}
+		cells = append(cells, g.subdivide(q, levels-1)...)
+	}
+	return cells
+}
+
+// variance returns the standard deviation of the luminance (0..1) of the
+// seed image within r.
+func (g *Gosaic) variance(r image.Rectangle) float64 {
+	r = r.Intersect(g.SeedImage.Bounds())
+	n := float64(r.Dx() * r.Dy())
+	if n == 0 {
+		return 0
+	}
+
+	var sum, sumSq float64
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			l := float64(color.GrayModel.Convert(g.SeedImage.RGBAAt(x, y)).(color.Gray).Y) / 255
+			sum += l
+			sumSq += l * l
+		}
+	}
+
+	mean := sum / n
+	return math.Sqrt(math.Max(sumSq/n-mean*mean, 0))
+}
+
+// scaleImage resizes img to w x h pixels
+func scaleImage(img image.Image, w, h int) *image.RGBA {
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	xdraw.CatmullRom.Scale(dst, dst.Bounds(), img, img.Bounds(), xdraw.Src, nil)
+	return dst
+}
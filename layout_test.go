@@ -0,0 +1,158 @@
+package gosaic
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestInsidePolygon(t *testing.T) {
+	triangle := []image.Point{{0, 0}, {10, 0}, {0, 10}}
+	tests := []struct {
+		x, y float64
+		want bool
+	}{
+		{1, 1, true},
+		{4.5, 4.5, true},
+		{5.5, 5.5, false},
+		{-1, 1, false},
+		{1, 11, false},
+	}
+	for _, tt := range tests {
+		if got := insidePolygon(tt.x, tt.y, triangle); got != tt.want {
+			t.Errorf("insidePolygon(%g, %g) = %v, want %v", tt.x, tt.y, got, tt.want)
+		}
+	}
+}
+
+// hexCoverage counts how many hexagons cover each pixel of a w x h seed
+func hexCoverage(t *testing.T, w, h, tilesize int) ([]cell, []int) {
+	t.Helper()
+	g := &Gosaic{SeedImage: image.NewRGBA(image.Rect(0, 0, w, h)), config: Config{TileSize: tilesize, Layout: LayoutHex}}
+	cells := g.cells()
+	counts := make([]int, w*h)
+	for _, c := range cells {
+		mask := c.Mask.(*image.Alpha)
+		for y := c.Rect.Min.Y; y < c.Rect.Max.Y; y++ {
+			for x := c.Rect.Min.X; x < c.Rect.Max.X; x++ {
+				if x < 0 || y < 0 || x >= w || y >= h {
+					continue
+				}
+				if mask.AlphaAt(x-c.Rect.Min.X, y-c.Rect.Min.Y).A != 0 {
+					counts[y*w+x]++
+				}
+			}
+		}
+	}
+	return cells, counts
+}
+
+// the hexagons tile the seed image without gaps or overlaps
+func TestHexCells(t *testing.T) {
+	for _, ts := range []int{8, 20, 32} {
+		cells, counts := hexCoverage(t, 100, 70, ts)
+		for i, n := range counts {
+			if n != 1 {
+				t.Fatalf("tile size %d: pixel %d/%d is covered by %d hexagons", ts, i%100, i/100, n)
+			}
+		}
+
+		// regular hexagons are sqrt(3)/2 times as wide as they are tall,
+		// and every other row is offset by half a hexagon
+		for _, c := range cells {
+			w := c.Rect.Dx()
+			if c.Rect.Dy() != ts || math.Abs(float64(w)-float64(ts)*math.Sqrt(3)/2) > 1 {
+				t.Fatalf("tile size %d: hexagon is %dx%d", ts, w, c.Rect.Dy())
+			}
+			if c.X == 0 && c.Y%2 == 1 && c.Rect.Min.X != -w/2 {
+				t.Errorf("tile size %d: row %d starts at %d, want %d", ts, c.Y, c.Rect.Min.X, -w/2)
+			}
+		}
+	}
+}
+
+// alphaRows draws the opaque pixels of mask as # and the transparent ones
+// as .
+func alphaRows(mask *image.Alpha) []string {
+	rows := []string{}
+	for y := mask.Rect.Min.Y; y < mask.Rect.Max.Y; y++ {
+		row := ""
+		for x := mask.Rect.Min.X; x < mask.Rect.Max.X; x++ {
+			if mask.AlphaAt(x, y).A >= 0x80 {
+				row += "#"
+			} else {
+				row += "."
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+func TestErodeMask(t *testing.T) {
+	// an L shape in a mask which doesn't start at the origin
+	mask := image.NewAlpha(image.Rect(3, 4, 10, 10))
+	for y := 4; y < 10; y++ {
+		for x := 3; x < 10; x++ {
+			if x < 7 || y >= 7 {
+				mask.SetAlpha(x, y, color.Alpha{A: 0xff})
+			}
+		}
+	}
+
+	tests := []struct {
+		r    int
+		want []string
+	}{
+		{0, []string{
+			"####...",
+			"####...",
+			"####...",
+			"#######",
+			"#######",
+			"#######",
+		}},
+		{1, []string{
+			".......",
+			".##....",
+			".##....",
+			".##....",
+			".#####.",
+			".......",
+		}},
+		{3, []string{
+			".......",
+			".......",
+			".......",
+			".......",
+			".......",
+			".......",
+		}},
+	}
+	for _, tt := range tests {
+		got := alphaRows(erodeMask(mask, tt.r))
+		if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+			t.Errorf("erodeMask(%d) =\n%s\nwant\n%s", tt.r, strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+		}
+	}
+}
+
+// fixed columns and rows give non-square cells, which the quadtree can't
+// split
+func TestCheckConfigGrid(t *testing.T) {
+	for _, tt := range []struct {
+		config Config
+		valid  bool
+	}{
+		{Config{TileSize: 20, Quadtree: 2}, true},
+		{Config{TileSize: 20, Cols: 10, Rows: 4}, true},
+		{Config{TileSize: 20, Cols: 10, Quadtree: 2}, false},
+		{Config{TileSize: 20, Rows: 4, Quadtree: 1}, false},
+	} {
+		if err := checkConfig(tt.config); (err == nil) != tt.valid {
+			t.Errorf("checkConfig(%+v) = %v, want valid %t", tt.config, err, tt.valid)
+		}
+	}
+}
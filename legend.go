@@ -0,0 +1,150 @@
+package gosaic
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+// Layout of the legend
+const (
+	legendColumns   = 4
+	legendThumbSize = 64
+	legendCardWidth = 360
+	legendPadding   = 8
+	legendLineWidth = (legendCardWidth - legendThumbSize - 3*legendPadding) / 7
+)
+
+// legendEntry is a tile of the legend with the cells it was placed at
+type legendEntry struct {
+	tile  string
+	cells []string
+}
+
+// cellLabel names the cell at column x and row y like a spreadsheet, the
+// columns with letters and the rows with numbers starting at 1.
+func cellLabel(x, y int) string {
+	col := ""
+	for x++; x > 0; x = (x - 1) / 26 {
+		col = string(rune('A'+(x-1)%26)) + col
+	}
+	return col + strconv.Itoa(y+1)
+}
+
+// legendEntries groups the cells of m by their tile, sorted by the name of
+// the tile file.
+func legendEntries(m Manifest) []legendEntry {
+	index := map[string]int{}
+	entries := []legendEntry{}
+	for _, c := range m.Cells {
+		if c.Tile == "" {
+			continue
+		}
+		i, ok := index[c.Tile]
+		if !ok {
+			i = len(entries)
+			index[c.Tile] = i
+			entries = append(entries, legendEntry{tile: c.Tile})
+		}
+		entries[i].cells = append(entries[i].cells, cellLabel(c.X, c.Y))
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		return filepath.Base(entries[i].tile) < filepath.Base(entries[j].tile)
+	})
+	return entries
+}
+
+// wrapText breaks the words into lines of at most width characters
+func wrapText(words []string, width int) []string {
+	lines := []string{}
+	line := ""
+	for _, w := range words {
+		if line != "" && len(line)+1+len(w) > width {
+			lines = append(lines, line)
+			line = ""
+		}
+		if line != "" {
+			line += " "
+		}
+		line += w
+	}
+	if line != "" {
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// saveLegend saves a contact sheet of every tile used in the mosaic of m
+// with the cells it was placed at, so the photos can be found in the
+// mosaic. It is saved as a PDF if filename ends with .pdf.
+func (g *Gosaic) saveLegend(m Manifest, filename string) error {
+	face := basicfont.Face7x13
+	lineHeight := face.Metrics().Height.Ceil()
+
+	// lay out the cards in columns, each as high as its text
+	entries := legendEntries(m)
+	texts := make([][]string, len(entries))
+	heights := make([]int, len(entries))
+	for i, e := range entries {
+		name := filepath.Base(e.tile)
+		if len(name) > legendLineWidth {
+			name = name[:legendLineWidth-3] + "..."
+		}
+		texts[i] = append([]string{name}, wrapText(e.cells, legendLineWidth)...)
+		heights[i] = legendThumbSize
+		if h := len(texts[i]) * lineHeight; h > heights[i] {
+			heights[i] = h
+		}
+	}
+
+	tops := make([]int, len(entries))
+	height := 0
+	for row := 0; row < len(entries); row += legendColumns {
+		rowHeight := 0
+		for i := row; i < row+legendColumns && i < len(entries); i++ {
+			tops[i] = height + legendPadding
+			if heights[i] > rowHeight {
+				rowHeight = heights[i]
+			}
+		}
+		height += rowHeight + legendPadding
+	}
+
+	sheet := image.NewRGBA(image.Rect(0, 0, legendColumns*legendCardWidth, height+legendPadding))
+	draw.Draw(sheet, sheet.Bounds(), &image.Uniform{color.White}, image.ZP, draw.Src)
+	drawer := &font.Drawer{Dst: sheet, Src: image.Black, Face: face}
+
+	for i, e := range entries {
+		left := (i%legendColumns)*legendCardWidth + legendPadding
+
+		tile, err := g.loadTile(e.tile, legendThumbSize, legendThumbSize)
+		if err != nil {
+			log.Errorf("legend: %s", err)
+		} else {
+			thumb := scaleImage(cropToAspect(tile.Tiny, 1, 1), legendThumbSize, legendThumbSize)
+			draw.Draw(sheet, image.Rect(left, tops[i], left+legendThumbSize, tops[i]+legendThumbSize), thumb, image.ZP, draw.Src)
+		}
+
+		for l, line := range texts[i] {
+			drawer.Dot = fixed.P(left+legendThumbSize+legendPadding, tops[i]+(l+1)*lineHeight-face.Descent)
+			drawer.DrawString(line)
+		}
+	}
+
+	if strings.ToLower(filepath.Ext(filename)) == ".pdf" {
+		b := sheet.Bounds()
+		return savePDF(sheet, filename, fmt.Sprintf("%dx%dpt", b.Dx(), b.Dy()), "", int(pointsPerInch), g.quality())
+	}
+	return g.saveImage(sheet, filename)
+}
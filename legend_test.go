@@ -0,0 +1,50 @@
+package gosaic
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCellLabel(t *testing.T) {
+	tests := []struct {
+		x, y int
+		want string
+	}{
+		{0, 0, "A1"},
+		{25, 9, "Z10"},
+		{26, 0, "AA1"},
+		{27, 1, "AB2"},
+		{701, 0, "ZZ1"},
+		{702, 0, "AAA1"},
+	}
+
+	for _, test := range tests {
+		if got := cellLabel(test.x, test.y); got != test.want {
+			t.Errorf("cellLabel(%d, %d) = %q, want %q", test.x, test.y, got, test.want)
+		}
+	}
+}
+
+func TestLegendEntries(t *testing.T) {
+	m := Manifest{Cells: []ManifestCell{
+		{X: 0, Y: 0, Tile: "b/zebra.jpg"},
+		{X: 1, Y: 0, Tile: "a/apple.jpg"},
+		{X: 2, Y: 0},
+		{X: 0, Y: 1, Tile: "b/zebra.jpg"},
+	}}
+	want := []legendEntry{
+		{tile: "a/apple.jpg", cells: []string{"B1"}},
+		{tile: "b/zebra.jpg", cells: []string{"A1", "A2"}},
+	}
+	if got := legendEntries(m); !reflect.DeepEqual(got, want) {
+		t.Errorf("legendEntries() = %v, want %v", got, want)
+	}
+}
+
+func TestWrapText(t *testing.T) {
+	got := wrapText([]string{"A1", "B2", "C3", "D4"}, 5)
+	want := []string{"A1 B2", "C3 D4"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("wrapText() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,101 @@
+package gosaic
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/davidbyttow/govips/v2/vips"
+)
+
+// imageExtensions are the extensions of the image files read as tiles,
+// besides the RAW camera files
+var imageExtensions = map[string]bool{
+	".jpg":  true,
+	".jpeg": true,
+	".png":  true,
+	".gif":  true,
+	".webp": true,
+	".tif":  true,
+	".tiff": true,
+	".bmp":  true,
+	".heic": true,
+	".heif": true,
+	".avif": true,
+}
+
+// IsImageFile tells if name is an image file gosaic can read by its
+// extension, so other files matching a pattern are skipped.
+func IsImageFile(name string) bool {
+	return imageExtensions[strings.ToLower(filepath.Ext(name))] || IsRAW(name)
+}
+
+// LoadImage loads the image file filename, decoding RAW camera files with
+// RAWDecoder.
+func LoadImage(filename string) (*vips.ImageRef, error) {
+	if IsRAW(filename) {
+		data, err := decodeRAW(filename)
+		if err != nil {
+			return nil, err
+		}
+		return decodeImage(data)
+	}
+
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return decodeImage(data)
+}
+
+// LoadImageFromBuffer loads the encoded image data, which was read from
+// name. RAW camera files are written to a temporary file for RAWDecoder.
+func LoadImageFromBuffer(data []byte, name string) (*vips.ImageRef, error) {
+	if !IsRAW(name) {
+		return decodeImage(data)
+	}
+
+	tmpfile, err := ioutil.TempFile("", "raw.*"+filepath.Ext(name))
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmpfile.Name())
+
+	if _, err := tmpfile.Write(data); err != nil {
+		tmpfile.Close()
+		return nil, err
+	}
+	if err := tmpfile.Close(); err != nil {
+		return nil, err
+	}
+	return LoadImage(tmpfile.Name())
+}
+
+// AutoOrient rotates and flips img upright after its EXIF orientation, so
+// photos taken in portrait aren't placed sideways.
+func AutoOrient(img *vips.ImageRef) error {
+	if img.GetOrientation() <= 1 {
+		return nil
+	}
+	return img.AutoRotate()
+}
+
+// decodeImage decodes data with libvips. HEIC and AVIF images need libvips
+// to be built with libheif, which is reported instead of an unsupported
+// format.
+func decodeImage(data []byte) (*vips.ImageRef, error) {
+	if len(data) < 12 {
+		return nil, errors.New("not an image")
+	}
+
+	switch t := vips.DetermineImageType(data); t {
+	case vips.ImageTypeHEIF, vips.ImageTypeAVIF:
+		if !vips.IsTypeSupported(t) {
+			return nil, errors.New("reading HEIC and AVIF images needs libvips built with libheif")
+		}
+	}
+
+	return vips.NewImageFromBuffer(data)
+}
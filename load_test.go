@@ -0,0 +1,26 @@
+package gosaic
+
+import "testing"
+
+func TestIsImageFile(t *testing.T) {
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"a.jpg", true},
+		{"a.JPEG", true},
+		{"IMG_0001.HEIC", true},
+		{"a.heif", true},
+		{"a.avif", true},
+		{"a.nef", true},
+		{"a.txt", false},
+		{".DS_Store", false},
+		{"Thumbs.db", false},
+	}
+
+	for _, test := range tests {
+		if got := IsImageFile(test.name); got != test.want {
+			t.Errorf("IsImageFile(%q) = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
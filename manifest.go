@@ -0,0 +1,101 @@
+package gosaic
+
+import (
+	"bytes"
+	"encoding/json"
+	"image/png"
+	"os"
+)
+
+// Manifest records the placement of every cell of a mosaic, so the mosaic
+// can be inspected by other tools or rendered again without matching.
+type Manifest struct {
+	Width      int            `json:"width"`
+	Height     int            `json:"height"`
+	RandomSeed int64          `json:"random_seed"`
+	Cells      []ManifestCell `json:"cells"`
+}
+
+// ManifestCell is a cell of the mosaic in pixels of the output. Tile is the
+// filename or cache key of the tile drawn into the cell, it is empty for
+// cells no tile has been matched to. Distance is the distance of the tile
+// to the cell (0..1). Mask is a PNG of the cell's alpha mask for cells
+// which aren't rectangles.
+type ManifestCell struct {
+	X         int        `json:"x"`
+	Y         int        `json:"y"`
+	Left      int        `json:"left"`
+	Top       int        `json:"top"`
+	Width     int        `json:"width"`
+	Height    int        `json:"height"`
+	Angle     float64    `json:"angle,omitempty"`
+	Tile      string     `json:"tile"`
+	Rotate    int        `json:"rotate,omitempty"`
+	Flip      bool       `json:"flip,omitempty"`
+	Distance  float64    `json:"distance"`
+	MeanColor [3]float64 `json:"mean_color"`
+	Mask      []byte     `json:"mask,omitempty"`
+}
+
+// manifest collects the placements of the rects
+func (g *Gosaic) manifest(rects []*TileData) (Manifest, error) {
+	b := g.SeedImage.Bounds()
+	m := Manifest{Width: b.Dx(), Height: b.Dy(), RandomSeed: g.seed, Cells: make([]ManifestCell, 0, len(rects))}
+	for _, td := range rects {
+		c := ManifestCell{
+			X:         td.X,
+			Y:         td.Y,
+			Left:      td.Cell.Min.X - b.Min.X,
+			Top:       td.Cell.Min.Y - b.Min.Y,
+			Width:     td.Cell.Dx(),
+			Height:    td.Cell.Dy(),
+			Angle:     td.Angle,
+			Tile:      td.MinTile.Filename,
+			MeanColor: td.MeanColor,
+		}
+		if c.Tile != "" {
+			c.Rotate, c.Flip = td.MinTransform.Rotate, td.MinTransform.Flip
+			c.Distance = *td.MinDist
+		}
+		if td.Mask != nil {
+			buf := bytes.NewBuffer([]byte{})
+			if err := png.Encode(buf, td.Mask); err != nil {
+				return m, err
+			}
+			c.Mask = buf.Bytes()
+		}
+		m.Cells = append(m.Cells, c)
+	}
+	return m, nil
+}
+
+// saveManifest writes the manifest of the rects as JSON to filename
+func (g *Gosaic) saveManifest(rects []*TileData, filename string) error {
+	m, err := g.manifest(rects)
+	if err != nil {
+		return err
+	}
+
+	fh, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer fh.Close()
+
+	return json.NewEncoder(fh).Encode(m)
+}
+
+// LoadManifest reads a manifest written with Config.Manifest
+func LoadManifest(filename string) (*Manifest, error) {
+	fh, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer fh.Close()
+
+	m := &Manifest{}
+	if err := json.NewDecoder(fh).Decode(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
@@ -0,0 +1,100 @@
+package gosaic
+
+import (
+	"image"
+	"math"
+)
+
+// mergedCells lays out the grid like cells, but merges neighbouring cells
+// whose mean colors differ by less than the merge threshold into 2x2, 2x1
+// and 1x2 cells covered by a single big tile.
+func (g *Gosaic) mergedCells() []cell {
+	size := g.SeedImage.Bounds().Size()
+	ts := g.config.TileSize
+	cols, rows := size.X/ts+1, size.Y/ts+1
+
+	means := make([][3]float64, cols*rows)
+	for x := 0; x < cols; x++ {
+		for y := 0; y < rows; y++ {
+			r := image.Rect(x*ts, y*ts, (x+1)*ts, (y+1)*ts).Intersect(g.SeedImage.Bounds())
+			means[y*cols+x] = meanColor(g.SeedImage.SubImage(r))
+		}
+	}
+
+	used := make([]bool, cols*rows)
+	free := func(x, y int) bool {
+		return x < cols && y < rows && !used[y*cols+x]
+	}
+	similar := func(x1, y1, x2, y2 int) bool {
+		a, b := means[y1*cols+x1], means[y2*cols+x2]
+		d := 0.0
+		for i := range a {
+			d += math.Abs(a[i]-b[i]) / 0x101
+		}
+		return d/3 < g.config.MergeThreshold
+	}
+	fits := func(x, y, w, h int) bool {
+		for dx := 0; dx < w; dx++ {
+			for dy := 0; dy < h; dy++ {
+				if !free(x+dx, y+dy) || !similar(x, y, x+dx, y+dy) {
+					return false
+				}
+			}
+		}
+		return true
+	}
+
+	cells := make([]cell, 0)
+	for y := 0; y < rows; y++ {
+		for x := 0; x < cols; x++ {
+			if used[y*cols+x] {
+				continue
+			}
+
+			// prefer the biggest cell, pick wide or tall cells at random
+			shapes := [][2]int{{2, 2}, {2, 1}, {1, 2}, {1, 1}}
+			if g.rand.Intn(2) == 0 {
+				shapes[1], shapes[2] = shapes[2], shapes[1]
+			}
+
+			for _, s := range shapes {
+				if !fits(x, y, s[0], s[1]) {
+					continue
+				}
+				for dx := 0; dx < s[0]; dx++ {
+					for dy := 0; dy < s[1]; dy++ {
+						used[(y+dy)*cols+x+dx] = true
+					}
+				}
+				r := image.Rect(x*ts, y*ts, (x+s[0])*ts, (y+s[1])*ts)
+				cells = append(cells, cell{X: x, Y: y, Rect: r})
+				break
+			}
+		}
+	}
+
+	return cells
+}
+
+// cropToAspect returns the centered part of img with the aspect ratio of
+// w x h.
+func cropToAspect(img image.Image, w, h int) image.Image {
+	b := img.Bounds()
+	cw, ch := b.Dx(), b.Dy()
+	if cw*h > ch*w {
+		cw = ch * w / h
+	} else {
+		ch = cw * h / w
+	}
+	if cw == b.Dx() && ch == b.Dy() {
+		return img
+	}
+
+	r := image.Rect(0, 0, cw, ch).Add(b.Min).Add(image.Pt((b.Dx()-cw)/2, (b.Dy()-ch)/2))
+	if s, ok := img.(interface {
+		SubImage(image.Rectangle) image.Image
+	}); ok {
+		return s.SubImage(r)
+	}
+	return img
+}
@@ -0,0 +1,195 @@
+package gosaic
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+)
+
+// Version is the version of gosaic recorded in the metadata of mosaics,
+// set at build time with -ldflags "-X github.com/elcamino/gosaic.Version=..."
+var Version = "dev"
+
+// xmpNamespace is the XML namespace of the gosaic XMP properties
+const xmpNamespace = "https://github.com/elcamino/gosaic/ns/1.0/"
+
+// EXIF tags and field types used by exifSegment
+const (
+	exifImageDescription = 0x010e
+	exifSoftware         = 0x0131
+	exifASCII            = 2
+)
+
+// buildParameterNames are the parameters recorded in the metadata, those
+// which shape the mosaic. Everything else, like credentials, local paths
+// and server settings, is left out.
+var buildParameterNames = []string{
+	"OutputSize", "OutputWidth", "OutputHeight", "OutputStretch", "TileSize",
+	"TileExtensions", "VideoFrameStep", "CompareSize", "CompareDist", "Unique",
+	"SmartCrop", "RedisLabel", "TileFilter", "Cache", "TilesCache",
+	"Candidates", "Assignment", "AllowRotate", "AllowFlip", "ColorBlend",
+	"OverlayOpacity", "OverlayMode", "RepeatDistance", "MaxUses", "Quadtree",
+	"QuadtreeThreshold", "Layout", "VoronoiSites", "VoronoiSaliency",
+	"GroutWidth", "GroutColor", "Grayscale", "GrayscaleOutput", "CenterWeight",
+	"Background", "MaskBlank", "RandomSeed", "Dedupe", "DedupeDistance",
+	"MinSharpness", "MinContrast", "MaxUniformity", "WidenSteps", "Filler",
+	"MergeThreshold", "CollageRotation", "CollageJitter", "CollageOverlap",
+	"PaletteDistance", "PaletteSize", "TileStyle", "DuotoneShadow",
+	"DuotoneHighlight", "Order", "LinearLight", "Refine", "Feather", "Cols",
+	"Rows", "Letterbox", "MatteColor", "NoTrim", "TrimThreshold", "TrimColor",
+	"Crop", "FaceSplit", "UsagePenalty", "PickFromTop", "SelfMosaic",
+	"SelfSlices", "Recurse", "RecurseSplit", "CompareSpace", "DCTSize",
+	"HistogramMatch", "OutputFormat", "Quality", "Lossless", "WatermarkPos",
+	"WatermarkOpacity",
+}
+
+// urlParameters are the build parameters which may be URLs, they are only
+// recorded without their user info, and not at all if they are local paths.
+var urlParameters = map[string]bool{"Cache": true, "TilesCache": true}
+
+// publicURL returns spec without the user and password if it is a URL
+// with a host, and "" otherwise.
+func publicURL(spec string) string {
+	u, err := url.Parse(spec)
+	if err != nil || u.Host == "" {
+		return ""
+	}
+	u.User = nil
+	return u.String()
+}
+
+// buildParameters returns the set build parameters of config as JSON.
+func buildParameters(config Config) (string, error) {
+	data, err := json.Marshal(config)
+	if err != nil {
+		return "", err
+	}
+	all := map[string]interface{}{}
+	if err := json.Unmarshal(data, &all); err != nil {
+		return "", err
+	}
+
+	params := map[string]interface{}{}
+	for _, k := range buildParameterNames {
+		switch v := all[k].(type) {
+		case bool:
+			if v {
+				params[k] = v
+			}
+		case float64:
+			if v != 0 {
+				params[k] = v
+			}
+		case string:
+			if urlParameters[k] {
+				v = publicURL(v)
+			}
+			if v != "" {
+				params[k] = v
+			}
+		}
+	}
+	data, err = json.Marshal(params)
+	return string(data), err
+}
+
+// xmpPacket returns an XMP packet describing the mosaic
+func xmpPacket(props map[string]string) []byte {
+	keys := make([]string, 0, len(props))
+	for k := range props {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	buf := bytes.NewBuffer([]byte{})
+	buf.WriteString("<?xpacket begin=\"\xef\xbb\xbf\" id=\"W5M0MpCehiHzreSzNTczkc9d\"?>\n")
+	buf.WriteString("<x:xmpmeta xmlns:x=\"adobe:ns:meta/\">\n")
+	buf.WriteString(" <rdf:RDF xmlns:rdf=\"http://www.w3.org/1999/02/22-rdf-syntax-ns#\">\n")
+	fmt.Fprintf(buf, "  <rdf:Description rdf:about=\"\" xmlns:xmp=\"http://ns.adobe.com/xap/1.0/\" xmlns:gosaic=\"%s\"\n", xmpNamespace)
+	fmt.Fprintf(buf, "   xmp:CreatorTool=\"gosaic %s\"", Version)
+	for _, k := range keys {
+		fmt.Fprintf(buf, "\n   gosaic:%s=\"", k)
+		xml.EscapeText(buf, []byte(props[k]))
+		buf.WriteString("\"")
+	}
+	buf.WriteString("/>\n </rdf:RDF>\n</x:xmpmeta>\n<?xpacket end=\"w\"?>")
+	return buf.Bytes()
+}
+
+// exifSegment returns the payload of an EXIF APP1 segment, or eXIf chunk,
+// with the description and software of the image.
+func exifSegment(description, software string) []byte {
+	values := []struct {
+		tag   uint16
+		value string
+	}{
+		{exifImageDescription, description},
+		{exifSoftware, software},
+	}
+
+	be := binary.BigEndian
+	buf := bytes.NewBuffer([]byte{'M', 'M', 0, 42, 0, 0, 0, 8})
+	entries := make([]byte, 2+12*len(values)+4)
+	be.PutUint16(entries, uint16(len(values)))
+	offset := uint32(buf.Len() + len(entries))
+	data := []byte{}
+	for i, v := range values {
+		e := entries[2+12*i:]
+		be.PutUint16(e[0:], v.tag)
+		be.PutUint16(e[2:], exifASCII)
+		be.PutUint32(e[4:], uint32(len(v.value)+1))
+		be.PutUint32(e[8:], offset+uint32(len(data)))
+		data = append(data, v.value...)
+		data = append(data, 0)
+	}
+	buf.Write(entries)
+	buf.Write(data)
+	return buf.Bytes()
+}
+
+// metadata returns the XMP packet and EXIF data describing how the mosaic
+// was built: the gosaic version, the random seed, the tiles and all set
+// parameters, so the mosaic can be reproduced.
+func (g *Gosaic) metadata() ([]byte, []byte, error) {
+	params, err := buildParameters(g.config)
+	if err != nil {
+		return nil, nil, err
+	}
+	label := g.config.RedisLabel
+	if label == "" {
+		label = orDefault(g.config.TilesDir, g.config.TilesGlob)
+	}
+
+	xmp := xmpPacket(map[string]string{
+		"Version":    Version,
+		"RandomSeed": strconv.FormatInt(g.seed, 10),
+		"TileLabel":  label,
+		"Parameters": params,
+	})
+	description := fmt.Sprintf("gosaic mosaic, random seed %d, tiles %s", g.seed, label)
+	return xmp, exifSegment(description, "gosaic "+Version), nil
+}
+
+// embedMetadata embeds the XMP packet and EXIF data into a JPEG or PNG.
+// Other formats are returned unchanged.
+func embedMetadata(data, xmp, exif []byte) []byte {
+	switch {
+	case bytes.HasPrefix(data, jpegMagic):
+		return embedJPEGSegments(data, [][]byte{
+			jpegSegment(0xe1, append([]byte("Exif\x00\x00"), exif...)),
+			jpegSegment(0xe1, append([]byte("http://ns.adobe.com/xap/1.0/\x00"), xmp...)),
+		})
+	case bytes.HasPrefix(data, pngMagic):
+		// the keyword is followed by the compression flag and method, and
+		// the empty language tag and translated keyword
+		itxt := append([]byte("XML:com.adobe.xmp\x00\x00\x00\x00\x00"), xmp...)
+		data = embedPNGChunk(data, "iTXt", itxt)
+		return embedPNGChunk(data, "eXIf", exif)
+	}
+	return data
+}
@@ -0,0 +1,113 @@
+package gosaic
+
+import (
+	"image"
+	"math"
+)
+
+// metricsSize is the longer side to which the mosaic and the seed are
+// scaled down before they are compared, the metrics rate how well the
+// mosaic resembles the seed from a distance.
+const metricsSize = 512
+
+// maxPSNR is reported for identical images
+const maxPSNR = 100.0
+
+// ssimWindow is the size of the windows whose structural similarity is
+// averaged, the windows overlap by half their size.
+const ssimWindow = 8
+
+// QualityReport holds objective metrics of how well the mosaic resembles
+// its seed image.
+type QualityReport struct {
+	// PSNR is the peak signal to noise ratio of the colors in dB
+	PSNR float64 `json:"psnr"`
+	// SSIM is the mean structural similarity of the luminance (-1..1)
+	SSIM float64 `json:"ssim"`
+}
+
+// metricsImage scales img down for the quality metrics
+func metricsImage(img image.Image) *image.RGBA {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w > metricsSize || h > metricsSize {
+		scale := math.Min(float64(metricsSize)/float64(w), float64(metricsSize)/float64(h))
+		w, h = int(math.Max(1, float64(w)*scale)), int(math.Max(1, float64(h)*scale))
+	}
+	return scaleImage(img, w, h)
+}
+
+// qualityReport compares two images of the same size
+func qualityReport(img, ref *image.RGBA) QualityReport {
+	return QualityReport{PSNR: psnr(img, ref), SSIM: ssim(img, ref)}
+}
+
+// psnr returns the peak signal to noise ratio of the red, green and blue
+// channels of two images of the same size.
+func psnr(img, ref *image.RGBA) float64 {
+	var sum float64
+	n := 0
+	for i := 0; i+3 < len(img.Pix) && i+3 < len(ref.Pix); i += 4 {
+		for c := 0; c < 3; c++ {
+			d := float64(img.Pix[i+c]) - float64(ref.Pix[i+c])
+			sum += d * d
+		}
+		n += 3
+	}
+	if n == 0 || sum == 0 {
+		return maxPSNR
+	}
+
+	return math.Min(10*math.Log10(255*255/(sum/float64(n))), maxPSNR)
+}
+
+// ssim returns the mean structural similarity of the luminance of two
+// images of the same size.
+func ssim(img, ref *image.RGBA) float64 {
+	const (
+		c1 = (0.01 * 255) * (0.01 * 255)
+		c2 = (0.03 * 255) * (0.03 * 255)
+	)
+
+	a, w, h := lumaPlane(img)
+	b, _, _ := lumaPlane(ref)
+
+	size := ssimWindow
+	if w < size || h < size {
+		size = int(math.Min(float64(w), float64(h)))
+	}
+	if size == 0 {
+		return 1
+	}
+	step := size / 2
+	if step < 1 {
+		step = 1
+	}
+
+	var total float64
+	windows := 0
+	for y := 0; y+size <= h; y += step {
+		for x := 0; x+size <= w; x += step {
+			var sumA, sumB, sumAA, sumBB, sumAB float64
+			for j := y; j < y+size; j++ {
+				for i := x; i < x+size; i++ {
+					va, vb := a[j*w+i], b[j*w+i]
+					sumA += va
+					sumB += vb
+					sumAA += va * va
+					sumBB += vb * vb
+					sumAB += va * vb
+				}
+			}
+
+			n := float64(size * size)
+			meanA, meanB := sumA/n, sumB/n
+			varA, varB := sumAA/n-meanA*meanA, sumBB/n-meanB*meanB
+			cov := sumAB/n - meanA*meanB
+			total += (2*meanA*meanB + c1) * (2*cov + c2) / ((meanA*meanA + meanB*meanB + c1) * (varA + varB + c2))
+			windows++
+		}
+	}
+
+	return total / float64(windows)
+}
@@ -0,0 +1,44 @@
+package gosaic
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestQualityReport(t *testing.T) {
+	uniform := func(c color.Color) *image.RGBA {
+		img := image.NewRGBA(image.Rect(0, 0, 32, 32))
+		draw.Draw(img, img.Bounds(), &image.Uniform{c}, image.ZP, draw.Src)
+		return img
+	}
+	noise := image.NewRGBA(image.Rect(0, 0, 32, 32))
+	rand.New(rand.NewSource(1)).Read(noise.Pix)
+	gray := color.RGBA{R: 0x80, G: 0x80, B: 0x80, A: 0xff}
+
+	tests := []struct {
+		name     string
+		img, ref *image.RGBA
+		psnr     float64
+		ssim     float64
+	}{
+		{"identical", noise, noise, maxPSNR, 1},
+		{"off by one", uniform(color.RGBA{R: 0x81, G: 0x81, B: 0x81, A: 0xff}), uniform(gray), 20 * math.Log10(255), 1},
+		{"black and white", uniform(color.Black), uniform(color.White), 0, 1e-4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := qualityReport(tt.img, tt.ref)
+			if math.Abs(q.PSNR-tt.psnr) > 1e-6 {
+				t.Fatalf("PSNR %f, want %f", q.PSNR, tt.psnr)
+			}
+			if math.Abs(q.SSIM-tt.ssim) > 1e-3 {
+				t.Fatalf("SSIM %f, want %f", q.SSIM, tt.ssim)
+			}
+		})
+	}
+}
@@ -0,0 +1,138 @@
+package gosaic
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"path/filepath"
+	"strings"
+
+	"github.com/davidbyttow/govips/v2/vips"
+)
+
+// Formats of the output image
+const (
+	FormatJPEG   = "jpeg"
+	FormatPNG    = "png"
+	FormatWebP   = "webp"
+	FormatTIFF   = "tiff"
+	FormatTIFF16 = "tiff16"
+	FormatAVIF   = "avif"
+)
+
+// StdoutOutput is the output image which writes the mosaic to stdout
+const StdoutOutput = "-"
+
+// DefaultQuality is the quality (1..100) of lossy output formats
+const DefaultQuality = 85
+
+// formatExtensions maps file extensions to output formats
+var formatExtensions = map[string]string{
+	".jpg":  FormatJPEG,
+	".jpeg": FormatJPEG,
+	".png":  FormatPNG,
+	".webp": FormatWebP,
+	".tif":  FormatTIFF,
+	".tiff": FormatTIFF,
+	".avif": FormatAVIF,
+}
+
+// formatContentTypes are the MIME types of the output formats
+var formatContentTypes = map[string]string{
+	FormatJPEG: "image/jpeg",
+	FormatPNG:  "image/png",
+	FormatWebP: "image/webp",
+	FormatTIFF: "image/tiff",
+	FormatAVIF: "image/avif",
+}
+
+// contentType returns the MIME type of the image filename by its
+// extension, falling back to JPEG like outputFormat
+func contentType(filename string) string {
+	if format, ok := formatExtensions[strings.ToLower(filepath.Ext(filename))]; ok {
+		return formatContentTypes[format]
+	}
+	return formatContentTypes[FormatJPEG]
+}
+
+// outputFormat returns the configured output format, or the one of the
+// extension of filename, falling back to JPEG.
+func (g *Gosaic) outputFormat(filename string) string {
+	if g.config.OutputFormat != "" {
+		return g.config.OutputFormat
+	}
+	if format, ok := formatExtensions[strings.ToLower(filepath.Ext(filename))]; ok {
+		return format
+	}
+	return FormatJPEG
+}
+
+func (g *Gosaic) quality() int {
+	if g.config.Quality > 0 {
+		return g.config.Quality
+	}
+	return DefaultQuality
+}
+
+// saveImage saves img to filename in the output format
+func (g *Gosaic) saveImage(img image.Image, filename string) error {
+	data, err := g.encode(img, g.outputFormat(filename))
+	if err != nil {
+		return err
+	}
+	return g.writeImage(data, filename)
+}
+
+// encode encodes img in format. JPEG and PNG are encoded directly, the
+// other formats by the exporters of vips. WebP and AVIF are lossless if
+// Lossless is set, 16 bit TIFFs hold the same 8 bit colors for print
+// workflows which expect 16 bits.
+func (g *Gosaic) encode(img image.Image, format string) ([]byte, error) {
+	return encodeImage(img, format, g.quality(), g.config.Lossless)
+}
+
+// encodeImage encodes img in format with quality, losslessly if lossless
+// is set and the format supports it
+func encodeImage(img image.Image, format string, quality int, lossless bool) ([]byte, error) {
+	buf := bytes.NewBuffer([]byte{})
+	switch format {
+	case FormatJPEG:
+		err := jpeg.Encode(buf, img, &jpeg.Options{Quality: quality})
+		return buf.Bytes(), err
+	case FormatPNG:
+		err := png.Encode(buf, img)
+		return buf.Bytes(), err
+	case FormatWebP, FormatTIFF, FormatTIFF16, FormatAVIF:
+	default:
+		return nil, fmt.Errorf("unknown output format %q", format)
+	}
+
+	// hand the mosaic to vips as a PNG, which is fast to encode
+	encoder := png.Encoder{CompressionLevel: png.NoCompression}
+	if err := encoder.Encode(buf, img); err != nil {
+		return nil, err
+	}
+	imgRef, err := vips.NewImageFromBuffer(buf.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	defer imgRef.Close()
+
+	var data []byte
+	switch format {
+	case FormatWebP:
+		data, _, err = imgRef.ExportWebp(&vips.WebpExportParams{Quality: quality, Lossless: lossless, ReductionEffort: 4})
+	case FormatAVIF:
+		data, _, err = imgRef.ExportAvif(&vips.AvifExportParams{Quality: quality, Lossless: lossless, Speed: 5})
+	case FormatTIFF16:
+		if err = imgRef.ToColorSpace(vips.InterpretationRGB16); err != nil {
+			return nil, err
+		}
+		fallthrough
+	case FormatTIFF:
+		data, _, err = imgRef.ExportTiff(&vips.TiffExportParams{Quality: quality, Compression: vips.TiffCompressionLzw, Predictor: vips.TiffPredictorHorizontal})
+	}
+	return data, err
+}
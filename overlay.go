@@ -0,0 +1,64 @@
+package gosaic
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+)
+
+const (
+	BlendNormal    = "normal"
+	BlendMultiply  = "multiply"
+	BlendSoftLight = "softlight"
+)
+
+// blendFuncs combine a base and a top channel value, both in 0..1
+var blendFuncs = map[string]func(base, top float64) float64{
+	BlendNormal: func(base, top float64) float64 {
+		return top
+	},
+	BlendMultiply: func(base, top float64) float64 {
+		return base * top
+	},
+	BlendSoftLight: func(base, top float64) float64 {
+		return (1-2*top)*base*base + 2*top*base
+	},
+}
+
+// overlay composites top over dst with the given blend mode and opacity
+func overlay(dst *image.RGBA, top image.Image, opacity float64, mode string) error {
+	if mode == "" {
+		mode = BlendNormal
+	}
+	blend, ok := blendFuncs[mode]
+	if !ok {
+		return fmt.Errorf("unknown blend mode %q", mode)
+	}
+
+	if opacity > 1 {
+		opacity = 1
+	}
+
+	b := dst.Bounds().Intersect(top.Bounds())
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			base := dst.RGBAAt(x, y)
+			tr, tg, tb, _ := top.At(x, y).RGBA()
+
+			mix := func(bv uint8, tv uint32) uint8 {
+				bf := float64(bv) / 0xff
+				tf := float64(tv) / 0xffff
+				return clamp8(255 * (bf*(1-opacity) + blend(bf, tf)*opacity))
+			}
+
+			dst.SetRGBA(x, y, color.RGBA{
+				R: mix(base.R, tr),
+				G: mix(base.G, tg),
+				B: mix(base.B, tb),
+				A: base.A,
+			})
+		}
+	}
+
+	return nil
+}
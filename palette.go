@@ -0,0 +1,126 @@
+package gosaic
+
+import (
+	"container/list"
+	"math"
+	"strings"
+
+	"github.com/davidbyttow/govips/v2/vips"
+	log "github.com/sirupsen/logrus"
+)
+
+// Defaults of the palette filter
+const (
+	DefaultPaletteDistance = 40.0
+	DefaultPaletteSize     = 8
+)
+
+// loadPalette returns the colors (0..255) of a palette given as a comma
+// separated list of hex colors, or extracted from the image file spec.
+func loadPalette(spec string, size int) ([][3]float64, error) {
+	if strings.HasPrefix(strings.TrimSpace(spec), "#") {
+		palette := make([][3]float64, 0)
+		for _, s := range strings.Split(spec, ",") {
+			c, err := parseHexColor(strings.TrimSpace(s))
+			if err != nil {
+				return nil, err
+			}
+			palette = append(palette, [3]float64{float64(c.R), float64(c.G), float64(c.B)})
+		}
+		return palette, nil
+	}
+
+	imgRef, err := vips.NewImageFromFile(spec)
+	if err != nil {
+		return nil, err
+	}
+	defer imgRef.Close()
+
+	img, err := imgRef.ToImage(vips.NewDefaultPNGExportParams())
+	if err != nil {
+		return nil, err
+	}
+
+	small := scaleImage(img, 64, 64)
+	pixels := make([][3]float64, 0, 64*64)
+	for i := 0; i < len(small.Pix); i += 4 {
+		pixels = append(pixels, [3]float64{float64(small.Pix[i]), float64(small.Pix[i+1]), float64(small.Pix[i+2])})
+	}
+
+	return kMeans(pixels, size, 10), nil
+}
+
+// kMeans clusters the colors into k groups and returns their centers. The
+// centers start at evenly spaced colors for reproducible palettes.
+func kMeans(colors [][3]float64, k, iterations int) [][3]float64 {
+	if k > len(colors) {
+		k = len(colors)
+	}
+	if k < 1 {
+		return nil
+	}
+
+	centers := make([][3]float64, k)
+	for i := range centers {
+		centers[i] = colors[i*len(colors)/k]
+	}
+
+	for it := 0; it < iterations; it++ {
+		sums := make([][3]float64, k)
+		counts := make([]int, k)
+		for _, c := range colors {
+			n, _ := nearestColor(centers, c)
+			for i := range c {
+				sums[n][i] += c[i]
+			}
+			counts[n]++
+		}
+
+		for n := range centers {
+			if counts[n] == 0 {
+				continue
+			}
+			for i := range centers[n] {
+				centers[n][i] = sums[n][i] / float64(counts[n])
+			}
+		}
+	}
+
+	return centers
+}
+
+// nearestColor returns the index of and the euclidean distance to the
+// palette color closest to c.
+func nearestColor(palette [][3]float64, c [3]float64) (int, float64) {
+	best, bestDist := -1, math.Inf(1)
+	for n, p := range palette {
+		d := math.Sqrt((p[0]-c[0])*(p[0]-c[0]) + (p[1]-c[1])*(p[1]-c[1]) + (p[2]-c[2])*(p[2]-c[2]))
+		if d < bestDist {
+			best, bestDist = n, d
+		}
+	}
+	return best, bestDist
+}
+
+// filterPalette removes all tiles whose mean color is further than maxDist
+// from every color of the palette.
+func (g *Gosaic) filterPalette(palette [][3]float64, maxDist float64) {
+	var next *list.Element
+	for cur := g.Tiles.Front(); cur != nil; cur = next {
+		next = cur.Next()
+		tile := cur.Value.(Tile)
+		if tile.Tiny == nil {
+			continue
+		}
+
+		mean := meanColor(tile.Tiny)
+		for i := range mean {
+			mean[i] /= 0x101
+		}
+		if _, d := nearestColor(palette, mean); d > maxDist {
+			g.Tiles.Remove(cur)
+		}
+	}
+
+	log.Infof("%d tiles left after filtering by the palette of %d colors", g.Tiles.Len(), len(palette))
+}
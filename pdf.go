@@ -0,0 +1,227 @@
+package gosaic
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"io/ioutil"
+	"math"
+	"strconv"
+	"strings"
+
+	xdraw "golang.org/x/image/draw"
+	"golang.org/x/image/math/f64"
+)
+
+// DefaultDPI is the resolution at which the mosaic is printed
+const DefaultDPI = 300
+
+const (
+	pointsPerInch = 72.0
+	// pdfMargin is the margin around the printed mosaic which holds the
+	// crop marks, in points (10 mm)
+	pdfMargin = 10 * pointsPerInch / 25.4
+	// cropMarkLength and cropMarkGap are the length of the crop marks and
+	// their distance to the trimmed area, in points
+	cropMarkLength = 5 * pointsPerInch / 25.4
+	cropMarkGap    = 2 * pointsPerInch / 25.4
+)
+
+// paperSizes are the named paper sizes in portrait, in points
+var paperSizes = map[string][2]float64{
+	"a0":      {2383.94, 3370.39},
+	"a1":      {1683.78, 2383.94},
+	"a2":      {1190.55, 1683.78},
+	"a3":      {841.89, 1190.55},
+	"a4":      {595.28, 841.89},
+	"a5":      {419.53, 595.28},
+	"letter":  {612, 792},
+	"legal":   {612, 1008},
+	"tabloid": {792, 1224},
+}
+
+// unitPoints are the units of physical sizes, in points
+var unitPoints = map[string]float64{
+	"mm": pointsPerInch / 25.4,
+	"cm": pointsPerInch / 2.54,
+	"in": pointsPerInch,
+	"pt": 1,
+}
+
+// parsePrintSize parses a physical size like 60x90cm, 24x36in or
+// 600x900mm, or a paper size like A4 or letter, into points.
+func parsePrintSize(s string) (float64, float64, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if size, ok := paperSizes[s]; ok {
+		return size[0], size[1], nil
+	}
+
+	for unit, points := range unitPoints {
+		if !strings.HasSuffix(s, unit) {
+			continue
+		}
+		parts := strings.Split(strings.TrimSpace(strings.TrimSuffix(s, unit)), "x")
+		if len(parts) != 2 {
+			break
+		}
+		w, errW := strconv.ParseFloat(strings.TrimSpace(parts[0]), 64)
+		h, errH := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+		if errW != nil || errH != nil || w <= 0 || h <= 0 {
+			break
+		}
+		return w * points, h * points, nil
+	}
+	return 0, 0, fmt.Errorf("invalid print size %q, use WxH with cm, mm or in, or a paper size like A4", s)
+}
+
+// pdfPage is a page of the PDF showing a part of the mosaic in trim, in
+// points from the bottom left corner of the page.
+type pdfPage struct {
+	width, height float64
+	trim          [4]float64
+	img           []byte
+	imgW, imgH    int
+}
+
+// savePDF writes img to filename as a PDF for printing, fitted into the
+// print size at dpi. With a page size the mosaic is split across as many
+// pages as needed, each with crop marks at the edges of its part.
+func savePDF(img image.Image, filename, printSize, pageSize string, dpi, quality int) error {
+	printW, printH, err := parsePrintSize(printSize)
+	if err != nil {
+		return err
+	}
+	if dpi <= 0 {
+		dpi = DefaultDPI
+	}
+
+	// fit the mosaic into the print size, keeping its aspect ratio
+	b := img.Bounds()
+	scale := math.Min(printW/float64(b.Dx()), printH/float64(b.Dy()))
+	printW, printH = scale*float64(b.Dx()), scale*float64(b.Dy())
+
+	pageW, pageH := printW+2*pdfMargin, printH+2*pdfMargin
+	if pageSize != "" {
+		if pageW, pageH, err = parsePrintSize(pageSize); err != nil {
+			return err
+		}
+	}
+	areaW, areaH := pageW-2*pdfMargin, pageH-2*pdfMargin
+	if areaW <= 0 || areaH <= 0 {
+		return fmt.Errorf("page size %q is too small", pageSize)
+	}
+
+	// turn the pages if that needs fewer of them
+	cols, rows := math.Ceil(printW/areaW), math.Ceil(printH/areaH)
+	if c, r := math.Ceil(printW/areaH), math.Ceil(printH/areaW); c*r < cols*rows {
+		pageW, pageH, areaW, areaH = pageH, pageW, areaH, areaW
+		cols, rows = c, r
+	}
+
+	pxPerPoint := float64(dpi) / pointsPerInch
+	pages := []pdfPage{}
+	for row := 0; row < int(rows); row++ {
+		for col := 0; col < int(cols); col++ {
+			x0, y0 := float64(col)*areaW, float64(row)*areaH
+			w, h := math.Min(areaW, printW-x0), math.Min(areaH, printH-y0)
+
+			// render this part of the mosaic at the print resolution
+			part := image.NewRGBA(image.Rect(0, 0, int(math.Ceil(w*pxPerPoint)), int(math.Ceil(h*pxPerPoint))))
+			s := scale * pxPerPoint
+			s2d := f64.Aff3{s, 0, -x0 * pxPerPoint, 0, s, -y0 * pxPerPoint}
+			xdraw.CatmullRom.Transform(part, s2d, img, b, xdraw.Src, nil)
+
+			buf := bytes.NewBuffer([]byte{})
+			if err := jpeg.Encode(buf, part, &jpeg.Options{Quality: quality}); err != nil {
+				return err
+			}
+
+			// PDF coordinates start at the bottom left corner
+			left, top := pdfMargin, pageH-pdfMargin
+			if pageSize == "" {
+				left, top = (pageW-w)/2, (pageH+h)/2
+			}
+			pages = append(pages, pdfPage{
+				width:  pageW,
+				height: pageH,
+				trim:   [4]float64{left, top - h, w, h},
+				img:    buf.Bytes(),
+				imgW:   part.Bounds().Dx(),
+				imgH:   part.Bounds().Dy(),
+			})
+		}
+	}
+
+	data, err := encodePDF(pages)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filename, data, 0644)
+}
+
+// cropMarks returns the content stream drawing the crop marks around the
+// corners of the trimmed rectangle x, y, w, h.
+func cropMarks(x, y, w, h float64) string {
+	var sb strings.Builder
+	sb.WriteString("0 G 0.25 w\n")
+	for _, cx := range []float64{x, x + w} {
+		for _, cy := range []float64{y, y + h} {
+			dx, dy := math.Copysign(1, cx-x-w/2), math.Copysign(1, cy-y-h/2)
+			fmt.Fprintf(&sb, "%.2f %.2f m %.2f %.2f l S\n", cx+dx*cropMarkGap, cy, cx+dx*(cropMarkGap+cropMarkLength), cy)
+			fmt.Fprintf(&sb, "%.2f %.2f m %.2f %.2f l S\n", cx, cy+dy*cropMarkGap, cx, cy+dy*(cropMarkGap+cropMarkLength))
+		}
+	}
+	return sb.String()
+}
+
+// encodePDF encodes the pages as a PDF with one JPEG image per page
+func encodePDF(pages []pdfPage) ([]byte, error) {
+	if len(pages) == 0 {
+		return nil, errors.New("no pages")
+	}
+
+	buf := bytes.NewBuffer([]byte{})
+	offsets := []int{}
+	object := func(body string, stream []byte) {
+		offsets = append(offsets, buf.Len())
+		fmt.Fprintf(buf, "%d 0 obj\n%s\n", len(offsets), body)
+		if stream != nil {
+			buf.WriteString("stream\n")
+			buf.Write(stream)
+			buf.WriteString("\nendstream\n")
+		}
+		buf.WriteString("endobj\n")
+	}
+
+	buf.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+
+	// the catalog and page tree come first, each page takes three objects
+	kids := make([]string, len(pages))
+	for i := range pages {
+		kids[i] = fmt.Sprintf("%d 0 R", 3+i*3)
+	}
+	object("<< /Type /Catalog /Pages 2 0 R >>", nil)
+	object(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(pages)), nil)
+
+	for i, p := range pages {
+		content, xobject := 4+i*3, 5+i*3
+		x, y, w, h := p.trim[0], p.trim[1], p.trim[2], p.trim[3]
+		stream := fmt.Sprintf("q %.2f 0 0 %.2f %.2f %.2f cm /Im0 Do Q\n%s", w, h, x, y, cropMarks(x, y, w, h))
+
+		object(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.2f %.2f] /TrimBox [%.2f %.2f %.2f %.2f] /Contents %d 0 R /Resources << /XObject << /Im0 %d 0 R >> >> >>",
+			p.width, p.height, x, y, x+w, y+h, content, xobject), nil)
+		object(fmt.Sprintf("<< /Length %d >>", len(stream)), []byte(stream))
+		object(fmt.Sprintf("<< /Type /XObject /Subtype /Image /Width %d /Height %d /ColorSpace /DeviceRGB /BitsPerComponent 8 /Filter /DCTDecode /Length %d >>",
+			p.imgW, p.imgH, len(p.img)), p.img)
+	}
+
+	xref := buf.Len()
+	fmt.Fprintf(buf, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, o := range offsets {
+		fmt.Fprintf(buf, "%010d 00000 n \n", o)
+	}
+	fmt.Fprintf(buf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+	return buf.Bytes(), nil
+}
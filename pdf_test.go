@@ -0,0 +1,55 @@
+package gosaic
+
+import (
+	"bytes"
+	"math"
+	"testing"
+)
+
+func TestParsePrintSize(t *testing.T) {
+	tests := []struct {
+		s    string
+		w, h float64
+		err  bool
+	}{
+		{"A4", 595.28, 841.89, false},
+		{"letter", 612, 792, false},
+		{"24x36in", 24 * 72, 36 * 72, false},
+		{"60x90cm", 60 / 2.54 * 72, 90 / 2.54 * 72, false},
+		{"600 x 900 mm", 600 / 25.4 * 72, 900 / 25.4 * 72, false},
+		{"60x90", 0, 0, true},
+		{"0x90cm", 0, 0, true},
+		{"A9", 0, 0, true},
+	}
+
+	for _, test := range tests {
+		w, h, err := parsePrintSize(test.s)
+		if (err != nil) != test.err {
+			t.Errorf("parsePrintSize(%q) error = %v, want error %v", test.s, err, test.err)
+			continue
+		}
+		if math.Abs(w-test.w) > 1e-9 || math.Abs(h-test.h) > 1e-9 {
+			t.Errorf("parsePrintSize(%q) = %f, %f, want %f, %f", test.s, w, h, test.w, test.h)
+		}
+	}
+}
+
+func TestEncodePDF(t *testing.T) {
+	pages := []pdfPage{
+		{width: 100, height: 100, trim: [4]float64{10, 10, 80, 80}, img: []byte{0xff, 0xd8}, imgW: 1, imgH: 1},
+		{width: 100, height: 100, trim: [4]float64{10, 10, 80, 80}, img: []byte{0xff, 0xd8}, imgW: 1, imgH: 1},
+	}
+	data, err := encodePDF(pages)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(data, []byte("%PDF-1.4")) || !bytes.HasSuffix(data, []byte("%%EOF\n")) {
+		t.Error("missing PDF header or trailer")
+	}
+	if !bytes.Contains(data, []byte("/Count 2")) {
+		t.Error("page tree doesn't count 2 pages")
+	}
+	if !bytes.Contains(data, []byte("xref\n0 9\n")) {
+		t.Error("xref doesn't list 8 objects")
+	}
+}
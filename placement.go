@@ -0,0 +1,50 @@
+package gosaic
+
+import (
+	"image"
+	"sync"
+)
+
+// placementMap records the cells at which each tile has been placed
+type placementMap struct {
+	mutex sync.Mutex
+	cells map[string][]image.Point
+}
+
+func newPlacementMap() *placementMap {
+	return &placementMap{cells: map[string][]image.Point{}}
+}
+
+func (p *placementMap) add(filename string, x, y int) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.cells[filename] = append(p.cells[filename], image.Pt(x, y))
+}
+
+// uses returns how often the tile has been placed
+func (p *placementMap) uses(filename string) int {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return len(p.cells[filename])
+}
+
+// near reports whether the tile has been placed within dist cells
+// (manhattan distance) of x/y.
+func (p *placementMap) near(filename string, x, y, dist int) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	for _, c := range p.cells[filename] {
+		if abs(c.X-x)+abs(c.Y-y) <= dist {
+			return true
+		}
+	}
+	return false
+}
+
+func abs(a int) int {
+	if a < 0 {
+		return -a
+	}
+	return a
+}
@@ -0,0 +1,61 @@
+package gosaic
+
+import (
+	"container/list"
+	"image/color"
+	"sync"
+	"testing"
+	"time"
+)
+
+// the usage penalty ranks the tiles, but the best tile keeps its own
+// distance
+func TestUsagePenalty(t *testing.T) {
+	rect := numbered(4, 4)
+	other := numbered(4, 4)
+	other.SetRGBA(0, 0, color.RGBA{R: 0x80, A: 0xff})
+
+	for _, tt := range []struct {
+		penalty float64
+		want    string
+	}{
+		{0, "used"},
+		{1e-6, "used"},
+		{1, "fresh"},
+	} {
+		g := &Gosaic{config: Config{UsagePenalty: tt.penalty, CompareDist: 1, Workers: 2}, placements: newPlacementMap()}
+		g.placements.add("used", 0, 0)
+
+		tiles := list.New()
+		tiles.PushBack(Tile{Filename: "used", Tiny: rect})
+		tiles.PushBack(Tile{Filename: "fresh", Tiny: other})
+		candidates := []*list.Element{tiles.Front(), tiles.Back()}
+
+		minDist := maxDistance
+		td := &TileData{
+			CompareImage: rect,
+			Rect:         rect.Bounds(),
+			MinDist:      &minDist,
+			MinTile:      &Tile{},
+			MinElem:      &list.Element{},
+			MinTransform: &Transform{},
+			CompareTime:  new(time.Duration),
+			Mutex:        &sync.Mutex{},
+			Weight:       0.5,
+		}
+		td.Variants = g.compareVariants(rect, nil)
+		g.compareCandidates(td, candidates)
+
+		if td.MinTile.Filename != tt.want {
+			t.Errorf("penalty %g: matched %s, want %s", tt.penalty, td.MinTile.Filename, tt.want)
+			continue
+		}
+		want, _, err := g.tileDistance(td, *td.MinTile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if *td.MinDist != want {
+			t.Errorf("penalty %g: distance %f, want the unpenalized %f", tt.penalty, *td.MinDist, want)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package gosaic
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// DefaultSplitOverlap is the overlap of neighbouring poster pages in
+// pixels, 5 mm at 300 dpi
+const DefaultSplitOverlap = 60
+
+// dashLength is the length of the dashes of the alignment lines
+const dashLength = 8
+
+// parsePageSize parses a paper size like A4, a physical size like 20x30cm
+// at dpi, or a size in pixels like 2480x3508 into pixels.
+func parsePageSize(s string, dpi int) (int, int, error) {
+	if w, h, err := parsePrintSize(s); err == nil {
+		if dpi <= 0 {
+			dpi = DefaultDPI
+		}
+		px := float64(dpi) / pointsPerInch
+		return int(math.Round(w * px)), int(math.Round(h * px)), nil
+	}
+
+	parts := strings.Split(strings.ToLower(s), "x")
+	if len(parts) == 2 {
+		w, errW := strconv.Atoi(strings.TrimSpace(parts[0]))
+		h, errH := strconv.Atoi(strings.TrimSpace(parts[1]))
+		if errW == nil && errH == nil && w > 0 && h > 0 {
+			return w, h, nil
+		}
+	}
+	return 0, 0, fmt.Errorf("invalid page size %q, use a paper size like A4, WxH with cm, mm or in, or WxH in pixels", s)
+}
+
+// pageSegments splits a w x h image into rows of pages of pw x ph pixels
+// which overlap their neighbours by overlap pixels. The pages are turned
+// if that needs fewer of them, their size is returned with the segments.
+func pageSegments(w, h, pw, ph, overlap int) ([][]image.Rectangle, int, int) {
+	count := func(size, page int) int {
+		if size <= page {
+			return 1
+		}
+		return 1 + (size-overlap-1)/(page-overlap)
+	}
+	if count(w, ph)*count(h, pw) < count(w, pw)*count(h, ph) {
+		pw, ph = ph, pw
+	}
+
+	segments := [][]image.Rectangle{}
+	for y := 0; ; y += ph - overlap {
+		row := []image.Rectangle{}
+		for x := 0; ; x += pw - overlap {
+			row = append(row, image.Rect(x, y, x+pw, y+ph).Intersect(image.Rect(0, 0, w, h)))
+			if x+pw >= w {
+				break
+			}
+		}
+		segments = append(segments, row)
+		if y+ph >= h {
+			break
+		}
+	}
+	return segments, pw, ph
+}
+
+// dashedLine draws a dashed black and white line from x0, y0 to x1, y1,
+// which is either horizontal or vertical, so it shows on any tiles.
+func dashedLine(img *image.RGBA, x0, y0, x1, y1 int) {
+	dx, dy := 0, 1
+	if y0 == y1 {
+		dx, dy = 1, 0
+	}
+	for i := 0; x0+i*dx <= x1 && y0+i*dy <= y1; i++ {
+		c := color.RGBA{A: 0xff}
+		if i/dashLength%2 == 1 {
+			c = color.RGBA{0xff, 0xff, 0xff, 0xff}
+		}
+		img.SetRGBA(x0+i*dx, y0+i*dy, c)
+	}
+}
+
+// splitPages saves the mosaic cut into overlapping pages for printing at
+// home, named after the output image with their row and column. A dashed
+// line in the middle of each overlap shows where to cut and align the
+// pages, the pages at the right and bottom are filled up with white.
+func (g *Gosaic) splitPages() error {
+	pw, ph, err := parsePageSize(g.config.SplitPages, g.config.DPI)
+	if err != nil {
+		return err
+	}
+	overlap := g.config.SplitOverlap
+	if overlap < 0 || 2*overlap >= pw || 2*overlap >= ph {
+		return fmt.Errorf("the overlap of %d pixels doesn't fit pages of %dx%d pixels", overlap, pw, ph)
+	}
+
+	b := g.SeedImage.Bounds()
+	segments, pw, ph := pageSegments(b.Dx(), b.Dy(), pw, ph, overlap)
+	ext := filepath.Ext(g.config.OutputImage)
+	base := strings.TrimSuffix(g.config.OutputImage, ext)
+
+	for row, pages := range segments {
+		for col, r := range pages {
+			page := image.NewRGBA(image.Rect(0, 0, pw, ph))
+			draw.Draw(page, page.Bounds(), &image.Uniform{color.White}, image.ZP, draw.Src)
+			draw.Draw(page, r.Sub(r.Min), g.SeedImage, r.Min.Add(b.Min), draw.Src)
+
+			// mark the middle of the overlaps with the neighbours
+			mid := overlap / 2
+			if col > 0 {
+				dashedLine(page, mid, 0, mid, r.Dy()-1)
+			}
+			if col < len(pages)-1 {
+				dashedLine(page, r.Dx()-overlap+mid, 0, r.Dx()-overlap+mid, r.Dy()-1)
+			}
+			if row > 0 {
+				dashedLine(page, 0, mid, r.Dx()-1, mid)
+			}
+			if row < len(segments)-1 {
+				dashedLine(page, 0, r.Dy()-overlap+mid, r.Dx()-1, r.Dy()-overlap+mid)
+			}
+
+			filename := fmt.Sprintf("%s-r%02d-c%02d%s", base, row+1, col+1, ext)
+			if err := g.saveImage(page, filename); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package gosaic
+
+import "testing"
+
+func TestParsePageSize(t *testing.T) {
+	tests := []struct {
+		s    string
+		dpi  int
+		w, h int
+		err  bool
+	}{
+		{"A4", 300, 2480, 3508, false},
+		{"10x20cm", 254, 1000, 2000, false},
+		{"2480x3508", 300, 2480, 3508, false},
+		{"0x10", 300, 0, 0, true},
+		{"A4x", 300, 0, 0, true},
+	}
+
+	for _, test := range tests {
+		w, h, err := parsePageSize(test.s, test.dpi)
+		if (err != nil) != test.err {
+			t.Errorf("parsePageSize(%q) error = %v, want error %v", test.s, err, test.err)
+			continue
+		}
+		if w != test.w || h != test.h {
+			t.Errorf("parsePageSize(%q) = %d, %d, want %d, %d", test.s, w, h, test.w, test.h)
+		}
+	}
+}
+
+func TestPageSegments(t *testing.T) {
+	tests := []struct {
+		w, h, pw, ph, overlap int
+		rows, cols            int
+		turned                bool
+	}{
+		{100, 100, 100, 100, 10, 1, 1, false},
+		{50, 50, 100, 200, 10, 1, 1, false},
+		{190, 100, 100, 100, 10, 1, 2, false},
+		{191, 100, 100, 100, 10, 1, 3, false},
+		{300, 100, 100, 200, 10, 1, 2, true},
+	}
+
+	for _, test := range tests {
+		segments, pw, ph := pageSegments(test.w, test.h, test.pw, test.ph, test.overlap)
+		if len(segments) != test.rows || len(segments[0]) != test.cols {
+			t.Errorf("pageSegments(%d, %d) gave %dx%d pages, want %dx%d", test.w, test.h, len(segments[0]), len(segments), test.cols, test.rows)
+			continue
+		}
+		if turned := pw != test.pw; turned != test.turned {
+			t.Errorf("pageSegments(%d, %d) turned the pages: %v, want %v", test.w, test.h, turned, test.turned)
+		}
+
+		// the pages cover the image and overlap their neighbours
+		covered := 0
+		for row, pages := range segments {
+			for col, r := range pages {
+				if r.Dx() > pw || r.Dy() > ph {
+					t.Errorf("segment %v is larger than the page", r)
+				}
+				if col > 0 && pages[col-1].Intersect(r).Dx() != test.overlap {
+					t.Errorf("segments %v and %v don't overlap by %d", pages[col-1], r, test.overlap)
+				}
+				if row == 0 {
+					covered += r.Dx()
+				}
+			}
+		}
+		if want := test.w + (test.cols-1)*test.overlap; covered != want {
+			t.Errorf("pageSegments(%d, %d) covers %d columns, want %d", test.w, test.h, covered, want)
+		}
+	}
+}
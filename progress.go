@@ -0,0 +1,97 @@
+package gosaic
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/cheggaaa/pb/v3"
+)
+
+// Progress is the state of a build reported to Config.OnProgress
+type Progress struct {
+	Done     int           `json:"done"`
+	Total    int           `json:"total"`
+	Elapsed  float64       `json:"elapsed_seconds"`
+	ETA      float64       `json:"eta_seconds"`
+	Distance DistanceStats `json:"distance"`
+}
+
+// DistanceStats sums up the distances of the tiles placed so far to their
+// cells, lower is better
+type DistanceStats struct {
+	Placed int     `json:"placed"`
+	Min    float64 `json:"min"`
+	Mean   float64 `json:"mean"`
+	Max    float64 `json:"max"`
+}
+
+// progressHook reports every matched cell to fn and passes it on to the
+// progress indicator next, if there is one. The distances of the placed
+// tiles are added by place.
+type progressHook struct {
+	next    ProgressIndicator
+	fn      func(Progress)
+	total   int
+	started time.Time
+	mutex   sync.Mutex
+	done    int
+	dist    DistanceStats
+	sum     float64
+}
+
+func newProgressHook(next ProgressIndicator, fn func(Progress), total int) *progressHook {
+	return &progressHook{next: next, fn: fn, total: total, started: time.Now()}
+}
+
+// place adds the distance of a placed tile to the stats
+func (h *progressHook) place(dist float64) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if h.dist.Placed == 0 {
+		h.dist.Min, h.dist.Max = dist, dist
+	}
+	h.dist.Placed++
+	h.sum += dist
+	h.dist.Min = math.Min(h.dist.Min, dist)
+	h.dist.Max = math.Max(h.dist.Max, dist)
+	h.dist.Mean = h.sum / float64(h.dist.Placed)
+}
+
+// progress returns the current progress, estimating the time left from the
+// pace so far
+func (h *progressHook) progress() Progress {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	p := Progress{
+		Done:     h.done,
+		Total:    h.total,
+		Elapsed:  time.Since(h.started).Seconds(),
+		Distance: h.dist,
+	}
+	if p.Done > 0 && p.Done < p.Total {
+		p.ETA = p.Elapsed / float64(p.Done) * float64(p.Total-p.Done)
+	}
+	return p
+}
+
+func (h *progressHook) Increment() *pb.ProgressBar {
+	h.mutex.Lock()
+	h.done++
+	h.mutex.Unlock()
+	h.fn(h.progress())
+	if h.next != nil {
+		return h.next.Increment()
+	}
+	return nil
+}
+
+// Finish reports the final progress, the tiles of optimal assignments are
+// only placed after all cells have been compared.
+func (h *progressHook) Finish() *pb.ProgressBar {
+	h.fn(h.progress())
+	if h.next != nil {
+		return h.next.Finish()
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+package gosaic
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+)
+
+// lumaPlane returns the luminance (0..255) of every pixel of img
+func lumaPlane(img image.Image) ([]float64, int, int) {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	luma := make([]float64, w*h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			luma[y*w+x] = float64(color.GrayModel.Convert(img.At(b.Min.X+x, b.Min.Y+y)).(color.Gray).Y)
+		}
+	}
+	return luma, w, h
+}
+
+// sharpness returns the variance of the laplacian of img. Blurry images
+// have few edges and thus a low variance.
+func sharpness(luma []float64, w, h int) float64 {
+	if w < 3 || h < 3 {
+		return 0
+	}
+
+	sum, sumSq, n := 0.0, 0.0, 0.0
+	for y := 1; y < h-1; y++ {
+		for x := 1; x < w-1; x++ {
+			i := y*w + x
+			l := luma[i-w] + luma[i+w] + luma[i-1] + luma[i+1] - 4*luma[i]
+			sum += l
+			sumSq += l * l
+			n++
+		}
+	}
+
+	mean := sum / n
+	return sumSq/n - mean*mean
+}
+
+// contrast returns the standard deviation of the luminance
+func contrast(luma []float64) float64 {
+	if len(luma) == 0 {
+		return 0
+	}
+
+	sum, sumSq := 0.0, 0.0
+	for _, l := range luma {
+		sum += l
+		sumSq += l * l
+	}
+
+	n := float64(len(luma))
+	mean := sum / n
+	return math.Sqrt(math.Max(sumSq/n-mean*mean, 0))
+}
+
+// uniformity returns the share of pixels falling into the most common of
+// 16 luminance buckets.
+func uniformity(luma []float64) float64 {
+	if len(luma) == 0 {
+		return 1
+	}
+
+	var buckets [16]int
+	for _, l := range luma {
+		buckets[int(l)/16]++
+	}
+
+	most := 0
+	for _, c := range buckets {
+		if c > most {
+			most = c
+		}
+	}
+
+	return float64(most) / float64(len(luma))
+}
+
+// checkQuality returns an error if the tile is too blurry, too flat or too
+// uniform according to the configured thresholds.
+func (g *Gosaic) checkQuality(tile Tile) error {
+	if g.config.MinSharpness <= 0 && g.config.MinContrast <= 0 && g.config.MaxUniformity <= 0 {
+		return nil
+	}
+	if tile.Tiny == nil {
+		return nil
+	}
+
+	luma, w, h := lumaPlane(tile.Tiny)
+
+	if g.config.MinSharpness > 0 {
+		if s := sharpness(luma, w, h); s < g.config.MinSharpness {
+			return fmt.Errorf("too blurry (sharpness %.1f < %.1f)", s, g.config.MinSharpness)
+		}
+	}
+
+	if g.config.MinContrast > 0 {
+		if c := contrast(luma); c < g.config.MinContrast {
+			return fmt.Errorf("too little contrast (%.1f < %.1f)", c, g.config.MinContrast)
+		}
+	}
+
+	if g.config.MaxUniformity > 0 {
+		if u := uniformity(luma); u > g.config.MaxUniformity {
+			return fmt.Errorf("too uniform (%.2f > %.2f)", u, g.config.MaxUniformity)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,88 @@
+package gosaic
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// keyRateLimiter keeps a token bucket per API key. A bucket holds a minute
+// of requests and refills continuously, so a key may burst up to its
+// limit.
+type keyRateLimiter struct {
+	mutex   sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newKeyRateLimiter() *keyRateLimiter {
+	return &keyRateLimiter{buckets: map[string]*tokenBucket{}}
+}
+
+// allow takes a token of key, allowing perMinute requests per minute. If
+// the bucket is empty it returns false and the time until the next token.
+func (l *keyRateLimiter) allow(key string, perMinute int, now time.Time) (bool, time.Duration) {
+	if perMinute <= 0 {
+		return true, 0
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	perSecond := float64(perMinute) / 60
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: float64(perMinute), last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(float64(perMinute), b.tokens+now.Sub(b.last).Seconds()*perSecond)
+	b.last = now
+
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / perSecond * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+// rateLimit rejects the requests of API keys beyond their rate limit
+func rateLimit(l *keyRateLimiter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key, ok := c.Get("APIKey")
+		if !ok {
+			return
+		}
+		k := key.(APIKey)
+		if ok, wait := l.allow(k.owner(), k.RateLimit, time.Now()); !ok {
+			tooManyRequests(c, wait, fmt.Sprintf("rate limit of %d requests per minute exceeded", k.RateLimit))
+		}
+	}
+}
+
+// tooManyRequests answers with 429 and when to retry
+func tooManyRequests(c *gin.Context, wait time.Duration, msg string) {
+	c.Header("Retry-After", fmt.Sprint(retryAfter(wait)))
+	c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": msg})
+}
+
+// retryAfter returns wait in whole seconds for a Retry-After header
+func retryAfter(wait time.Duration) int {
+	if s := int(math.Ceil(wait.Seconds())); s > 1 {
+		return s
+	}
+	return 1
+}
+
+// monthStart returns the start of the calendar month (UTC) of t, from
+// which on the builds count against the quotas
+func monthStart(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
@@ -0,0 +1,61 @@
+package gosaic
+
+import (
+	"testing"
+	"time"
+)
+
+func TestKeyRateLimiter(t *testing.T) {
+	l := newKeyRateLimiter()
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		key     string
+		after   time.Duration
+		allowed bool
+		wait    time.Duration
+	}{
+		{"a", 0, true, 0},
+		{"a", 0, true, 0},
+		{"a", 0, false, 30 * time.Second},
+		{"b", 0, true, 0},
+		{"a", 10 * time.Second, false, 20 * time.Second},
+		{"a", 20 * time.Second, true, 0},
+		{"a", 0, false, 30 * time.Second},
+	}
+	for i, tt := range tests {
+		now = now.Add(tt.after)
+		allowed, wait := l.allow(tt.key, 2, now)
+		if allowed != tt.allowed || wait.Round(time.Millisecond) != tt.wait {
+			t.Errorf("request %d of %s: allow = %t, %s, want %t, %s", i, tt.key, allowed, wait, tt.allowed, tt.wait)
+		}
+	}
+
+	if ok, _ := l.allow("c", 0, now); !ok {
+		t.Error("a key without a rate limit was limited")
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	tests := []struct {
+		wait time.Duration
+		want int
+	}{
+		{0, 1},
+		{300 * time.Millisecond, 1},
+		{1500 * time.Millisecond, 2},
+		{30 * time.Second, 30},
+	}
+	for _, tt := range tests {
+		if got := retryAfter(tt.wait); got != tt.want {
+			t.Errorf("retryAfter(%s) = %d, want %d", tt.wait, got, tt.want)
+		}
+	}
+}
+
+func TestMonthStart(t *testing.T) {
+	got := monthStart(time.Date(2024, 2, 29, 23, 59, 0, 0, time.FixedZone("", -3600)))
+	if want := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC); !got.Equal(want) {
+		t.Errorf("monthStart = %s, want %s", got, want)
+	}
+}
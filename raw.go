@@ -0,0 +1,58 @@
+package gosaic
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// RAWDecoder is the dcraw compatible command RAW camera files are decoded
+// with, as libvips only reads the small preview embedded into them.
+var RAWDecoder = "dcraw"
+
+// rawDecoderArgs demosaic with AHD interpolation and the white balance of
+// the camera into a 16 bit sRGB TIFF on stdout
+var rawDecoderArgs = []string{"-c", "-w", "-q", "3", "-o", "1", "-6", "-T"}
+
+// rawExtensions are the extensions of the supported RAW camera files
+var rawExtensions = map[string]bool{
+	".arw": true,
+	".cr2": true,
+	".crw": true,
+	".dng": true,
+	".nef": true,
+	".nrw": true,
+	".orf": true,
+	".pef": true,
+	".raf": true,
+	".rw2": true,
+	".srw": true,
+}
+
+// IsRAW tells if name is a RAW camera file by its extension. URLs are
+// judged by their path.
+func IsRAW(name string) bool {
+	if u, err := url.Parse(name); err == nil && u.Scheme != "" {
+		name = u.Path
+	}
+	return rawExtensions[strings.ToLower(filepath.Ext(name))]
+}
+
+// decodeRAW decodes the RAW camera file filename with RAWDecoder
+func decodeRAW(filename string) ([]byte, error) {
+	path, err := exec.LookPath(RAWDecoder)
+	if err != nil {
+		return nil, fmt.Errorf("%s: decoding RAW files needs %s: %s", filename, RAWDecoder, err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(path, append(rawDecoderArgs, filename)...)
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("%s: %s: %s", filename, err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.Bytes(), nil
+}
@@ -0,0 +1,25 @@
+package gosaic
+
+import "testing"
+
+func TestIsRAW(t *testing.T) {
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"IMG_0001.CR2", true},
+		{"photos/dsc_1234.nef", true},
+		{"a.dng", true},
+		{"a.jpg", false},
+		{"a.tif", false},
+		{"https://example.com/raw/a.arw?download=1", true},
+		{"https://example.com/a.nef/view", false},
+		{"-", false},
+	}
+
+	for _, test := range tests {
+		if got := IsRAW(test.name); got != test.want {
+			t.Errorf("IsRAW(%q) = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package gosaic
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+)
+
+// DefaultRecurseSplit is the number of sub-tiles per side into which every
+// tile of a recursive mosaic is split.
+const DefaultRecurseSplit = 4
+
+// recurseCandidates is the number of tiles with the nearest feature vectors
+// which are compared to each sub-tile.
+const recurseCandidates = 16
+
+// minRecurseSize is the smallest sub-tile a tile is split into
+const minRecurseSize = 4
+
+// recurseTile replaces img, the tile of a w x h cell, with a mosaic of
+// smaller tiles which are mosaics themselves down to depth levels. Each
+// level only keeps its own canvas and the sub-tile currently drawn in
+// memory, the sub-tiles are loaded when they are drawn.
+func (g *Gosaic) recurseTile(img image.Image, w, h, depth int) image.Image {
+	split := g.config.RecurseSplit
+	if split <= 0 {
+		split = DefaultRecurseSplit
+	}
+	if depth <= 0 || w/split < minRecurseSize || h/split < minRecurseSize {
+		return img
+	}
+
+	src := scaleImage(cropToAspect(img, w, h), w, h)
+	canvas := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(canvas, canvas.Bounds(), src, image.ZP, draw.Src)
+
+	for y := 0; y < split; y++ {
+		for x := 0; x < split; x++ {
+			r := image.Rect(x*w/split, y*h/split, (x+1)*w/split, (y+1)*h/split)
+
+			// parts without a match keep the pixels of the tile
+			name, err := g.recurseMatch(src.SubImage(r))
+			if err != nil {
+				continue
+			}
+			tile, err := g.loadTile(name, r.Dx(), r.Dy())
+			if err != nil {
+				continue
+			}
+
+			sub := g.recurseTile(tile.Tiny, r.Dx(), r.Dy(), depth-1)
+			sub = scaleImage(cropToAspect(sub, r.Dx(), r.Dy()), r.Dx(), r.Dy())
+			draw.Draw(canvas, r, sub, image.ZP, draw.Src)
+		}
+	}
+
+	return canvas
+}
+
+// recurseMatch returns the name of the tile closest to img, a part of a
+// tile. The sub-tiles may repeat and don't count towards the uses of a
+// tile.
+func (g *Gosaic) recurseMatch(img image.Image) (string, error) {
+	compare := image.Image(scaleImage(img, g.config.CompareSize, g.config.CompareSize))
+	if g.config.Grayscale {
+		compare = toGray(compare)
+	}
+
+	best, bestDist := "", maxDistance
+	for _, le := range g.recurseTree.Nearest(featureVector(compare), recurseCandidates) {
+		tile := le.Value.(Tile)
+		dist, err := g.Difference(compare, tile.Tiny)
+		if err != nil {
+			return "", err
+		}
+		if dist < bestDist || (dist == bestDist && tile.Filename < best) {
+			best, bestDist = tile.Filename, dist
+		}
+	}
+
+	if best == "" {
+		return "", fmt.Errorf("no tile matches %v", img.Bounds())
+	}
+	return best, nil
+}
@@ -0,0 +1,48 @@
+package gosaic
+
+import (
+	"container/list"
+	"math"
+	"sort"
+)
+
+// refineCandidates narrows the candidates of td down to the configured
+// number of tiles whose 4x4 block signatures are closest to the one of td
+// or one of its variants. Tiles outside the average color distance are
+// dropped first, so only the best few get the expensive pixel comparison.
+func (g *Gosaic) refineCandidates(td *TileData, candidates []*list.Element) []*list.Element {
+	if g.config.Refine <= 0 || len(candidates) <= g.config.Refine {
+		return candidates
+	}
+
+	type ranked struct {
+		elem *list.Element
+		dist float64
+	}
+
+	maxDist := g.compareDist(td)
+	ranks := make([]ranked, 0, len(candidates))
+	for _, le := range candidates {
+		tile := le.Value.(Tile)
+		if tile.Tiny == nil || math.Abs(tile.Average-td.Average) > maxDist {
+			continue
+		}
+
+		features := tile.Features
+		if features == nil {
+			features = featureVector(tile.Tiny)
+		}
+		ranks = append(ranks, ranked{elem: le, dist: variantDist(td, features)})
+	}
+
+	sort.Slice(ranks, func(i, j int) bool { return ranks[i].dist < ranks[j].dist })
+	if len(ranks) > g.config.Refine {
+		ranks = ranks[:g.config.Refine]
+	}
+
+	refined := make([]*list.Element, len(ranks))
+	for i, r := range ranks {
+		refined[i] = r.elem
+	}
+	return refined
+}
@@ -0,0 +1,222 @@
+package gosaic
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/davidbyttow/govips/v2/vips"
+	log "github.com/sirupsen/logrus"
+)
+
+// streamBandHeight is the height of the bands of streamed renders, two
+// rows of TIFF tiles
+const streamBandHeight = 2 * tiffTileSize
+
+// manifestScale returns the factor by which the mosaic of m is scaled to
+// the output size of config. Like the seed image, the mosaic is scaled to
+// OutputWidth or OutputHeight, or its shorter side to OutputSize.
+func manifestScale(m *Manifest, config Config) float64 {
+	switch {
+	case config.OutputWidth > 0:
+		return float64(config.OutputWidth) / float64(m.Width)
+	case config.OutputHeight > 0:
+		return float64(config.OutputHeight) / float64(m.Height)
+	case config.OutputSize > 0:
+		return float64(config.OutputSize) / math.Min(float64(m.Width), float64(m.Height))
+	}
+	return 1
+}
+
+// Render draws the mosaic of a manifest at the output size of config and
+// saves it to the output image, without loading the seed image or
+// matching any tiles. The tiles are loaded the same way as by New, from
+// the cache, from disk or as slices of the seed image. With Stream the
+// mosaic is drawn band by band into a tiled TIFF, so its size isn't
+// limited by the memory.
+func Render(config Config, m *Manifest) error {
+	vips.LoggingSettings(func(messageDomain string, messageLevel vips.LogLevel, message string) {
+		log.Error(message)
+	}, vips.LogLevelError)
+
+	if err := checkConfig(config); err != nil {
+		return err
+	}
+
+	scale := manifestScale(m, config)
+	width, height := int(math.Round(float64(m.Width)*scale)), int(math.Round(float64(m.Height)*scale))
+
+	g := &Gosaic{
+		config:     config,
+		placements: newPlacementMap(),
+		rand:       rand.New(rand.NewSource(m.RandomSeed)),
+		seed:       m.RandomSeed,
+		stats:      Stats{TStart: time.Now(), Seed: m.RandomSeed},
+	}
+
+	// the slices of a self mosaic are cut from the seed at the output size
+	if config.SelfMosaic && config.TileSource == nil {
+		config.OutputWidth, config.OutputHeight, config.OutputStretch = width, height, true
+		seed, _, err := loadSeed(config)
+		if err != nil {
+			return err
+		}
+		g.SeedImage = seed
+	}
+	if err := g.openTileSource(); err != nil {
+		return err
+	}
+	defer g.Close()
+
+	if config.Legend != "" {
+		if err := g.saveLegend(*m, config.Legend); err != nil {
+			return err
+		}
+	}
+
+	if config.Stream {
+		if err := g.renderStream(m, scale, width, height); err != nil {
+			return err
+		}
+		log.Infof("Rendered %d cells at %dx%d", len(m.Cells), width, height)
+		log.Infof("Wall time: %s", time.Now().Sub(g.stats.TStart))
+		return nil
+	}
+
+	if err := g.renderRegion(m, scale, image.Rect(0, 0, width, height)); err != nil {
+		return err
+	}
+
+	if err := g.finishOutput(); err != nil {
+		return err
+	}
+
+	log.Infof("Rendered %d cells at %dx%d", len(m.Cells), width, height)
+	log.Infof("Wall time: %s", time.Now().Sub(g.stats.TStart))
+	return g.saveOutput()
+}
+
+// renderRegion draws the cells of m which reach into region onto a new
+// mosaic of the size of region. Rotated and feathered tiles may reach
+// beyond their cells, so the cells are grown by half their size and the
+// feathering before they are tested.
+func (g *Gosaic) renderRegion(m *Manifest, scale float64, region image.Rectangle) error {
+	g.SeedImage = image.NewRGBA(region)
+
+	if g.config.GroutWidth > 0 {
+		grout, err := parseHexColor(orDefault(g.config.GroutColor, DefaultGroutColor))
+		if err != nil {
+			return err
+		}
+		draw.Draw(g.SeedImage, region, &image.Uniform{grout}, region.Min, draw.Src)
+	}
+
+	for _, c := range m.Cells {
+		td, err := renderCell(c, scale)
+		if err != nil {
+			return err
+		}
+		grow := td.Cell.Dx()
+		if td.Cell.Dy() > grow {
+			grow = td.Cell.Dy()
+		}
+		if !td.Cell.Inset(-grow/2 - g.config.Feather).Overlaps(region) {
+			continue
+		}
+
+		if c.Tile == "" {
+			if g.config.Filler == "" {
+				continue
+			}
+			if err := g.drawFiller(td); err != nil {
+				return err
+			}
+			if td.Cell.Min.Y >= region.Min.Y {
+				g.stats.Fillers++
+			}
+			continue
+		}
+
+		if err := g.drawTile(td); err != nil {
+			log.Error(err)
+		}
+	}
+	return nil
+}
+
+// renderStream renders the mosaic in bands into the tiled TIFF of the
+// output image, so only a band has to fit into the memory instead of the
+// whole mosaic. Cells reaching across bands are drawn into both.
+func (g *Gosaic) renderStream(m *Manifest, scale float64, width, height int) error {
+	out, err := newTiledTIFF(g.config.OutputImage, width, height)
+	if err != nil {
+		return err
+	}
+
+	for y := 0; y < height; y += streamBandHeight {
+		band := image.Rect(0, y, width, y+streamBandHeight).Intersect(image.Rect(0, 0, width, height))
+		if err := g.renderRegion(m, scale, band); err != nil {
+			out.close()
+			return err
+		}
+		if err := g.finishOutput(); err != nil {
+			out.close()
+			return err
+		}
+
+		b := g.SeedImage.Bounds()
+		for ty := b.Min.Y; ty < b.Max.Y; ty += tiffTileSize {
+			rows := image.Rect(b.Min.X, ty, b.Max.X, ty+tiffTileSize).Intersect(b)
+			if err := out.writeBand(g.SeedImage.SubImage(rows)); err != nil {
+				out.close()
+				return err
+			}
+		}
+	}
+	return out.close()
+}
+
+// renderCell returns the tile data to draw a manifest cell scaled by scale.
+// The edges of the cells are rounded, so neighbouring cells still meet.
+func renderCell(c ManifestCell, scale float64) (*TileData, error) {
+	round := func(v int) int { return int(math.Round(float64(v) * scale)) }
+	r := image.Rect(round(c.Left), round(c.Top), round(c.Left+c.Width), round(c.Top+c.Height))
+	if r.Dx() < 1 {
+		r.Max.X = r.Min.X + 1
+	}
+	if r.Dy() < 1 {
+		r.Max.Y = r.Min.Y + 1
+	}
+
+	// fillers match the mean color of the cell
+	mean := color.RGBA{R: clamp8(c.MeanColor[0] / 0x101), G: clamp8(c.MeanColor[1] / 0x101), B: clamp8(c.MeanColor[2] / 0x101), A: 0xff}
+	compare := image.NewRGBA(image.Rect(0, 0, 2, 2))
+	draw.Draw(compare, compare.Bounds(), &image.Uniform{mean}, image.ZP, draw.Src)
+
+	td := &TileData{
+		X:            c.X,
+		Y:            c.Y,
+		Cell:         r,
+		Rect:         r,
+		Angle:        c.Angle,
+		MeanColor:    c.MeanColor,
+		CompareImage: compare,
+		MinTile:      &Tile{Filename: c.Tile},
+		MinTransform: &Transform{Rotate: c.Rotate, Flip: c.Flip},
+	}
+
+	if len(c.Mask) > 0 {
+		mask, err := png.Decode(bytes.NewReader(c.Mask))
+		if err != nil {
+			return nil, err
+		}
+		td.Mask = scaleImage(mask, r.Dx(), r.Dy())
+	}
+
+	return td, nil
+}
@@ -0,0 +1,204 @@
+package gosaic
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Defaults of the result storage of the server
+const (
+	DefaultResults   = "mosaics"
+	DefaultResultTTL = time.Hour
+)
+
+// ResultOptions configure where the server keeps the finished mosaics
+type ResultOptions struct {
+	// Store is a directory or an s3:// or gs:// bucket and prefix
+	Store string
+	// URLTTL is how long the download URLs of the mosaics are valid
+	URLTTL time.Duration
+	// Retention is how long finished jobs and their mosaics are kept, 0
+	// keeps them for ever
+	Retention time.Duration
+	// MinFreeDisk is the free space in MB the output directories need for
+	// the server to be ready
+	MinFreeDisk int
+}
+
+// resultStore keeps the finished mosaics of the server under their keys
+type resultStore interface {
+	// put stores the mosaic file filename as key, the file is moved or
+	// removed
+	put(key, filename string) error
+	// url returns a download URL of the mosaic key which expires. It is
+	// relative to the server for mosaics the server serves itself.
+	url(key string, now time.Time) string
+	// file returns the local file of the mosaic key, "" if it is kept
+	// remotely
+	file(key string) string
+	remove(key string) error
+}
+
+// resultKey returns the key of the mosaic of the job id
+func resultKey(id string) string {
+	return id + ".jpg"
+}
+
+// openResultStore opens the result store of spec, a directory or an s3://
+// or gs:// URL, whose download URLs are valid for ttl
+func openResultStore(spec string, ttl time.Duration) (resultStore, error) {
+	if err := checkBlobURL(spec); err != nil {
+		return nil, err
+	}
+	if IsBlobURL(spec) {
+		scheme, bucket, prefix := splitBlobURL(spec)
+		s, err := newBlobStore(scheme, bucket)
+		if err != nil {
+			return nil, err
+		}
+		if prefix != "" && !strings.HasSuffix(prefix, "/") {
+			prefix += "/"
+		}
+		return &blobResults{s: s, prefix: prefix, ttl: ttl}, nil
+	}
+
+	if err := os.MkdirAll(spec, 0755); err != nil {
+		return nil, err
+	}
+	// the URLs are signed with a secret of the process, they don't
+	// survive a restart
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	return &localResults{dir: spec, secret: secret, ttl: ttl}, nil
+}
+
+// localResults keeps the mosaics in a directory. The server serves them at
+// /results/<key> with a signature and an expiry in the query.
+type localResults struct {
+	dir    string
+	secret []byte
+	ttl    time.Duration
+}
+
+func (r *localResults) put(key, filename string) error {
+	dst := r.file(key)
+	if filepath.Clean(filename) == filepath.Clean(dst) {
+		return nil
+	}
+	if err := os.Rename(filename, dst); err == nil {
+		return nil
+	}
+
+	// the directory may be on another device
+	if err := copyFile(dst, filename); err != nil {
+		return err
+	}
+	return os.Remove(filename)
+}
+
+func (r *localResults) url(key string, now time.Time) string {
+	expires := strconv.FormatInt(now.Add(r.ttl).Unix(), 10)
+	query := url.Values{"expires": {expires}, "signature": {r.signature(key, expires)}}
+	return "/results/" + url.PathEscape(key) + "?" + query.Encode()
+}
+
+func (r *localResults) file(key string) string {
+	return filepath.Join(r.dir, filepath.Base(key))
+}
+
+func (r *localResults) remove(key string) error {
+	if err := os.Remove(r.file(key)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// signature returns the signature of the URL of key expiring at expires
+func (r *localResults) signature(key, expires string) string {
+	mac := hmac.New(sha256.New, r.secret)
+	mac.Write([]byte(key + "\n" + expires))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+var (
+	errBadSignature = errors.New("invalid signature")
+	errURLExpired   = errors.New("the URL has expired")
+)
+
+// verify checks the signature and expiry of a URL of key at now
+func (r *localResults) verify(key, expires, signature string, now time.Time) error {
+	if !hmac.Equal([]byte(signature), []byte(r.signature(key, expires))) {
+		return errBadSignature
+	}
+	t, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil {
+		return errBadSignature
+	}
+	if now.Unix() > t {
+		return errURLExpired
+	}
+	return nil
+}
+
+// blobResults keeps the mosaics below a prefix of a bucket, they are
+// downloaded with presigned URLs
+type blobResults struct {
+	s      *blobStore
+	prefix string
+	ttl    time.Duration
+}
+
+func (r *blobResults) put(key, filename string) error {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	if err := r.s.put(r.prefix+key, data, "image/jpeg"); err != nil {
+		return err
+	}
+	return os.Remove(filename)
+}
+
+func (r *blobResults) url(key string, now time.Time) string {
+	return r.s.presign(r.prefix+key, r.ttl, now)
+}
+
+func (r *blobResults) file(key string) string {
+	return ""
+}
+
+func (r *blobResults) remove(key string) error {
+	return r.s.remove(r.prefix + key)
+}
+
+// copyFile copies the file src to dst
+func copyFile(dst, src string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return fmt.Errorf("%s: %s", dst, err)
+	}
+	return out.Close()
+}
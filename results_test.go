@@ -0,0 +1,110 @@
+package gosaic
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestLocalResults(t *testing.T) {
+	dir := t.TempDir()
+	store, err := openResultStore(filepath.Join(dir, "results"), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := store.(*localResults)
+
+	built := filepath.Join(dir, "job.jpg")
+	ioutil.WriteFile(built, []byte("jpeg"), 0644)
+	if err := r.put("job.jpg", built); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := ioutil.ReadFile(r.file("job.jpg")); err != nil || string(data) != "jpeg" {
+		t.Errorf("stored mosaic is %q, %v", data, err)
+	}
+	if _, err := os.Stat(built); !os.IsNotExist(err) {
+		t.Error("the built mosaic wasn't moved")
+	}
+	// a mosaic built in the directory stays where it is
+	if err := r.put("job.jpg", r.file("job.jpg")); err != nil {
+		t.Error(err)
+	}
+
+	now := time.Now()
+	u, err := url.Parse(r.url("job.jpg", now))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Path != "/results/job.jpg" {
+		t.Errorf("URL path is %s", u.Path)
+	}
+	expires, signature := u.Query().Get("expires"), u.Query().Get("signature")
+
+	tests := []struct {
+		key, expires, signature string
+		at                      time.Time
+		want                    error
+	}{
+		{"job.jpg", expires, signature, now, nil},
+		{"job.jpg", expires, signature, now.Add(2 * time.Hour), errURLExpired},
+		{"other.jpg", expires, signature, now, errBadSignature},
+		{"job.jpg", expires + "0", signature, now, errBadSignature},
+		{"job.jpg", expires, strings.Repeat("0", len(signature)), now, errBadSignature},
+	}
+	for _, tt := range tests {
+		if err := r.verify(tt.key, tt.expires, tt.signature, tt.at); err != tt.want {
+			t.Errorf("verify(%s, %s, %s) = %v, want %v", tt.key, tt.expires, tt.signature, err, tt.want)
+		}
+	}
+
+	if err := r.remove("job.jpg"); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.remove("job.jpg"); err != nil {
+		t.Errorf("removing a removed mosaic returned %v", err)
+	}
+}
+
+func TestSendFile(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	dir := t.TempDir()
+	ioutil.WriteFile(filepath.Join(dir, "job.png"), []byte("png"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "job.jpg"), []byte("jpeg"), 0644)
+
+	tests := []struct {
+		file        string
+		code        int
+		contentType string
+		disposition string
+	}{
+		{"job.png", http.StatusOK, "image/png", `attachment; filename="job.png"`},
+		{"job.jpg", http.StatusOK, "image/jpeg", `attachment; filename="job.jpg"`},
+		{"job.webp", http.StatusNotFound, "", ""},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		sendFile(c, "job", filepath.Join(dir, tt.file))
+		if w.Code != tt.code {
+			t.Errorf("%s: answered %d, want %d", tt.file, w.Code, tt.code)
+			continue
+		}
+		if tt.code != http.StatusOK {
+			continue
+		}
+		if got := w.Header().Get("Content-Type"); got != tt.contentType {
+			t.Errorf("%s: Content-Type %q, want %q", tt.file, got, tt.contentType)
+		}
+		if got := w.Header().Get("Content-Disposition"); got != tt.disposition {
+			t.Errorf("%s: Content-Disposition %q, want %q", tt.file, got, tt.disposition)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package gosaic
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// Limits of seed images read from a URL
+const (
+	seedTimeout = 60 * time.Second
+	maxSeedSize = 256 << 20
+)
+
+// openSeed opens the seed image of config: its SeedReader if set, stdin
+// for a SeedImage of "-", the response for an http or https URL and the
+// file otherwise.
+func openSeed(config Config) (io.ReadCloser, error) {
+	switch {
+	case config.SeedReader != nil:
+		return ioutil.NopCloser(config.SeedReader), nil
+	case config.SeedImage == "-":
+		return ioutil.NopCloser(os.Stdin), nil
+	case strings.HasPrefix(config.SeedImage, "http://") || strings.HasPrefix(config.SeedImage, "https://"):
+		client := http.Client{Timeout: seedTimeout}
+		resp, err := client.Get(config.SeedImage)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, fmt.Errorf("%s: %s", config.SeedImage, resp.Status)
+		}
+		return resp.Body, nil
+	}
+	return os.Open(config.SeedImage)
+}
+
+// readSeed reads the encoded seed image of config
+func readSeed(config Config) ([]byte, error) {
+	r, err := openSeed(config)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	data, err := ioutil.ReadAll(io.LimitReader(r, maxSeedSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxSeedSize {
+		return nil, errors.New("the seed image is larger than 256 MB")
+	}
+	return data, nil
+}
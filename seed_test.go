@@ -0,0 +1,41 @@
+package gosaic
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestReadSeed(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/seed.jpg" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte("from url"))
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		config Config
+		want   string
+		err    bool
+	}{
+		{Config{SeedReader: strings.NewReader("from reader"), SeedImage: srv.URL + "/seed.jpg"}, "from reader", false},
+		{Config{SeedImage: srv.URL + "/seed.jpg"}, "from url", false},
+		{Config{SeedImage: srv.URL + "/missing.jpg"}, "", true},
+		{Config{SeedImage: "testdata/missing.jpg"}, "", true},
+	}
+
+	for _, test := range tests {
+		data, err := readSeed(test.config)
+		if (err != nil) != test.err {
+			t.Errorf("readSeed(%q) error = %v, want error %v", test.config.SeedImage, err, test.err)
+			continue
+		}
+		if string(data) != test.want {
+			t.Errorf("readSeed(%q) = %q, want %q", test.config.SeedImage, data, test.want)
+		}
+	}
+}
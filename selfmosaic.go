@@ -0,0 +1,93 @@
+package gosaic
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	"sort"
+
+	"github.com/davidbyttow/govips/v2/vips"
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultSelfSlices is the number of slices per side into which the self
+// mosaic cuts its source image.
+const DefaultSelfSlices = 8
+
+// cutSelfSlices cuts the source of the self mosaic, the seed unless
+// SelfImage is set, into SelfSlices x SelfSlices slices which are used as
+// the tiles. A single slice uses the whole image for every tile.
+func (g *Gosaic) cutSelfSlices() error {
+	var src image.Image = g.SeedImage
+	if g.config.SelfImage != "" {
+		imgRef, err := LoadImage(g.config.SelfImage)
+		if err != nil {
+			return err
+		}
+		defer imgRef.Close()
+
+		if err := ToSRGB(imgRef); err != nil {
+			return err
+		}
+		src, err = imgRef.ToImage(vips.NewDefaultPNGExportParams())
+		if err != nil {
+			return err
+		}
+	}
+
+	n := g.config.SelfSlices
+	if n <= 0 {
+		n = DefaultSelfSlices
+	}
+
+	// copy the slices, the seed is drawn over during the build
+	b := src.Bounds()
+	g.selfTiles = make(map[string]*image.RGBA, n*n)
+	for y := 0; y < n; y++ {
+		for x := 0; x < n; x++ {
+			r := image.Rect(x*b.Dx()/n, y*b.Dy()/n, (x+1)*b.Dx()/n, (y+1)*b.Dy()/n).Add(b.Min)
+			if r.Empty() {
+				continue
+			}
+			slice := image.NewRGBA(image.Rect(0, 0, r.Dx(), r.Dy()))
+			draw.Draw(slice, slice.Bounds(), src, r.Min, draw.Src)
+			g.selfTiles[fmt.Sprintf("self:%d:%d", x, y)] = slice
+		}
+	}
+
+	log.Infof("Cut the self mosaic source into %d slices", len(g.selfTiles))
+	return nil
+}
+
+// selfSource is the TileSource of the slices of a self mosaic
+type selfSource struct {
+	g *Gosaic
+}
+
+// List returns the names of the slices
+func (s selfSource) List() ([]string, error) {
+	names := make([]string, 0, len(s.g.selfTiles))
+	for name := range s.g.selfTiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Load returns the slice id scaled to size
+func (s selfSource) Load(id string, size int) (Tile, error) {
+	return s.g.selfTile(id, size)
+}
+
+// selfTile returns the slice name of the self mosaic cropped to a square
+// and scaled to size.
+func (g *Gosaic) selfTile(name string, size int) (Tile, error) {
+	slice, ok := g.selfTiles[name]
+	if !ok {
+		return Tile{}, fmt.Errorf("unknown slice %s", name)
+	}
+
+	img := scaleImage(cropToAspect(slice, 1, 1), size, size)
+	mean := meanColor(img)
+	return g.styleTile(Tile{Filename: name, Tiny: img, Average: (mean[0] + mean[1] + mean[2]) / 3 / 0x101})
+}
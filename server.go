@@ -7,6 +7,9 @@ import (
 	"mime/multipart"
 	"net/http"
 	"os"
+	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
@@ -14,39 +17,146 @@ import (
 )
 
 type Seed struct {
-	Seed        *multipart.FileHeader `form:"seed" binding:"required" json:"seed"`
-	Tilesize    int                   `form:"tilesize" binding:"required" json:"tilesize"`
-	Comparesize int                   `form:"comparesize" binding:"required" json:"comparesize"`
-	RedisLabel  string                `form:"redislabel" binding:"required" json:"redislabel"`
-	OutputSize  int                   `form:"outputsize" binding:"required" json:"outputsize"`
-	CompareDist float64               `form:"comparedist" binding:"required" json:"comparedist"`
-	Unique      bool                  `form:"unique" binding:"-" json:"unique"`
-	SmartCrop   bool                  `form:"smartcrop" binding:"-" json:"smartcrop"`
-	Progress    bool                  `form:"progress" binding:"-" json:"progress"`
-	Workers     int                   `form:"workers" binding:"-" json:"workers"`
+	Seed              *multipart.FileHeader `form:"seed" binding:"required" json:"seed"`
+	Tilesize          int                   `form:"tilesize" binding:"required" json:"tilesize"`
+	Comparesize       int                   `form:"comparesize" binding:"required" json:"comparesize"`
+	RedisLabel        string                `form:"redislabel" binding:"required" json:"redislabel"`
+	OutputSize        int                   `form:"outputsize" binding:"required" json:"outputsize"`
+	CompareDist       float64               `form:"comparedist" binding:"required" json:"comparedist"`
+	Unique            bool                  `form:"unique" binding:"-" json:"unique"`
+	SmartCrop         bool                  `form:"smartcrop" binding:"-" json:"smartcrop"`
+	Progress          bool                  `form:"progress" binding:"-" json:"progress"`
+	Workers           int                   `form:"workers" binding:"-" json:"workers"`
+	Candidates        int                   `form:"candidates" binding:"-" json:"candidates"`
+	Assignment        string                `form:"assignment" binding:"-" json:"assignment"`
+	AllowRotate       bool                  `form:"allowrotate" binding:"-" json:"allowrotate"`
+	AllowFlip         bool                  `form:"allowflip" binding:"-" json:"allowflip"`
+	ColorBlend        float64               `form:"colorblend" binding:"-" json:"colorblend"`
+	OverlayOpacity    float64               `form:"overlayopacity" binding:"-" json:"overlayopacity"`
+	OverlayMode       string                `form:"overlaymode" binding:"-" json:"overlaymode"`
+	RepeatDistance    int                   `form:"repeatdistance" binding:"-" json:"repeatdistance"`
+	MaxUses           int                   `form:"maxuses" binding:"-" json:"maxuses"`
+	Quadtree          int                   `form:"quadtree" binding:"-" json:"quadtree"`
+	QuadtreeThreshold float64               `form:"quadtreethreshold" binding:"-" json:"quadtreethreshold"`
+	Layout            string                `form:"layout" binding:"-" json:"layout"`
+	VoronoiSites      int                   `form:"voronoisites" binding:"-" json:"voronoisites"`
+	VoronoiSaliency   bool                  `form:"voronoisaliency" binding:"-" json:"voronoisaliency"`
+	GroutWidth        int                   `form:"groutwidth" binding:"-" json:"groutwidth"`
+	GroutColor        string                `form:"groutcolor" binding:"-" json:"groutcolor"`
+	Grayscale         bool                  `form:"grayscale" binding:"-" json:"grayscale"`
+	GrayscaleOutput   bool                  `form:"grayscaleoutput" binding:"-" json:"grayscaleoutput"`
+	CenterWeight      float64               `form:"centerweight" binding:"-" json:"centerweight"`
+	Background        string                `form:"background" binding:"-" json:"background"`
+	RandomSeed        int64                 `form:"randomseed" binding:"-" json:"randomseed"`
+	Dedupe            bool                  `form:"dedupe" binding:"-" json:"dedupe"`
+	MinSharpness      float64               `form:"minsharpness" binding:"-" json:"minsharpness"`
+	MinContrast       float64               `form:"mincontrast" binding:"-" json:"mincontrast"`
+	MaxUniformity     float64               `form:"maxuniformity" binding:"-" json:"maxuniformity"`
+	WidenSteps        int                   `form:"widensteps" binding:"-" json:"widensteps"`
+	Filler            string                `form:"filler" binding:"-" json:"filler"`
+	MergeThreshold    float64               `form:"merge" binding:"-" json:"merge"`
+	CollageRotation   float64               `form:"collagerotation" binding:"-" json:"collagerotation"`
+	CollageJitter     float64               `form:"collagejitter" binding:"-" json:"collagejitter"`
+	CollageOverlap    float64               `form:"collageoverlap" binding:"-" json:"collageoverlap"`
+	Palette           string                `form:"palette" binding:"-" json:"palette"`
+	PaletteDistance   float64               `form:"palettedistance" binding:"-" json:"palettedistance"`
+	TileStyle         string                `form:"tilestyle" binding:"-" json:"tilestyle"`
+	DuotoneShadow     string                `form:"duotoneshadow" binding:"-" json:"duotoneshadow"`
+	DuotoneHighlight  string                `form:"duotonehighlight" binding:"-" json:"duotonehighlight"`
+	Order             string                `form:"order" binding:"-" json:"order"`
+	LinearLight       bool                  `form:"linearlight" binding:"-" json:"linearlight"`
+	Refine            int                   `form:"refine" binding:"-" json:"refine"`
+	Feather           int                   `form:"feather" binding:"-" json:"feather"`
+	OutputWidth       int                   `form:"outputwidth" binding:"-" json:"outputwidth"`
+	OutputHeight      int                   `form:"outputheight" binding:"-" json:"outputheight"`
+	OutputStretch     bool                  `form:"outputstretch" binding:"-" json:"outputstretch"`
+	Cols              int                   `form:"cols" binding:"-" json:"cols"`
+	Rows              int                   `form:"rows" binding:"-" json:"rows"`
+	Crop              string                `form:"crop" binding:"-" json:"crop"`
+	UsagePenalty      float64               `form:"usagepenalty" binding:"-" json:"usagepenalty"`
+	PickFromTop       int                   `form:"pickfromtop" binding:"-" json:"pickfromtop"`
+	SelfMosaic        bool                  `form:"self" binding:"-" json:"self"`
+	SelfSlices        int                   `form:"selfslices" binding:"-" json:"selfslices"`
+	Recurse           int                   `form:"recurse" binding:"-" json:"recurse"`
+	RecurseSplit      int                   `form:"recursesplit" binding:"-" json:"recursesplit"`
+	CompareSpace      string                `form:"compare" binding:"-" json:"compare"`
+	DCTSize           int                   `form:"dctsize" binding:"-" json:"dctsize"`
+	HistogramMatch    float64               `form:"histogrammatch" binding:"-" json:"histogrammatch"`
+	Callback          string                `form:"callback" binding:"-" json:"callback"`
 }
 
 type Server struct {
-	addr      string
-	router    *gin.Engine
-	redisAddr string
+	addr   string
+	router *gin.Engine
+	cache  string
+	redis  RedisOptions
+	jobs   *jobManager
+	// results keeps the finished mosaics
+	results resultStore
+	// webhooks configure the webhooks of the jobs
+	webhooks WebhookOptions
+	// webhookClient posts the webhooks to the allowed addresses only
+	webhookClient *http.Client
 }
 
 func (s *Server) Run() error {
 	return s.router.Run(s.addr)
 }
 
-func NewServer(addr, redisAddr, user, password string) (*Server, error) {
+// NewServer returns the server at addr. All endpoints but /ping, the
+// health checks and the signed result URLs require one of apiKeys if there are any and are
+// limited per key. The jobs are kept in the job store of storeSpec if it
+// is set and the queued ones are resumed. Their mosaics are kept as
+// configured by results and their webhooks are posted as configured by
+// webhooks.
+func NewServer(addr, cache string, redis RedisOptions, user, password string, apiKeys []APIKey, limits JobLimits, storeSpec string, results ResultOptions, webhooks WebhookOptions) (*Server, error) {
 	srv := &Server{
-		addr:      addr,
-		redisAddr: redisAddr,
+		addr:          addr,
+		cache:         cache,
+		redis:         redis,
+		webhooks:      webhooks,
+		webhookClient: webhookClient(webhooks.Allow),
+	}
+
+	if err := os.MkdirAll("mosaics", 0755); err != nil {
+		return nil, err
+	}
+	if results.URLTTL <= 0 {
+		results.URLTTL = DefaultResultTTL
+	}
+	var err error
+	if srv.results, err = openResultStore(orDefault(results.Store, DefaultResults), results.URLTTL); err != nil {
+		return nil, err
+	}
+	var store jobStore
+	if storeSpec != "" {
+		if store, err = openJobStore(storeSpec, redis); err != nil {
+			return nil, err
+		}
+	}
+	srv.jobs = newJobManager(limits, store, srv.build)
+	srv.jobs.onFinish = srv.callback
+	if err := srv.jobs.resume(); err != nil {
+		return nil, err
+	}
+	if results.Retention > 0 {
+		go func() {
+			for {
+				srv.expire(results.Retention)
+				time.Sleep(expireInterval)
+			}
+		}()
 	}
 
 	srv.router = gin.Default()
 
 	srv.router.Use(func(c *gin.Context) {
-		c.Set("RedisAddr", srv.redisAddr)
+		c.Set("Cache", srv.cache)
+		c.Set("Redis", srv.redis)
 		c.Set("HTTPAddr", addr)
+		c.Set("Jobs", srv.jobs)
+		c.Set("Results", srv.results)
+		c.Set("Webhooks", srv.webhooks)
 	})
 
 	srv.router.GET("/ping", func(c *gin.Context) {
@@ -54,100 +164,691 @@ func NewServer(addr, redisAddr, user, password string) (*Server, error) {
 			"message": "pong",
 		})
 	})
+	// the probes of orchestrators like Kubernetes don't authenticate
+	srv.router.GET("/healthz", healthz)
+	srv.router.GET("/readyz", readiness(srv.readyChecks(storeSpec, results.MinFreeDisk)))
+	// the signature of the URL authorizes the download
+	srv.router.GET("/results/:key", getResult)
 
+	routes := srv.router.Group("/")
 	if user != "" && password != "" {
-		authGroup := srv.router.Group("/", gin.BasicAuth(gin.Accounts{user: password}))
-		authGroup.POST("/seed", postSeed)
-	} else {
-		srv.router.POST("/seed", postSeed)
+		routes = srv.router.Group("/", gin.BasicAuth(gin.Accounts{user: password}))
+	}
+	if len(apiKeys) > 0 {
+		routes.Use(apiKeyAuth(apiKeys), rateLimit(newKeyRateLimiter()))
 	}
+	routes.POST("/seed", postSeed)
+	routes.POST("/jobs", postJob)
+	routes.GET("/jobs/:id", getJob)
+	routes.GET("/jobs/:id/result", getJobResult)
+	routes.GET("/jobs/:id/events", getJobEvents)
+	routes.GET("/labels", getLabels)
+	routes.GET("/labels/:label", getLabels)
+	routes.POST("/labels/:label/rename", renameLabel)
+	routes.DELETE("/labels/:label", deleteLabel)
+	routes.POST("/tiles", postTiles)
 
 	return srv, nil
 }
 
-func postSeed(c *gin.Context) {
-	s := Seed{}
+// bindSeed binds the parameters of a mosaic request, answering the
+// request if they are invalid
+func bindSeed(c *gin.Context) (Seed, bool) {
+	s := Seed{
+		CollageRotation: DefaultCollageRotation,
+		CollageJitter:   DefaultCollageJitter,
+		CollageOverlap:  DefaultCollageOverlap,
+		PaletteDistance: DefaultPaletteDistance,
+	}
 	err := c.ShouldBind(&s)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return s, false
+	}
+
+	// palettes can't be read from files on the server
+	if s.Palette != "" && !strings.HasPrefix(s.Palette, "#") {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "the palette must be a list of hex colors"})
+		return s, false
+	}
+	if s.Callback != "" {
+		if err := checkCallback(s.Callback, c.MustGet("Webhooks").(WebhookOptions).Allow); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return s, false
+		}
+	}
+	return s, true
+}
+
+// seedConfig returns the configuration of the mosaic of s, whose seed
+// image is read from seed, written to outFile
+func (srv *Server) seedConfig(s Seed, seed io.Reader, outFile string) Config {
+	return Config{
+		SeedReader:        seed,
+		TileSize:          s.Tilesize,
+		OutputSize:        s.OutputSize,
+		OutputWidth:       s.OutputWidth,
+		OutputHeight:      s.OutputHeight,
+		OutputStretch:     s.OutputStretch,
+		Cols:              s.Cols,
+		Rows:              s.Rows,
+		Crop:              s.Crop,
+		UsagePenalty:      s.UsagePenalty,
+		PickFromTop:       s.PickFromTop,
+		SelfMosaic:        s.SelfMosaic,
+		SelfSlices:        s.SelfSlices,
+		Recurse:           s.Recurse,
+		RecurseSplit:      s.RecurseSplit,
+		CompareSpace:      s.CompareSpace,
+		DCTSize:           s.DCTSize,
+		HistogramMatch:    s.HistogramMatch,
+		OutputImage:       outFile,
+		CompareSize:       s.Comparesize,
+		CompareDist:       float64(s.CompareDist),
+		Unique:            s.Unique,
+		SmartCrop:         s.SmartCrop,
+		ProgressBar:       false,
+		Cache:             srv.cache,
+		RedisPassword:     srv.redis.Password,
+		RedisDB:           srv.redis.DB,
+		RedisTLS:          srv.redis.TLS,
+		RedisLabel:        s.RedisLabel,
+		HTTPAddr:          srv.addr,
+		ProgressText:      s.Progress,
+		Workers:           s.Workers,
+		Candidates:        s.Candidates,
+		Assignment:        s.Assignment,
+		AllowRotate:       s.AllowRotate,
+		AllowFlip:         s.AllowFlip,
+		ColorBlend:        s.ColorBlend,
+		OverlayOpacity:    s.OverlayOpacity,
+		OverlayMode:       s.OverlayMode,
+		RepeatDistance:    s.RepeatDistance,
+		MaxUses:           s.MaxUses,
+		Quadtree:          s.Quadtree,
+		QuadtreeThreshold: s.QuadtreeThreshold,
+		Layout:            s.Layout,
+		VoronoiSites:      s.VoronoiSites,
+		VoronoiSaliency:   s.VoronoiSaliency,
+		GroutWidth:        s.GroutWidth,
+		GroutColor:        s.GroutColor,
+		Grayscale:         s.Grayscale,
+		GrayscaleOutput:   s.GrayscaleOutput,
+		CenterWeight:      s.CenterWeight,
+		Background:        s.Background,
+		RandomSeed:        s.RandomSeed,
+		Dedupe:            s.Dedupe,
+		DedupeDistance:    DefaultDedupeDistance,
+		MinSharpness:      s.MinSharpness,
+		MinContrast:       s.MinContrast,
+		MaxUniformity:     s.MaxUniformity,
+		WidenSteps:        s.WidenSteps,
+		Filler:            s.Filler,
+		MergeThreshold:    s.MergeThreshold,
+		CollageRotation:   s.CollageRotation,
+		CollageJitter:     s.CollageJitter,
+		CollageOverlap:    s.CollageOverlap,
+		Palette:           s.Palette,
+		PaletteDistance:   s.PaletteDistance,
+		TileStyle:         s.TileStyle,
+		DuotoneShadow:     s.DuotoneShadow,
+		DuotoneHighlight:  s.DuotoneHighlight,
+		Order:             s.Order,
+		LinearLight:       s.LinearLight,
+		Refine:            s.Refine,
+		Feather:           s.Feather,
+	}
+}
+
+// postSeed builds the mosaic of the request and answers with it once it is
+// done. The build waits for its turn like the jobs.
+func postSeed(c *gin.Context) {
+	job, ok := startSeedJob(c)
+	if !ok {
+		return
+	}
+
+	job, err := c.MustGet("Jobs").(*jobManager).wait(c.Request.Context(), job.ID)
 	if err != nil {
 		log.Error(err)
-		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err})
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if job.State == JobFailed {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": job.Error})
 		return
 	}
 
-	mpf, err := s.Seed.Open()
+	sendResult(c, job)
+}
+
+// sendResult answers with the mosaic of the done job and its quality.
+// Mosaics which are kept remotely are redirected to.
+func sendResult(c *gin.Context, job Job) {
+	// the quality metrics can't be part of the image response body
+	c.Header("X-Mosaic-PSNR", fmt.Sprintf("%.2f", job.Quality.PSNR))
+	c.Header("X-Mosaic-SSIM", fmt.Sprintf("%.4f", job.Quality.SSIM))
+
+	results := c.MustGet("Results").(resultStore)
+	outFile := results.file(resultKey(job.ID))
+	if outFile == "" {
+		c.Redirect(http.StatusFound, results.url(resultKey(job.ID), time.Now()))
+		return
+	}
+	sendFile(c, job.ID, outFile)
+}
+
+// sendFile answers with the mosaic id in outFile, typed by its extension
+func sendFile(c *gin.Context, id, outFile string) {
+	stat, err := os.Stat(outFile)
+	if os.IsNotExist(err) {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "the mosaic was removed"})
+		return
+	}
 	if err != nil {
 		log.Error(err)
-		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err})
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	tmpfile, err := ioutil.TempFile("", "seed.*.jpg")
+	fh, err := os.Open(outFile)
 	if err != nil {
 		log.Error(err)
-		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err})
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	defer fh.Close()
 
-	defer os.Remove(tmpfile.Name()) // clean up
+	disposition := fmt.Sprintf("attachment; filename=\"%s%s\"", id, filepath.Ext(outFile))
+	c.DataFromReader(http.StatusOK, stat.Size(), contentType(outFile), fh, map[string]string{"Content-Disposition": disposition})
+}
 
-	if _, err := io.Copy(tmpfile, mpf); err != nil {
-		tmpfile.Close()
+// startSeedJob queues the build of the mosaic of the request, answering
+// the request if it can't be queued
+func startSeedJob(c *gin.Context) (Job, bool) {
+	s, ok := bindSeed(c)
+	if !ok {
+		return Job{}, false
+	}
+
+	// the uploaded seed is removed when the request is answered, the job
+	// keeps its own copy until it is built
+	id := uuid.NewString()
+	seedFile := fmt.Sprintf("mosaics/%s.seed", id)
+	if err := c.SaveUploadedFile(s.Seed, seedFile); err != nil {
 		log.Error(err)
-		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err})
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return Job{}, false
+	}
+	s.Seed = nil
+
+	jobs := c.MustGet("Jobs").(*jobManager)
+	rec := jobRecord{
+		Job:      Job{ID: id},
+		Output:   fmt.Sprintf("mosaics/%s.jpg", id),
+		Params:   s,
+		SeedFile: seedFile,
+		Memory:   jobMemory(s, jobs.limits.JobMemory),
+		BaseURL:  requestURL(c, ""),
+	}
+	quota := 0
+	if key, ok := c.Get("APIKey"); ok {
+		rec.Owner, quota = key.(APIKey).owner(), key.(APIKey).Quota
+	}
+	job, err := jobs.start(rec, quota)
+	if err != nil {
+		os.Remove(seedFile)
+	}
+	switch err {
+	case nil:
+		return job, true
+	case errQueueFull:
+		tooManyRequests(c, 30*time.Second, err.Error())
+	case errQuotaExceeded:
+		now := time.Now()
+		tooManyRequests(c, monthStart(now).AddDate(0, 1, 0).Sub(now), err.Error())
+	case errJobTooLarge:
+		c.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, gin.H{"error": err.Error()})
+	default:
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+	return Job{}, false
+}
+
+// build returns the build of the job rec. The seed of the job is removed
+// once it is built and the mosaic is put into the result store.
+func (srv *Server) build(rec jobRecord) buildFunc {
+	return func(onProgress func(Progress)) (QualityReport, error) {
+		seed, err := os.Open(rec.SeedFile)
+		if err != nil {
+			return QualityReport{}, err
+		}
+		defer os.Remove(rec.SeedFile)
+		defer seed.Close()
+
+		config := srv.seedConfig(rec.Params, seed, rec.Output)
+		config.OnProgress = onProgress
+		g, err := New(config)
+		if err != nil {
+			return QualityReport{}, err
+		}
+		defer g.Close()
+		if err := g.Build(); err != nil {
+			return QualityReport{}, err
+		}
+		quality := g.QualityReport()
+		if err := srv.results.put(resultKey(rec.Job.ID), rec.Output); err != nil {
+			return QualityReport{}, err
+		}
+		return quality, nil
+	}
+}
+
+// requestURL returns the absolute URL of path on the server the request
+// was sent to, behind a proxy as well
+func requestURL(c *gin.Context, path string) string {
+	scheme := "http"
+	if c.Request.TLS != nil || c.GetHeader("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	host := c.Request.Host
+	if fwd := c.GetHeader("X-Forwarded-Host"); fwd != "" {
+		host = fwd
+	}
+	return fmt.Sprintf("%s://%s%s", scheme, host, path)
+}
+
+// resultURL returns the download URL of the mosaic of the job id on the
+// server at baseURL
+func resultURL(results resultStore, baseURL, id string) string {
+	u := results.url(resultKey(id), time.Now())
+	if strings.HasPrefix(u, "/") {
+		return baseURL + u
+	}
+	return u
+}
+
+// expireInterval is how often finished jobs are checked for their
+// retention
+const expireInterval = 10 * time.Minute
+
+// expire removes the jobs which finished longer than retention ago and
+// their mosaics. Their builds still count against the quotas of the month.
+func (srv *Server) expire(retention time.Duration) {
+	for _, rec := range srv.jobs.expire(time.Now().Add(-retention)) {
+		if rec.Job.State != JobDone {
+			continue
+		}
+		if err := srv.results.remove(resultKey(rec.Job.ID)); err != nil {
+			log.Errorf("job %s: %s", rec.Job.ID, err)
+		}
+	}
+}
+
+// callback posts the finished job rec to its callback URL if it has one
+func (srv *Server) callback(rec jobRecord) {
+	if rec.Params.Callback == "" {
 		return
 	}
-	if err := tmpfile.Close(); err != nil {
+	event := WebhookEvent{Job: rec.Job}
+	if rec.Job.State == JobDone {
+		event.ResultURL = resultURL(srv.results, rec.BaseURL, rec.Job.ID)
+		event.Job.ResultURL = event.ResultURL
+	}
+	if err := postWebhook(srv.webhookClient, rec.Params.Callback, srv.webhooks.Secret, event, time.Second); err != nil {
+		log.Errorf("job %s: webhook %s: %s", rec.Job.ID, rec.Params.Callback, err)
+	}
+}
+
+// postJob queues the build of the mosaic of the request and answers with
+// the job right away
+func postJob(c *gin.Context) {
+	job, ok := startSeedJob(c)
+	if !ok {
+		return
+	}
+	c.Header("Location", "/jobs/"+job.ID)
+	c.JSON(http.StatusAccepted, job)
+}
+
+// getJob answers with the state and progress of the job in the path, and
+// the download URL of the mosaic once it is done
+func getJob(c *gin.Context) {
+	job, err := c.MustGet("Jobs").(*jobManager).get(c.Param("id"))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if job.State == JobDone {
+		job.ResultURL = resultURL(c.MustGet("Results").(resultStore), requestURL(c, ""), job.ID)
+	}
+	c.JSON(http.StatusOK, job)
+}
+
+// getJobEvents streams the job in the path as server-sent events until it
+// is finished. The events are named after the state of the job.
+func getJobEvents(c *gin.Context) {
+	job, updates, cancel, err := c.MustGet("Jobs").(*jobManager).watch(c.Param("id"))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	defer cancel()
+
+	// keep proxies from buffering the stream
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+
+	c.SSEvent(job.State, job)
+	if job.finished() {
+		return
+	}
+	c.Writer.Flush()
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case job := <-updates:
+			c.SSEvent(job.State, job)
+			return !job.finished()
+		case <-c.Request.Context().Done():
+			return false
+		}
+	})
+}
+
+// getJobResult answers with the mosaic of the job in the path once it is
+// done
+func getJobResult(c *gin.Context) {
+	job, err := c.MustGet("Jobs").(*jobManager).get(c.Param("id"))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if job.State != JobDone {
+		c.AbortWithStatusJSON(http.StatusConflict, job)
+		return
+	}
+	sendResult(c, job)
+}
+
+// getResult answers with the mosaic of the key in the path if the URL is
+// signed and hasn't expired
+func getResult(c *gin.Context) {
+	results, ok := c.MustGet("Results").(*localResults)
+	if !ok {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "the results aren't served by the server"})
+		return
+	}
+	key := c.Param("key")
+	if err := results.verify(key, c.Query("expires"), c.Query("signature"), time.Now()); err != nil {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+	sendFile(c, strings.TrimSuffix(key, ".jpg"), results.file(key))
+}
+
+// openCache opens the tile cache of the server
+func openCache(c *gin.Context) (TileCache, error) {
+	return OpenCache(c.MustGet("Cache").(string), c.MustGet("Redis").(RedisOptions))
+}
+
+// labelStatus returns the HTTP status of an error of the label functions
+func labelStatus(err error) int {
+	if err == ErrUnknownLabel {
+		return http.StatusNotFound
+	}
+	return http.StatusInternalServerError
+}
+
+// getLabels lists the tile counts per label and size, of all labels or of
+// the label in the path
+func getLabels(c *gin.Context) {
+	cache, err := openCache(c)
+	if err != nil {
 		log.Error(err)
-		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err})
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	defer cache.Close()
 
-	mosaicUUID := uuid.NewString()
-	outFile := fmt.Sprintf("mosaics/%s.jpg", mosaicUUID)
+	index, err := LabelIndex(cache, c.Param("label"))
+	if err != nil {
+		c.AbortWithStatusJSON(labelStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, index)
+}
 
-	config := Config{
-		SeedImage:    tmpfile.Name(),
-		TileSize:     s.Tilesize,
-		OutputSize:   s.OutputSize,
-		OutputImage:  outFile,
-		CompareSize:  s.Comparesize,
-		CompareDist:  float64(s.CompareDist),
-		Unique:       s.Unique,
-		SmartCrop:    s.SmartCrop,
-		ProgressBar:  false,
-		RedisAddr:    c.MustGet("RedisAddr").(string),
-		RedisLabel:   s.RedisLabel,
-		HTTPAddr:     c.MustGet("HTTPAddr").(string),
-		ProgressText: s.Progress,
-		Workers:      s.Workers,
+// renameLabel renames the label in the path to the label "to" of the form
+func renameLabel(c *gin.Context) {
+	var req struct {
+		To string `form:"to" json:"to" binding:"required"`
+	}
+	if err := c.ShouldBind(&req); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
 	}
 
-	g, err := New(config)
+	cache, err := openCache(c)
 	if err != nil {
 		log.Error(err)
-		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err})
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	defer cache.Close()
 
-	err = g.Build()
+	n, err := RenameLabel(cache, c.Param("label"), req.To)
 	if err != nil {
-		log.Fatal(err)
+		c.AbortWithStatusJSON(labelStatus(err), gin.H{"error": err.Error()})
+		return
 	}
+	c.JSON(http.StatusOK, gin.H{"renamed": n})
+}
 
-	stat, err := os.Stat(outFile)
+// deleteLabel deletes the tiles of the label in the path
+func deleteLabel(c *gin.Context) {
+	cache, err := openCache(c)
 	if err != nil {
 		log.Error(err)
-		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err})
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	defer cache.Close()
 
-	fh, err := os.Open(outFile)
+	n, err := DeleteLabel(cache, c.Param("label"))
+	if err != nil {
+		c.AbortWithStatusJSON(labelStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"deleted": n})
+}
+
+// TileUpload is the form of a tile upload, with the settings of
+// redisimport
+type TileUpload struct {
+	Tiles           []*multipart.FileHeader `form:"tiles" binding:"required"`
+	Label           string                  `form:"label" binding:"required"`
+	Tilesize        int                     `form:"tilesize" binding:"required"`
+	Comparesize     int                     `form:"comparesize" binding:"-"`
+	Crop            string                  `form:"crop" binding:"-"`
+	NoTrim          bool                    `form:"notrim" binding:"-"`
+	TrimThreshold   float64                 `form:"trimthreshold" binding:"-"`
+	TrimColor       string                  `form:"trimcolor" binding:"-"`
+	MinWidth        int                     `form:"minwidth" binding:"-"`
+	MinHeight       int                     `form:"minheight" binding:"-"`
+	MaxAspect       float64                 `form:"maxaspect" binding:"-"`
+	DedupeThreshold int                     `form:"dedupethreshold" binding:"-"`
+	Tags            string                  `form:"tags" binding:"-"`
+	TTL             string                  `form:"ttl" binding:"-"`
+	CacheFormat     string                  `form:"cacheformat" binding:"-"`
+	CacheQuality    int                     `form:"cachequality" binding:"-"`
+	Force           bool                    `form:"force" binding:"-"`
+}
+
+// TileUploadResult tells what became of each uploaded file
+type TileUploadResult struct {
+	// Imported are the names of the tiles of the imported files
+	Imported map[string]string `json:"imported"`
+	// Skipped are the files which are already imported
+	Skipped []string `json:"skipped"`
+	// Rejected and Failed are the reasons of the files which weren't
+	// imported
+	Rejected map[string]string `json:"rejected"`
+	Failed   map[string]string `json:"failed"`
+}
+
+// tileImport returns the import of the upload u
+func (u TileUpload) tileImport() (*TileImport, error) {
+	ti := NewTileImport(u.Label, u.Tilesize, u.Comparesize)
+	ti.NoTrim = u.NoTrim
+	ti.TrimThreshold = u.TrimThreshold
+	ti.TrimColor = u.TrimColor
+	ti.MinWidth = u.MinWidth
+	ti.MinHeight = u.MinHeight
+	ti.MaxAspect = u.MaxAspect
+	ti.Tags = ParseTags(u.Tags)
+	ti.Encoding = CacheEncoding{Format: u.CacheFormat, Quality: u.CacheQuality}
+
+	var err error
+	if ti.Crop, err = ParseCrop(u.Crop); err != nil {
+		return nil, err
+	}
+	if u.TTL != "" {
+		if ti.TTL, err = time.ParseDuration(u.TTL); err != nil {
+			return nil, err
+		}
+	}
+	if u.MaxAspect != 0 && u.MaxAspect < 1 {
+		return nil, fmt.Errorf("max aspect %g is less than 1", u.MaxAspect)
+	}
+	if u.TrimThreshold < 0 {
+		return nil, fmt.Errorf("trim threshold %g is negative", u.TrimThreshold)
+	}
+	return ti, ti.Encoding.Check()
+}
+
+// postTiles imports the uploaded image files into the tile cache like
+// redisimport
+func postTiles(c *gin.Context) {
+	u := TileUpload{
+		Comparesize:     50,
+		Crop:            CropCenter,
+		TrimThreshold:   DefaultTrimThreshold,
+		TrimColor:       DefaultTrimColor,
+		DedupeThreshold: -1,
+		CacheFormat:     DefaultCacheEncoding.Format,
+		CacheQuality:    DefaultCacheEncoding.Quality,
+	}
+	if err := c.ShouldBind(&u); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	ti, err := u.tileImport()
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	cache, err := openCache(c)
 	if err != nil {
 		log.Error(err)
-		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err})
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	defer fh.Close()
+	defer cache.Close()
 
-	c.DataFromReader(http.StatusOK, stat.Size(), "image/jpeg", fh, map[string]string{"Content-Displsition": fmt.Sprintf("attachment; filename=\"%s.jpg\"", mosaicUUID)})
+	uploader := &tileUploader{cache: cache, ti: ti, tilesize: u.Tilesize, force: u.Force}
+	if u.DedupeThreshold >= 0 {
+		uploader.hashes = NewHashIndex(u.DedupeThreshold)
+		if err := LabelHashes(cache, u.Label, u.Tilesize, uploader.hashes); err != nil {
+			log.Error(err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	dir, err := ioutil.TempDir("", "gosaic-upload")
+	if err != nil {
+		log.Error(err)
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	result := TileUploadResult{
+		Imported: map[string]string{},
+		Skipped:  []string{},
+		Rejected: map[string]string{},
+		Failed:   map[string]string{},
+	}
+	for i, fh := range u.Tiles {
+		// the loaders tell the formats by the extensions
+		filename := filepath.Join(dir, fmt.Sprintf("%d%s", i, filepath.Ext(fh.Filename)))
+		name, reason, err := uploader.upload(c, fh, filename)
+		switch {
+		case err != nil:
+			log.Errorf("%s: %s", fh.Filename, err)
+			result.Failed[fh.Filename] = err.Error()
+		case reason != "":
+			result.Rejected[fh.Filename] = reason
+		case name == "":
+			result.Skipped = append(result.Skipped, fh.Filename)
+		default:
+			result.Imported[fh.Filename] = name
+		}
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+// tileUploader imports the files of a tile upload
+type tileUploader struct {
+	cache TileCache
+	ti    *TileImport
+	// hashes are those of the tiles of the label in tilesize if near
+	// duplicates are rejected
+	hashes   *HashIndex
+	tilesize int
+	force    bool
+}
+
+// upload imports the uploaded file fh, saved as filename. It returns the
+// name of the imported tile, "" for a file which is already imported, or
+// why the file was rejected.
+func (u *tileUploader) upload(c *gin.Context, fh *multipart.FileHeader, filename string) (string, string, error) {
+	if err := c.SaveUploadedFile(fh, filename); err != nil {
+		return "", "", err
+	}
+	name, err := FileHash(filename)
+	if err != nil {
+		return "", "", err
+	}
+	if !u.force && u.ti.UpToDate(u.cache, name) {
+		return "", "", nil
+	}
+
+	tiles, err := u.ti.Tiles(filename, name, fh.Filename, time.Now().UnixNano(), nil)
+	if rejected, ok := err.(*RejectError); ok {
+		return "", rejected.Reason, nil
+	}
+	if err != nil {
+		return "", "", err
+	}
+
+	if u.hashes != nil {
+		for _, tile := range tiles {
+			if tile.Size != u.tilesize {
+				continue
+			}
+			if duplicate, ok := u.hashes.Duplicate(tile.PHash, tile.Name); ok {
+				return "", "near duplicate of " + duplicate, nil
+			}
+			u.hashes.Add(tile.PHash, tile.Name)
+		}
+	}
+
+	for _, tile := range tiles {
+		if err := u.cache.Set(tile); err != nil {
+			return "", "", err
+		}
+	}
+	return name, "", nil
 }
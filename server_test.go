@@ -0,0 +1,33 @@
+package gosaic
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// invalid forms are the client's fault and answered with the reason
+func TestBindSeedInvalid(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/", func(c *gin.Context) {
+		c.Set("Webhooks", WebhookOptions{})
+		if _, ok := bindSeed(c); ok {
+			c.Status(http.StatusOK)
+		}
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("tilesize=big"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("answered %d, want %d", w.Code, http.StatusBadRequest)
+	}
+	if !strings.Contains(w.Body.String(), "big") {
+		t.Errorf("answered %s, want the binding error", w.Body)
+	}
+}
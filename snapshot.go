@@ -0,0 +1,21 @@
+package gosaic
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// snapshotName returns the filename of the snapshot taken after n tiles
+// have been placed, the output filename with the zero padded count
+// appended.
+func snapshotName(output string, n int) string {
+	ext := filepath.Ext(output)
+	return fmt.Sprintf("%s-%06d%s", strings.TrimSuffix(output, ext), n, ext)
+}
+
+// saveSnapshot saves the partially assembled mosaic in the format of the
+// output image.
+func (g *Gosaic) saveSnapshot(n int) error {
+	return g.saveImage(g.SeedImage, snapshotName(g.config.OutputImage, n))
+}
@@ -0,0 +1,125 @@
+package gosaic
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+
+	xdraw "golang.org/x/image/draw"
+)
+
+// Treatments applied to all tiles at load time for a coherent look
+const (
+	StyleSepia              = "sepia"
+	StyleDuotone            = "duotone"
+	StyleDesaturate         = "desaturate"
+	DefaultDuotoneShadow    = "#1b1b4b"
+	DefaultDuotoneHighlight = "#f2d27a"
+)
+
+// DefaultMatteColor fills the cells around letterboxed tiles
+const DefaultMatteColor = "#000000"
+
+// checkStyle returns an error if the tile style or its colors are invalid
+func checkStyle(config Config) error {
+	switch config.TileStyle {
+	case "", StyleSepia, StyleDesaturate:
+	case StyleDuotone:
+		if _, err := parseHexColor(orDefault(config.DuotoneShadow, DefaultDuotoneShadow)); err != nil {
+			return err
+		}
+		if _, err := parseHexColor(orDefault(config.DuotoneHighlight, DefaultDuotoneHighlight)); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown tile style %q", config.TileStyle)
+	}
+	return nil
+}
+
+// styleImage applies the configured tile style to img
+func (g *Gosaic) styleImage(img image.Image) (image.Image, error) {
+	var lo, hi color.RGBA
+	switch g.config.TileStyle {
+	case "":
+		return img, nil
+	case StyleSepia, StyleDesaturate:
+	case StyleDuotone:
+		var err error
+		if lo, err = parseHexColor(orDefault(g.config.DuotoneShadow, DefaultDuotoneShadow)); err != nil {
+			return nil, err
+		}
+		if hi, err = parseHexColor(orDefault(g.config.DuotoneHighlight, DefaultDuotoneHighlight)); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown tile style %q", g.config.TileStyle)
+	}
+
+	b := img.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	for y := 0; y < b.Dy(); y++ {
+		for x := 0; x < b.Dx(); x++ {
+			c := color.RGBAModel.Convert(img.At(b.Min.X+x, b.Min.Y+y)).(color.RGBA)
+			r, gr, bl := float64(c.R), float64(c.G), float64(c.B)
+
+			switch g.config.TileStyle {
+			case StyleSepia:
+				r, gr, bl = 0.393*r+0.769*gr+0.189*bl, 0.349*r+0.686*gr+0.168*bl, 0.272*r+0.534*gr+0.131*bl
+			case StyleDesaturate:
+				l := 0.299*r + 0.587*gr + 0.114*bl
+				r, gr, bl = l, l, l
+			case StyleDuotone:
+				t := (0.299*r + 0.587*gr + 0.114*bl) / 255
+				r = float64(lo.R)*(1-t) + float64(hi.R)*t
+				gr = float64(lo.G)*(1-t) + float64(hi.G)*t
+				bl = float64(lo.B)*(1-t) + float64(hi.B)*t
+			}
+
+			dst.SetRGBA(x, y, color.RGBA{R: clamp8(r), G: clamp8(gr), B: clamp8(bl), A: c.A})
+		}
+	}
+
+	return dst, nil
+}
+
+// styleTile applies the tile style to tile and updates its average
+func (g *Gosaic) styleTile(tile Tile) (Tile, error) {
+	if g.config.TileStyle == "" || tile.Tiny == nil {
+		return tile, nil
+	}
+
+	img, err := g.styleImage(tile.Tiny)
+	if err != nil {
+		return tile, err
+	}
+
+	mean := meanColor(img)
+	tile.Tiny = img
+	tile.Average = (mean[0] + mean[1] + mean[2]) / 3 / 0x101
+	return tile, nil
+}
+
+func orDefault(s, def string) string {
+	if s == "" {
+		return def
+	}
+	return s
+}
+
+// letterbox fits img into a w x h canvas of the matte color without
+// cropping it.
+func letterbox(img image.Image, w, h int, matte color.RGBA) *image.RGBA {
+	b := img.Bounds()
+	scale := math.Min(float64(w)/float64(b.Dx()), float64(h)/float64(b.Dy()))
+	sw, sh := int(math.Round(float64(b.Dx())*scale)), int(math.Round(float64(b.Dy())*scale))
+
+	canvas := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(canvas, canvas.Bounds(), &image.Uniform{matte}, image.ZP, draw.Src)
+
+	r := image.Rect(0, 0, sw, sh).Add(image.Pt((w-sw)/2, (h-sh)/2))
+	xdraw.CatmullRom.Scale(canvas, r, img, b, xdraw.Over, nil)
+	return canvas
+}
@@ -0,0 +1,191 @@
+package gosaic
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// TagFilter is a boolean expression over the tags of the tiles, like
+// "beach AND (2023 OR 2024) AND NOT night". AND binds stronger than OR,
+// the operators and the tags are case insensitive.
+type TagFilter struct {
+	root tagExpr
+}
+
+// tagExpr is a node of a TagFilter
+type tagExpr interface {
+	match(tags map[string]bool) bool
+}
+
+type (
+	tagTerm string
+	tagNot  struct{ expr tagExpr }
+	tagAnd  []tagExpr
+	tagOr   []tagExpr
+)
+
+func (t tagTerm) match(tags map[string]bool) bool { return tags[string(t)] }
+func (n tagNot) match(tags map[string]bool) bool  { return !n.expr.match(tags) }
+
+func (a tagAnd) match(tags map[string]bool) bool {
+	for _, e := range a {
+		if !e.match(tags) {
+			return false
+		}
+	}
+	return true
+}
+
+func (o tagOr) match(tags map[string]bool) bool {
+	for _, e := range o {
+		if e.match(tags) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseTags splits a comma separated list of tags, dropping empty ones
+func ParseTags(s string) []string {
+	tags := []string{}
+	for _, t := range strings.Split(s, ",") {
+		if t = strings.ToLower(strings.TrimSpace(t)); t != "" {
+			tags = append(tags, t)
+		}
+	}
+	return tags
+}
+
+// ParseTagFilter parses the tag expression expr
+func ParseTagFilter(expr string) (*TagFilter, error) {
+	p := &tagParser{tokens: tokenizeTags(expr)}
+	if len(p.tokens) == 0 {
+		return nil, errors.New("empty tag filter")
+	}
+	root, err := p.or()
+	if err != nil {
+		return nil, fmt.Errorf("tag filter %q: %s", expr, err)
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("tag filter %q: unexpected %q", expr, p.tokens[p.pos])
+	}
+	return &TagFilter{root: root}, nil
+}
+
+// Match tells if tags satisfy the filter
+func (f *TagFilter) Match(tags []string) bool {
+	set := make(map[string]bool, len(tags))
+	for _, t := range tags {
+		set[strings.ToLower(t)] = true
+	}
+	return f.root.match(set)
+}
+
+// tokenizeTags splits expr into words and parentheses
+func tokenizeTags(expr string) []string {
+	tokens := []string{}
+	word := ""
+	flush := func() {
+		if word != "" {
+			tokens = append(tokens, word)
+			word = ""
+		}
+	}
+	for _, r := range expr {
+		switch {
+		case r == '(' || r == ')':
+			flush()
+			tokens = append(tokens, string(r))
+		case r == ' ' || r == '\t' || r == '\n':
+			flush()
+		default:
+			word += string(r)
+		}
+	}
+	flush()
+	return tokens
+}
+
+// tagParser is a recursive descent parser of tag expressions
+type tagParser struct {
+	tokens []string
+	pos    int
+}
+
+// peek returns the next token in upper case, "" at the end
+func (p *tagParser) peek() string {
+	if p.pos >= len(p.tokens) {
+		return ""
+	}
+	return strings.ToUpper(p.tokens[p.pos])
+}
+
+func (p *tagParser) or() (tagExpr, error) {
+	terms := tagOr{}
+	for {
+		e, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		terms = append(terms, e)
+		if p.peek() != "OR" {
+			break
+		}
+		p.pos++
+	}
+	if len(terms) == 1 {
+		return terms[0], nil
+	}
+	return terms, nil
+}
+
+func (p *tagParser) and() (tagExpr, error) {
+	terms := tagAnd{}
+	for {
+		e, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		terms = append(terms, e)
+		if p.peek() != "AND" {
+			break
+		}
+		p.pos++
+	}
+	if len(terms) == 1 {
+		return terms[0], nil
+	}
+	return terms, nil
+}
+
+func (p *tagParser) not() (tagExpr, error) {
+	switch tok := p.peek(); tok {
+	case "NOT":
+		p.pos++
+		e, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		return tagNot{e}, nil
+	case "(":
+		p.pos++
+		e, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != ")" {
+			return nil, errors.New("missing )")
+		}
+		p.pos++
+		return e, nil
+	case "", ")", "AND", "OR":
+		if tok == "" {
+			return nil, errors.New("unexpected end")
+		}
+		return nil, fmt.Errorf("unexpected %q", p.tokens[p.pos])
+	}
+	term := tagTerm(strings.ToLower(p.tokens[p.pos]))
+	p.pos++
+	return term, nil
+}
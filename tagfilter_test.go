@@ -0,0 +1,62 @@
+package gosaic
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTagFilter(t *testing.T) {
+	tests := []struct {
+		expr string
+		tags []string
+		want bool
+	}{
+		{"beach", []string{"beach"}, true},
+		{"beach", []string{"city"}, false},
+		{"Beach", []string{"BEACH", "2023"}, true},
+		{"beach AND 2023", []string{"beach", "2023"}, true},
+		{"beach and 2023", []string{"beach"}, false},
+		{"beach OR city", []string{"city"}, true},
+		{"NOT night", []string{"beach"}, true},
+		{"NOT night", []string{"night"}, false},
+		{"NOT night", nil, true},
+		{"beach OR city AND night", []string{"beach"}, true},
+		{"beach OR city AND night", []string{"city"}, false},
+		{"(beach OR city) AND night", []string{"beach"}, false},
+		{"(beach OR city) AND night", []string{"city", "night"}, true},
+		{"beach AND NOT (2022 OR 2023)", []string{"beach", "2024"}, true},
+		{"beach AND NOT (2022 OR 2023)", []string{"beach", "2022"}, false},
+	}
+	for _, tt := range tests {
+		f, err := ParseTagFilter(tt.expr)
+		if err != nil {
+			t.Errorf("ParseTagFilter(%q) failed: %s", tt.expr, err)
+			continue
+		}
+		if got := f.Match(tt.tags); got != tt.want {
+			t.Errorf("%q.Match(%v) = %t, want %t", tt.expr, tt.tags, got, tt.want)
+		}
+	}
+
+	for _, expr := range []string{"", "beach AND", "OR city", "(beach", "beach)", "beach city", "NOT", "()"} {
+		if _, err := ParseTagFilter(expr); err == nil {
+			t.Errorf("ParseTagFilter(%q) didn't fail", expr)
+		}
+	}
+}
+
+func TestParseTags(t *testing.T) {
+	tests := []struct {
+		s    string
+		want []string
+	}{
+		{"", []string{}},
+		{"beach", []string{"beach"}},
+		{" Beach , 2023,,", []string{"beach", "2023"}},
+	}
+	for _, tt := range tests {
+		if got := ParseTags(tt.s); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseTags(%q) = %v, want %v", tt.s, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package gosaic
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// thumbIndex is the metadata index file of a thumbnail cache
+const thumbIndex = "index.json"
+
+// thumbEntry describes a cached thumbnail of a tile file. The thumbnail
+// is used as long as the file and the options it was made with are the
+// same.
+type thumbEntry struct {
+	ModTime int64   `json:"mtime"`
+	Size    int64   `json:"size"`
+	Options string  `json:"options"`
+	Average float64 `json:"average"`
+}
+
+// thumbCache keeps the trimmed and scaled tiles of tile files on disk in
+// a directory, as PNGs named after the file and the options, and their
+// averages in an index file, so later builds over the same files skip
+// decoding and scaling them.
+type thumbCache struct {
+	mutex   sync.Mutex
+	dir     string
+	entries map[string]thumbEntry
+	dirty   bool
+}
+
+// openThumbCache opens the thumbnail cache in dir
+func openThumbCache(dir string) (*thumbCache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	c := &thumbCache{dir: dir, entries: map[string]thumbEntry{}}
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, thumbIndex))
+	if os.IsNotExist(err) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &c.entries); err != nil {
+		return nil, fmt.Errorf("%s: %s", filepath.Join(dir, thumbIndex), err)
+	}
+	return c, nil
+}
+
+// thumbName returns the name of the thumbnail of the file made with the
+// options
+func thumbName(path, options string) string {
+	sum := sha1.Sum([]byte(path + "\x00" + options))
+	return hex.EncodeToString(sum[:]) + ".png"
+}
+
+// get returns the cached thumbnail of the file path made with the options,
+// ok is false if there is none or the file changed.
+func (c *thumbCache) get(path string, info os.FileInfo, options string) (image.Image, float64, bool) {
+	c.mutex.Lock()
+	e, ok := c.entries[path+"\x00"+options]
+	c.mutex.Unlock()
+	if !ok || e.ModTime != info.ModTime().UnixNano() || e.Size != info.Size() {
+		return nil, 0, false
+	}
+
+	fh, err := os.Open(filepath.Join(c.dir, thumbName(path, options)))
+	if err != nil {
+		return nil, 0, false
+	}
+	defer fh.Close()
+	img, err := png.Decode(fh)
+	if err != nil {
+		return nil, 0, false
+	}
+	return img, e.Average, true
+}
+
+// put caches the thumbnail img of the file path made with the options
+func (c *thumbCache) put(path string, info os.FileInfo, options string, img image.Image, avg float64) error {
+	buf := bytes.NewBuffer([]byte{})
+	encoder := png.Encoder{CompressionLevel: png.BestSpeed}
+	if err := encoder.Encode(buf, img); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(c.dir, thumbName(path, options)), buf.Bytes(), 0644); err != nil {
+		return err
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.entries[path+"\x00"+options] = thumbEntry{
+		ModTime: info.ModTime().UnixNano(),
+		Size:    info.Size(),
+		Options: options,
+		Average: avg,
+	}
+	c.dirty = true
+	return nil
+}
+
+// save writes the index if thumbnails were added
+func (c *thumbCache) save() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if !c.dirty {
+		return nil
+	}
+
+	data, err := json.Marshal(c.entries)
+	if err != nil {
+		return err
+	}
+	// replace the index at once, so it is never left half written
+	tmp := filepath.Join(c.dir, thumbIndex+".tmp")
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, filepath.Join(c.dir, thumbIndex)); err != nil {
+		return err
+	}
+	c.dirty = false
+	return nil
+}
+
+// thumbOptions returns the options which change how a tile file of size is
+// loaded. Tiles are rotated after their EXIF orientation since autorot,
+// which invalidates the thumbnails made before.
+func (g *Gosaic) thumbOptions(size int) string {
+	return fmt.Sprintf("autorot size=%d crop=%s smartcrop=%t letterbox=%t matte=%s notrim=%t trim=%g trimcolor=%s", size, g.config.Crop, g.config.SmartCrop, g.config.Letterbox, g.config.MatteColor, g.config.NoTrim, g.config.TrimThreshold, g.config.TrimColor)
+}
+
+// loadThumb loads the tile file filename in size from the thumbnail cache,
+// making and caching the thumbnail if the file isn't cached yet. Only
+// files on disk are cached.
+func (g *Gosaic) loadThumb(filename string, size int) (Tile, error) {
+	info, err := os.Stat(filename)
+	if err != nil || !info.Mode().IsRegular() {
+		return g.loadTileFromDisk(filename, size, size)
+	}
+	path, err := filepath.Abs(filename)
+	if err != nil {
+		return Tile{}, err
+	}
+
+	options := g.thumbOptions(size)
+	img, avg, ok := g.thumbs.get(path, info, options)
+	if !ok {
+		img, avg, err = g.loadTileFile(filename, size, size)
+		if err != nil {
+			return Tile{}, err
+		}
+		if err := g.thumbs.put(path, info, options, img, avg); err != nil {
+			return Tile{}, err
+		}
+	}
+	return g.styleTile(Tile{Tiny: img, Average: avg, Filename: filename})
+}
@@ -0,0 +1,64 @@
+package gosaic
+
+import (
+	"image"
+	"image/color"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestThumbCache(t *testing.T) {
+	dir := t.TempDir()
+	tile := filepath.Join(dir, "tile.jpg")
+	if err := ioutil.WriteFile(tile, []byte("jpeg"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(tile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := openThumbCache(filepath.Join(dir, "thumbs"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	thumb := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	thumb.Set(1, 2, color.RGBA{200, 100, 50, 255})
+	if err := c.put(tile, info, "size=4", thumb, 42); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.save(); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err = openThumbCache(filepath.Join(dir, "thumbs"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, avg, ok := c.get(tile, info, "size=4")
+	if !ok {
+		t.Fatal("get() missed the cached thumbnail")
+	}
+	if avg != 42 || img.Bounds() != thumb.Bounds() {
+		t.Errorf("get() = %v, %g, want %v, 42", img.Bounds(), avg, thumb.Bounds())
+	}
+	if r, g, b, _ := img.At(1, 2).RGBA(); r>>8 != 200 || g>>8 != 100 || b>>8 != 50 {
+		t.Errorf("get() pixel = %d,%d,%d, want 200,100,50", r>>8, g>>8, b>>8)
+	}
+
+	if _, _, ok := c.get(tile, info, "size=8"); ok {
+		t.Error("get() hit a thumbnail made with other options")
+	}
+
+	later := info.ModTime().Add(time.Second)
+	if err := os.Chtimes(tile, later, later); err != nil {
+		t.Fatal(err)
+	}
+	changed, _ := os.Stat(tile)
+	if _, _, ok := c.get(tile, changed, "size=4"); ok {
+		t.Error("get() hit the thumbnail of a changed file")
+	}
+}
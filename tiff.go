@@ -0,0 +1,197 @@
+package gosaic
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"image"
+	"io"
+	"os"
+)
+
+// tiffTileSize is the width and height of the tiles of tiled TIFFs
+const tiffTileSize = 256
+
+// TIFF tags and field types used by tiledTIFF
+const (
+	tiffImageWidth          = 256
+	tiffImageLength         = 257
+	tiffBitsPerSample       = 258
+	tiffCompression         = 259
+	tiffPhotometric         = 262
+	tiffSamplesPerPixel     = 277
+	tiffPlanarConfiguration = 284
+	tiffTileWidth           = 322
+	tiffTileLength          = 323
+	tiffTileOffsets         = 324
+	tiffTileByteCounts      = 325
+
+	tiffShort = 3
+	tiffLong  = 4
+	tiffLong8 = 16
+
+	tiffDeflate = 8
+	tiffRGB     = 2
+)
+
+// tiledTIFF writes an RGB BigTIFF of deflate compressed tiles band by band,
+// so images larger than the memory can be written. BigTIFF lifts the 4 GB
+// limit of TIFF files.
+type tiledTIFF struct {
+	fh             *os.File
+	width, height  int
+	rows           int
+	offsets, sizes []uint64
+	pos            uint64
+}
+
+// newTiledTIFF creates filename for an image of width x height pixels
+func newTiledTIFF(filename string, width, height int) (*tiledTIFF, error) {
+	fh, err := os.Create(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	// the offset of the directory is filled in by close
+	header := []byte{'I', 'I', 43, 0, 8, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+	if _, err := fh.Write(header); err != nil {
+		fh.Close()
+		return nil, err
+	}
+	return &tiledTIFF{fh: fh, width: width, height: height, pos: uint64(len(header))}, nil
+}
+
+// writeBand writes the next row of tiles, band has to be tiffTileSize
+// pixels high, or less for the last band, and as wide as the image.
+func (t *tiledTIFF) writeBand(band image.Image) error {
+	b := band.Bounds()
+	if b.Dx() != t.width || t.rows+b.Dy() > t.height || b.Dy() > tiffTileSize {
+		return errors.New("band doesn't fit the image")
+	}
+
+	raw := make([]byte, tiffTileSize*tiffTileSize*3)
+	for x0 := 0; x0 < t.width; x0 += tiffTileSize {
+		// tiles at the edges are padded to the full size
+		for i := range raw {
+			raw[i] = 0
+		}
+		rgba, _ := band.(*image.RGBA)
+		for y := 0; y < b.Dy(); y++ {
+			for x := 0; x < tiffTileSize && x0+x < t.width; x++ {
+				i := (y*tiffTileSize + x) * 3
+				if rgba != nil {
+					j := rgba.PixOffset(b.Min.X+x0+x, b.Min.Y+y)
+					copy(raw[i:i+3], rgba.Pix[j:j+3])
+					continue
+				}
+				r, g, bl, _ := band.At(b.Min.X+x0+x, b.Min.Y+y).RGBA()
+				raw[i], raw[i+1], raw[i+2] = uint8(r>>8), uint8(g>>8), uint8(bl>>8)
+			}
+		}
+
+		var compressed bytes.Buffer
+		zw := zlib.NewWriter(&compressed)
+		if _, err := zw.Write(raw); err != nil {
+			return err
+		}
+		if err := zw.Close(); err != nil {
+			return err
+		}
+		if _, err := t.fh.Write(compressed.Bytes()); err != nil {
+			return err
+		}
+		t.offsets = append(t.offsets, t.pos)
+		t.sizes = append(t.sizes, uint64(compressed.Len()))
+		t.pos += uint64(compressed.Len())
+	}
+	t.rows += b.Dy()
+	return nil
+}
+
+// close writes the directory of the image and closes the file
+func (t *tiledTIFF) close() error {
+	defer t.fh.Close()
+	if t.rows != t.height {
+		return errors.New("not all bands of the image have been written")
+	}
+
+	// the directory holds the tags, followed by the arrays of the tile
+	// offsets and byte counts
+	const entries = 11
+	pad := (8 - t.pos%8) % 8
+	if _, err := t.fh.Write(make([]byte, pad)); err != nil {
+		return err
+	}
+	ifd := t.pos + pad
+	arrays := ifd + 8 + entries*20 + 8
+	le := binary.LittleEndian
+
+	buf := bytes.NewBuffer([]byte{})
+	entry := func(tag, typ uint16, count uint64, value []byte) {
+		e := make([]byte, 20)
+		le.PutUint16(e[0:], tag)
+		le.PutUint16(e[2:], typ)
+		le.PutUint64(e[4:], count)
+		copy(e[12:], value)
+		buf.Write(e)
+	}
+	short := func(vs ...uint16) []byte {
+		b := make([]byte, 2*len(vs))
+		for i, v := range vs {
+			le.PutUint16(b[2*i:], v)
+		}
+		return b
+	}
+	long := func(v uint32) []byte {
+		b := make([]byte, 4)
+		le.PutUint32(b, v)
+		return b
+	}
+	long8 := func(v uint64) []byte {
+		b := make([]byte, 8)
+		le.PutUint64(b, v)
+		return b
+	}
+
+	n := uint64(len(t.offsets))
+	count := make([]byte, 8)
+	le.PutUint64(count, entries)
+	buf.Write(count)
+	entry(tiffImageWidth, tiffLong, 1, long(uint32(t.width)))
+	entry(tiffImageLength, tiffLong, 1, long(uint32(t.height)))
+	entry(tiffBitsPerSample, tiffShort, 3, short(8, 8, 8))
+	entry(tiffCompression, tiffShort, 1, short(tiffDeflate))
+	entry(tiffPhotometric, tiffShort, 1, short(tiffRGB))
+	entry(tiffSamplesPerPixel, tiffShort, 1, short(3))
+	entry(tiffPlanarConfiguration, tiffShort, 1, short(1))
+	entry(tiffTileWidth, tiffLong, 1, long(tiffTileSize))
+	entry(tiffTileLength, tiffLong, 1, long(tiffTileSize))
+	if n == 1 {
+		entry(tiffTileOffsets, tiffLong8, n, long8(t.offsets[0]))
+		entry(tiffTileByteCounts, tiffLong8, n, long8(t.sizes[0]))
+	} else {
+		entry(tiffTileOffsets, tiffLong8, n, long8(arrays))
+		entry(tiffTileByteCounts, tiffLong8, n, long8(arrays+8*n))
+	}
+	buf.Write(make([]byte, 8)) // no further directories
+	if n > 1 {
+		for _, o := range t.offsets {
+			buf.Write(long8(o))
+		}
+		for _, s := range t.sizes {
+			buf.Write(long8(s))
+		}
+	}
+
+	if _, err := t.fh.Write(buf.Bytes()); err != nil {
+		return err
+	}
+	if _, err := t.fh.Seek(8, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := t.fh.Write(long8(ifd)); err != nil {
+		return err
+	}
+	return t.fh.Close()
+}
@@ -0,0 +1,72 @@
+package gosaic
+
+import (
+	"encoding/binary"
+	"image"
+	"image/color"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestTiledTIFF(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "mosaic.tif")
+	w, h := 300, 600
+	out, err := newTiledTIFF(filename, w, h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	img.SetRGBA(299, 599, color.RGBA{1, 2, 3, 255})
+	for y := 0; y < h; y += tiffTileSize {
+		if err := out.writeBand(img.SubImage(image.Rect(0, y, w, y+tiffTileSize).Intersect(img.Bounds()))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := out.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	le := binary.LittleEndian
+	if string(data[:2]) != "II" || le.Uint16(data[2:]) != 43 {
+		t.Fatal("not a little endian BigTIFF")
+	}
+	ifd := le.Uint64(data[8:])
+	if ifd%8 != 0 {
+		t.Errorf("directory at %d isn't aligned", ifd)
+	}
+
+	tags := map[uint16][]byte{}
+	counts := map[uint16]uint64{}
+	n := le.Uint64(data[ifd:])
+	for i := uint64(0); i < n; i++ {
+		e := data[ifd+8+i*20:]
+		tags[le.Uint16(e)] = e[12:20]
+		counts[le.Uint16(e)] = le.Uint64(e[4:])
+	}
+	if got := le.Uint32(tags[tiffImageWidth]); got != uint32(w) {
+		t.Errorf("width = %d, want %d", got, w)
+	}
+	if got := le.Uint32(tags[tiffImageLength]); got != uint32(h) {
+		t.Errorf("height = %d, want %d", got, h)
+	}
+
+	// 2 columns and 3 rows of tiles, each at its offset
+	if counts[tiffTileOffsets] != 6 || counts[tiffTileByteCounts] != 6 {
+		t.Fatalf("%d tiles, want 6", counts[tiffTileOffsets])
+	}
+	offsets, sizes := le.Uint64(tags[tiffTileOffsets]), le.Uint64(tags[tiffTileByteCounts])
+	for i := uint64(0); i < 6; i++ {
+		o, s := le.Uint64(data[offsets+8*i:]), le.Uint64(data[sizes+8*i:])
+		if o+s > ifd {
+			t.Errorf("tile %d at %d+%d runs into the directory at %d", i, o, s, ifd)
+		}
+		if data[o] != 0x78 {
+			t.Errorf("tile %d isn't zlib compressed", i)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package gosaic
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/davidbyttow/govips/v2/vips"
+	log "github.com/sirupsen/logrus"
+)
+
+// Stages of the import of an image file by TileImport.Tiles
+const (
+	StageLoad   = "load"   // decoding and rotating the file
+	StageScale  = "scale"  // trimming, converting and scaling the image
+	StageEncode = "encode" // encoding the tiles and their metadata
+)
+
+// TileImport turns image files into the cached tiles of a label. It is
+// shared by redisimport and the tile uploads of the server.
+type TileImport struct {
+	Label string
+	// Sizes are the sizes the tiles are imported in, the largest first
+	Sizes []int
+	Crop  vips.Interesting
+	// NoTrim keeps the frames of TrimColor around the images, which are
+	// removed up to TrimThreshold otherwise
+	NoTrim        bool
+	TrimThreshold float64
+	TrimColor     string
+	// MinWidth, MinHeight and MaxAspect reject images which are smaller
+	// or more elongated, 0 for no limit
+	MinWidth  int
+	MinHeight int
+	MaxAspect float64
+	// Encoding is the format and quality the tiles are cached in
+	Encoding CacheEncoding
+	// TTL is how long the imported tiles are kept, 0 for ever
+	TTL time.Duration
+	// Tags are added to every imported tile
+	Tags []string
+}
+
+// NewTileImport returns the import of the tiles of label in tilesize and,
+// if it isn't 0, compareSize with the default settings
+func NewTileImport(label string, tilesize, compareSize int) *TileImport {
+	sizes := []int{tilesize}
+	if compareSize > 0 && compareSize != tilesize {
+		sizes = append(sizes, compareSize)
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(sizes)))
+
+	return &TileImport{
+		Label:         label,
+		Sizes:         sizes,
+		Crop:          vips.InterestingCentre,
+		TrimThreshold: DefaultTrimThreshold,
+		TrimColor:     DefaultTrimColor,
+		Encoding:      DefaultCacheEncoding,
+		Tags:          []string{},
+	}
+}
+
+// RejectError is returned for images which the import rejects
+type RejectError struct {
+	Reason string
+}
+
+func (e *RejectError) Error() string {
+	return "rejected: " + e.Reason
+}
+
+// Reject returns why an image of width x height isn't imported, "" if it
+// is
+func (ti *TileImport) Reject(width, height int) string {
+	if width < ti.MinWidth {
+		return fmt.Sprintf("narrower than %d pixels", ti.MinWidth)
+	}
+	if height < ti.MinHeight {
+		return fmt.Sprintf("lower than %d pixels", ti.MinHeight)
+	}
+	if ti.MaxAspect > 0 {
+		aspect := float64(width) / float64(height)
+		if aspect < 1 {
+			aspect = 1 / aspect
+		}
+		if aspect > ti.MaxAspect {
+			return fmt.Sprintf("more elongated than %g:1", ti.MaxAspect)
+		}
+	}
+	return ""
+}
+
+// UpToDate tells if the file with the content hash name is cached in c in
+// all sizes with the tags
+func (ti *TileImport) UpToDate(c TileCache, name string) bool {
+	for _, size := range ti.Sizes {
+		tiles, err := c.Get(ti.Label, size, []string{name})
+		if err != nil || tiles[0] == nil || strings.Join(tiles[0].Tags, ",") != strings.Join(ti.Tags, ",") {
+			return false
+		}
+	}
+	return true
+}
+
+// Tiles loads the image file filename and returns its tiles in all sizes,
+// named by its content hash name and recording source and the modification
+// time modified. Images which are rejected return a *RejectError. stage is
+// called with each stage when it is done if it is set.
+func (ti *TileImport) Tiles(filename, name, source string, modified int64, stage func(string)) ([]*CachedTile, error) {
+	if stage == nil {
+		stage = func(string) {}
+	}
+
+	img, err := LoadImage(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer img.Close()
+
+	if err := AutoOrient(img); err != nil {
+		return nil, err
+	}
+	stage(StageLoad)
+
+	if reason := ti.Reject(img.Width(), img.Height()); reason != "" {
+		return nil, &RejectError{Reason: reason}
+	}
+
+	// remove a frame around the picture
+	if !ti.NoTrim {
+		if err := TrimFrame(img, ti.TrimThreshold, ti.TrimColor); err != nil {
+			log.Warnf("%s: %s", filename, err)
+		}
+	}
+
+	if err := ToSRGB(img); err != nil {
+		return nil, err
+	}
+	if err := img.Thumbnail(ti.Sizes[0], ti.Sizes[0], ti.Crop); err != nil {
+		return nil, err
+	}
+	avg, err := img.Average()
+	if err != nil {
+		return nil, err
+	}
+	image, err := img.ToImage(vips.NewDefaultPNGExportParams())
+	if err != nil {
+		return nil, err
+	}
+	stage(StageScale)
+
+	tiles, err := NewCachedTiles(ti.Label, ti.Sizes, name, source, image, avg, ti.Encoding)
+	if err != nil {
+		return nil, err
+	}
+	for _, tile := range tiles {
+		tile.Modified = modified
+		tile.Tags = ti.Tags
+		if ti.TTL > 0 {
+			tile.Expires = time.Now().Add(ti.TTL).Unix()
+		}
+	}
+	stage(StageEncode)
+	return tiles, nil
+}
@@ -0,0 +1,72 @@
+package gosaic
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNewTileImport(t *testing.T) {
+	tests := []struct {
+		tilesize, compareSize int
+		want                  []int
+	}{
+		{100, 50, []int{100, 50}},
+		{50, 100, []int{100, 50}},
+		{100, 100, []int{100}},
+		{100, 0, []int{100}},
+	}
+	for _, tt := range tests {
+		if got := NewTileImport("l", tt.tilesize, tt.compareSize).Sizes; !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("sizes of %d and %d = %v, want %v", tt.tilesize, tt.compareSize, got, tt.want)
+		}
+	}
+}
+
+func TestTileImportReject(t *testing.T) {
+	ti := NewTileImport("l", 100, 50)
+	ti.MinWidth, ti.MinHeight, ti.MaxAspect = 100, 50, 2
+
+	tests := []struct {
+		width, height int
+		rejected      bool
+	}{
+		{200, 150, false},
+		{99, 150, true},
+		{200, 49, true},
+		{200, 100, false},
+		{201, 100, true},
+		{100, 201, true},
+	}
+	for _, tt := range tests {
+		if got := ti.Reject(tt.width, tt.height); (got != "") != tt.rejected {
+			t.Errorf("Reject(%d, %d) = %q, want rejected %t", tt.width, tt.height, got, tt.rejected)
+		}
+	}
+}
+
+func TestTileUploadImport(t *testing.T) {
+	valid := TileUpload{Label: "l", Tilesize: 100, Crop: CropCenter, TrimThreshold: DefaultTrimThreshold, CacheFormat: "webp", CacheQuality: 80, TTL: "72h", Tags: "Beach,2023"}
+	ti, err := valid.tileImport()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ti.TTL.Hours() != 72 || !reflect.DeepEqual(ti.Tags, []string{"beach", "2023"}) || ti.Encoding.Format != "webp" {
+		t.Errorf("import of %+v is %+v", valid, ti)
+	}
+
+	invalid := []func(*TileUpload){
+		func(u *TileUpload) { u.Crop = "sideways" },
+		func(u *TileUpload) { u.TTL = "3 days" },
+		func(u *TileUpload) { u.MaxAspect = 0.5 },
+		func(u *TileUpload) { u.TrimThreshold = -1 },
+		func(u *TileUpload) { u.CacheFormat = "gif" },
+		func(u *TileUpload) { u.CacheQuality = 0 },
+	}
+	for i, change := range invalid {
+		u := valid
+		change(&u)
+		if _, err := u.tileImport(); err == nil {
+			t.Errorf("invalid upload %d: %+v didn't fail", i, u)
+		}
+	}
+}
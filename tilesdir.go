@@ -0,0 +1,100 @@
+package gosaic
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// systemFiles are the files and directories created by operating systems
+// and NAS devices which are skipped when walking a tile directory
+var systemFiles = map[string]bool{
+	"thumbs.db":                 true,
+	"desktop.ini":               true,
+	"__macosx":                  true,
+	"@eadir":                    true,
+	"$recycle.bin":              true,
+	"system volume information": true,
+}
+
+// ParseExtensions parses a comma separated list of file extensions, with or
+// without the leading dot, into a set of lower case extensions with dots.
+func ParseExtensions(s string) map[string]bool {
+	exts := map[string]bool{}
+	for _, e := range strings.Split(s, ",") {
+		e = strings.ToLower(strings.TrimSpace(e))
+		if e == "" {
+			continue
+		}
+		if !strings.HasPrefix(e, ".") {
+			e = "." + e
+		}
+		exts[e] = true
+	}
+	return exts
+}
+
+// ListImageFiles walks the directory tree dir and returns the sorted paths
+// of the image files in it, skipping hidden and system files and
+// directories. Only files with one of exts are listed if exts isn't
+// empty, otherwise all files gosaic can read.
+func ListImageFiles(dir string, exts map[string]bool) ([]string, error) {
+	paths := []string{}
+	err := filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		name := d.Name()
+		if path != dir && (strings.HasPrefix(name, ".") || systemFiles[strings.ToLower(name)]) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() || !d.Type().IsRegular() {
+			return nil
+		}
+
+		if len(exts) > 0 && exts[strings.ToLower(filepath.Ext(name))] || len(exts) == 0 && IsImageFile(name) {
+			paths = append(paths, path)
+		}
+		return nil
+	})
+	sort.Strings(paths)
+	return paths, err
+}
+
+// tilePaths returns the paths of the tile files in TilesDir, the URLs of
+// the list TilesURLs, the frames of the videos TilesVideo, the images below
+// the blob URL or in the archive TilesGlob, or the files matching
+// TilesGlob.
+func (g *Gosaic) tilePaths() ([]string, error) {
+	if g.config.TilesDir != "" {
+		return ListImageFiles(g.config.TilesDir, ParseExtensions(g.config.TileExtensions))
+	}
+	if g.config.TilesURLs != "" {
+		return g.urlTileNames()
+	}
+	if g.config.TilesVideo != "" {
+		return g.videoTileNames()
+	}
+	if IsBlobURL(g.config.TilesGlob) {
+		return g.blobTileNames(g.config.TilesGlob)
+	}
+	if IsArchive(g.config.TilesGlob) {
+		return g.archiveTileNames(g.config.TilesGlob)
+	}
+
+	matches, err := filepath.Glob(g.config.TilesGlob)
+	if err != nil {
+		return nil, err
+	}
+	paths := make([]string, 0, len(matches))
+	for _, p := range matches {
+		if IsImageFile(p) {
+			paths = append(paths, p)
+		}
+	}
+	return paths, nil
+}
@@ -0,0 +1,55 @@
+package gosaic
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestListImageFiles(t *testing.T) {
+	dir := t.TempDir()
+	files := []string{
+		"a.jpg",
+		"b.PNG",
+		"notes.txt",
+		".hidden.jpg",
+		"Thumbs.db",
+		"sub/c.heic",
+		"sub/deeper/d.jpeg",
+		".git/e.jpg",
+		"@eaDir/a.jpg/SYNOPHOTO_THUMB_M.jpg",
+	}
+	for _, f := range files {
+		path := filepath.Join(dir, f)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		exts string
+		want []string
+	}{
+		{"", []string{"a.jpg", "b.PNG", "sub/c.heic", "sub/deeper/d.jpeg"}},
+		{"jpg, .JPEG", []string{"a.jpg", "sub/deeper/d.jpeg"}},
+	}
+
+	for _, test := range tests {
+		paths, err := ListImageFiles(dir, ParseExtensions(test.exts))
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := make([]string, len(test.want))
+		for i, f := range test.want {
+			want[i] = filepath.Join(dir, f)
+		}
+		if !reflect.DeepEqual(paths, want) {
+			t.Errorf("ListImageFiles(%q) = %v, want %v", test.exts, paths, want)
+		}
+	}
+}
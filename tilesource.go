@@ -0,0 +1,168 @@
+package gosaic
+
+import (
+	"bytes"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+
+	_ "golang.org/x/image/webp"
+)
+
+// TileSource lists the tiles of a mosaic and loads them by their id. The
+// tiles are loaded at the compare size to match them and at the size of
+// their cells to draw them. Library users can set Config.TileSource to
+// load the tiles from anywhere.
+type TileSource interface {
+	List() ([]string, error)
+	Load(id string, size int) (Tile, error)
+}
+
+// tileBatchSize is the number of tiles loaded at once from a tileBatcher
+const tileBatchSize = 500
+
+// tileBatcher is implemented by TileSources which load many tiles faster
+// at once than one by one, like the cache
+type tileBatcher interface {
+	LoadBatch(ids []string, size int) ([]Tile, []error)
+}
+
+// loadBatch loads the tiles ids of source in size, at once if source is a
+// tileBatcher. It returns the tiles and the errors of loading them.
+func loadBatch(source TileSource, ids []string, size int) ([]Tile, []error) {
+	if b, ok := source.(tileBatcher); ok {
+		return b.LoadBatch(ids, size)
+	}
+	tiles := make([]Tile, len(ids))
+	errs := make([]error, len(ids))
+	for i, id := range ids {
+		tiles[i], errs[i] = source.Load(id, size)
+	}
+	return tiles, errs
+}
+
+// fileSource is the TileSource of the tile files on disk, in archives,
+// blob stores or at URLs
+type fileSource struct {
+	g *Gosaic
+}
+
+// List returns the paths of the tile files
+func (s fileSource) List() ([]string, error) {
+	return s.g.tilePaths()
+}
+
+// Load loads the tile file id cropped to a square of size. Tiles in the
+// compare size are read from the thumbnail cache if there is one.
+func (s fileSource) Load(id string, size int) (Tile, error) {
+	if s.g.thumbs != nil && size == s.g.config.CompareSize {
+		return s.g.loadThumb(id, size)
+	}
+	return s.g.loadTileFromDisk(id, size, size)
+}
+
+// cacheSource is the TileSource of the tiles imported into the cache under
+// RedisLabel
+type cacheSource struct {
+	g *Gosaic
+}
+
+// List returns the names of the tiles in the compare size
+func (s cacheSource) List() ([]string, error) {
+	return s.g.cache.Names(s.g.config.RedisLabel, s.g.config.CompareSize)
+}
+
+// Load loads the tile id. The cache only holds the tiles in the compare
+// and the tile size, so the tile size is loaded for any other size and
+// scaled when it is painted. Tiles which were only imported in the
+// compare size are loaded in that size.
+func (s cacheSource) Load(id string, size int) (Tile, error) {
+	if size != s.g.config.CompareSize {
+		tiles, errs := s.LoadBatch([]string{id}, s.g.config.TileSize)
+		if errs[0] != ErrNotCached {
+			return tiles[0], errs[0]
+		}
+	}
+	tiles, errs := s.LoadBatch([]string{id}, s.g.config.CompareSize)
+	return tiles[0], errs[0]
+}
+
+// LoadBatch loads the tiles ids in size with a single request to the
+// cache
+func (s cacheSource) LoadBatch(ids []string, size int) ([]Tile, []error) {
+	tiles := make([]Tile, len(ids))
+	errs := make([]error, len(ids))
+
+	cached, err := s.g.cache.Get(s.g.config.RedisLabel, size, ids)
+	for i := range ids {
+		switch {
+		case err != nil:
+			errs[i] = err
+		case cached[i] == nil:
+			errs[i] = ErrNotCached
+		default:
+			tiles[i], errs[i] = s.g.decodeCachedTile(cached[i])
+		}
+	}
+	return tiles, errs
+}
+
+// decodeCachedTile decodes the JPEG, PNG or WebP of a cached tile. Its tags are kept,
+// and its features unless the tile is styled.
+func (g *Gosaic) decodeCachedTile(ct *CachedTile) (Tile, error) {
+	img, _, err := image.Decode(bytes.NewReader(ct.Data))
+	if err != nil {
+		return Tile{Filename: ct.Name}, err
+	}
+	tile, err := g.buildTile(img, ct.Name, ct.Average)
+	tile.Tags = ct.Tags
+	if err == nil && g.config.TileStyle == "" && len(ct.Features) == featureGrid*featureGrid*3 {
+		tile.Features = ct.Features
+	}
+	return tile, err
+}
+
+// cacheSpec returns the spec of the tile cache, Cache or else RedisAddr
+func (config Config) cacheSpec() string {
+	return orDefault(config.Cache, config.RedisAddr)
+}
+
+// openTileSource picks the source the tiles are loaded from: the one of
+// the config, the slices of a self mosaic, the cache if a label is given or
+// else the tile files. The cache is only connected to when it is used.
+func (g *Gosaic) openTileSource() error {
+	switch {
+	case g.config.TileSource != nil:
+		g.source = g.config.TileSource
+	case g.config.SelfMosaic:
+		if err := g.cutSelfSlices(); err != nil {
+			return err
+		}
+		g.source = selfSource{g}
+	case g.config.cacheSpec() != "" && g.config.RedisLabel != "":
+		cache, err := OpenCache(g.config.cacheSpec(), RedisOptions{Password: g.config.RedisPassword, DB: g.config.RedisDB, TLS: g.config.RedisTLS})
+		if err != nil {
+			return err
+		}
+		g.cache = cache
+		g.source = cacheSource{g}
+	default:
+		if g.config.ThumbCache != "" {
+			thumbs, err := openThumbCache(g.config.ThumbCache)
+			if err != nil {
+				return err
+			}
+			g.thumbs = thumbs
+		}
+		g.source = fileSource{g}
+	}
+	return nil
+}
+
+// Close closes the tile cache
+func (g *Gosaic) Close() error {
+	if g.cache == nil {
+		return nil
+	}
+	return g.cache.Close()
+}
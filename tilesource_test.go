@@ -0,0 +1,113 @@
+package gosaic
+
+import (
+	"bytes"
+	"container/list"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// colorSource is a TileSource of solid tiles named after their gray level
+type colorSource struct {
+	levels []uint8
+	mutex  sync.Mutex
+	sizes  []int
+}
+
+func (s *colorSource) List() ([]string, error) {
+	ids := make([]string, len(s.levels))
+	for i, l := range s.levels {
+		ids[i] = fmt.Sprintf("gray-%03d", l)
+	}
+	return ids, nil
+}
+
+func (s *colorSource) Load(id string, size int) (Tile, error) {
+	var level uint8
+	if _, err := fmt.Sscanf(id, "gray-%d", &level); err != nil {
+		return Tile{}, err
+	}
+	s.mutex.Lock()
+	s.sizes = append(s.sizes, size)
+	s.mutex.Unlock()
+	img := image.NewRGBA(image.Rect(0, 0, size, size))
+	draw.Draw(img, img.Bounds(), &image.Uniform{color.Gray{level}}, image.ZP, draw.Src)
+	return Tile{Filename: id, Tiny: img, Average: float64(level)}, nil
+}
+
+func TestConfigTileSource(t *testing.T) {
+	source := &colorSource{levels: []uint8{0, 128, 255}}
+	g := &Gosaic{
+		config:     Config{CompareSize: 8, TileSize: 32, TileSource: source},
+		Tiles:      list.New(),
+		Comparator: RGBComparator{},
+	}
+	if err := g.openTileSource(); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.loadTiles(g.source); err != nil {
+		t.Fatal(err)
+	}
+	if g.Tiles.Len() != len(source.levels) {
+		t.Errorf("loaded %d tiles, want %d", g.Tiles.Len(), len(source.levels))
+	}
+
+	tile, err := g.loadTile("gray-128", 32, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tile.Tiny.Bounds().Dx() != 32 || source.sizes[len(source.sizes)-1] != 32 {
+		t.Errorf("loadTile() loaded size %d, want 32", source.sizes[len(source.sizes)-1])
+	}
+}
+
+func TestCacheSourceLoadBatch(t *testing.T) {
+	cache, err := OpenFileCache(filepath.Join(t.TempDir(), "tiles.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+
+	img := image.NewRGBA(image.Rect(0, 0, 8, 8))
+	draw.Draw(img, img.Bounds(), &image.Uniform{color.Gray{100}}, image.ZP, draw.Src)
+	buf := bytes.NewBuffer([]byte{})
+	if err := jpeg.Encode(buf, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	features := make([]float64, featureGrid*featureGrid*3)
+	for i := range features {
+		features[i] = 0.25
+	}
+	for _, name := range []string{"a.jpg", "b.jpg"} {
+		if err := cache.Set(&CachedTile{Label: "beach", Size: 8, Name: name, Data: buf.Bytes(), Average: 100, Features: features, Tags: []string{"beach", name}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	g := &Gosaic{config: Config{CompareSize: 8, TileSize: 32, RedisLabel: "beach"}, cache: cache}
+	ids := []string{"a.jpg", "missing.jpg", "b.jpg"}
+	tiles, errs := loadBatch(cacheSource{g}, ids, 8)
+	for i, id := range ids {
+		if missing := id == "missing.jpg"; (errs[i] != nil) != missing {
+			t.Errorf("LoadBatch() error of %s = %v", id, errs[i])
+			continue
+		}
+		if errs[i] == nil && (tiles[i].Filename != id || tiles[i].Average != 100 || tiles[i].Tiny.Bounds().Dx() != 8) {
+			t.Errorf("LoadBatch() tile %s = %s, %g, %v", id, tiles[i].Filename, tiles[i].Average, tiles[i].Tiny.Bounds())
+		}
+		// the cached features are used instead of those of the pixels
+		if errs[i] == nil && !reflect.DeepEqual(tiles[i].Features, features) {
+			t.Errorf("LoadBatch() features of %s = %v, want the cached ones", id, tiles[i].Features)
+		}
+		if errs[i] == nil && !reflect.DeepEqual(tiles[i].Tags, []string{"beach", id}) {
+			t.Errorf("LoadBatch() tags of %s = %v, want the cached ones", id, tiles[i].Tags)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package gosaic
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	"math"
+)
+
+// Transform describes how a tile is turned before it is drawn. The tile is
+// mirrored horizontally first if Flip is set and then rotated clockwise by
+// Rotate quarter turns.
+type Transform struct {
+	Rotate int
+	Flip   bool
+}
+
+func (t Transform) String() string {
+	s := fmt.Sprintf("rotate %d", t.Rotate*90)
+	if t.Flip {
+		s = "flip, " + s
+	}
+	return s
+}
+
+// Inverse returns the transform that undoes t
+func (t Transform) Inverse() Transform {
+	if t.Flip {
+		// mirrored rotations are their own inverse
+		return t
+	}
+	return Transform{Rotate: (4 - t.Rotate) % 4}
+}
+
+// Variant is a compare image of a rect transformed by the inverse of
+// Transform, so comparing it to a plain tile is the same as comparing the
+// rect to the transformed tile. Features is the feature vector of Image,
+// DCT its coefficients if the DCTComparator is used and there is no mask.
+type Variant struct {
+	Transform Transform
+	Image     image.Image
+	Mask      image.Image
+	Features  []float64
+	DCT       []float64
+}
+
+// transforms returns all transforms which may be applied to the tiles
+func transforms(allowRotate, allowFlip bool) []Transform {
+	ts := []Transform{{}}
+	if allowRotate {
+		ts = append(ts, Transform{Rotate: 1}, Transform{Rotate: 2}, Transform{Rotate: 3})
+	}
+	if allowFlip {
+		ts = append(ts, Transform{Flip: true}, Transform{Flip: true, Rotate: 2})
+	}
+	if allowRotate && allowFlip {
+		ts = append(ts, Transform{Flip: true, Rotate: 1}, Transform{Flip: true, Rotate: 3})
+	}
+	return ts
+}
+
+// applyTransform returns a transformed copy of img
+func applyTransform(img image.Image, t Transform) *image.RGBA {
+	b := img.Bounds()
+	src := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(src, src.Bounds(), img, b.Min, draw.Src)
+
+	if t.Flip {
+		w := src.Bounds().Dx()
+		dst := image.NewRGBA(src.Bounds())
+		for y := 0; y < src.Bounds().Dy(); y++ {
+			for x := 0; x < w; x++ {
+				dst.SetRGBA(x, y, src.RGBAAt(w-1-x, y))
+			}
+		}
+		src = dst
+	}
+
+	for i := 0; i < t.Rotate%4; i++ {
+		w, h := src.Bounds().Dx(), src.Bounds().Dy()
+		dst := image.NewRGBA(image.Rect(0, 0, h, w))
+		for y := 0; y < w; y++ {
+			for x := 0; x < h; x++ {
+				dst.SetRGBA(x, y, src.RGBAAt(y, h-1-x))
+			}
+		}
+		src = dst
+	}
+
+	return src
+}
+
+// compareVariants returns the compare image and mask of a rect for every
+// allowed transform.
+func (g *Gosaic) compareVariants(img, mask image.Image) []Variant {
+	ts := transforms(g.config.AllowRotate, g.config.AllowFlip)
+	dct, isDCT := g.Comparator.(DCTComparator)
+	variants := make([]Variant, 0, len(ts))
+	for _, t := range ts {
+		v := Variant{Transform: t, Image: img, Mask: mask}
+		if t != (Transform{}) {
+			v.Image = applyTransform(img, t.Inverse())
+			if mask != nil {
+				v.Mask = applyTransform(mask, t.Inverse())
+			}
+		}
+		v.Features = featureVector(v.Image)
+
+		// the coefficients of masked rects depend on the tile's mask too
+		if isDCT && mask == nil {
+			v.DCT = dct.Coefficients(v.Image)
+		}
+		variants = append(variants, v)
+	}
+	return variants
+}
+
+// tileDistance compares tile to all variants of td and returns the
+// smallest distance and the transform it was found with.
+func (g *Gosaic) tileDistance(td *TileData, tile Tile) (float64, Transform, error) {
+	variants := td.Variants
+	if len(variants) == 0 {
+		variants = []Variant{{Image: td.CompareImage}}
+	}
+
+	dct, isDCT := g.Comparator.(DCTComparator)
+	minDist := math.Inf(1)
+	var minTransform Transform
+	var err error
+	for _, v := range variants {
+		if isDCT && v.DCT != nil && tile.DCT != nil {
+			if dist := dct.CoefficientDistance(v.DCT, tile.DCT); dist < minDist {
+				minDist = dist
+				minTransform = v.Transform
+			}
+			continue
+		}
+
+		var a, b image.Image = v.Image.(*image.RGBA).SubImage(td.Rect), tile.Tiny.(*image.RGBA)
+		if v.Mask != nil {
+			a = maskedImage{Image: a, mask: v.Mask}
+			b = maskedImage{Image: b, mask: v.Mask}
+		}
+
+		var dist float64
+		dist, err = g.Difference(a, b)
+		if err != nil {
+			continue
+		}
+		if dist < minDist {
+			minDist = dist
+			minTransform = v.Transform
+		}
+	}
+
+	g.mutex.Lock()
+	g.stats.Comparisons += len(variants)
+	g.mutex.Unlock()
+
+	if math.IsInf(minDist, 1) {
+		return 0.0, minTransform, err
+	}
+	return minDist, minTransform, nil
+}
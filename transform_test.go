@@ -0,0 +1,106 @@
+package gosaic
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"testing"
+)
+
+// numbered returns a w x h image whose red channel counts the pixels row
+// by row
+func numbered(w, h int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for i := 0; i < w*h; i++ {
+		img.SetRGBA(i%w, i/w, color.RGBA{R: uint8(i), A: 0xff})
+	}
+	return img
+}
+
+// rows returns the red channel of img row by row
+func rows(img *image.RGBA) [][]uint8 {
+	b := img.Bounds()
+	rs := make([][]uint8, b.Dy())
+	for y := range rs {
+		for x := 0; x < b.Dx(); x++ {
+			rs[y] = append(rs[y], img.RGBAAt(x, y).R)
+		}
+	}
+	return rs
+}
+
+func TestTransforms(t *testing.T) {
+	tests := []struct {
+		rotate, flip bool
+		want         int
+	}{
+		{false, false, 1},
+		{true, false, 4},
+		{false, true, 3},
+		{true, true, 8},
+	}
+	for _, tt := range tests {
+		ts := transforms(tt.rotate, tt.flip)
+		if len(ts) != tt.want {
+			t.Errorf("transforms(%v, %v) returned %d transforms, want %d", tt.rotate, tt.flip, len(ts), tt.want)
+		}
+		seen := map[Transform]bool{}
+		for _, tr := range ts {
+			if seen[tr] {
+				t.Errorf("transforms(%v, %v) returned %s twice", tt.rotate, tt.flip, tr)
+			}
+			seen[tr] = true
+		}
+	}
+}
+
+func TestApplyTransform(t *testing.T) {
+	// 0 1 2
+	// 3 4 5
+	img := numbered(3, 2)
+
+	tests := []struct {
+		transform Transform
+		want      string
+	}{
+		{Transform{}, "[[0 1 2] [3 4 5]]"},
+		{Transform{Rotate: 1}, "[[3 0] [4 1] [5 2]]"},
+		{Transform{Rotate: 2}, "[[5 4 3] [2 1 0]]"},
+		{Transform{Rotate: 3}, "[[2 5] [1 4] [0 3]]"},
+		{Transform{Flip: true}, "[[2 1 0] [5 4 3]]"},
+		{Transform{Flip: true, Rotate: 1}, "[[5 2] [4 1] [3 0]]"},
+	}
+	for _, tt := range tests {
+		if got := fmt.Sprint(rows(applyTransform(img, tt.transform))); got != tt.want {
+			t.Errorf("applyTransform(%s) = %s, want %s", tt.transform, got, tt.want)
+		}
+		back := applyTransform(applyTransform(img, tt.transform), tt.transform.Inverse())
+		if got, want := fmt.Sprint(rows(back)), fmt.Sprint(rows(img)); got != want {
+			t.Errorf("the inverse of %s returns %s", tt.transform, got)
+		}
+	}
+}
+
+// a rect which shows a tile turned by a quarter matches it perfectly with
+// that transform
+func TestTileDistanceTransform(t *testing.T) {
+	tile := numbered(4, 4)
+	rect := applyTransform(tile, Transform{Rotate: 1})
+
+	for _, allow := range []bool{false, true} {
+		g := &Gosaic{config: Config{AllowRotate: allow}}
+		td := &TileData{CompareImage: rect, Rect: rect.Bounds()}
+		td.Variants = g.compareVariants(rect, nil)
+
+		dist, transform, err := g.tileDistance(td, Tile{Tiny: tile})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if allow && (dist != 0 || transform != Transform{Rotate: 1}) {
+			t.Errorf("with rotations the distance is %f with %s, want 0 with rotate 90", dist, transform)
+		}
+		if !allow && (dist == 0 || transform != Transform{}) {
+			t.Errorf("without rotations the distance is %f with %s", dist, transform)
+		}
+	}
+}
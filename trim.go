@@ -0,0 +1,42 @@
+package gosaic
+
+import (
+	"github.com/davidbyttow/govips/v2/vips"
+)
+
+// Defaults of the frame removed around the tiles
+const (
+	DefaultTrimThreshold = 40
+	DefaultTrimColor     = "#ffffff"
+)
+
+// TrimFrame removes a frame of the hex color around img, the rows and
+// columns at the edges whose pixels differ from it by less than threshold.
+func TrimFrame(img *vips.ImageRef, threshold float64, frame string) error {
+	c, err := parseHexColor(frame)
+	if err != nil {
+		return err
+	}
+
+	left, top, width, height, err := img.FindTrim(threshold, &vips.Color{R: c.R, G: c.G, B: c.B})
+	if err != nil {
+		return err
+	}
+	if width < img.Width() || height < img.Height() {
+		return img.ExtractArea(left, top, width, height)
+	}
+	return nil
+}
+
+// trimFrame removes the configured frame around the tile img unless
+// NoTrim is set
+func (g *Gosaic) trimFrame(img *vips.ImageRef) error {
+	if g.config.NoTrim {
+		return nil
+	}
+	threshold := g.config.TrimThreshold
+	if threshold == 0 {
+		threshold = DefaultTrimThreshold
+	}
+	return TrimFrame(img, threshold, orDefault(g.config.TrimColor, DefaultTrimColor))
+}
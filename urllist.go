@@ -0,0 +1,164 @@
+package gosaic
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Downloads of tiles from URLs
+const (
+	downloadRetries = 3
+	downloadBackoff = time.Second
+	downloadTimeout = 60 * time.Second
+)
+
+// IsURL tells if name is an http or https URL
+func IsURL(name string) bool {
+	return strings.HasPrefix(name, "http://") || strings.HasPrefix(name, "https://")
+}
+
+// parseURLList parses a list of tile URLs, either a JSON array of URLs or
+// of objects with a url, or a text file with a URL per line. Empty lines
+// and lines starting with # are skipped.
+func parseURLList(data []byte) ([]string, error) {
+	trimmed := bytes.TrimSpace(data)
+	if bytes.HasPrefix(trimmed, []byte("[")) {
+		var items []json.RawMessage
+		if err := json.Unmarshal(trimmed, &items); err != nil {
+			return nil, err
+		}
+		urls := make([]string, 0, len(items))
+		for _, item := range items {
+			var u string
+			if err := json.Unmarshal(item, &u); err != nil {
+				var obj struct {
+					URL string `json:"url"`
+				}
+				if err := json.Unmarshal(item, &obj); err != nil {
+					return nil, err
+				}
+				u = obj.URL
+			}
+			if !IsURL(u) {
+				return nil, fmt.Errorf("%q is not an http or https URL", u)
+			}
+			urls = append(urls, u)
+		}
+		return urls, nil
+	}
+
+	urls := []string{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if !IsURL(line) {
+			return nil, fmt.Errorf("%q is not an http or https URL", line)
+		}
+		urls = append(urls, line)
+	}
+	return urls, scanner.Err()
+}
+
+// rateLimiter spaces out events to at most rate per second
+type rateLimiter struct {
+	mutex    sync.Mutex
+	interval time.Duration
+	next     time.Time
+}
+
+// newRateLimiter returns a limiter of rate events per second, a rate of 0
+// doesn't limit.
+func newRateLimiter(rate float64) *rateLimiter {
+	l := &rateLimiter{}
+	if rate > 0 {
+		l.interval = time.Duration(float64(time.Second) / rate)
+	}
+	return l
+}
+
+// wait blocks until the next event is allowed
+func (l *rateLimiter) wait() {
+	if l.interval == 0 {
+		return
+	}
+	l.mutex.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	at := l.next
+	l.next = l.next.Add(l.interval)
+	l.mutex.Unlock()
+
+	time.Sleep(time.Until(at))
+}
+
+// downloader downloads tiles with retries, at a limited rate
+type downloader struct {
+	once    sync.Once
+	client  *http.Client
+	limiter *rateLimiter
+	backoff time.Duration
+}
+
+// download fetches u, retrying failed requests, server errors and rate
+// limited requests with an exponential backoff.
+func (d *downloader) download(u string) ([]byte, error) {
+	var lastErr error
+	for attempt := 0; attempt < downloadRetries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(d.backoff << uint(attempt-1))
+		}
+		d.limiter.wait()
+
+		resp, err := d.client.Get(u)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		data, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		switch {
+		case err != nil:
+			lastErr = err
+		case resp.StatusCode == http.StatusOK:
+			return data, nil
+		case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+			lastErr = fmt.Errorf("%s: %s", u, resp.Status)
+		default:
+			return nil, fmt.Errorf("%s: %s", u, resp.Status)
+		}
+	}
+	return nil, lastErr
+}
+
+// urlTileNames reads the tile URLs of the list in TilesURLs
+func (g *Gosaic) urlTileNames() ([]string, error) {
+	data, err := ioutil.ReadFile(g.config.TilesURLs)
+	if err != nil {
+		return nil, err
+	}
+	return parseURLList(data)
+}
+
+// readURL downloads the tile at the URL u
+func (g *Gosaic) readURL(u string) ([]byte, error) {
+	g.downloads.once.Do(func() {
+		g.downloads.client = &http.Client{Timeout: downloadTimeout}
+		g.downloads.limiter = newRateLimiter(g.config.DownloadRate)
+		g.downloads.backoff = downloadBackoff
+	})
+	return g.readCached(u, func() ([]byte, error) {
+		return g.downloads.download(u)
+	})
+}
@@ -0,0 +1,89 @@
+package gosaic
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseURLList(t *testing.T) {
+	tests := []struct {
+		data string
+		want []string
+		err  bool
+	}{
+		{"# tiles\nhttps://cdn.example.com/a.jpg\n\n  http://example.com/b.png  \n", []string{"https://cdn.example.com/a.jpg", "http://example.com/b.png"}, false},
+		{`["https://cdn.example.com/a.jpg", {"url": "https://cdn.example.com/b.jpg", "id": 2}]`, []string{"https://cdn.example.com/a.jpg", "https://cdn.example.com/b.jpg"}, false},
+		{"", []string{}, false},
+		{"a.jpg\n", nil, true},
+		{`[{"url": "ftp://example.com/a.jpg"}]`, nil, true},
+		{`["https://cdn.example.com/a.jpg"`, nil, true},
+	}
+
+	for _, test := range tests {
+		got, err := parseURLList([]byte(test.data))
+		if (err != nil) != test.err {
+			t.Errorf("parseURLList(%q) error = %v", test.data, err)
+			continue
+		}
+		if !test.err && !reflect.DeepEqual(got, test.want) {
+			t.Errorf("parseURLList(%q) = %v, want %v", test.data, got, test.want)
+		}
+	}
+}
+
+func TestDownload(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		switch {
+		case r.URL.Path == "/missing.jpg":
+			http.NotFound(w, r)
+		case r.URL.Path == "/flaky.jpg" && requests == 1:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case r.URL.Path == "/busy.jpg":
+			w.WriteHeader(http.StatusTooManyRequests)
+		default:
+			w.Write([]byte("tile"))
+		}
+	}))
+	defer server.Close()
+
+	d := &downloader{client: server.Client(), limiter: newRateLimiter(0), backoff: time.Millisecond}
+	tests := []struct {
+		path     string
+		err      bool
+		requests int
+	}{
+		{"/flaky.jpg", false, 2},
+		{"/missing.jpg", true, 1},
+		{"/busy.jpg", true, downloadRetries},
+	}
+
+	for _, test := range tests {
+		requests = 0
+		data, err := d.download(server.URL + test.path)
+		if (err != nil) != test.err {
+			t.Errorf("download(%s) error = %v", test.path, err)
+		}
+		if !test.err && string(data) != "tile" {
+			t.Errorf("download(%s) = %q, want tile", test.path, data)
+		}
+		if requests != test.requests {
+			t.Errorf("download(%s) made %d requests, want %d", test.path, requests, test.requests)
+		}
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	l := newRateLimiter(100)
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		l.wait()
+	}
+	if elapsed := time.Since(start); elapsed < 40*time.Millisecond {
+		t.Errorf("5 events at 100/s took %s, want at least 40ms", elapsed)
+	}
+}
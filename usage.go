@@ -0,0 +1,95 @@
+package gosaic
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// CellRef is the grid coordinate of a cell
+type CellRef struct {
+	X int `json:"x"`
+	Y int `json:"y"`
+}
+
+// TileUsage lists the cells at which a tile has been placed
+type TileUsage struct {
+	Filename string    `json:"filename"`
+	Uses     int       `json:"uses"`
+	Cells    []CellRef `json:"cells"`
+}
+
+// UsageReport lists the tiles placed in the mosaic, the most used first,
+// and the tiles of the library which have never been placed.
+type UsageReport struct {
+	Used   []TileUsage `json:"used"`
+	Unused []string    `json:"unused"`
+}
+
+// usageReport collects the placements of the tiles of library
+func (p *placementMap) usageReport(library []string) UsageReport {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	report := UsageReport{Used: []TileUsage{}, Unused: []string{}}
+	for filename, cells := range p.cells {
+		usage := TileUsage{Filename: filename, Uses: len(cells), Cells: make([]CellRef, len(cells))}
+		for i, c := range cells {
+			usage.Cells[i] = CellRef{X: c.X, Y: c.Y}
+		}
+		report.Used = append(report.Used, usage)
+	}
+	sort.Slice(report.Used, func(i, j int) bool {
+		if report.Used[i].Uses != report.Used[j].Uses {
+			return report.Used[i].Uses > report.Used[j].Uses
+		}
+		return report.Used[i].Filename < report.Used[j].Filename
+	})
+
+	for _, filename := range library {
+		if len(p.cells[filename]) == 0 {
+			report.Unused = append(report.Unused, filename)
+		}
+	}
+	sort.Strings(report.Unused)
+
+	return report
+}
+
+// saveUsageReport writes the usage report as CSV if filename ends in .csv
+// and as JSON otherwise. The CSV has a row per tile with its filename, its
+// number of uses and its cells as x/y pairs separated by spaces, unused
+// tiles have 0 uses.
+func saveUsageReport(report UsageReport, filename string) error {
+	fh, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer fh.Close()
+
+	if strings.ToLower(filepath.Ext(filename)) != ".csv" {
+		enc := json.NewEncoder(fh)
+		enc.SetIndent("", "  ")
+		return enc.Encode(report)
+	}
+
+	w := csv.NewWriter(fh)
+	w.Write([]string{"filename", "uses", "cells"})
+	for _, u := range report.Used {
+		cells := make([]string, len(u.Cells))
+		for i, c := range u.Cells {
+			cells[i] = fmt.Sprintf("%d/%d", c.X, c.Y)
+		}
+		w.Write([]string{u.Filename, strconv.Itoa(u.Uses), strings.Join(cells, " ")})
+	}
+	for _, filename := range report.Unused {
+		w.Write([]string{filename, "0", ""})
+	}
+	w.Flush()
+	return w.Error()
+}
@@ -0,0 +1,106 @@
+package gosaic
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// FFmpeg is the command frames are extracted from videos with
+var FFmpeg = "ffmpeg"
+
+// DefaultVideoFrameStep extracts a frame per second of a 30 fps video
+const DefaultVideoFrameStep = 30
+
+// framesDone marks a directory whose frames have all been extracted
+const framesDone = ".done"
+
+// videoFrameArgs returns the ffmpeg arguments which extract every step-th
+// frame of video into JPEGs named after pattern.
+func videoFrameArgs(video, pattern string, step int) []string {
+	return []string{
+		"-nostdin", "-loglevel", "error",
+		"-i", video,
+		"-vf", fmt.Sprintf(`select=not(mod(n\,%d))`, step),
+		"-vsync", "vfr",
+		"-q:v", "2",
+		pattern,
+	}
+}
+
+// framesDir returns the directory the frames of video are extracted to,
+// below TilesCache or the temporary directory. It depends on the video and
+// the step, so the frames are only extracted once.
+func (g *Gosaic) framesDir(video string, step int) (string, error) {
+	abs, err := filepath.Abs(video)
+	if err != nil {
+		return "", err
+	}
+	sum := sha1.Sum([]byte(fmt.Sprintf("%s:%d", abs, step)))
+	return filepath.Join(orDefault(g.config.TilesCache, os.TempDir()), "gosaic-frames-"+hex.EncodeToString(sum[:8])), nil
+}
+
+// extractFrames extracts every step-th frame of video with FFmpeg and
+// returns the frame files.
+func (g *Gosaic) extractFrames(video string, step int) ([]string, error) {
+	dir, err := g.framesDir(video, step)
+	if err != nil {
+		return nil, err
+	}
+	name := strings.TrimSuffix(filepath.Base(video), filepath.Ext(video))
+	pattern := filepath.Join(dir, name+"-%06d.jpg")
+
+	if _, err := os.Stat(filepath.Join(dir, framesDone)); err != nil {
+		path, err := exec.LookPath(FFmpeg)
+		if err != nil {
+			return nil, fmt.Errorf("%s: extracting frames needs %s: %s", video, FFmpeg, err)
+		}
+		if err := os.RemoveAll(dir); err != nil {
+			return nil, err
+		}
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, err
+		}
+
+		var stderr bytes.Buffer
+		cmd := exec.Command(path, videoFrameArgs(video, pattern, step)...)
+		cmd.Stderr = &stderr
+		if err := cmd.Run(); err != nil {
+			return nil, fmt.Errorf("%s: %s: %s", video, err, strings.TrimSpace(stderr.String()))
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, framesDone), nil, 0644); err != nil {
+			return nil, err
+		}
+	}
+
+	return filepath.Glob(filepath.Join(dir, name+"-*.jpg"))
+}
+
+// videoTileNames extracts the frames of the comma separated videos in
+// TilesVideo to use them as tiles
+func (g *Gosaic) videoTileNames() ([]string, error) {
+	step := g.config.VideoFrameStep
+	if step <= 0 {
+		step = DefaultVideoFrameStep
+	}
+
+	names := []string{}
+	for _, video := range strings.Split(g.config.TilesVideo, ",") {
+		video = strings.TrimSpace(video)
+		if video == "" {
+			continue
+		}
+		frames, err := g.extractFrames(video, step)
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, frames...)
+	}
+	return names, nil
+}
@@ -0,0 +1,35 @@
+package gosaic
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestVideoFrameArgs(t *testing.T) {
+	got := videoFrameArgs("movie.mp4", "/tmp/frames/movie-%06d.jpg", 24)
+	want := []string{
+		"-nostdin", "-loglevel", "error",
+		"-i", "movie.mp4",
+		"-vf", `select=not(mod(n\,24))`,
+		"-vsync", "vfr",
+		"-q:v", "2",
+		"/tmp/frames/movie-%06d.jpg",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("videoFrameArgs() = %v, want %v", got, want)
+	}
+}
+
+func TestFramesDir(t *testing.T) {
+	g := &Gosaic{config: Config{TilesCache: "/cache"}}
+	a, _ := g.framesDir("movie.mp4", 30)
+	b, _ := g.framesDir("movie.mp4", 30)
+	c, _ := g.framesDir("movie.mp4", 10)
+	d, _ := g.framesDir("trailer.mp4", 30)
+	if a != b {
+		t.Errorf("framesDir() isn't stable: %s != %s", a, b)
+	}
+	if a == c || a == d {
+		t.Errorf("framesDir() is shared by different videos or steps: %s, %s, %s", a, c, d)
+	}
+}
@@ -0,0 +1,177 @@
+package gosaic
+
+import (
+	"image"
+	"image/color"
+	"math"
+)
+
+// voronoiCells tessellates the seed image into the voronoi regions of
+// randomly placed sites. Every region is covered by a single tile masked to
+// the shape of the region.
+func (g *Gosaic) voronoiCells() []cell {
+	bounds := g.SeedImage.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	ts := g.config.TileSize
+
+	n := g.config.VoronoiSites
+	if n <= 0 {
+		n = w * h / (ts * ts)
+	}
+	if n < 1 {
+		n = 1
+	}
+
+	var sites []image.Point
+	if g.config.VoronoiSaliency {
+		sites = g.salientSites(n)
+	} else {
+		sites = make([]image.Point, n)
+		for i := range sites {
+			sites[i] = image.Pt(g.rand.Intn(w), g.rand.Intn(h))
+		}
+	}
+
+	labels := voronoiLabels(w, h, sites)
+
+	boxes := make([]image.Rectangle, len(sites))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			i := labels[y*w+x]
+			px := image.Rect(x, y, x+1, y+1)
+			if boxes[i].Empty() {
+				boxes[i] = px
+			} else {
+				boxes[i] = boxes[i].Union(px)
+			}
+		}
+	}
+
+	// tiles are square, so center each region in a square
+	cells := make([]cell, 0, len(sites))
+	masks := make([]*image.Alpha, len(sites))
+	origins := make([]image.Point, len(sites))
+	for i, box := range boxes {
+		if box.Empty() {
+			continue
+		}
+
+		size := box.Dx()
+		if box.Dy() > size {
+			size = box.Dy()
+		}
+		min := image.Pt(box.Min.X-(size-box.Dx())/2, box.Min.Y-(size-box.Dy())/2)
+		r := image.Rectangle{Min: min, Max: min.Add(image.Pt(size, size))}
+
+		origins[i] = min
+		masks[i] = image.NewAlpha(image.Rect(0, 0, size, size))
+		cells = append(cells, cell{
+			X:    sites[i].X / ts,
+			Y:    sites[i].Y / ts,
+			Rect: r.Add(bounds.Min),
+			Mask: masks[i],
+		})
+	}
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			i := labels[y*w+x]
+			masks[i].SetAlpha(x-origins[i].X, y-origins[i].Y, color.Alpha{A: 0xff})
+		}
+	}
+
+	return cells
+}
+
+// salientSites places n sites with a density proportional to the detail of
+// the seed image.
+func (g *Gosaic) salientSites(n int) []image.Point {
+	bounds := g.SeedImage.Bounds()
+	block := g.config.TileSize / 2
+	if block < 1 {
+		block = 1
+	}
+
+	blocks := make([]image.Rectangle, 0)
+	weights := make([]float64, 0)
+	total := 0.0
+	for y := bounds.Min.Y; y < bounds.Max.Y; y += block {
+		for x := bounds.Min.X; x < bounds.Max.X; x += block {
+			r := image.Rect(x, y, x+block, y+block).Intersect(bounds)
+			weight := g.variance(r) + 0.01
+			blocks = append(blocks, r)
+			weights = append(weights, weight)
+			total += weight
+		}
+	}
+
+	sites := make([]image.Point, n)
+	for i := range sites {
+		pick := g.rand.Float64() * total
+		b := len(blocks) - 1
+		for j, weight := range weights {
+			if pick < weight {
+				b = j
+				break
+			}
+			pick -= weight
+		}
+
+		r := blocks[b]
+		sites[i] = image.Pt(r.Min.X-bounds.Min.X+g.rand.Intn(r.Dx()), r.Min.Y-bounds.Min.Y+g.rand.Intn(r.Dy()))
+	}
+
+	return sites
+}
+
+// voronoiLabels returns the index of the nearest site for every pixel of a
+// w x h image. Sites are bucketed into a grid so only the buckets around a
+// pixel need to be searched.
+func voronoiLabels(w, h int, sites []image.Point) []int32 {
+	s := int(math.Sqrt(float64(w*h) / float64(len(sites))))
+	if s < 1 {
+		s = 1
+	}
+	gw, gh := w/s+1, h/s+1
+
+	buckets := make([][]int32, gw*gh)
+	for i, p := range sites {
+		b := (p.Y/s)*gw + p.X/s
+		buckets[b] = append(buckets[b], int32(i))
+	}
+
+	labels := make([]int32, w*h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			bx, by := x/s, y/s
+			best := int32(-1)
+			bestDist := math.MaxInt64
+
+			for r := 0; ; r++ {
+				for gy := by - r; gy <= by+r; gy++ {
+					for gx := bx - r; gx <= bx+r; gx++ {
+						// only visit the buckets on the ring of radius r
+						if gx < 0 || gy < 0 || gx >= gw || gy >= gh || (abs(gx-bx) != r && abs(gy-by) != r) {
+							continue
+						}
+						for _, i := range buckets[gy*gw+gx] {
+							dx, dy := sites[i].X-x, sites[i].Y-y
+							if d := dx*dx + dy*dy; d < bestDist {
+								bestDist = d
+								best = i
+							}
+						}
+					}
+				}
+
+				if (best >= 0 && bestDist <= r*s*r*s) || (r > gw && r > gh) {
+					break
+				}
+			}
+
+			labels[y*w+x] = best
+		}
+	}
+
+	return labels
+}
@@ -0,0 +1,77 @@
+package gosaic
+
+import (
+	"fmt"
+	"image"
+	"math/rand"
+	"testing"
+)
+
+func TestVoronoiLabels(t *testing.T) {
+	// two sites split a row at their midpoint
+	labels := voronoiLabels(10, 1, []image.Point{{1, 0}, {8, 0}})
+	if got := fmt.Sprint(labels); got != "[0 0 0 0 0 1 1 1 1 1]" {
+		t.Errorf("voronoiLabels of two sites = %s", got)
+	}
+
+	// sites clustered in a corner leave most buckets empty, so the search
+	// has to widen its ring far
+	rnd := rand.New(rand.NewSource(1))
+	sites := make([]image.Point, 40)
+	for i := range sites {
+		sites[i] = image.Pt(rnd.Intn(8), rnd.Intn(8))
+	}
+	sites = append(sites, image.Pt(79, 59))
+	sqDist := func(p image.Point, x, y int) int {
+		return (p.X-x)*(p.X-x) + (p.Y-y)*(p.Y-y)
+	}
+
+	labels = voronoiLabels(80, 60, sites)
+	for y := 0; y < 60; y++ {
+		for x := 0; x < 80; x++ {
+			nearest := sqDist(sites[0], x, y)
+			for _, s := range sites {
+				if d := sqDist(s, x, y); d < nearest {
+					nearest = d
+				}
+			}
+			// ties may go to either site
+			if got := sqDist(sites[labels[y*80+x]], x, y); got != nearest {
+				t.Fatalf("pixel %d/%d is labeled with a site %d away, the nearest is %d away", x, y, got, nearest)
+			}
+		}
+	}
+}
+
+// the masks of the regions cover every pixel of the seed exactly once
+func TestVoronoiCells(t *testing.T) {
+	g := &Gosaic{SeedImage: image.NewRGBA(image.Rect(0, 0, 60, 40)), config: Config{TileSize: 10, VoronoiSites: 12}, rand: rand.New(rand.NewSource(1))}
+	cells := g.voronoiCells()
+	if len(cells) == 0 || len(cells) > 12 {
+		t.Fatalf("%d cells of 12 sites", len(cells))
+	}
+
+	counts := make([]int, 60*40)
+	for _, c := range cells {
+		if c.Rect.Dx() != c.Rect.Dy() {
+			t.Errorf("cell %v isn't square", c.Rect)
+		}
+		mask := c.Mask.(*image.Alpha)
+		for y := c.Rect.Min.Y; y < c.Rect.Max.Y; y++ {
+			for x := c.Rect.Min.X; x < c.Rect.Max.X; x++ {
+				if mask.AlphaAt(x-c.Rect.Min.X, y-c.Rect.Min.Y).A == 0 {
+					continue
+				}
+				if x < 0 || y < 0 || x >= 60 || y >= 40 {
+					t.Fatalf("cell %v is opaque outside the seed at %d/%d", c.Rect, x, y)
+				}
+				counts[y*60+x]++
+			}
+		}
+	}
+	for i, n := range counts {
+		if n != 1 {
+			t.Fatalf("pixel %d/%d is covered by %d regions", i%60, i/60, n)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package gosaic
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+
+	"github.com/davidbyttow/govips/v2/vips"
+)
+
+// Positions of the watermark
+const (
+	PositionTopLeft     = "topleft"
+	PositionTop         = "top"
+	PositionTopRight    = "topright"
+	PositionLeft        = "left"
+	PositionCenter      = "center"
+	PositionRight       = "right"
+	PositionBottomLeft  = "bottomleft"
+	PositionBottom      = "bottom"
+	PositionBottomRight = "bottomright"
+)
+
+// DefaultWatermarkOpacity is the opacity of the watermark
+const DefaultWatermarkOpacity = 0.5
+
+const (
+	// maxWatermarkWidth is the largest width of the watermark relative to
+	// the mosaic, larger watermarks are scaled down
+	maxWatermarkWidth = 0.25
+	// watermarkMargin is the distance of the watermark to the edges of the
+	// mosaic relative to its shorter side
+	watermarkMargin = 0.02
+)
+
+// watermarkOrigin returns the top left corner of a w x h watermark at pos
+// in b, keeping margin pixels to the edges.
+func watermarkOrigin(b image.Rectangle, w, h, margin int, pos string) image.Point {
+	x := b.Min.X + (b.Dx()-w)/2
+	y := b.Min.Y + (b.Dy()-h)/2
+	switch pos {
+	case PositionTopLeft, PositionLeft, PositionBottomLeft:
+		x = b.Min.X + margin
+	case PositionTopRight, PositionRight, PositionBottomRight, "":
+		x = b.Max.X - margin - w
+	}
+	switch pos {
+	case PositionTopLeft, PositionTop, PositionTopRight:
+		y = b.Min.Y + margin
+	case PositionBottomLeft, PositionBottom, PositionBottomRight, "":
+		y = b.Max.Y - margin - h
+	}
+	return image.Pt(x, y)
+}
+
+// loadWatermark loads the watermark image in sRGB
+func loadWatermark(filename string) (image.Image, error) {
+	imgRef, err := vips.NewImageFromFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer imgRef.Close()
+
+	if err := ToSRGB(imgRef); err != nil {
+		return nil, err
+	}
+	return imgRef.ToImage(vips.NewDefaultPNGExportParams())
+}
+
+// drawWatermark composites the watermark onto the mosaic at the configured
+// position and opacity, keeping the transparency of the watermark.
+func (g *Gosaic) drawWatermark() error {
+	wm, err := loadWatermark(g.config.Watermark)
+	if err != nil {
+		return err
+	}
+
+	b := g.SeedImage.Bounds()
+	wb := wm.Bounds()
+	if maxW := int(float64(b.Dx()) * maxWatermarkWidth); wb.Dx() > maxW && maxW > 0 {
+		h := int(math.Round(float64(wb.Dy()) * float64(maxW) / float64(wb.Dx())))
+		if h < 1 {
+			h = 1
+		}
+		wm = scaleImage(wm, maxW, h)
+		wb = wm.Bounds()
+	}
+
+	opacity := g.config.WatermarkOpacity
+	if opacity <= 0 || opacity > 1 {
+		opacity = DefaultWatermarkOpacity
+	}
+	mask := image.NewUniform(color.Alpha{uint8(math.Round(opacity * 0xff))})
+
+	shorter := math.Min(float64(b.Dx()), float64(b.Dy()))
+	origin := watermarkOrigin(b, wb.Dx(), wb.Dy(), int(shorter*watermarkMargin), g.config.WatermarkPos)
+	draw.DrawMask(g.SeedImage, image.Rectangle{origin, origin.Add(wb.Size())}, wm, wb.Min, mask, image.ZP, draw.Over)
+	return nil
+}
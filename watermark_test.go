@@ -0,0 +1,28 @@
+package gosaic
+
+import (
+	"image"
+	"testing"
+)
+
+func TestWatermarkOrigin(t *testing.T) {
+	b := image.Rect(0, 0, 100, 50)
+	tests := []struct {
+		pos  string
+		want image.Point
+	}{
+		{PositionTopLeft, image.Pt(5, 5)},
+		{PositionTop, image.Pt(40, 5)},
+		{PositionRight, image.Pt(75, 20)},
+		{PositionCenter, image.Pt(40, 20)},
+		{PositionBottomLeft, image.Pt(5, 35)},
+		{PositionBottomRight, image.Pt(75, 35)},
+		{"", image.Pt(75, 35)},
+	}
+
+	for _, test := range tests {
+		if got := watermarkOrigin(b, 20, 10, 5, test.pos); got != test.want {
+			t.Errorf("watermarkOrigin(%q) = %v, want %v", test.pos, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package gosaic
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// WebhookSignatureHeader carries the HMAC-SHA256 of the body of a webhook
+// with the secret of the server, like "sha256=<hex>"
+const WebhookSignatureHeader = "X-Gosaic-Signature"
+
+const (
+	// webhookAttempts is how often a webhook is posted before giving up
+	webhookAttempts = 3
+	// webhookTimeout limits each attempt
+	webhookTimeout = 10 * time.Second
+)
+
+// WebhookOptions configure the webhooks of the jobs
+type WebhookOptions struct {
+	// Secret signs the webhooks if it is set
+	Secret string
+	// Allow lists the networks of loopback, private and link-local
+	// addresses webhooks may still be posted to
+	Allow []*net.IPNet
+}
+
+// errWebhookAddress is returned for webhooks to addresses which aren't
+// allowed
+var errWebhookAddress = errors.New("webhooks to loopback, private and link-local addresses aren't allowed")
+
+// privateNetworks are the private and shared address ranges webhooks
+// aren't posted to unless they are allowed
+var privateNetworks = parseNetworks("10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "100.64.0.0/10", "fc00::/7")
+
+// parseNetworks parses the CIDRs of trusted constants
+func parseNetworks(cidrs ...string) []*net.IPNet {
+	nets := make([]*net.IPNet, len(cidrs))
+	for i, cidr := range cidrs {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		nets[i] = n
+	}
+	return nets
+}
+
+// ParseWebhookAllow parses the comma separated networks and addresses of
+// spec, like "10.1.0.0/16,192.168.1.5", for WebhookOptions.Allow
+func ParseWebhookAllow(spec string) ([]*net.IPNet, error) {
+	nets := []*net.IPNet{}
+	for _, s := range strings.Split(spec, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		if ip := net.ParseIP(s); ip != nil {
+			bits := 8 * len(ip)
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 32
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// webhookAllowed tells if webhooks may be posted to ip: public addresses
+// and those of the allowed networks
+func webhookAllowed(ip net.IP, allow []*net.IPNet) bool {
+	for _, n := range allow {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	if ip.IsLoopback() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsMulticast() {
+		return false
+	}
+	for _, n := range privateNetworks {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// webhookClient returns the client posting the webhooks. The addresses
+// the host names resolve to are checked when connecting, for every
+// attempt and redirect, so a callback can't reach the internal network.
+func webhookClient(allow []*net.IPNet) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: webhookTimeout,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !webhookAllowed(ip, allow) {
+				return fmt.Errorf("%s: %w", host, errWebhookAddress)
+			}
+			return nil
+		},
+	}
+	return &http.Client{
+		Timeout:   webhookTimeout,
+		Transport: &http.Transport{DialContext: dialer.DialContext, TLSHandshakeTimeout: webhookTimeout},
+	}
+}
+
+// WebhookEvent is posted to the callback URL of a job when it is finished
+type WebhookEvent struct {
+	Job Job `json:"job"`
+	// ResultURL is where the mosaic can be fetched if the job is done
+	ResultURL string `json:"result_url,omitempty"`
+}
+
+// checkCallback tells if callback is an absolute http or https URL which
+// isn't a loopback, private or link-local address outside of allow. Host
+// names are only checked when the webhook is posted.
+func checkCallback(callback string, allow []*net.IPNet) error {
+	u, err := url.ParseRequestURI(callback)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("the callback %q isn't an http or https URL", callback)
+	}
+	if ip := net.ParseIP(u.Hostname()); ip != nil && !webhookAllowed(ip, allow) {
+		return fmt.Errorf("the callback %q: %w", callback, errWebhookAddress)
+	}
+	return nil
+}
+
+// webhookSignature returns the signature of body with secret
+func webhookSignature(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// postWebhook posts event as JSON to callback, signed with secret if it is
+// set. Failed attempts are retried after backoff, doubled every time.
+func postWebhook(client *http.Client, callback, secret string, event WebhookEvent, backoff time.Duration) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	for attempt := 1; ; attempt++ {
+		err = func() error {
+			req, err := http.NewRequest(http.MethodPost, callback, bytes.NewReader(body))
+			if err != nil {
+				return err
+			}
+			req.Header.Set("Content-Type", "application/json")
+			if secret != "" {
+				req.Header.Set(WebhookSignatureHeader, webhookSignature(secret, body))
+			}
+			resp, err := client.Do(req)
+			if err != nil {
+				return err
+			}
+			resp.Body.Close()
+			if resp.StatusCode < 200 || resp.StatusCode > 299 {
+				return errors.New(resp.Status)
+			}
+			return nil
+		}()
+		if err == nil || attempt == webhookAttempts {
+			return err
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
@@ -0,0 +1,117 @@
+package gosaic
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheckCallback(t *testing.T) {
+	allow, err := ParseWebhookAllow("10.1.0.0/16, 127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		callback string
+		allow    []*net.IPNet
+		valid    bool
+	}{
+		{"https://example.com/hooks/gosaic", nil, true},
+		{"http://93.184.216.34/hook", nil, true},
+		{"http://10.0.0.1:8080/", nil, false},
+		{"http://172.20.1.1/", nil, false},
+		{"http://192.168.1.1/", nil, false},
+		{"http://127.0.0.1:8080/", nil, false},
+		{"http://[::1]/", nil, false},
+		{"http://169.254.169.254/latest/meta-data/", nil, false},
+		{"http://0.0.0.0/", nil, false},
+		{"http://[fd00::1]/", nil, false},
+		{"http://10.1.2.3:8080/", allow, true},
+		{"http://127.0.0.1:8080/", allow, true},
+		{"http://10.0.0.1:8080/", allow, false},
+		{"ftp://example.com/", nil, false},
+		{"example.com/hook", nil, false},
+		{"https:///hook", nil, false},
+		{"", nil, false},
+	}
+	for _, tt := range tests {
+		if err := checkCallback(tt.callback, tt.allow); (err == nil) != tt.valid {
+			t.Errorf("checkCallback(%q, %v) = %v, want valid %t", tt.callback, tt.allow, err, tt.valid)
+		}
+	}
+
+	if _, err := ParseWebhookAllow("10.0.0.0/33"); err == nil {
+		t.Error("ParseWebhookAllow of an invalid network didn't fail")
+	}
+}
+
+// the webhook client refuses to connect to addresses a host name resolves
+// to which aren't allowed
+func TestWebhookClient(t *testing.T) {
+	posted := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		posted++
+	}))
+	defer srv.Close()
+	callback := strings.Replace(srv.URL, "127.0.0.1", "localhost", 1)
+
+	event := WebhookEvent{Job: Job{ID: "job", State: JobDone}}
+	err := postWebhook(webhookClient(nil), callback, "", event, time.Millisecond)
+	if !errors.Is(err, errWebhookAddress) {
+		t.Errorf("webhook to %s returned %v, want %v", callback, err, errWebhookAddress)
+	}
+	if posted != 0 {
+		t.Errorf("the refused webhook was posted %d times", posted)
+	}
+
+	allow, _ := ParseWebhookAllow("127.0.0.0/8,::1")
+	if err := postWebhook(webhookClient(allow), callback, "", event, time.Millisecond); err != nil {
+		t.Errorf("webhook to the allowed %s returned %v", callback, err)
+	}
+	if posted != 1 {
+		t.Errorf("the allowed webhook was posted %d times, want 1", posted)
+	}
+}
+
+func TestPostWebhook(t *testing.T) {
+	attempts := 0
+	var event WebhookEvent
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		body, _ := ioutil.ReadAll(r.Body)
+		if got, want := r.Header.Get(WebhookSignatureHeader), webhookSignature("secret", body); got != want {
+			t.Errorf("signature is %q, want %q", got, want)
+		}
+		if attempts < 2 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		json.Unmarshal(body, &event)
+	}))
+	defer srv.Close()
+
+	sent := WebhookEvent{Job: Job{ID: "job", State: JobDone}, ResultURL: "http://gosaic/jobs/job/result"}
+	if err := postWebhook(srv.Client(), srv.URL, "secret", sent, time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if attempts != 2 {
+		t.Errorf("the webhook was posted %d times, want 2", attempts)
+	}
+	if event.Job.ID != "job" || event.ResultURL != sent.ResultURL {
+		t.Errorf("posted %+v, want %+v", event, sent)
+	}
+
+	attempts = -10
+	if err := postWebhook(srv.Client(), srv.URL, "secret", sent, time.Millisecond); err == nil {
+		t.Error("a webhook which always fails didn't fail")
+	}
+	if attempts != -10+webhookAttempts {
+		t.Errorf("the failing webhook was posted %d times, want %d", attempts+10, webhookAttempts)
+	}
+}
@@ -0,0 +1,49 @@
+package gosaic
+
+import (
+	"image"
+)
+
+// cellWeight returns the mean brightness of the weight map within r in the
+// range 0..1. Without a weight map every cell weighs 0.5, cells covering a
+// face always weigh 1 to get the strictest matches.
+func (g *Gosaic) cellWeight(r image.Rectangle) float64 {
+	if g.onFace(r) {
+		return 1
+	}
+	if g.weightMap == nil {
+		return 0.5
+	}
+
+	r = r.Intersect(g.weightMap.Bounds())
+	if r.Empty() {
+		return 0.5
+	}
+
+	sum := 0
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			sum += int(g.weightMap.GrayAt(x, y).Y)
+		}
+	}
+
+	return float64(sum) / float64(r.Dx()*r.Dy()) / 0xff
+}
+
+// compareDist returns the maximum average color distance accepted for td.
+// Bright regions of the weight map halve it, dark regions raise it by half
+// and every widening step for cells without a match doubles it.
+func (g *Gosaic) compareDist(td *TileData) float64 {
+	return g.config.CompareDist * (1.5 - td.Weight) * float64(int(1)<<uint(td.Widen))
+}
+
+// candidates returns the number of nearest tiles to compare to td. Bright
+// regions of the weight map compare up to 1.5 times as many, dark regions
+// half as many.
+func (g *Gosaic) candidates(td *TileData) int {
+	n := int(float64(g.config.Candidates)*(0.5+td.Weight) + 0.5)
+	if n < 1 {
+		n = 1
+	}
+	return n
+}